@@ -1,13 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/akamensky/argparse"
 	"github.com/spf13/afero"
 
 	"github.com/driessamyn/deduplicater/pkg/deduper"
+	"github.com/driessamyn/deduplicater/pkg/server"
 )
 
 var (
@@ -25,6 +31,43 @@ const (
 	Delete             = iota
 )
 
+func newLogger(out io.Writer, level string, format string) *slog.Logger {
+	var l slog.Level
+	// level is validated by the parser
+	l.UnmarshalText([]byte(level))
+	opts := &slog.HandlerOptions{Level: l}
+
+	if "json" == format {
+		return slog.New(slog.NewJSONHandler(out, opts))
+	}
+
+	return slog.New(slog.NewTextHandler(out, opts))
+}
+
+// imageHashKinds parses the image hash kinds, of an algorithm, or an algorithm and size separated by a colon, e.g.
+// perception:16. Kinds without a size have the default size.
+func imageHashKinds(algorithms []string, defaultSize int) ([]deduper.ImageHashKind, error) {
+	kinds := []deduper.ImageHashKind{}
+	for _, a := range algorithms {
+		algorithm, size := a, defaultSize
+		if i := strings.Index(a, ":"); i >= 0 {
+			var err error
+			algorithm = a[:i]
+			size, err = strconv.Atoi(a[i+1:])
+			if nil != err {
+				return nil, fmt.Errorf("image hash size of %q is not a number", a)
+			}
+		}
+		kind, err := deduper.NewImageHashKind(algorithm, size)
+		if nil != err {
+			return nil, err
+		}
+		kinds = append(kinds, kind)
+	}
+
+	return kinds, nil
+}
+
 func main() {
 	run(os.Args)
 }
@@ -39,6 +82,23 @@ func run(args []string) {
 		},
 	)
 
+	quietFlag := parser.Flag("q", "quiet", &argparse.Options{
+		Required: false,
+		Help:     "Do not show progress",
+		Default:  false,
+	})
+
+	logLevel := parser.Selector("", "log-level", []string{"debug", "info", "warn", "error"}, &argparse.Options{
+		Required: false,
+		Help:     "Log level",
+		Default:  "info",
+	})
+	logFormat := parser.Selector("", "log-format", []string{"text", "json"}, &argparse.Options{
+		Required: false,
+		Help:     "Log format",
+		Default:  "text",
+	})
+
 	indexPath := parser.String("f", "file", &argparse.Options{Required: false, Help: "Path to the index file to create/use"})
 	md5Flag := parser.Flag("", "md5", &argparse.Options{
 		Required: false,
@@ -52,14 +112,114 @@ func run(args []string) {
 		Default:  false,
 	})
 
+	imageAlgorithms := parser.StringList("", "image-algorithm", &argparse.Options{
+		Required: false,
+		Help:     "Perceptual hash of the image hash: difference, average or perception, optionally with its size, e.g. perception:16. Repeat to index several kinds at once, find uses the first",
+		Default:  []string{"difference"},
+	})
+	imageHashSize := parser.Int("", "image-hash-size", &argparse.Options{
+		Required: false,
+		Help:     "Bits per side of the image hash: 4, 8 or 16",
+		Default:  8,
+	})
+
+	pixelHashFlag := parser.Flag("", "pixelhash", &argparse.Options{
+		Required: false,
+		Help:     "Use pixel hash, to find images with the same pixels and different metadata",
+		Default:  false,
+	})
+
+	videoHashFlag := parser.Flag("", "videohash", &argparse.Options{
+		Required: false,
+		Help:     "Use video hash. Requires ffmpeg and ffprobe",
+		Default:  false,
+	})
+	videoTolerance := parser.Int("", "video-tolerance", &argparse.Options{
+		Required: false,
+		Help:     "Average number of bits, out of 64, that frames of duplicate videos may differ by",
+		Default:  8,
+	})
+
+	audioHashFlag := parser.Flag("", "audiohash", &argparse.Options{
+		Required: false,
+		Help:     "Use audio hash, ignoring tags",
+		Default:  false,
+	})
+	audioPrintFlag := parser.Flag("", "audioprint", &argparse.Options{
+		Required: false,
+		Help:     "Use acoustic fingerprint of wav and flac files",
+		Default:  false,
+	})
+
 	// index
 	indexCmd := parser.NewCommand("index", "Index allfiles")
-	dirpath := indexCmd.String("d", "dir", &argparse.Options{Required: true, Help: "Directory of files to use"})
+	dirpath := indexCmd.String("d", "dir", &argparse.Options{Required: false, Help: "Directory of files to use"})
+	workers := indexCmd.Int("w", "workers", &argparse.Options{Required: false, Help: "Number of files to hash concurrently. Defaults to the number of CPUs"})
+	followSymlinks := indexCmd.Flag("", "follow-symlinks", &argparse.Options{Required: false, Help: "Walk symlinked directories and hash the targets of symlinked files"})
+	noFollow := indexCmd.Flag("", "no-follow", &argparse.Options{Required: false, Help: "Only record symlinks in the index, without following them. This is the default"})
+	archives := indexCmd.Flag("", "archives", &argparse.Options{Required: false, Help: "Also index the files in zip, tar and tar.gz archives. Files in archives are only reported, never moved or removed"})
+	rotations := indexCmd.Flag("", "rotations", &argparse.Options{Required: false, Help: "With --imagehash, also hash images rotated and flipped, to find rotated and mirrored copies"})
+	thumbnails := indexCmd.Flag("", "thumbnails", &argparse.Options{Required: false, Help: "With --imagehash, cache thumbnails of the images next to the index, to review duplicates without reading the images again"})
+
+	// index maintenance: index merge <a> <b> -o <c>, index diff <a> <b>, index prune
+	indexAction := indexCmd.SelectorPositional([]string{"merge", "diff", "prune"}, &argparse.Options{Help: "Index maintenance action: merge, diff or prune"})
+	firstIndex := indexCmd.StringPositional(&argparse.Options{Help: "First (old) index file, or directory containing it, to merge or diff"})
+	secondIndex := indexCmd.StringPositional(&argparse.Options{Help: "Second (new) index file, or directory containing it, to merge or diff"})
+	mergeOutput := indexCmd.String("o", "output", &argparse.Options{Required: false, Help: "Index file, or directory, to write the merged index to"})
 
 	// find
 	findCmd := parser.NewCommand("find", "Find duplicates")
-	deleteFlag := findCmd.Flag("", "remove", &argparse.Options{Required: false, Help: "Force remove duplicate files"})
+	deleteFlag := findCmd.Flag("", "remove", &argparse.Options{Required: false, Help: "Permanently remove duplicate files, after confirming"})
+	yesFlag := findCmd.Flag("y", "yes", &argparse.Options{Required: false, Help: "With --remove, do not ask for confirmation, e.g. when not run in a terminal"})
+	dryRun := findCmd.Flag("", "dry-run", &argparse.Options{Required: false, Help: "Only log the files --move-dir or --remove would move or remove"})
 	moveDir := findCmd.String("", "move-dir", &argparse.Options{Required: false, Help: "Directory to move the files to"})
+	dirsFlag := findCmd.Flag("", "dirs", &argparse.Options{Required: false, Help: "Find directories with the same content, rather than files. Requires an md5 index"})
+	overlap := findCmd.Int("", "overlap", &argparse.Options{
+		Required: false,
+		Help:     "With --dirs, also report pairs of directories that share at least this percentage of their files",
+		Default:  100,
+	})
+	pruneEmptyDirs := findCmd.Flag("", "prune-empty-dirs", &argparse.Options{Required: false, Help: "Remove directories left empty after moving or removing duplicates"})
+	emitScript := findCmd.Selector("", "emit-script", []string{"bash", "powershell"}, &argparse.Options{
+		Required: false,
+		Help:     "Write a script to stdout that moves (--move-dir), removes (--remove) or links (--link) the duplicates, rather than acting on them",
+	})
+	linkFlag := findCmd.Flag("", "link", &argparse.Options{Required: false, Help: "With --emit-script, replace duplicates with hard links to the file kept"})
+	outputFormat := findCmd.Selector("o", "output", []string{"text", "json"}, &argparse.Options{
+		Required: false,
+		Help:     "Output format. When using json, you are not prompted for what to do with the duplicates",
+		Default:  "text",
+	})
+
+	// stats
+	statsCmd := parser.NewCommand("stats", "Show index statistics and how much space removing duplicates would save")
+	topFlag := statsCmd.Int("n", "top", &argparse.Options{Required: false, Help: "Number of groups, directories and extensions to show", Default: 10})
+
+	// watch
+	watchCmd := parser.NewCommand("watch", "Index a directory, and keep the index up to date while files change. Linux only")
+	watchDirpath := watchCmd.String("d", "dir", &argparse.Options{Required: true, Help: "Directory of files to watch"})
+
+	// lookup
+	lookupCmd := parser.NewCommand("lookup", "Find indexed files that match a file, without walking the indexed directory again")
+	sampleFile := lookupCmd.StringPositional(&argparse.Options{Help: "File to look up"})
+	maxDistance := lookupCmd.Int("", "distance", &argparse.Options{
+		Required: false,
+		Help:     "Number of bits, out of 64 for 8x8 hashes, that the image hash of a match may differ by",
+		Default:  5,
+	})
+	lookupFormat := lookupCmd.Selector("o", "output", []string{"text", "json"}, &argparse.Options{
+		Required: false,
+		Help:     "Output format",
+		Default:  "text",
+	})
+
+	// serve
+	serveCmd := parser.NewCommand("serve", "Serve a local HTTP/JSON API to index, query and act on duplicates")
+	listenAddr := serveCmd.String("l", "listen", &argparse.Options{
+		Required: false,
+		Help:     "Address to listen on. The API has no authentication, so only listen on localhost unless it is protected otherwise",
+		Default:  "localhost:8080",
+	})
 
 	err := parser.Parse(args)
 	if err != nil {
@@ -67,39 +227,160 @@ func run(args []string) {
 		return
 	}
 
-	deduper := deduper.NewDeduper(afero.NewOsFs(), *indexPath, *md5Flag, *imageHashFlag)
+	if *followSymlinks && *noFollow {
+		fmt.Print(parser.Usage("[--follow-symlinks] and [--no-follow] cannot be used together"))
+		return
+	}
+
+	if "" != *emitScript {
+		actions := 0
+		for _, set := range []bool{*deleteFlag, "" != *moveDir, *linkFlag} {
+			if set {
+				actions++
+			}
+		}
+		if 1 != actions {
+			fmt.Print(parser.Usage("[--emit-script] requires one of [--move-dir], [--remove] or [--link]"))
+			return
+		}
+	} else if *linkFlag {
+		fmt.Print(parser.Usage("[--link] requires [--emit-script]"))
+		return
+	}
+
+	imageKinds, err := imageHashKinds(*imageAlgorithms, *imageHashSize)
+	if nil != err {
+		fmt.Print(parser.Usage(err))
+		return
+	}
+
+	fs := afero.NewOsFs()
+	strategies := []deduper.Strategy{}
+	if *md5Flag {
+		strategies = append(strategies, deduper.StrategyMd5)
+	}
+	if *imageHashFlag {
+		strategies = append(strategies, deduper.StrategyImageHash)
+	}
+	if *pixelHashFlag {
+		strategies = append(strategies, deduper.StrategyPixelHash)
+	}
+	if *videoHashFlag {
+		strategies = append(strategies, deduper.StrategyVideoHash)
+	}
+	if *audioHashFlag {
+		strategies = append(strategies, deduper.StrategyAudioHash)
+	}
+	if *audioPrintFlag {
+		strategies = append(strategies, deduper.StrategyAudioPrint)
+	}
+	thumbnailSize := 0
+	if *thumbnails {
+		thumbnailSize = deduper.DefaultThumbnailSize
+	}
+	logger := newLogger(os.Stderr, *logLevel, *logFormat)
+	opts := []deduper.Option{
+		deduper.WithHashers(strategies...),
+		deduper.WithVideoTolerance(*videoTolerance),
+		deduper.WithWorkers(*workers),
+		deduper.WithFollowSymlinks(*followSymlinks),
+		deduper.WithArchives(*archives),
+		deduper.WithImageTransforms(*rotations),
+		deduper.WithThumbnails(thumbnailSize),
+		deduper.WithImageHashes(imageKinds...),
+		deduper.WithPruneEmptyDirs(*pruneEmptyDirs),
+		deduper.WithDryRun(*dryRun),
+	}
+	dedup := deduper.New(fs, *indexPath, append(opts,
+		deduper.WithProgress(newProgressRenderer(os.Stdout, *quietFlag)),
+		deduper.WithLogger(logger))...)
 
 	switch {
+	case indexCmd.Happened() && "" != *indexAction:
+		switch *indexAction {
+		case "merge":
+			if "" == *firstIndex || "" == *secondIndex || "" == *mergeOutput {
+				fmt.Print(parser.Usage("index merge requires 2 index files and [-o|--output]"))
+				return
+			}
+			mergeIndexes(fs, *firstIndex, *secondIndex, *mergeOutput)
+		case "diff":
+			if "" == *firstIndex || "" == *secondIndex {
+				fmt.Print(parser.Usage("index diff requires 2 index files"))
+				return
+			}
+			diffIndexes(fs, *firstIndex, *secondIndex)
+		case "prune":
+			pruneIndex(fs, *indexPath)
+		}
+
 	case indexCmd.Happened():
+		if "" == *dirpath {
+			fmt.Print(parser.Usage("[-d|--dir] is required"))
+			return
+		}
+
 		fmt.Printf("Indexing %v to %v\n", *dirpath, *indexPath)
 
-		err := deduper.Create(*dirpath)
+		// keep the hashes of kinds not hashed now, e.g. of other image hash kinds
+		if err := dedup.Load(); nil != err && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Failed loading index: %v\n", err)
+			return
+		}
+		err := dedup.Create(*dirpath)
 
 		if nil != err {
 			fmt.Printf("Failed creating index: %v\n", err)
 		}
 
 	case findCmd.Happened():
-		fmt.Printf("Finding duplicates in %v using 'md5 checksum'\n", *indexPath)
+		interactive := "text" == *outputFormat && "" == *emitScript
+		if interactive {
+			fmt.Printf("Finding duplicates in %v\n", *indexPath)
+		}
+		// keep the script written to stdout clean
+		messages := io.Writer(os.Stdout)
+		if "" != *emitScript {
+			messages = os.Stderr
+		}
 
-		err := deduper.Load()
+		err := dedup.Load()
 		if nil != err {
-			fmt.Printf("Failed loading index: %v\n", err)
+			fmt.Fprintf(messages, "Failed loading index: %v\n", err)
 		}
 
-		dupes, err := deduper.Find()
+		var dupes []deduper.DuplicateGroup
+		if *dirsFlag {
+			dupes, err = dedup.FindDirectories(float64(*overlap) / 100)
+		} else {
+			dupes, err = dedup.Find()
+		}
 		if nil != err {
-			fmt.Printf("Failed finding duplicates: %v\n", err)
+			fmt.Fprintf(messages, "Failed finding duplicates: %v\n", err)
 		}
 
-		if len(dupes) == 0 {
-			fmt.Println("No duplicates found")
+		if "" != *emitScript {
+			if nil != err {
+				return
+			}
+			script := deduper.Script{Shell: deduper.ScriptShell(*emitScript), Action: deduper.ScriptDelete}
+			if "" != *moveDir {
+				script.Action, script.Target = deduper.ScriptMove, *moveDir
+			} else if *linkFlag {
+				script.Action = deduper.ScriptLink
+			}
+			if err := dedup.WriteScript(os.Stdout, dupes, script); nil != err {
+				fmt.Fprintf(messages, "Failed writing script: %v\n", err)
+			}
 			return
 		}
 
-		fmt.Printf("%v duplicates found:\n", len(dupes))
-		for _, v := range dupes {
-			fmt.Printf("%v\n", v)
+		if err := newGroupFormatter(*outputFormat).format(os.Stdout, dupes); nil != err {
+			fmt.Printf("Failed writing duplicates: %v\n", err)
+		}
+
+		if len(deduper.FilterGroups(dupes, deduper.CategoryDuplicate, deduper.CategoryDirectory)) == 0 {
+			return
 		}
 
 		var findAction FindAction
@@ -107,21 +388,95 @@ func run(args []string) {
 			findAction = Delete
 		} else if "" != *moveDir {
 			findAction = Move
-		} else {
-			findAction, moveDir = PromptAction(deduper.IsDirExist)
 		}
 
 		if Move == findAction {
-			err := deduper.MoveDuplicates(dupes, *moveDir)
+			err := dedup.MoveDuplicates(dupes, *moveDir)
 			if nil != err {
 				fmt.Printf("Failed to move files: %v", err)
 			}
 		} else if Delete == findAction {
-			fmt.Println("TODO: delete")
-		} else {
+			if !*yesFlag && !*dryRun {
+				if !isTerminal(os.Stdin) {
+					fmt.Println("Not removing duplicates without confirmation, use [--yes] to confirm")
+					return
+				}
+				question := fmt.Sprintf("Permanently remove %v files? This cannot be undone", actedFiles(dupes))
+				if !confirm(os.Stdin, os.Stdout, question) {
+					fmt.Println("Do Nothing")
+					return
+				}
+			}
+			err := dedup.DeleteDuplicates(dupes)
+			if nil != err {
+				fmt.Printf("Failed to delete files: %v", err)
+			}
+		} else if interactive && isTerminal(os.Stdin) {
+			result, err := reviewDuplicates(dedup, dupes)
+			if nil != err {
+				fmt.Printf("Failed reviewing duplicates: %v", err)
+			} else {
+				fmt.Println(result)
+			}
+		} else if interactive {
 			fmt.Println("Do Nothing")
 		}
 
+	case statsCmd.Happened():
+		err := dedup.Load()
+		if nil != err {
+			fmt.Printf("Failed loading index: %v\n", err)
+			return
+		}
+
+		stats, err := dedup.Stats()
+		if nil != err {
+			fmt.Printf("Failed calculating statistics: %v\n", err)
+			return
+		}
+
+		printStats(stats, *topFlag)
+
+	case watchCmd.Happened():
+		fmt.Printf("Watching %v, indexing to %v. Press Ctrl+C to stop\n", *watchDirpath, *indexPath)
+
+		if err := watchDir(dedup, *watchDirpath); nil != err {
+			fmt.Printf("Failed watching: %v\n", err)
+		}
+
+	case lookupCmd.Happened():
+		if "" == *sampleFile {
+			fmt.Print(parser.Usage("lookup requires a file"))
+			return
+		}
+
+		err := dedup.Load()
+		if nil != err {
+			fmt.Printf("Failed loading index: %v\n", err)
+			return
+		}
+
+		matches, err := dedup.Lookup(*sampleFile, *maxDistance)
+		if nil != err {
+			fmt.Printf("Failed looking up file: %v\n", err)
+			return
+		}
+
+		if err := formatMatches(os.Stdout, *lookupFormat, *sampleFile, matches); nil != err {
+			fmt.Printf("Failed writing matches: %v\n", err)
+		}
+
+	case serveCmd.Happened():
+		s := server.New(fs, *indexPath, logger, opts...)
+		if err := s.Load(); nil != err {
+			fmt.Printf("No index loaded, index a directory first: %v\n", err)
+		}
+
+		fmt.Printf("Serving the API for %v on http://%v. Press Ctrl+C to stop\n", *indexPath, *listenAddr)
+		if err := serve(s, *listenAddr); nil != err {
+			fmt.Printf("Failed serving: %v\n", err)
+		}
+
 	case *versionFlag:
 		fmt.Printf("deduplicater %v (%v - %v)", version, commit, date)
 	}
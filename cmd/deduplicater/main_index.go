@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/afero"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+func mergeIndexes(fs afero.Fs, first string, second string, output string) {
+	fmt.Printf("Merging %v and %v into %v\n", first, second, output)
+
+	firstIndex, err := deduper.LoadIndex(fs, first)
+	if nil != err {
+		fmt.Printf("Failed loading index %v: %v\n", first, err)
+		return
+	}
+
+	secondIndex, err := deduper.LoadIndex(fs, second)
+	if nil != err {
+		fmt.Printf("Failed loading index %v: %v\n", second, err)
+		return
+	}
+
+	merged := deduper.MergeIndexes(firstIndex, secondIndex)
+	if err := deduper.SaveIndex(fs, output, merged); nil != err {
+		fmt.Printf("Failed saving index: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Merged %v and %v files into %v files\n", firstIndex.Len(), secondIndex.Len(), merged.Len())
+}
+
+func diffIndexes(fs afero.Fs, old string, new string) {
+	oldIndex, err := deduper.LoadIndex(fs, old)
+	if nil != err {
+		fmt.Printf("Failed loading index %v: %v\n", old, err)
+		return
+	}
+
+	newIndex, err := deduper.LoadIndex(fs, new)
+	if nil != err {
+		fmt.Printf("Failed loading index %v: %v\n", new, err)
+		return
+	}
+
+	diff := deduper.DiffIndexes(oldIndex, newIndex)
+	if diff.IsEmpty() {
+		fmt.Println("No differences found")
+		return
+	}
+
+	for _, f := range diff.Added {
+		fmt.Printf("+ %v\n", f.Path)
+	}
+	for _, f := range diff.Removed {
+		fmt.Printf("- %v\n", f.Path)
+	}
+	for _, c := range diff.Changed {
+		fmt.Printf("~ %v\n", c.New.Path)
+	}
+
+	fmt.Printf("%v added, %v removed, %v changed\n", len(diff.Added), len(diff.Removed), len(diff.Changed))
+}
+
+func pruneIndex(fs afero.Fs, indexPath string) {
+	index, err := deduper.LoadIndex(fs, indexPath)
+	if nil != err {
+		fmt.Printf("Failed loading index: %v\n", err)
+		return
+	}
+
+	removed, err := deduper.PruneIndex(fs, index)
+	if nil != err {
+		fmt.Printf("Failed pruning index: %v\n", err)
+		return
+	}
+
+	for _, p := range removed {
+		fmt.Printf("Removed %v\n", p)
+	}
+
+	if err := deduper.SaveIndex(fs, indexPath, index); nil != err {
+		fmt.Printf("Failed saving index: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Pruned %v files, %v files remaining\n", len(removed), index.Len())
+}
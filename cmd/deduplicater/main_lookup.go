@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+// formatMatches writes the indexed files that match the sample, as text or json.
+func formatMatches(w io.Writer, format string, sample string, matches []deduper.Match) error {
+	if "json" == format {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", " ")
+
+		return encoder.Encode(matches)
+	}
+
+	if 0 == len(matches) {
+		_, err := fmt.Fprintf(w, "No files match %v\n", sample)
+		return err
+	}
+	fmt.Fprintf(w, "%v files match %v:\n", len(matches), sample)
+	for _, m := range matches {
+		details := formatBytes(m.Size)
+		if "" != m.Archive {
+			details += ", in archive"
+		}
+		if nil != m.Image {
+			details += ", " + formatImage(*m.Image)
+		}
+		if 0 == m.Distance {
+			details += ", exact"
+		} else {
+			details += fmt.Sprintf(", distance %v", m.Distance)
+		}
+		if "" != m.Transform {
+			details += ", " + m.Transform
+		}
+		if _, err := fmt.Fprintf(w, "  [%v] %v (%v)\n", m.Strategy, m.Path, details); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
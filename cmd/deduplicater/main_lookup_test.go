@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+var testMatches = []deduper.Match{
+	{Strategy: deduper.StrategyMd5, IndexedFile: deduper.IndexedFile{Path: "test/cat1.jpg", Size: 2048}},
+	{
+		Strategy:    deduper.StrategyImageHash,
+		IndexedFile: deduper.IndexedFile{Path: "test/cat1-2.jpg", Size: 1024, Image: &deduper.ImageInfo{Width: 800, Height: 1200}},
+		Distance:    3,
+		Transform:   "rotated 90° clockwise",
+	},
+}
+
+func Test_Format_Matches_Text(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	assert.NoError(t, formatMatches(out, "text", "sample.jpg", testMatches))
+
+	assert.Equal(t, `2 files match sample.jpg:
+  [md5] test/cat1.jpg (2.0 KiB, exact)
+  [imagehash] test/cat1-2.jpg (1.0 KiB, 800x1200, distance 3, rotated 90° clockwise)
+`, out.String())
+}
+
+func Test_Format_Matches_None(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	assert.NoError(t, formatMatches(out, "text", "sample.jpg", []deduper.Match{}))
+
+	assert.Equal(t, "No files match sample.jpg\n", out.String())
+}
+
+func Test_Format_Matches_Json(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	assert.NoError(t, formatMatches(out, "json", "sample.jpg", testMatches))
+
+	var decoded []deduper.Match
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, testMatches, decoded)
+}
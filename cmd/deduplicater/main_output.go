@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+// groupFormatter writes groups of duplicates found to the output.
+type groupFormatter interface {
+	format(w io.Writer, groups []deduper.DuplicateGroup) error
+}
+
+func newGroupFormatter(format string) groupFormatter {
+	if "json" == format {
+		return jsonFormatter{}
+	}
+
+	return textFormatter{}
+}
+
+type textFormatter struct{}
+
+func (textFormatter) format(w io.Writer, groups []deduper.DuplicateGroup) error {
+	dupes := deduper.FilterGroups(groups, deduper.CategoryDuplicate, deduper.CategoryDirectory)
+	if 0 == len(dupes) {
+		fmt.Fprintln(w, "No duplicates found")
+	} else {
+		var reclaimable int64
+		for _, g := range dupes {
+			reclaimable += g.Reclaimable
+		}
+		fmt.Fprintf(w, "%v duplicates found, %v reclaimable:\n", len(dupes), formatBytes(reclaimable))
+	}
+
+	for _, g := range dupes {
+		noun := "files"
+		if deduper.CategoryDirectory == g.Category {
+			noun = "directories"
+		}
+		fmt.Fprintf(w, "[%v %v] %v %v, %v reclaimable\n", g.Strategy, g.Key, len(g.Members), noun, formatBytes(g.Reclaimable))
+		for _, m := range g.Members {
+			role := "dupe"
+			if m.Path == g.Keeper {
+				role = "keep"
+			}
+			path := m.Path
+			if m.Symlink {
+				path = fmt.Sprintf("%v -> %v", m.Path, m.LinkTarget)
+			}
+			size := formatBytes(m.Size)
+			if "" != m.Archive {
+				size += ", in archive"
+			}
+			if nil != m.Image {
+				size += ", " + formatImage(*m.Image)
+			}
+			if "" != m.Transform {
+				size += ", " + m.Transform
+			}
+			fmt.Fprintf(w, "  %v %v (%v)\n", role, path, size)
+			for _, l := range m.Links {
+				fmt.Fprintf(w, "       %v (link)\n", l)
+			}
+		}
+	}
+
+	overlapping := deduper.FilterGroups(groups, deduper.CategoryOverlap)
+	if 0 != len(overlapping) {
+		fmt.Fprintf(w, "%v pairs of directories partly the same:\n", len(overlapping))
+	}
+	for _, g := range overlapping {
+		if _, err := fmt.Fprintf(w, "  %.0f%% %v\n", 100*g.Overlap, strings.Join(g.Paths(), ", ")); nil != err {
+			return err
+		}
+	}
+
+	linked := deduper.FilterGroups(groups, deduper.CategoryLinked)
+	if 0 != len(linked) {
+		fmt.Fprintf(w, "%v files already linked:\n", len(linked))
+	}
+	for _, g := range linked {
+		if _, err := fmt.Fprintf(w, "  %v\n", strings.Join(g.Paths(), ", ")); nil != err {
+			return err
+		}
+	}
+
+	for _, g := range deduper.FilterGroups(groups, deduper.CategoryEmpty) {
+		fmt.Fprintf(w, "%v empty files:\n", len(g.Members))
+		for _, p := range g.Paths() {
+			if _, err := fmt.Fprintf(w, "  %v\n", p); nil != err {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// formatImage describes the resolution of the image, and the camera and date from its EXIF metadata if known.
+func formatImage(info deduper.ImageInfo) string {
+	parts := []string{fmt.Sprintf("%vx%v", info.Width, info.Height)}
+	// the model often starts with the make already
+	camera := info.Model
+	if !strings.HasPrefix(strings.ToLower(info.Model), strings.ToLower(info.Make)) {
+		camera = strings.TrimSpace(info.Make + " " + info.Model)
+	}
+	if "" != camera {
+		parts = append(parts, camera)
+	}
+	if !info.Taken.IsZero() {
+		parts = append(parts, "taken "+info.Taken.Format("2006-01-02 15:04"))
+	}
+	if info.GPS {
+		parts = append(parts, "with location")
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+type jsonFormatter struct{}
+
+func (jsonFormatter) format(w io.Writer, groups []deduper.DuplicateGroup) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", " ")
+
+	return encoder.Encode(groups)
+}
+
+// actedFiles returns the number of files that moving or deleting the duplicates of the groups acts on, which are all
+// hard links of the duplicates, but no archive members.
+func actedFiles(groups []deduper.DuplicateGroup) int {
+	files := 0
+	for _, g := range deduper.FilterGroups(groups, deduper.CategoryDuplicate, deduper.CategoryDirectory) {
+		for _, d := range g.Duplicates() {
+			if "" == d.Archive {
+				files += len(d.AllPaths())
+			}
+		}
+	}
+
+	return files
+}
+
+// confirm asks the question, and returns whether it was answered with yes.
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%v [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return "y" == answer || "yes" == answer
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+var testGroups = []deduper.DuplicateGroup{
+	{
+		Strategy: deduper.StrategyMd5,
+		Category: deduper.CategoryDuplicate,
+		Key:      "5d41402abc4b2a76b9719d911017c592",
+		Members: []deduper.GroupMember{
+			{IndexedFile: deduper.IndexedFile{Path: "test/fred.txt", Size: 5}},
+			{IndexedFile: deduper.IndexedFile{Path: "test/bob/freddy.txt", Size: 5}},
+		},
+		Keeper:      "test/fred.txt",
+		Reclaimable: 5,
+	},
+}
+
+func Test_Text_Formatter(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	err := newGroupFormatter("text").format(out, testGroups)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `1 duplicates found, 5 B reclaimable:
+[md5 5d41402abc4b2a76b9719d911017c592] 2 files, 5 B reclaimable
+  keep test/fred.txt (5 B)
+  dupe test/bob/freddy.txt (5 B)
+`, out.String())
+}
+
+func Test_Text_Formatter_No_Duplicates(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	newGroupFormatter("text").format(out, []deduper.DuplicateGroup{})
+
+	assert.Equal(t, "No duplicates found\n", out.String())
+}
+
+func Test_Text_Formatter_Linked(t *testing.T) {
+	out := &bytes.Buffer{}
+	linked := deduper.DuplicateGroup{
+		Strategy: deduper.StrategyMd5,
+		Category: deduper.CategoryLinked,
+		Members: []deduper.GroupMember{
+			{IndexedFile: deduper.IndexedFile{Path: "test/jo.txt"}},
+			{IndexedFile: deduper.IndexedFile{Path: "test/bob/jo.txt"}},
+		},
+	}
+
+	newGroupFormatter("text").format(out, []deduper.DuplicateGroup{linked})
+
+	assert.Equal(t, `No duplicates found
+1 files already linked:
+  test/jo.txt, test/bob/jo.txt
+`, out.String())
+}
+
+func Test_Text_Formatter_Symlink(t *testing.T) {
+	out := &bytes.Buffer{}
+	group := deduper.DuplicateGroup{
+		Strategy: deduper.StrategyMd5,
+		Category: deduper.CategoryDuplicate,
+		Key:      "5d41402abc4b2a76b9719d911017c592",
+		Members: []deduper.GroupMember{
+			{IndexedFile: deduper.IndexedFile{Path: "test/fred.txt", Size: 5}},
+			{IndexedFile: deduper.IndexedFile{Path: "test/fred-link.txt", Size: 5, Symlink: true, LinkTarget: "/tmp/fred.txt"}},
+		},
+		Keeper:      "test/fred.txt",
+		Reclaimable: 5,
+	}
+
+	newGroupFormatter("text").format(out, []deduper.DuplicateGroup{group})
+
+	assert.Equal(t, `1 duplicates found, 5 B reclaimable:
+[md5 5d41402abc4b2a76b9719d911017c592] 2 files, 5 B reclaimable
+  keep test/fred.txt (5 B)
+  dupe test/fred-link.txt -> /tmp/fred.txt (5 B)
+`, out.String())
+}
+
+func Test_Text_Formatter_Empty(t *testing.T) {
+	out := &bytes.Buffer{}
+	empty := deduper.DuplicateGroup{
+		Strategy: deduper.StrategyMd5,
+		Category: deduper.CategoryEmpty,
+		Members: []deduper.GroupMember{
+			{IndexedFile: deduper.IndexedFile{Path: "test/empty.txt"}},
+			{IndexedFile: deduper.IndexedFile{Path: "test/bob/empty.txt"}},
+		},
+	}
+
+	newGroupFormatter("text").format(out, []deduper.DuplicateGroup{empty})
+
+	assert.Equal(t, `No duplicates found
+2 empty files:
+  test/empty.txt
+  test/bob/empty.txt
+`, out.String())
+}
+
+func Test_Text_Formatter_Archive(t *testing.T) {
+	out := &bytes.Buffer{}
+	group := deduper.DuplicateGroup{
+		Strategy: deduper.StrategyMd5,
+		Category: deduper.CategoryDuplicate,
+		Key:      "5d41402abc4b2a76b9719d911017c592",
+		Members: []deduper.GroupMember{
+			{IndexedFile: deduper.IndexedFile{Path: "test/fred.txt", Size: 5}},
+			{IndexedFile: deduper.IndexedFile{Path: "test/backup.zip!/fred.txt", Size: 5, Archive: "test/backup.zip"}},
+		},
+		Keeper: "test/fred.txt",
+	}
+
+	newGroupFormatter("text").format(out, []deduper.DuplicateGroup{group})
+
+	assert.Equal(t, `1 duplicates found, 0 B reclaimable:
+[md5 5d41402abc4b2a76b9719d911017c592] 2 files, 0 B reclaimable
+  keep test/fred.txt (5 B)
+  dupe test/backup.zip!/fred.txt (5 B, in archive)
+`, out.String())
+}
+
+func Test_Text_Formatter_Image(t *testing.T) {
+	out := &bytes.Buffer{}
+	group := deduper.DuplicateGroup{
+		Strategy: deduper.StrategyImageHash,
+		Category: deduper.CategoryDuplicate,
+		Key:      "c0a0b0f0f0f8c0c0",
+		Members: []deduper.GroupMember{
+			{IndexedFile: deduper.IndexedFile{Path: "test/cat1.jpg", Size: 2048, Image: &deduper.ImageInfo{
+				Width: 3500, Height: 2334, Exif: true, Make: "NIKON CORPORATION", Model: "NIKON D7100",
+				Taken: time.Date(2018, 3, 3, 10, 25, 38, 0, time.UTC), GPS: true,
+			}}},
+			{IndexedFile: deduper.IndexedFile{Path: "test/cat1-2.jpg", Size: 1024, Image: &deduper.ImageInfo{
+				Width: 1750, Height: 1167, Exif: true, Make: "Canon", Model: "Canon EOS 5D",
+			}}},
+			{IndexedFile: deduper.IndexedFile{Path: "test/cat1-3.jpg", Size: 512, Image: &deduper.ImageInfo{Width: 533, Height: 800}}, Transform: "rotated 90° clockwise"},
+		},
+		Keeper:      "test/cat1.jpg",
+		Reclaimable: 1536,
+	}
+
+	newGroupFormatter("text").format(out, []deduper.DuplicateGroup{group})
+
+	assert.Equal(t, `1 duplicates found, 1.5 KiB reclaimable:
+[imagehash c0a0b0f0f0f8c0c0] 3 files, 1.5 KiB reclaimable
+  keep test/cat1.jpg (2.0 KiB, 3500x2334, NIKON CORPORATION NIKON D7100, taken 2018-03-03 10:25, with location)
+  dupe test/cat1-2.jpg (1.0 KiB, 1750x1167, Canon EOS 5D)
+  dupe test/cat1-3.jpg (512 B, 533x800, rotated 90° clockwise)
+`, out.String())
+}
+
+func Test_Text_Formatter_Directories(t *testing.T) {
+	out := &bytes.Buffer{}
+	groups := []deduper.DuplicateGroup{
+		{
+			Strategy: deduper.StrategyMd5,
+			Category: deduper.CategoryDirectory,
+			Key:      "5d41402abc4b2a76b9719d911017c592",
+			Members: []deduper.GroupMember{
+				{IndexedFile: deduper.IndexedFile{Path: "test/holiday", Size: 2048}},
+				{IndexedFile: deduper.IndexedFile{Path: "test/holiday (1)", Size: 2048}},
+			},
+			Keeper:      "test/holiday",
+			Reclaimable: 2048,
+			Overlap:     1,
+		},
+		{
+			Strategy: deduper.StrategyMd5,
+			Category: deduper.CategoryOverlap,
+			Members: []deduper.GroupMember{
+				{IndexedFile: deduper.IndexedFile{Path: "test/bar"}},
+				{IndexedFile: deduper.IndexedFile{Path: "test/foo"}},
+			},
+			Keeper:  "test/bar",
+			Overlap: 0.75,
+		},
+	}
+
+	newGroupFormatter("text").format(out, groups)
+
+	assert.Equal(t, `1 duplicates found, 2.0 KiB reclaimable:
+[md5 5d41402abc4b2a76b9719d911017c592] 2 directories, 2.0 KiB reclaimable
+  keep test/holiday (2.0 KiB)
+  dupe test/holiday (1) (2.0 KiB)
+1 pairs of directories partly the same:
+  75% test/bar, test/foo
+`, out.String())
+}
+
+func Test_Json_Formatter(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	err := newGroupFormatter("json").format(out, testGroups)
+
+	assert.NoError(t, err)
+	var groups []deduper.DuplicateGroup
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &groups))
+	assert.Equal(t, testGroups, groups)
+}
+
+func Test_Acted_Files(t *testing.T) {
+	// the hard link is acted on as well, the archive member and empty files are not
+	assert.Equal(t, 3, actedFiles(tuiGroups))
+}
+
+func Test_Confirm(t *testing.T) {
+	for answer, confirmed := range map[string]bool{"y\n": true, "Yes\n": true, "n\n": false, "\n": false, "": false} {
+		out := &bytes.Buffer{}
+
+		assert.Equal(t, confirmed, confirm(strings.NewReader(answer), out, "Remove?"), answer)
+		assert.Equal(t, "Remove? [y/N] ", out.String())
+	}
+}
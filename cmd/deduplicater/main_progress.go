@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+const (
+	progressBarWidth   = 30
+	progressLineWidth  = 120
+	progressLogEvery   = 5 * time.Second
+	progressRedrawRate = 100 * time.Millisecond
+)
+
+// progressRenderer shows a live progress bar on a terminal and periodic log lines otherwise.
+type progressRenderer struct {
+	out      io.Writer
+	tty      bool
+	lastDraw time.Time
+}
+
+func newProgressRenderer(out *os.File, quiet bool) deduper.ProgressReporter {
+	if quiet {
+		return deduper.ProgressFunc(func(p deduper.Progress) {})
+	}
+
+	return &progressRenderer{
+		out: out,
+		tty: isTerminal(out),
+	}
+}
+
+// isTerminal is true when f is a terminal, rather than a file, pipe or other character device like /dev/null.
+func isTerminal(f *os.File) bool {
+	return readline.IsTerminal(int(f.Fd()))
+}
+
+func (r *progressRenderer) Progress(p deduper.Progress) {
+	if p.Done {
+		// clear the progress bar, the indexer logs that it is done
+		if r.tty {
+			fmt.Fprintf(r.out, "\r%v\r", strings.Repeat(" ", progressLineWidth))
+		}
+		return
+	}
+
+	interval := progressLogEvery
+	if r.tty {
+		interval = progressRedrawRate
+	}
+	if time.Since(r.lastDraw) < interval {
+		return
+	}
+	r.lastDraw = time.Now()
+
+	if r.tty {
+		line := fmt.Sprintf("%v %v", progressBar(p), progressSummary(p))
+		if remaining := progressLineWidth - len(line) - 1; remaining > 10 && "" != p.CurrentFile {
+			line = fmt.Sprintf("%v %v", line, truncateLeft(p.CurrentFile, remaining))
+		}
+		fmt.Fprintf(r.out, "\r%-*v", progressLineWidth, line)
+	} else {
+		fmt.Fprintf(r.out, "Indexed %v\n", progressSummary(p))
+	}
+}
+
+func progressBar(p deduper.Progress) string {
+	fraction := 0.0
+	if p.BytesDiscovered > 0 {
+		fraction = float64(p.BytesHashed) / float64(p.BytesDiscovered)
+	} else if p.FilesDiscovered > 0 {
+		fraction = float64(p.FilesHashed) / float64(p.FilesDiscovered)
+	}
+	filled := int(fraction * progressBarWidth)
+
+	return fmt.Sprintf("[%v%v] %3.0f%%", strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled), fraction*100)
+}
+
+func progressSummary(p deduper.Progress) string {
+	summary := fmt.Sprintf("%v/%v files, %v/%v, %v/s",
+		p.FilesHashed, p.FilesDiscovered, formatBytes(p.BytesHashed), formatBytes(p.BytesDiscovered), formatBytes(int64(p.Throughput)))
+	if p.DiscoveryDone {
+		return fmt.Sprintf("%v, ETA %v", summary, p.ETA.Round(time.Second))
+	}
+
+	return fmt.Sprintf("%v, discovering files", summary)
+}
+
+func truncateLeft(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	return "..." + s[len(s)-max+3:]
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+func Test_Progress_Log_Lines(t *testing.T) {
+	out := &bytes.Buffer{}
+	renderer := &progressRenderer{out: out}
+
+	renderer.Progress(deduper.Progress{
+		FilesDiscovered: 4,
+		FilesHashed:     1,
+		BytesDiscovered: 4096,
+		BytesHashed:     1024,
+		Throughput:      512,
+		ETA:             6 * time.Second,
+		DiscoveryDone:   true,
+	})
+	// throttled
+	renderer.Progress(deduper.Progress{FilesHashed: 2})
+
+	assert.Equal(t, "Indexed 1/4 files, 1.0 KiB/4.0 KiB, 512 B/s, ETA 6s\n", out.String())
+}
+
+func Test_Progress_Bar(t *testing.T) {
+	out := &bytes.Buffer{}
+	renderer := &progressRenderer{out: out, tty: true}
+
+	renderer.Progress(deduper.Progress{
+		FilesDiscovered: 4,
+		FilesHashed:     2,
+		BytesDiscovered: 4096,
+		BytesHashed:     2048,
+		CurrentFile:     "/mnt/c/Users/bob/Pictures/cat.jpg",
+	})
+
+	assert.Contains(t, out.String(), "\r[===============               ]  50% 2/4 files")
+	assert.Contains(t, out.String(), "discovering files ...Users/bob/Pictures/cat.jpg")
+}
+
+func Test_Progress_Done(t *testing.T) {
+	out := &bytes.Buffer{}
+	renderer := &progressRenderer{out: out}
+
+	renderer.Progress(deduper.Progress{
+		FilesHashed: 3,
+		BytesHashed: 2048,
+		Elapsed:     1500 * time.Millisecond,
+		Done:        true,
+	})
+
+	// only logged by the indexer
+	assert.Empty(t, out.String())
+
+	tty := &bytes.Buffer{}
+	renderer = &progressRenderer{out: tty, tty: true}
+	renderer.Progress(deduper.Progress{Done: true})
+	assert.Equal(t, "\r"+strings.Repeat(" ", progressLineWidth)+"\r", tty.String())
+}
+
+func Test_Truncate_Left(t *testing.T) {
+	assert.Equal(t, "...ture.jpg", truncateLeft("/some/long/picture.jpg", 11))
+	assert.Equal(t, "short.jpg", truncateLeft("short.jpg", 11))
+}
+
+func Test_Is_Terminal(t *testing.T) {
+	devNull, err := os.Open(os.DevNull)
+	assert.NoError(t, err)
+	defer devNull.Close()
+
+	assert.False(t, isTerminal(devNull))
+}
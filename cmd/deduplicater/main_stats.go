@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+func printStats(stats deduper.Stats, top int) {
+	fmt.Printf("%v files, %v\n", stats.Files, formatBytes(stats.Bytes))
+	printBreakdown("By directory", stats.ByDirectory, top)
+	printBreakdown("By extension", stats.ByExtension, top)
+
+	for _, s := range stats.Strategies {
+		fmt.Printf("\nUsing '%v': %v duplicate groups, %v duplicate files, %v reclaimable\n",
+			s.Strategy, len(s.Groups), s.Duplicates, formatBytes(s.Reclaimable))
+		if 0 != s.InArchives {
+			fmt.Printf("  %v duplicate files in archives, which are never removed\n", s.InArchives)
+		}
+		if 0 != s.Linked || 0 != s.Empty {
+			fmt.Printf("  %v files already linked, %v empty files\n", s.Linked, s.Empty)
+		}
+		if 0 == len(s.Groups) {
+			continue
+		}
+
+		fmt.Println("  Largest groups:")
+		for i, g := range s.Groups {
+			if i == top {
+				break
+			}
+			fmt.Printf("    %v reclaimable: %v\n", formatBytes(g.Reclaimable), g.Paths())
+		}
+		printBreakdown("Reclaimable by directory", s.ByDirectory, top)
+		printBreakdown("Reclaimable by extension", s.ByExtension, top)
+	}
+}
+
+func printBreakdown(title string, breakdown []deduper.Breakdown, top int) {
+	fmt.Printf("  %v:\n", title)
+	for i, b := range breakdown {
+		if i == top {
+			fmt.Printf("    ... and %v more\n", len(breakdown)-top)
+			break
+		}
+		key := b.Key
+		if "" == key {
+			key = "(none)"
+		}
+		fmt.Printf("    %v: %v files, %v\n", key, b.Files, formatBytes(b.Bytes))
+	}
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
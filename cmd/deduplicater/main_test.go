@@ -1,16 +1,21 @@
 package main
 
 import (
+	"archive/zip"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
 )
 
 // end-to-end tests, using real files
@@ -92,6 +97,17 @@ func (suite *e2eTestSuite) Test_Main_Move_Md5() {
 	assert.FileExists(suite.T(), filepath.Join(suite.testDir, "fred.txt"))
 }
 
+func readIndexFile(path string) ([]deduper.IndexedFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+	var index []deduper.IndexedFile
+	err = json.Unmarshal(b, &index)
+
+	return index, err
+}
+
 func listFiles(root string) {
 	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if info.IsDir() && path != root {
@@ -141,6 +157,250 @@ func (suite *e2eTestSuite) Test_Main_Move_ImageHash() {
 	assert.FileExists(suite.T(), filepath.Join(suite.testDir, "cat2.jpg"))
 }
 
+func (suite *e2eTestSuite) Test_Main_Index_Prune() {
+	defer os.RemoveAll(suite.indexDir)
+	defer os.RemoveAll(suite.testDir)
+
+	args := []string{
+		"main",
+		"index",
+		"--md5",
+		"-d",
+		suite.testDir,
+		"-f",
+		suite.indexDir,
+	}
+	run(args)
+	os.Remove(filepath.Join(suite.testDir, "jo.txt"))
+
+	// index prune -f "/mnt/c/Users/bob/Pictures"
+	args = []string{
+		"main",
+		"index",
+		"prune",
+		"-f",
+		suite.indexDir,
+	}
+	run(args)
+
+	index, err := ioutil.ReadFile(filepath.Join(suite.indexDir, ".duplicate-index.json"))
+	assert.NoError(suite.T(), err)
+	assert.NotContains(suite.T(), string(index), "jo.txt")
+	assert.Contains(suite.T(), string(index), "fred.txt")
+}
+
+func (suite *e2eTestSuite) Test_Main_Index_Image_Hash_Kinds() {
+	defer os.RemoveAll(suite.indexDir)
+	defer os.RemoveAll(suite.testDir)
+
+	run([]string{"main", "index", "--imagehash", "--image-algorithm", "average", "--image-algorithm", "perception:16", "-d", suite.testDir, "-f", suite.indexDir})
+	// indexing again with another kind keeps the hashes of the others
+	run([]string{"main", "index", "--imagehash", "--image-algorithm", "difference:4", "-d", suite.testDir, "-f", suite.indexDir})
+
+	index, err := readIndexFile(filepath.Join(suite.indexDir, ".duplicate-index.json"))
+	assert.NoError(suite.T(), err)
+	kinds := map[string]bool{}
+	for _, f := range index {
+		for _, h := range f.ImageHashes {
+			kinds[h.ImageHashKind.String()] = true
+		}
+	}
+	assert.Equal(suite.T(), map[string]bool{"average": true, "perception 16x16": true, "difference 4x4": true}, kinds)
+}
+
+func (suite *e2eTestSuite) Test_Main_Index_Again_Removes_Deleted_Files() {
+	defer os.RemoveAll(suite.indexDir)
+	defer os.RemoveAll(suite.testDir)
+
+	run([]string{"main", "index", "--md5", "-d", suite.testDir, "-f", suite.indexDir})
+	os.Remove(filepath.Join(suite.testDir, "jo.txt"))
+	run([]string{"main", "index", "--md5", "-d", suite.testDir, "-f", suite.indexDir})
+
+	index, err := ioutil.ReadFile(filepath.Join(suite.indexDir, ".duplicate-index.json"))
+	assert.NoError(suite.T(), err)
+	assert.NotContains(suite.T(), string(index), "jo.txt")
+	assert.Contains(suite.T(), string(index), "fred.txt")
+}
+
+func (suite *e2eTestSuite) Test_Main_Move_Md5_Hard_Links() {
+	defer os.RemoveAll(suite.indexDir)
+	defer os.RemoveAll(suite.testDir)
+
+	err := os.Link(filepath.Join(suite.testDir, "fred.txt"), filepath.Join(suite.testDir, "fred-link.txt"))
+	if nil != err {
+		suite.T().Skipf("hard links not supported: %v", err)
+	}
+
+	run([]string{"main", "index", "--md5", "-d", suite.testDir, "-f", suite.indexDir})
+	run([]string{"main", "find", "--md5", "-f", suite.indexDir, "--move-dir", suite.moveDir})
+
+	// hard link to the keeper is not a duplicate
+	assert.FileExists(suite.T(), filepath.Join(suite.testDir, "fred.txt"))
+	assert.FileExists(suite.T(), filepath.Join(suite.testDir, "fred-link.txt"))
+	assert.FileExists(suite.T(), filepath.Join(suite.moveDir, "bob/freddy.txt"))
+}
+
+func (suite *e2eTestSuite) Test_Main_Move_Md5_Follow_Symlinks() {
+	defer os.RemoveAll(suite.indexDir)
+	defer os.RemoveAll(suite.testDir)
+
+	// a symlink to a copy outside the indexed directory
+	outsideDir := suite.T().TempDir()
+	content, err := ioutil.ReadFile(filepath.Join(suite.testDir, "fred.txt"))
+	assert.NoError(suite.T(), err)
+	assert.NoError(suite.T(), ioutil.WriteFile(filepath.Join(outsideDir, "fred.txt"), content, 0644))
+	err = os.Symlink(filepath.Join(outsideDir, "fred.txt"), filepath.Join(suite.testDir, "a-link.txt"))
+	if nil != err {
+		suite.T().Skipf("symlinks not supported: %v", err)
+	}
+
+	run([]string{"main", "index", "--md5", "-d", suite.testDir, "-f", suite.indexDir, "--follow-symlinks"})
+	run([]string{"main", "find", "--md5", "-f", suite.indexDir, "--move-dir", suite.moveDir})
+
+	// the symlink is never kept, and moving it leaves its target alone
+	assert.FileExists(suite.T(), filepath.Join(suite.testDir, "fred.txt"))
+	assert.FileExists(suite.T(), filepath.Join(outsideDir, "fred.txt"))
+	info, err := os.Lstat(filepath.Join(suite.moveDir, "a-link.txt"))
+	assert.NoError(suite.T(), err)
+	assert.NotZero(suite.T(), info.Mode()&os.ModeSymlink)
+}
+
+func (suite *e2eTestSuite) Test_Main_Remove_Md5_Prune_Empty_Dirs() {
+	defer os.RemoveAll(suite.indexDir)
+	defer os.RemoveAll(suite.testDir)
+
+	assert.NoError(suite.T(), ioutil.WriteFile(filepath.Join(suite.testDir, "empty.txt"), []byte{}, 0644))
+	assert.NoError(suite.T(), ioutil.WriteFile(filepath.Join(suite.testDir, "empty-too.txt"), []byte{}, 0644))
+
+	run([]string{"main", "index", "--md5", "-d", suite.testDir, "-f", suite.indexDir})
+	run([]string{"main", "find", "--md5", "-f", suite.indexDir, "--remove", "--yes", "--prune-empty-dirs"})
+
+	assert.FileExists(suite.T(), filepath.Join(suite.testDir, "fred.txt"))
+	assert.NoDirExists(suite.T(), filepath.Join(suite.testDir, "bob"))
+	// empty files are not duplicates of each other
+	assert.FileExists(suite.T(), filepath.Join(suite.testDir, "empty.txt"))
+	assert.FileExists(suite.T(), filepath.Join(suite.testDir, "empty-too.txt"))
+}
+
+func (suite *e2eTestSuite) Test_Main_Remove_Md5_Requires_Confirmation() {
+	defer os.RemoveAll(suite.indexDir)
+	defer os.RemoveAll(suite.testDir)
+
+	run([]string{"main", "index", "--md5", "-d", suite.testDir, "-f", suite.indexDir})
+	// not run in a terminal, so it cannot ask
+	run([]string{"main", "find", "--md5", "-f", suite.indexDir, "--remove"})
+	assert.FileExists(suite.T(), filepath.Join(suite.testDir, "bob/freddy.txt"))
+
+	run([]string{"main", "find", "--md5", "-f", suite.indexDir, "--remove", "--dry-run"})
+	assert.FileExists(suite.T(), filepath.Join(suite.testDir, "bob/freddy.txt"))
+}
+
+func (suite *e2eTestSuite) Test_Main_Remove_Md5_Directories() {
+	defer os.RemoveAll(suite.indexDir)
+	defer os.RemoveAll(suite.testDir)
+
+	assert.NoError(suite.T(), copyTree(filepath.Join(suite.testDir, "bob"), filepath.Join(suite.testDir, "bob (1)")))
+
+	run([]string{"main", "index", "--md5", "-d", suite.testDir, "-f", suite.indexDir})
+	run([]string{"main", "find", "--md5", "-f", suite.indexDir, "--dirs", "--remove", "--yes"})
+
+	assert.FileExists(suite.T(), filepath.Join(suite.testDir, "bob/freddy.txt"))
+	assert.NoDirExists(suite.T(), filepath.Join(suite.testDir, "bob (1)"))
+	// only directories are acted on
+	assert.FileExists(suite.T(), filepath.Join(suite.testDir, "fred.txt"))
+}
+
+func (suite *e2eTestSuite) Test_Main_Move_Md5_Archives() {
+	defer os.RemoveAll(suite.indexDir)
+	defer os.RemoveAll(suite.testDir)
+
+	content, err := ioutil.ReadFile(filepath.Join(suite.testDir, "fred.txt"))
+	assert.NoError(suite.T(), err)
+	zipFile, err := os.Create(filepath.Join(suite.testDir, "backup.zip"))
+	assert.NoError(suite.T(), err)
+	zw := zip.NewWriter(zipFile)
+	w, err := zw.Create("fred.txt")
+	assert.NoError(suite.T(), err)
+	w.Write(content)
+	assert.NoError(suite.T(), zw.Close())
+	assert.NoError(suite.T(), zipFile.Close())
+
+	run([]string{"main", "index", "--md5", "-d", suite.testDir, "-f", suite.indexDir, "--archives"})
+	run([]string{"main", "find", "--md5", "-f", suite.indexDir, "--move-dir", suite.moveDir})
+
+	index, err := ioutil.ReadFile(filepath.Join(suite.indexDir, ".duplicate-index.json"))
+	assert.NoError(suite.T(), err)
+	assert.Contains(suite.T(), string(index), "backup.zip!/fred.txt")
+	// the archive is never touched
+	assert.FileExists(suite.T(), filepath.Join(suite.testDir, "backup.zip"))
+	assert.FileExists(suite.T(), filepath.Join(suite.testDir, "fred.txt"))
+	assert.FileExists(suite.T(), filepath.Join(suite.moveDir, "bob/freddy.txt"))
+}
+
+func (suite *e2eTestSuite) Test_Main_Emit_Script_Link() {
+	defer os.RemoveAll(suite.indexDir)
+	defer os.RemoveAll(suite.testDir)
+	if _, err := exec.LookPath("bash"); nil != err {
+		suite.T().Skip("bash is not installed")
+	}
+
+	run([]string{"main", "index", "--md5", "-d", suite.testDir, "-f", suite.indexDir})
+	script := captureStdout(suite.T(), func() {
+		run([]string{"main", "find", "--md5", "-f", suite.indexDir, "--link", "--emit-script", "bash"})
+	})
+
+	assert.Contains(suite.T(), script, "# keep '"+filepath.Join(suite.testDir, "fred.txt")+"'")
+	// nothing is changed until the script is run
+	before, err := os.Stat(filepath.Join(suite.testDir, "bob/freddy.txt"))
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), os.SameFile(before, statFile(suite.T(), filepath.Join(suite.testDir, "fred.txt"))))
+
+	output, err := exec.Command("bash", "-c", script).CombinedOutput()
+
+	assert.NoError(suite.T(), err, string(output))
+	assert.True(suite.T(), os.SameFile(
+		statFile(suite.T(), filepath.Join(suite.testDir, "bob/freddy.txt")),
+		statFile(suite.T(), filepath.Join(suite.testDir, "fred.txt"))))
+}
+
+func (suite *e2eTestSuite) Test_Main_Emit_Script_Requires_Action() {
+	defer os.RemoveAll(suite.indexDir)
+	defer os.RemoveAll(suite.testDir)
+
+	run([]string{"main", "index", "--md5", "-d", suite.testDir, "-f", suite.indexDir})
+	output := captureStdout(suite.T(), func() {
+		run([]string{"main", "find", "--md5", "-f", suite.indexDir, "--emit-script", "powershell"})
+	})
+
+	assert.Contains(suite.T(), output, "[--emit-script] requires one of")
+}
+
+func statFile(t *testing.T, path string) os.FileInfo {
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+
+	return info
+}
+
+// captureStdout returns what fun writes to stdout.
+func captureStdout(t *testing.T, fun func()) string {
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+
+	fun()
+	os.Stdout = stdout
+	w.Close()
+
+	return <-out
+}
+
 func assertFileExist(path string) bool {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -205,3 +465,14 @@ func copyTree(src, dst string) error {
 	}
 	return nil
 }
+
+func Test_Image_Hash_Kinds(t *testing.T) {
+	kinds, err := imageHashKinds([]string{"difference", "Perception:16"}, 4)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []deduper.ImageHashKind{{Algorithm: deduper.ImageAlgorithmDifference, Size: 4}, {Algorithm: deduper.ImageAlgorithmPerception, Size: 16}}, kinds)
+	for _, invalid := range []string{"median", "average:x", "average:12"} {
+		_, err = imageHashKinds([]string{invalid}, 8)
+		assert.Error(t, err, invalid)
+	}
+}
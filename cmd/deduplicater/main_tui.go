@@ -0,0 +1,662 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiReverse = "\x1b[7m"
+	// the alternate screen keeps the output of find intact, and the hidden cursor does not flicker while redrawing
+	ansiEnterScreen = "\x1b[?1049h\x1b[?25l\x1b[2J"
+	ansiLeaveScreen = "\x1b[?25h\x1b[?1049l"
+	ansiHome        = "\x1b[H"
+
+	tuiPageSize = 10
+)
+
+type tuiScreen int
+
+const (
+	screenBrowse tuiScreen = iota
+	screenSearch
+	screenConfirm
+)
+
+type tuiFocus int
+
+const (
+	focusGroups tuiFocus = iota
+	focusMembers
+)
+
+// groupDecision is what the reviewer decided to do with a group. Only marked groups are acted on.
+type groupDecision int
+
+const (
+	decisionPending groupDecision = iota
+	decisionMarked
+	decisionSkipped
+)
+
+// groupActor acts on the reviewed groups, it is the Deduper.
+type groupActor interface {
+	IsDirExist(target string) error
+	MoveDuplicates(groups []deduper.DuplicateGroup, target string) error
+	DeleteDuplicates(groups []deduper.DuplicateGroup) error
+}
+
+// reviewedGroup is a group of duplicates with the keeper chosen by the reviewer.
+type reviewedGroup struct {
+	deduper.DuplicateGroup
+	decision groupDecision
+}
+
+// reclaimable is the number of bytes freed by removing all members except the chosen keeper and archive members.
+func (g reviewedGroup) reclaimable() int64 {
+	var reclaimable int64
+	for _, m := range g.Duplicates() {
+		if "" == m.Archive {
+			reclaimable += m.Size
+		}
+	}
+
+	return reclaimable
+}
+
+// tuiModel is the state of the terminal UI to review groups of duplicates. It is updated by keys, and drawn by view.
+type tuiModel struct {
+	actor  groupActor
+	groups []reviewedGroup
+	// shown are the indexes of the groups that match the search
+	shown []int
+	// selected is the index in shown of the selected group
+	selected int
+	// member is the index of the selected member of the selected group
+	member int
+	focus  tuiFocus
+	screen tuiScreen
+	search string
+	// action and target to move to, chosen on the confirm screen
+	action FindAction
+	target string
+	// message is an error or hint shown in the status line
+	message string
+	// result summarises what was done, once done
+	result string
+	done   bool
+}
+
+func newTuiModel(actor groupActor, groups []deduper.DuplicateGroup) *tuiModel {
+	m := &tuiModel{actor: actor, action: Move}
+	for _, g := range deduper.FilterGroups(groups, deduper.CategoryDuplicate, deduper.CategoryDirectory) {
+		m.groups = append(m.groups, reviewedGroup{DuplicateGroup: g})
+	}
+	m.filter()
+
+	return m
+}
+
+// reviewDuplicates shows the terminal UI on stdin and stdout until the reviewer quits, or acts on the groups they
+// marked. Returns a summary of what was done.
+func reviewDuplicates(actor groupActor, groups []deduper.DuplicateGroup) (string, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := readline.MakeRaw(fd)
+	if nil != err {
+		return "", fmt.Errorf("error starting the terminal UI: %w\n", err)
+	}
+	defer readline.Restore(fd, state)
+
+	size := func() (int, int) {
+		width, height, err := readline.GetSize(int(os.Stdout.Fd()))
+		if nil != err || width <= 0 || height <= 0 {
+			return 80, 24
+		}
+		return width, height
+	}
+	m := newTuiModel(actor, groups)
+	if err := runTui(m, os.Stdin, os.Stdout, size); nil != err {
+		return "", fmt.Errorf("error reading keys: %w\n", err)
+	}
+
+	return m.result, nil
+}
+
+// runTui redraws the UI after every key read from in, until the model is done or in is closed.
+func runTui(m *tuiModel, in io.Reader, out io.Writer, size func() (int, int)) error {
+	fmt.Fprint(out, ansiEnterScreen)
+	defer fmt.Fprint(out, ansiLeaveScreen)
+
+	buf := make([]byte, 256)
+	for !m.done {
+		width, height := size()
+		fmt.Fprint(out, ansiHome+strings.Join(m.view(width, height), "\r\n"))
+
+		n, err := in.Read(buf)
+		for _, k := range parseKeys(buf[:n]) {
+			if m.update(k); m.done {
+				break
+			}
+		}
+		if io.EOF == err {
+			break
+		}
+		if nil != err {
+			return err
+		}
+	}
+	if !m.done {
+		m.quit()
+	}
+
+	return nil
+}
+
+// parseKeys turns the bytes read from a terminal in raw mode into key names, e.g. "up", "enter" or "ctrl+c",
+// or the character typed.
+func parseKeys(b []byte) []string {
+	sequences := map[string]string{
+		"\x1b[A": "up", "\x1b[B": "down", "\x1b[C": "right", "\x1b[D": "left",
+		"\x1bOA": "up", "\x1bOB": "down", "\x1bOC": "right", "\x1bOD": "left",
+		"\x1b[H": "home", "\x1b[F": "end", "\x1b[1~": "home", "\x1b[4~": "end",
+		"\x1b[5~": "pgup", "\x1b[6~": "pgdown", "\x1b[3~": "delete",
+	}
+	keys := []string{}
+	for len(b) > 0 {
+		if '\x1b' == b[0] {
+			found := false
+			for seq, name := range sequences {
+				if strings.HasPrefix(string(b), seq) {
+					keys = append(keys, name)
+					b = b[len(seq):]
+					found = true
+					break
+				}
+			}
+			if !found {
+				keys = append(keys, "esc")
+				b = b[1:]
+			}
+			continue
+		}
+
+		r, n := utf8.DecodeRune(b)
+		b = b[n:]
+		switch r {
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case '\t':
+			keys = append(keys, "tab")
+		case '\x7f', '\b':
+			keys = append(keys, "backspace")
+		case '\x03':
+			keys = append(keys, "ctrl+c")
+		default:
+			if unicode.IsPrint(r) {
+				keys = append(keys, string(r))
+			}
+		}
+	}
+
+	return keys
+}
+
+// update applies the key to the model.
+func (m *tuiModel) update(key string) {
+	if "ctrl+c" == key {
+		m.quit()
+		return
+	}
+	m.message = ""
+
+	switch m.screen {
+	case screenSearch:
+		m.updateSearch(key)
+	case screenConfirm:
+		m.updateConfirm(key)
+	default:
+		m.updateBrowse(key)
+	}
+}
+
+func (m *tuiModel) updateBrowse(key string) {
+	switch key {
+	case "q":
+		m.quit()
+	case "up":
+		m.move(-1)
+	case "down":
+		m.move(1)
+	case "pgup":
+		m.move(-tuiPageSize)
+	case "pgdown":
+		m.move(tuiPageSize)
+	case "home":
+		m.move(-len(m.groups))
+	case "end":
+		m.move(len(m.groups))
+	case "tab":
+		m.focus = 1 - m.focus
+	case "left":
+		m.focus = focusGroups
+	case "right":
+		m.focus = focusMembers
+	case "enter", " ":
+		if focusMembers == m.focus {
+			m.keep(m.member)
+		} else {
+			m.focus = focusMembers
+		}
+	case "m":
+		m.decide(decisionMarked)
+	case "s":
+		m.decide(decisionSkipped)
+	case "a":
+		for _, i := range m.shown {
+			if decisionSkipped != m.groups[i].decision {
+				m.groups[i].decision = decisionMarked
+			}
+		}
+	case "/":
+		m.screen = screenSearch
+	case "esc":
+		m.search = ""
+		m.filter()
+	case "x":
+		if 0 == len(m.marked()) {
+			m.message = "Mark groups to act on first, with m, or a for all groups shown"
+			return
+		}
+		m.screen = screenConfirm
+	default:
+		// 1 to 9 keep that member
+		if r, _ := utf8.DecodeRuneInString(key); 1 == len(key) && r >= '1' && r <= '9' {
+			m.keep(int(r - '1'))
+		}
+	}
+}
+
+func (m *tuiModel) updateSearch(key string) {
+	switch key {
+	case "enter":
+		m.screen = screenBrowse
+	case "esc":
+		m.search = ""
+		m.screen = screenBrowse
+	case "backspace":
+		m.search = trimLastRune(m.search)
+	default:
+		if 1 == utf8.RuneCountInString(key) {
+			m.search += key
+		}
+	}
+	m.filter()
+}
+
+func (m *tuiModel) updateConfirm(key string) {
+	switch key {
+	case "esc":
+		m.screen = screenBrowse
+	case "tab":
+		if Move == m.action {
+			m.action = Delete
+		} else {
+			m.action = Move
+		}
+	case "enter":
+		m.execute()
+	case "backspace":
+		if Move == m.action {
+			m.target = trimLastRune(m.target)
+		}
+	default:
+		if Move == m.action && 1 == utf8.RuneCountInString(key) {
+			m.target += key
+		}
+	}
+}
+
+func (m *tuiModel) quit() {
+	m.done = true
+	m.result = "Do Nothing"
+}
+
+// current returns the selected group, or nil if no groups are shown.
+func (m *tuiModel) current() *reviewedGroup {
+	if 0 == len(m.shown) {
+		return nil
+	}
+
+	return &m.groups[m.shown[m.selected]]
+}
+
+// move selects another group, or another member of the selected group when the members are focused.
+func (m *tuiModel) move(by int) {
+	g := m.current()
+	if nil == g {
+		return
+	}
+	if focusMembers == m.focus {
+		m.member = max(0, min(len(g.Members)-1, m.member+by))
+		return
+	}
+	m.selected = max(0, min(len(m.shown)-1, m.selected+by))
+	m.member = 0
+}
+
+// keep makes the member with index i the keeper of the selected group. Files in archives are never kept, as the
+// duplicates would be removed while the archive may be changed or removed as a whole.
+func (m *tuiModel) keep(i int) {
+	g := m.current()
+	if nil == g || i >= len(g.Members) {
+		return
+	}
+	m.member = i
+	if "" != g.Members[i].Archive {
+		m.message = "Files in archives cannot be kept, keep another file"
+		return
+	}
+	g.Keeper = g.Members[i].Path
+}
+
+// decide toggles the decision for the selected group, and selects the next group to review.
+func (m *tuiModel) decide(decision groupDecision) {
+	g := m.current()
+	if nil == g {
+		return
+	}
+	if decision == g.decision {
+		g.decision = decisionPending
+		return
+	}
+	g.decision = decision
+	focus := m.focus
+	m.focus = focusGroups
+	m.move(1)
+	m.focus = focus
+}
+
+// filter shows the groups with the search in their strategy, key or any of their paths, ignoring case.
+func (m *tuiModel) filter() {
+	search := strings.ToLower(m.search)
+	m.shown = []int{}
+	for i, g := range m.groups {
+		text := strings.ToLower(string(g.Strategy) + " " + g.Key + " " + strings.Join(g.Paths(), " "))
+		if strings.Contains(text, search) {
+			m.shown = append(m.shown, i)
+		}
+	}
+	m.selected = max(0, min(len(m.shown)-1, m.selected))
+	m.member = 0
+}
+
+// marked returns the groups to act on, with the chosen keepers.
+func (m *tuiModel) marked() []deduper.DuplicateGroup {
+	marked := []deduper.DuplicateGroup{}
+	for _, g := range m.groups {
+		if decisionMarked == g.decision {
+			marked = append(marked, g.DuplicateGroup)
+		}
+	}
+
+	return marked
+}
+
+// totals returns the number of groups with the decision, and the bytes removing their duplicates reclaims.
+func (m *tuiModel) totals(decision groupDecision) (int, int64) {
+	count, reclaimable := 0, int64(0)
+	for _, g := range m.groups {
+		if decision == g.decision {
+			count++
+			reclaimable += g.reclaimable()
+		}
+	}
+
+	return count, reclaimable
+}
+
+// execute moves or deletes the duplicates of the marked groups. Files in archives are never moved or deleted.
+func (m *tuiModel) execute() {
+	groups := m.marked()
+	_, reclaimable := m.totals(decisionMarked)
+	files := actedFiles(groups)
+
+	if Move == m.action {
+		if err := m.actor.IsDirExist(m.target); nil != err {
+			m.message = err.Error()
+			return
+		}
+		m.done = true
+		if err := m.actor.MoveDuplicates(groups, m.target); nil != err {
+			m.result = fmt.Sprintf("Failed to move files: %v", err)
+			return
+		}
+		m.result = fmt.Sprintf("Moved %v files of %v groups to %v, %v reclaimed", files, len(groups), m.target, formatBytes(reclaimable))
+		return
+	}
+
+	m.done = true
+	if err := m.actor.DeleteDuplicates(groups); nil != err {
+		m.result = fmt.Sprintf("Failed to delete files: %v", err)
+		return
+	}
+	m.result = fmt.Sprintf("Deleted %v files of %v groups, %v reclaimed", files, len(groups), formatBytes(reclaimable))
+}
+
+// view draws the model as height lines of width characters.
+func (m *tuiModel) view(width, height int) []string {
+	width, height = max(width, 20), max(height, 5)
+	marked, markedBytes := m.totals(decisionMarked)
+	skipped, _ := m.totals(decisionSkipped)
+	var total int64
+	for _, g := range m.groups {
+		total += g.reclaimable()
+	}
+	header := fmt.Sprintf(" %v groups, %v marked, %v skipped | %v of %v reclaimable", len(m.groups), marked, skipped, formatBytes(markedBytes), formatBytes(total))
+	if "" != m.search {
+		header += fmt.Sprintf(" | %v shown", len(m.shown))
+	}
+
+	bodyHeight := height - 3
+	var body []string
+	var help string
+	switch m.screen {
+	case screenConfirm:
+		body = m.confirmView(width, bodyHeight)
+		help = "enter execute  tab move/delete  esc back  ctrl+c quit"
+	default:
+		body = m.browseView(width, bodyHeight)
+		help = "↑↓ select  tab files  1-9/enter keep  m mark  s skip  a mark all  / search  x execute  q quit"
+	}
+
+	status := m.message
+	if screenSearch == m.screen {
+		status = "/" + m.search + "_"
+	} else if "" == status && "" != m.search {
+		status = fmt.Sprintf("search: %v (esc to clear)", m.search)
+	}
+
+	lines := []string{ansiReverse + fit(header, width) + ansiReset}
+	lines = append(lines, body...)
+	lines = append(lines, ansiBold+fit(status, width)+ansiReset, ansiReverse+fit(help, width)+ansiReset)
+
+	return lines
+}
+
+// browseView draws the list of groups next to the members of the selected group.
+func (m *tuiModel) browseView(width, height int) []string {
+	listWidth := max(20, width*2/5)
+	detailWidth := max(0, width-listWidth-1)
+
+	list := make([]string, height)
+	// keep the selected group in the middle when scrolling
+	top := max(0, min(len(m.shown)-height, m.selected-height/2))
+	for row := range height {
+		i := top + row
+		if i >= len(m.shown) {
+			list[row] = fit("", listWidth)
+			if 0 == len(m.shown) && 0 == row {
+				list[row] = fit(" No duplicates to review", listWidth)
+			}
+			continue
+		}
+		g := m.groups[m.shown[i]]
+		marker := map[groupDecision]string{decisionPending: "[ ]", decisionMarked: "[x]", decisionSkipped: "[-]"}[g.decision]
+		line := fit(fmt.Sprintf("%v %-9v %3v %9v %v", marker, g.Strategy, len(g.Members), formatBytes(g.reclaimable()), filepath.Base(g.Keeper)), listWidth)
+		if i == m.selected {
+			style := ansiBold
+			if focusGroups == m.focus {
+				style = ansiReverse
+			}
+			line = style + line + ansiReset
+		}
+		list[row] = line
+	}
+
+	detail := m.detailView(detailWidth)
+	lines := make([]string, height)
+	for row := range height {
+		line := list[row] + "│"
+		if row < len(detail) {
+			line += detail[row]
+		}
+		lines[row] = line
+	}
+
+	return lines
+}
+
+// detailView draws the members of the selected group, with their metadata.
+func (m *tuiModel) detailView(width int) []string {
+	g := m.current()
+	if nil == g || width < 10 {
+		return nil
+	}
+	noun := "files"
+	if deduper.CategoryDirectory == g.Category {
+		noun = "directories"
+	}
+	lines := []string{
+		fit(fmt.Sprintf(" [%v %v]", g.Strategy, g.Key), width),
+		fit(fmt.Sprintf(" %v %v, %v reclaimable", len(g.Members), noun, formatBytes(g.reclaimable())), width),
+		"",
+	}
+	for i, member := range g.Members {
+		role := "dupe"
+		if member.Path == g.Keeper {
+			role = "keep"
+		}
+		path := member.Path
+		if member.Symlink {
+			path = fmt.Sprintf("%v -> %v", member.Path, member.LinkTarget)
+		}
+		prefix := fmt.Sprintf(" %v %v ", i+1, role)
+		line := prefix + truncateLeft(path, max(4, width-len(prefix)))
+		if i == m.member && focusMembers == m.focus {
+			line = ansiReverse + fit(line, width) + ansiReset
+		} else if member.Path == g.Keeper {
+			line = ansiBold + fit(line, width) + ansiReset
+		}
+		lines = append(lines, line)
+
+		details := []string{formatBytes(member.Size)}
+		if nil != member.Image {
+			details = append(details, formatImage(*member.Image))
+		} else if !member.ModTime.IsZero() {
+			details = append(details, "modified "+member.ModTime.Format("2006-01-02 15:04"))
+		}
+		if "" != member.Transform {
+			details = append(details, member.Transform)
+		}
+		if "" != member.Archive {
+			details = append(details, "in archive")
+		}
+		lines = append(lines, fit("        "+strings.Join(details, ", "), width))
+		for _, l := range member.Links {
+			lines = append(lines, fit("        link "+truncateLeft(l, max(4, width-13)), width))
+		}
+	}
+
+	return lines
+}
+
+// confirmView draws what executing does to the marked groups.
+func (m *tuiModel) confirmView(width, height int) []string {
+	marked, reclaimable := m.totals(decisionMarked)
+	lines := []string{
+		"",
+		fmt.Sprintf(" %v groups marked, %v reclaimable. Groups that are not marked are left alone.", marked, formatBytes(reclaimable)),
+		"",
+	}
+	// the warning is bold
+	warning := -1
+	if Move == m.action {
+		lines = append(lines,
+			" Action: [move duplicates to another directory]  delete duplicates",
+			" Directory to move duplicates to: "+m.target+"_",
+		)
+	} else {
+		warning = len(lines) + 1
+		lines = append(lines,
+			" Action: move duplicates to another directory  [delete duplicates]",
+			" Duplicates are deleted permanently. THIS CANNOT BE UNDONE",
+		)
+	}
+	lines = append(lines, "")
+
+	verb := "move"
+	if Delete == m.action {
+		verb = "delete"
+	}
+	for _, g := range m.marked() {
+		lines = append(lines, " keep   "+truncateLeft(g.Keeper, max(4, width-8)))
+		for _, d := range g.Duplicates() {
+			if "" != d.Archive {
+				continue
+			}
+			for _, p := range d.AllPaths() {
+				lines = append(lines, fmt.Sprintf("   %-7v%v", verb, truncateLeft(p, max(4, width-10))))
+			}
+		}
+	}
+
+	for len(lines) < height {
+		lines = append(lines, "")
+	}
+	lines = lines[:height]
+	for i, l := range lines {
+		lines[i] = fit(l, width)
+		if i == warning {
+			lines[i] = ansiBold + lines[i] + ansiReset
+		}
+	}
+
+	return lines
+}
+
+// fit pads or truncates s to width characters.
+func fit(s string, width int) string {
+	n := utf8.RuneCountInString(s)
+	if n <= width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	runes := []rune(s)
+
+	return string(runes[:max(0, width-1)]) + "…"
+}
+
+func trimLastRune(s string) string {
+	_, n := utf8.DecodeLastRuneInString(s)
+
+	return s[:len(s)-n]
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+// fakeActor records the groups acted on.
+type fakeActor struct {
+	dirs    []string
+	moved   []deduper.DuplicateGroup
+	target  string
+	deleted []deduper.DuplicateGroup
+	err     error
+}
+
+func (a *fakeActor) IsDirExist(target string) error {
+	for _, d := range a.dirs {
+		if d == target {
+			return nil
+		}
+	}
+	return errors.New("Directory " + target + " does not exist")
+}
+
+func (a *fakeActor) MoveDuplicates(groups []deduper.DuplicateGroup, target string) error {
+	a.moved, a.target = groups, target
+	return a.err
+}
+
+func (a *fakeActor) DeleteDuplicates(groups []deduper.DuplicateGroup) error {
+	a.deleted = groups
+	return a.err
+}
+
+var tuiGroups = []deduper.DuplicateGroup{
+	testGroups[0],
+	{
+		Strategy: deduper.StrategyImageHash,
+		Category: deduper.CategoryDuplicate,
+		Key:      "ffff0000ffff0000",
+		Members: []deduper.GroupMember{
+			{IndexedFile: deduper.IndexedFile{Path: "photos/a.jpg", Size: 2048, Image: &deduper.ImageInfo{Width: 4000, Height: 3000}}},
+			{IndexedFile: deduper.IndexedFile{Path: "photos/copy/a.jpg", Size: 1024}, Links: []string{"photos/link/a.jpg"}},
+			{IndexedFile: deduper.IndexedFile{Path: "backup.zip!/a.jpg", Size: 1024, Archive: "backup.zip"}},
+		},
+		Keeper:      "photos/a.jpg",
+		Reclaimable: 1024,
+	},
+	{
+		Strategy: deduper.StrategyMd5,
+		Category: deduper.CategoryEmpty,
+		Key:      "d41d8cd98f00b204e9800998ecf8427e",
+		Members: []deduper.GroupMember{
+			{IndexedFile: deduper.IndexedFile{Path: "empty/1"}},
+			{IndexedFile: deduper.IndexedFile{Path: "empty/2"}},
+		},
+	},
+}
+
+func updateKeys(m *tuiModel, keys ...string) {
+	for _, k := range keys {
+		m.update(k)
+	}
+}
+
+func Test_Parse_Keys(t *testing.T) {
+	assert.Equal(t,
+		[]string{"up", "down", "right", "left", "pgdown", "esc", "q", "enter", "tab", "backspace", "ctrl+c", "é", "up"},
+		parseKeys([]byte("\x1b[A\x1b[B\x1b[C\x1b[D\x1b[6~\x1bq\r\t\x7f\x03é\x1bOA")))
+}
+
+func Test_Tui_Only_Reviews_Duplicates(t *testing.T) {
+	m := newTuiModel(&fakeActor{}, tuiGroups)
+
+	assert.Len(t, m.groups, 2)
+	assert.Equal(t, []int{0, 1}, m.shown)
+}
+
+func Test_Tui_Mark_And_Skip(t *testing.T) {
+	m := newTuiModel(&fakeActor{}, tuiGroups)
+
+	// marking selects the next group
+	updateKeys(m, "m", "s")
+
+	assert.Equal(t, decisionMarked, m.groups[0].decision)
+	assert.Equal(t, decisionSkipped, m.groups[1].decision)
+	assert.Equal(t, 1, m.selected)
+
+	// again to undo
+	updateKeys(m, "s")
+	assert.Equal(t, decisionPending, m.groups[1].decision)
+
+	updateKeys(m, "up", "s", "a")
+	assert.Equal(t, decisionSkipped, m.groups[0].decision)
+	assert.Equal(t, decisionMarked, m.groups[1].decision)
+}
+
+func Test_Tui_Keep_Other_Member(t *testing.T) {
+	m := newTuiModel(&fakeActor{}, tuiGroups)
+	updateKeys(m, "down")
+
+	updateKeys(m, "2")
+
+	assert.Equal(t, "photos/copy/a.jpg", m.groups[1].Keeper)
+	// archive members are not reclaimed
+	assert.Equal(t, int64(2048), m.groups[1].reclaimable())
+
+	updateKeys(m, "tab", "up", "enter")
+	assert.Equal(t, "photos/a.jpg", m.groups[1].Keeper)
+}
+
+func Test_Tui_Keep_Archive_Member(t *testing.T) {
+	m := newTuiModel(&fakeActor{}, tuiGroups)
+	updateKeys(m, "down")
+
+	updateKeys(m, "3")
+
+	assert.Equal(t, "photos/a.jpg", m.groups[1].Keeper)
+	assert.Contains(t, m.message, "cannot be kept")
+
+	updateKeys(m, "tab", "enter")
+	assert.Equal(t, "photos/a.jpg", m.groups[1].Keeper)
+}
+
+func Test_Tui_Search(t *testing.T) {
+	m := newTuiModel(&fakeActor{}, tuiGroups)
+
+	updateKeys(m, "/", "C", "o", "p", "y", "x", "backspace", "enter")
+
+	assert.Equal(t, "Copy", m.search)
+	assert.Equal(t, []int{1}, m.shown)
+	assert.Equal(t, screenBrowse, m.screen)
+
+	// marks the groups shown only
+	updateKeys(m, "a", "esc")
+	assert.Equal(t, []int{0, 1}, m.shown)
+	assert.Equal(t, decisionPending, m.groups[0].decision)
+	assert.Equal(t, decisionMarked, m.groups[1].decision)
+}
+
+func Test_Tui_Execute_Requires_Marked_Groups(t *testing.T) {
+	m := newTuiModel(&fakeActor{}, tuiGroups)
+
+	updateKeys(m, "x")
+
+	assert.Equal(t, screenBrowse, m.screen)
+	assert.Contains(t, m.message, "Mark groups")
+}
+
+func Test_Tui_Move(t *testing.T) {
+	actor := &fakeActor{dirs: []string{"moved"}}
+	m := newTuiModel(actor, tuiGroups)
+	updateKeys(m, "down", "2", "m", "x")
+	assert.Equal(t, screenConfirm, m.screen)
+
+	updateKeys(m, "m", "o", "v", "enter")
+	assert.False(t, m.done)
+	assert.Equal(t, "Directory mov does not exist", m.message)
+
+	updateKeys(m, "e", "d", "enter")
+
+	assert.True(t, m.done)
+	assert.Equal(t, "moved", actor.target)
+	assert.Len(t, actor.moved, 1)
+	assert.Equal(t, "photos/copy/a.jpg", actor.moved[0].Keeper)
+	assert.Equal(t, "Moved 1 files of 1 groups to moved, 2.0 KiB reclaimed", m.result)
+}
+
+func Test_Tui_Delete(t *testing.T) {
+	actor := &fakeActor{}
+	m := newTuiModel(actor, tuiGroups)
+
+	updateKeys(m, "down", "m", "x", "tab", "enter")
+
+	assert.True(t, m.done)
+	assert.Nil(t, actor.moved)
+	assert.Equal(t, []deduper.DuplicateGroup{tuiGroups[1]}, actor.deleted)
+	// the hard link is deleted too
+	assert.Equal(t, "Deleted 2 files of 1 groups, 1.0 KiB reclaimed", m.result)
+}
+
+func Test_Tui_Failed(t *testing.T) {
+	actor := &fakeActor{err: errors.New("disk full")}
+	m := newTuiModel(actor, tuiGroups)
+
+	updateKeys(m, "m", "x", "tab", "enter")
+
+	assert.True(t, m.done)
+	assert.Equal(t, "Failed to delete files: disk full", m.result)
+}
+
+func Test_Tui_Quit(t *testing.T) {
+	actor := &fakeActor{}
+	m := newTuiModel(actor, tuiGroups)
+
+	updateKeys(m, "a", "x", "ctrl+c")
+
+	assert.True(t, m.done)
+	assert.Equal(t, "Do Nothing", m.result)
+	assert.Nil(t, actor.deleted)
+	assert.Nil(t, actor.moved)
+}
+
+func Test_Tui_View(t *testing.T) {
+	m := newTuiModel(&fakeActor{}, tuiGroups)
+	updateKeys(m, "down", "m", "up")
+
+	lines := m.view(100, 12)
+
+	assert.Len(t, lines, 12)
+	assert.Contains(t, lines[0], "2 groups, 1 marked, 0 skipped | 1.0 KiB of 1.0 KiB reclaimable")
+	assert.Contains(t, lines[1], "[ ] md5")
+	assert.Contains(t, lines[1], "fred.txt")
+	assert.Contains(t, lines[2], "[x] imagehash")
+	assert.Contains(t, lines[1], "[md5 5d41402abc4b2a76b9719d911017c592]")
+	assert.Contains(t, lines[4], "1 keep test/fred.txt")
+	assert.Contains(t, lines[6], "2 dupe test/bob/freddy.txt")
+	assert.Contains(t, lines[11], "x execute")
+}
+
+func Test_Tui_Confirm_View(t *testing.T) {
+	m := newTuiModel(&fakeActor{}, tuiGroups)
+	updateKeys(m, "down", "m", "x", "tab")
+
+	view := strings.Join(m.view(100, 14), "\n")
+
+	assert.Contains(t, view, "1 groups marked, 1.0 KiB reclaimable")
+	assert.Contains(t, view, "[delete duplicates]")
+	assert.Contains(t, view, "THIS CANNOT BE UNDONE")
+	assert.Contains(t, view, "keep   photos/a.jpg")
+	assert.Contains(t, view, "delete photos/copy/a.jpg")
+	assert.Contains(t, view, "delete photos/link/a.jpg")
+	assert.NotContains(t, view, "backup.zip")
+}
+
+func Test_Run_Tui(t *testing.T) {
+	actor := &fakeActor{}
+	m := newTuiModel(actor, tuiGroups)
+	out := &bytes.Buffer{}
+
+	err := runTui(m, strings.NewReader("m\x1b[Bmx\t\r"), out, func() (int, int) { return 80, 24 })
+
+	assert.NoError(t, err)
+	assert.Len(t, actor.deleted, 2)
+	assert.True(t, strings.HasPrefix(out.String(), ansiEnterScreen))
+	assert.True(t, strings.HasSuffix(out.String(), ansiLeaveScreen))
+}
+
+func Test_Run_Tui_Closed_Input(t *testing.T) {
+	m := newTuiModel(&fakeActor{}, tuiGroups)
+
+	err := runTui(m, strings.NewReader("m"), &bytes.Buffer{}, func() (int, int) { return 80, 24 })
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Do Nothing", m.result)
+}
+
+func Test_Fit(t *testing.T) {
+	assert.Equal(t, "ab  ", fit("ab", 4))
+	assert.Equal(t, "abc…", fit("abcdef", 4))
+	assert.Equal(t, "é…", fit("éèê", 2))
+}
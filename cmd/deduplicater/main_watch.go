@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+// watchDir indexes dir and keeps the index up to date until interrupted, printing the changes and new duplicates.
+func watchDir(dedup deduper.Deduper, dir string) error {
+	stop := make(chan struct{})
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	defer signal.Stop(interrupted)
+	go func() {
+		<-interrupted
+		close(stop)
+	}()
+
+	return dedup.Watch(dir, stop, func(event deduper.WatchEvent) {
+		if err := printWatchEvent(os.Stdout, event); nil != err {
+			fmt.Printf("Failed writing changes: %v\n", err)
+		}
+	})
+}
+
+// printWatchEvent writes the changed files, and the groups of duplicates they are in.
+func printWatchEvent(w io.Writer, event deduper.WatchEvent) error {
+	for _, path := range event.Updated {
+		fmt.Fprintf(w, "updated %v\n", path)
+	}
+	for _, path := range event.Removed {
+		fmt.Fprintf(w, "removed %v\n", path)
+	}
+	renamed := []string{}
+	for from := range event.Renamed {
+		renamed = append(renamed, from)
+	}
+	sort.Strings(renamed)
+	for _, from := range renamed {
+		fmt.Fprintf(w, "renamed %v -> %v\n", from, event.Renamed[from])
+	}
+	if 0 == len(event.Groups) {
+		return nil
+	}
+
+	return newGroupFormatter("text").format(w, event.Groups)
+}
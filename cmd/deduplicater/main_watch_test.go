@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+func Test_Print_Watch_Event(t *testing.T) {
+	out := &bytes.Buffer{}
+
+	assert.NoError(t, printWatchEvent(out, deduper.WatchEvent{
+		Updated: []string{"test/new.jpg"},
+		Removed: []string{"test/gone.jpg"},
+		Renamed: map[string]string{"test/b": "test/c", "test/a": "test/d"},
+	}))
+
+	assert.Equal(t, `updated test/new.jpg
+removed test/gone.jpg
+renamed test/a -> test/d
+renamed test/b -> test/c
+`, out.String())
+}
@@ -1,11 +1,24 @@
 module github.com/driessamyn/deduplicater
 
-go 1.15
+go 1.24
 
 require (
 	github.com/akamensky/argparse v1.4.0
+	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e
 	github.com/corona10/goimagehash v1.1.0
-	github.com/manifoldco/promptui v0.9.0
+	github.com/mewkiz/flac v1.0.14
+	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/spf13/afero v1.9.5
 	github.com/stretchr/testify v1.8.2
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/icza/bitio v1.1.0 // indirect
+	github.com/mewkiz/pkg v0.0.0-20250417130911-3f050ff8c56d // indirect
+	github.com/mewpkg/term v0.0.0-20241026122259-37a80af23985 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
+	golang.org/x/text v0.3.7 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
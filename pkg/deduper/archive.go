@@ -0,0 +1,502 @@
+package deduper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// ARCHIVE_SEPARATOR separates the path of an archive from the path of a member in it, e.g. backup.zip!/photos/a.jpg
+const ARCHIVE_SEPARATOR = "!/"
+
+var errArchiveMember = errors.New("archive members are read only")
+
+// isArchive is true for files that are indexed as archives: zip, tar and gzipped tar files.
+func isArchive(filePath string) bool {
+	name := strings.ToLower(filePath)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitArchivePath returns the path of the archive and the member in it, ok is false if the path is not a member.
+func splitArchivePath(filePath string) (archive string, member string, ok bool) {
+	i := strings.Index(filePath, ARCHIVE_SEPARATOR)
+	if i < 0 {
+		return "", "", false
+	}
+
+	return filePath[:i], filePath[i+len(ARCHIVE_SEPARATOR):], true
+}
+
+func archiveMemberPath(archive string, member string) string {
+	return archive + ARCHIVE_SEPARATOR + member
+}
+
+// cleanMember normalises the name of a member, as archives may contain names like ./photos/a.jpg
+func cleanMember(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// archiveMemberInfo is the info of a member, passed by walkArchive, which records the archive it is in.
+type archiveMemberInfo struct {
+	os.FileInfo
+	archive string
+}
+
+// walkArchive calls fun for all regular files in the archive. Archives in the archive are not walked.
+// The info passed is an archiveMemberInfo.
+func walkArchive(fs afero.Fs, archive string, fun func(member string, info os.FileInfo)) error {
+	return scanArchive(fs, archive, func(member string, location memberLocation) {
+		fun(member, archiveMemberInfo{location.info, archive})
+	})
+}
+
+// memberLocation is where the content of a member is stored in its archive.
+type memberLocation struct {
+	info os.FileInfo
+	// offset of the content in the zip file, or in the tar after decompressing it
+	offset int64
+	// size of the content as stored, compressed in zip files
+	size int64
+	// method the content is compressed with in zip files
+	method uint16
+}
+
+// scanArchive calls fun for all regular files in the archive, with the location of their content.
+func scanArchive(fs afero.Fs, archive string, fun func(member string, location memberLocation)) error {
+	f, err := fs.Open(archive)
+	if nil != err {
+		return err
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		zr, err := newZipReader(f)
+		if nil != err {
+			return err
+		}
+		for _, zf := range zr.File {
+			if !zf.Mode().IsRegular() {
+				continue
+			}
+			offset, err := zf.DataOffset()
+			if nil != err {
+				return err
+			}
+			fun(cleanMember(zf.Name), memberLocation{zf.FileInfo(), offset, int64(zf.CompressedSize64), zf.Method})
+		}
+
+		return nil
+	}
+
+	r, err := decompressTar(archive, f)
+	if nil != err {
+		return err
+	}
+	// the tar reader reads no further than the header of a member, so the content starts at the bytes read so far
+	counter := &countingReader{r: r}
+	tr := tar.NewReader(counter)
+	for {
+		hdr, err := tr.Next()
+		if io.EOF == err {
+			return nil
+		}
+		if nil != err {
+			return err
+		}
+		if tar.TypeReg == hdr.Typeflag {
+			fun(cleanMember(hdr.Name), memberLocation{info: hdr.FileInfo(), offset: counter.n, size: hdr.Size})
+		}
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func newZipReader(f afero.File) (*zip.Reader, error) {
+	info, err := f.Stat()
+	if nil != err {
+		return nil, err
+	}
+
+	return zip.NewReader(f, info.Size())
+}
+
+func isCompressedTar(archive string) bool {
+	name := strings.ToLower(archive)
+	return strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// decompressTar decompresses the tar archive if needed.
+func decompressTar(archive string, r io.Reader) (io.Reader, error) {
+	if isCompressedTar(archive) {
+		return gzip.NewReader(r)
+	}
+
+	return r, nil
+}
+
+// archiveFs opens members of archives by their path, e.g. backup.zip!/photos/a.jpg, and all other files
+// from the wrapped file system. Members can only be read, and are read from the start to the end.
+// The locations of the members are found once per archive, so that opening all members does not read the archive
+// over and over. Gzipped tars cannot be read from a location, so those are read from member to member by a tarStream.
+type archiveFs struct {
+	afero.Fs
+	mu *sync.Mutex
+	// archives scanned, by path
+	archives map[string]*archiveMembers
+	// streams of the gzipped tars read last, the first one read last, the ones after maxOpenStreams are closed
+	streams *[]*tarStream
+}
+
+// archiveMembers are the locations of the members of an archive, of the size and time it had when scanned.
+type archiveMembers struct {
+	size    int64
+	modTime time.Time
+	members map[string]memberLocation
+	// stream of a gzipped tar
+	stream *tarStream
+}
+
+func newArchiveFs(fs afero.Fs) archiveFs {
+	return archiveFs{fs, &sync.Mutex{}, make(map[string]*archiveMembers), &[]*tarStream{}}
+}
+
+// member returns the archive and member of the path, ok is false if it is not the path of a member, including for
+// files in directories with names like backup.zip!
+func (a archiveFs) member(name string) (archive string, member string, ok bool) {
+	archive, member, ok = splitArchivePath(name)
+	if !ok {
+		return "", "", false
+	}
+	if _, err := a.Fs.Stat(name); nil == err {
+		return "", "", false
+	}
+
+	return archive, member, true
+}
+
+func (a archiveFs) Open(name string) (afero.File, error) {
+	archive, member, ok := a.member(name)
+	if !ok {
+		return a.Fs.Open(name)
+	}
+
+	f, err := a.Fs.Open(archive)
+	if nil != err {
+		return nil, err
+	}
+	content, info, err := a.openMember(archive, f, member)
+	if nil != err {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &archiveMember{content, name, info, f}, nil
+}
+
+func (a archiveFs) Stat(name string) (os.FileInfo, error) {
+	if _, _, ok := a.member(name); !ok {
+		return a.Fs.Stat(name)
+	}
+
+	f, err := a.Open(name)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Stat()
+}
+
+// members returns the locations of the members of the opened archive, scanning it if it was not scanned since it
+// last changed.
+func (a archiveFs) members(archive string, f afero.File) (*archiveMembers, error) {
+	info, err := f.Stat()
+	if nil != err {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if scanned, found := a.archives[archive]; found && scanned.size == info.Size() && scanned.modTime.Equal(info.ModTime()) {
+		return scanned, nil
+	}
+	scanned := &archiveMembers{info.Size(), info.ModTime(), make(map[string]memberLocation), nil}
+	err = scanArchive(a.Fs, archive, func(member string, location memberLocation) {
+		scanned.members[member] = location
+	})
+	if nil != err {
+		return nil, err
+	}
+	if isCompressedTar(archive) {
+		scanned.stream = &tarStream{}
+	}
+	a.archives[archive] = scanned
+
+	return scanned, nil
+}
+
+// readStream moves the stream to the front of the streams read last, and closes the ones after maxOpenStreams.
+func (a archiveFs) readStream(stream *tarStream) {
+	a.mu.Lock()
+	streams := []*tarStream{stream}
+	for _, s := range *a.streams {
+		if s != stream {
+			streams = append(streams, s)
+		}
+	}
+	var closed []*tarStream
+	if len(streams) > maxOpenStreams {
+		closed = streams[maxOpenStreams:]
+		streams = streams[:maxOpenStreams]
+	}
+	*a.streams = streams
+	a.mu.Unlock()
+
+	for _, s := range closed {
+		s.close()
+	}
+}
+
+func (a archiveFs) openMember(archive string, f afero.File, member string) (io.ReadCloser, os.FileInfo, error) {
+	scanned, err := a.members(archive, f)
+	if nil != err {
+		return nil, nil, err
+	}
+	location, found := scanned.members[member]
+	if !found {
+		return nil, nil, os.ErrNotExist
+	}
+
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		content := io.NewSectionReader(f, location.offset, location.size)
+		switch location.method {
+		case zip.Store:
+			return io.NopCloser(content), location.info, nil
+		case zip.Deflate:
+			return flate.NewReader(content), location.info, nil
+		default:
+			return nil, nil, zip.ErrAlgorithm
+		}
+	}
+
+	if !isCompressedTar(archive) {
+		if _, err := f.Seek(location.offset, io.SeekStart); nil != err {
+			return nil, nil, err
+		}
+		return io.NopCloser(io.LimitReader(f, location.size)), location.info, nil
+	}
+	a.readStream(scanned.stream)
+	content, err := scanned.stream.open(a.Fs, archive, location)
+	if nil != err {
+		return nil, nil, err
+	}
+
+	return content, location.info, nil
+}
+
+const (
+	// maxOpenStreams is the number of gzipped tars kept open to read their next members
+	maxOpenStreams = 2
+	// maxRecentMembers is the number of members of a gzipped tar kept after reading them
+	maxRecentMembers = 4
+	// maxRecentMemberSize is the size up to which members of a gzipped tar are kept after reading them
+	maxRecentMemberSize = 8 << 20
+)
+
+// tarStream is the decompressed content of a gzipped tar, read from member to member so that reading all the
+// members in order decompresses the archive once, and reading a member before the last one read starts over.
+// The members read last are kept if small, as each hasher reads them again, and members hashed concurrently
+// may be read out of order. Larger members are read from the stream, which is locked until they are closed.
+type tarStream struct {
+	mu   sync.Mutex
+	file afero.File
+	r    io.Reader
+	// pos is the offset of the stream in the decompressed tar
+	pos int64
+	// err is the last error reading the stream, after which it starts over
+	err    error
+	recent []recentMember
+}
+
+// recentMember is the content of a member read from a tarStream.
+type recentMember struct {
+	offset  int64
+	content []byte
+}
+
+// open returns the content of the member at the location, reading the archive from the fs if needed.
+func (s *tarStream) open(fs afero.Fs, archive string, location memberLocation) (io.ReadCloser, error) {
+	s.mu.Lock()
+	for _, recent := range s.recent {
+		if recent.offset == location.offset {
+			s.mu.Unlock()
+			return io.NopCloser(bytes.NewReader(recent.content)), nil
+		}
+	}
+
+	if err := s.seek(fs, archive, location.offset); nil != err {
+		s.reset()
+		s.mu.Unlock()
+		return nil, err
+	}
+	if location.size > maxRecentMemberSize {
+		return &tarStreamMember{io.LimitReader(s, location.size), s}, nil
+	}
+	content := make([]byte, location.size)
+	if _, err := io.ReadFull(s, content); nil != err {
+		s.reset()
+		s.mu.Unlock()
+		return nil, err
+	}
+	s.recent = append(s.recent, recentMember{location.offset, content})
+	if len(s.recent) > maxRecentMembers {
+		s.recent = s.recent[1:]
+	}
+	s.mu.Unlock()
+
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// seek moves the stream to the offset, starting over if it is past it.
+func (s *tarStream) seek(fs afero.Fs, archive string, offset int64) error {
+	if nil == s.file || nil != s.err || offset < s.pos {
+		s.reset()
+		f, err := fs.Open(archive)
+		if nil != err {
+			return err
+		}
+		s.file = f
+		if s.r, err = decompressTar(archive, f); nil != err {
+			return err
+		}
+	}
+	_, err := io.CopyN(io.Discard, s, offset-s.pos)
+
+	return err
+}
+
+func (s *tarStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.pos += int64(n)
+	if nil != err {
+		s.err = err
+	}
+
+	return n, err
+}
+
+// reset closes the archive, the next member is read from the start.
+func (s *tarStream) reset() {
+	if nil != s.file {
+		s.file.Close()
+	}
+	s.file, s.r, s.pos, s.err = nil, nil, 0, nil
+}
+
+// close closes the archive and drops the members kept.
+func (s *tarStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+	s.recent = nil
+}
+
+// tarStreamMember is a larger member read from a tarStream, which is unlocked when it is closed.
+type tarStreamMember struct {
+	io.Reader
+	stream *tarStream
+}
+
+func (m *tarStreamMember) Close() error {
+	m.stream.mu.Unlock()
+	return nil
+}
+
+// archiveMember is an open member of an archive, closing it closes the archive.
+type archiveMember struct {
+	content io.ReadCloser
+	name    string
+	info    os.FileInfo
+	archive io.Closer
+}
+
+func (m *archiveMember) Read(p []byte) (int, error) {
+	return m.content.Read(p)
+}
+
+func (m *archiveMember) Close() error {
+	m.content.Close()
+	return m.archive.Close()
+}
+
+func (m *archiveMember) Name() string {
+	return m.name
+}
+
+func (m *archiveMember) Stat() (os.FileInfo, error) {
+	return m.info, nil
+}
+
+func (m *archiveMember) ReadAt(p []byte, off int64) (int, error) {
+	return 0, errArchiveMember
+}
+
+func (m *archiveMember) Seek(offset int64, whence int) (int64, error) {
+	return 0, errArchiveMember
+}
+
+func (m *archiveMember) Write(p []byte) (int, error) {
+	return 0, errArchiveMember
+}
+
+func (m *archiveMember) WriteAt(p []byte, off int64) (int, error) {
+	return 0, errArchiveMember
+}
+
+func (m *archiveMember) WriteString(s string) (int, error) {
+	return 0, errArchiveMember
+}
+
+func (m *archiveMember) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, fmt.Errorf("%v is not a directory", m.name)
+}
+
+func (m *archiveMember) Readdirnames(n int) ([]string, error) {
+	return nil, fmt.Errorf("%v is not a directory", m.name)
+}
+
+func (m *archiveMember) Sync() error {
+	return nil
+}
+
+func (m *archiveMember) Truncate(size int64) error {
+	return errArchiveMember
+}
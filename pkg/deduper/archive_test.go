@@ -0,0 +1,356 @@
+package deduper
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+var archiveContent = map[string]string{
+	"photos/foo.txt": "content: foo",
+	"bar.txt":        "content: bar",
+}
+
+// zipArchive is a zip of the archive content.
+func zipArchive(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	zw.Create("photos/")
+	for _, member := range []string{"photos/foo.txt", "bar.txt"} {
+		w, err := zw.Create(member)
+		assert.NoError(t, err)
+		w.Write([]byte(archiveContent[member]))
+	}
+	assert.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+// tarArchive is a tar of the archive content, gzipped if compressed.
+func tarArchive(t *testing.T, compress bool) []byte {
+	buf := &bytes.Buffer{}
+	var w io.Writer = buf
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(buf)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	tw.WriteHeader(&tar.Header{Name: "./photos/", Typeflag: tar.TypeDir, Mode: 0755})
+	for _, member := range []string{"./photos/foo.txt", "bar.txt"} {
+		content := archiveContent[cleanMember(member)]
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: member, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		tw.Write([]byte(content))
+	}
+	assert.NoError(t, tw.Close())
+	if compress {
+		assert.NoError(t, gz.Close())
+	}
+
+	return buf.Bytes()
+}
+
+func archiveFixture(t *testing.T) afero.Fs {
+	fs := afero.NewMemMapFs()
+	writeFiles(t, fs, map[string][]byte{
+		"backup/backup.zip": zipArchive(t),
+		"backup/backup.tar": tarArchive(t, false),
+		"backup/backup.TGZ": tarArchive(t, true),
+	})
+
+	return fs
+}
+
+func Test_Is_Archive(t *testing.T) {
+	assert.True(t, isArchive("backup.zip"))
+	assert.True(t, isArchive("backup.tar"))
+	assert.True(t, isArchive("backup.tar.gz"))
+	assert.True(t, isArchive("BACKUP.TGZ"))
+	assert.False(t, isArchive("backup.gz"))
+	assert.False(t, isArchive("photo.jpg"))
+}
+
+func Test_Split_Archive_Path(t *testing.T) {
+	archive, member, ok := splitArchivePath("backup/backup.zip!/photos/foo.txt")
+
+	assert.True(t, ok)
+	assert.Equal(t, "backup/backup.zip", archive)
+	assert.Equal(t, "photos/foo.txt", member)
+
+	_, _, ok = splitArchivePath("backup/backup.zip")
+	assert.False(t, ok)
+}
+
+func Test_Walk_Archive(t *testing.T) {
+	fs := archiveFixture(t)
+
+	for _, archive := range []string{"backup/backup.zip", "backup/backup.tar", "backup/backup.TGZ"} {
+		members := map[string]int64{}
+		err := walkArchive(fs, archive, func(member string, info os.FileInfo) {
+			members[member] = info.Size()
+		})
+
+		assert.NoError(t, err, archive)
+		assert.Equal(t, map[string]int64{"photos/foo.txt": 12, "bar.txt": 12}, members, archive)
+	}
+}
+
+func Test_Walk_Archive_Invalid(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "backup.zip", []byte("not a zip"), 0644)
+
+	err := walkArchive(fs, "backup.zip", func(member string, info os.FileInfo) {})
+
+	assert.Error(t, err)
+}
+
+func Test_Archive_Fs_Open_Member(t *testing.T) {
+	fs := newArchiveFs(archiveFixture(t))
+
+	for _, archive := range []string{"backup/backup.zip", "backup/backup.tar", "backup/backup.TGZ"} {
+		f, err := fs.Open(archiveMemberPath(archive, "photos/foo.txt"))
+		assert.NoError(t, err, archive)
+		content, err := io.ReadAll(f)
+		assert.NoError(t, err, archive)
+		assert.Equal(t, "content: foo", string(content), archive)
+		info, err := f.Stat()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(12), info.Size())
+		_, err = f.Write([]byte("nope"))
+		assert.Error(t, err)
+		assert.NoError(t, f.Close())
+	}
+}
+
+// openCountingFs counts the files opened.
+type openCountingFs struct {
+	afero.Fs
+	opened map[string]int
+}
+
+func (c openCountingFs) Open(name string) (afero.File, error) {
+	c.opened[name]++
+	return c.Fs.Open(name)
+}
+
+func Test_Archive_Fs_Scans_Archive_Once(t *testing.T) {
+	counting := openCountingFs{archiveFixture(t), map[string]int{}}
+	fs := newArchiveFs(counting)
+
+	for _, archive := range []string{"backup/backup.zip", "backup/backup.tar", "backup/backup.TGZ"} {
+		// in the order of the archive, as gzipped tars are read again from the start for earlier members
+		for i := 0; i < 2; i++ {
+			for _, member := range []string{"photos/foo.txt", "bar.txt"} {
+				content, err := afero.ReadFile(fs, archiveMemberPath(archive, member))
+				assert.NoError(t, err, archive)
+				assert.Equal(t, archiveContent[member], string(content), archive)
+			}
+		}
+
+		// scanned once, then opened to read each member, and gzipped tars decompressed once to read the members
+		expected := 1 + 4
+		if isCompressedTar(archive) {
+			expected++
+		}
+		assert.Equal(t, expected, counting.opened[archive], archive)
+	}
+}
+
+// readCountingFs counts the bytes read from files.
+type readCountingFs struct {
+	afero.Fs
+	read map[string]int64
+}
+
+func (c readCountingFs) Open(name string) (afero.File, error) {
+	f, err := c.Fs.Open(name)
+	if nil != err {
+		return nil, err
+	}
+
+	return readCountingFile{f, c.read}, nil
+}
+
+type readCountingFile struct {
+	afero.File
+	read map[string]int64
+}
+
+func (f readCountingFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.read[f.Name()] += int64(n)
+
+	return n, err
+}
+
+// gzippedTar is a gzipped tar with the members in order.
+func gzippedTar(t *testing.T, members []string, content map[string][]byte) []byte {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, member := range members {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: member, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content[member]))}))
+		tw.Write(content[member])
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+
+	return buf.Bytes()
+}
+
+func Test_Archive_Fs_Decompresses_Gzipped_Tar_Once(t *testing.T) {
+	base := afero.NewMemMapFs()
+	random := rand.New(rand.NewSource(42))
+	var members []string
+	content := map[string][]byte{}
+	for i := 0; i < 50; i++ {
+		member := fmt.Sprintf("photos/%02d.jpg", i)
+		members = append(members, member)
+		content[member] = make([]byte, 16<<10)
+		random.Read(content[member])
+	}
+	writeFiles(t, base, map[string][]byte{"backup.tgz": gzippedTar(t, members, content)})
+	info, err := base.Stat("backup.tgz")
+	assert.NoError(t, err)
+	counting := readCountingFs{base, map[string]int64{}}
+	fs := newArchiveFs(counting)
+
+	// each member read by two hashers, the members after the first in order
+	for _, member := range members {
+		for i := 0; i < 2; i++ {
+			read, err := afero.ReadFile(fs, archiveMemberPath("backup.tgz", member))
+			assert.NoError(t, err, member)
+			assert.Equal(t, content[member], read, member)
+		}
+	}
+
+	// decompressed once to scan the archive, and once to read the members
+	assert.LessOrEqual(t, counting.read["backup.tgz"], 2*info.Size())
+}
+
+func Test_Archive_Fs_Gzipped_Tar_Out_Of_Order(t *testing.T) {
+	base := afero.NewMemMapFs()
+	members := []string{"a.jpg", "large.mp4", "b.jpg", "c.jpg", "d.jpg", "e.jpg", "f.jpg"}
+	content := map[string][]byte{}
+	for _, member := range members {
+		content[member] = []byte("content: " + member)
+	}
+	content["large.mp4"] = bytes.Repeat([]byte("large"), maxRecentMemberSize)
+	writeFiles(t, base, map[string][]byte{"backup.tgz": gzippedTar(t, members, content)})
+	fs := newArchiveFs(base)
+
+	// members kept, and members read again from the start of the archive when they are no longer kept
+	for _, member := range []string{"f.jpg", "large.mp4", "large.mp4", "a.jpg", "e.jpg", "b.jpg", "c.jpg", "d.jpg", "a.jpg", "f.jpg"} {
+		read, err := afero.ReadFile(fs, archiveMemberPath("backup.tgz", member))
+		assert.NoError(t, err, member)
+		assert.Equal(t, content[member], read, member)
+	}
+}
+
+func Test_Archive_Fs_Archive_Changed(t *testing.T) {
+	base := archiveFixture(t)
+	fs := newArchiveFs(base)
+	_, err := afero.ReadFile(fs, "backup/backup.tar!/bar.txt")
+	assert.NoError(t, err)
+	archiveContent["bar.txt"] = "changed: bar, and longer"
+	defer func() { archiveContent["bar.txt"] = "content: bar" }()
+	writeFiles(t, base, map[string][]byte{"backup/backup.tar": tarArchive(t, false)})
+
+	content, err := afero.ReadFile(fs, "backup/backup.tar!/bar.txt")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "changed: bar, and longer", string(content))
+}
+
+func Test_Archive_Fs_Stored_Zip_Member(t *testing.T) {
+	base := afero.NewMemMapFs()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "bar.txt", Method: zip.Store})
+	assert.NoError(t, err)
+	w.Write([]byte("content: bar"))
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, afero.WriteFile(base, "backup.zip", buf.Bytes(), 0644))
+
+	content, err := afero.ReadFile(newArchiveFs(base), "backup.zip!/bar.txt")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "content: bar", string(content))
+}
+
+func Test_Archive_Fs_Stat_Member(t *testing.T) {
+	fs := newArchiveFs(archiveFixture(t))
+
+	info, err := fs.Stat("backup/backup.tar!/bar.txt")
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), info.Size())
+}
+
+func Test_Archive_Fs_Member_Not_Found(t *testing.T) {
+	fs := newArchiveFs(archiveFixture(t))
+
+	_, err := fs.Open("backup/backup.zip!/fred.txt")
+
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_Archive_Fs_Other_Files(t *testing.T) {
+	fs := newArchiveFs(archiveFixture(t))
+	afero.WriteFile(fs, "foo.txt", []byte("content: foo"), 0644)
+
+	content, err := afero.ReadFile(fs, "foo.txt")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "content: foo", string(content))
+}
+
+func Test_Create_Archives(t *testing.T) {
+	fs := archiveFixture(t)
+	afero.WriteFile(fs, "backup/foo.txt", []byte("content: foo"), 0644)
+	o := defaultOptions()
+	WithArchives(true)(o)
+	index := newIndex([]IndexedFile{})
+
+	err := newIndexer(fs, "backup", index, o).Create("backup")
+
+	assert.NoError(t, err)
+	// 3 archives with 2 members each, and the loose file
+	assert.Equal(t, 10, index.Len())
+	member := index.ind[index.iMap["backup/backup.TGZ!/photos/foo.txt"]]
+	assert.Equal(t, "backup/backup.TGZ", member.Archive)
+	assert.Equal(t, int64(12), member.Size)
+
+	groups, err := newCompositeFinder(index, 0, DefaultImageHashKind, StrategyMd5).Find()
+	assert.NoError(t, err)
+	dupes := FilterGroups(groups, CategoryDuplicate)
+	assert.Len(t, dupes, 2)
+	// the loose file is kept, and archive members cannot be reclaimed
+	assert.Equal(t, "backup/foo.txt", dupes[1].Keeper)
+	assert.Len(t, dupes[1].Members, 4)
+	assert.Equal(t, int64(0), dupes[1].Reclaimable)
+}
+
+func Test_Create_Directory_Named_Like_Member(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "backup/backup.zip!/foo.txt", []byte("content: foo"), 0644)
+	o := defaultOptions()
+	WithArchives(true)(o)
+	index := newIndex([]IndexedFile{})
+
+	assert.NoError(t, newIndexer(fs, "backup", index, o).Create("backup"))
+
+	// a file in a directory, not in an archive
+	f, found := index.get("backup/backup.zip!/foo.txt")
+	assert.True(t, found)
+	assert.Empty(t, f.Archive)
+}
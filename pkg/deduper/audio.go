@@ -0,0 +1,410 @@
+package deduper
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// audio formats, by extension
+var audioFormats = map[string]string{
+	".mp3":  "mp3",
+	".aac":  "mp3", // ADTS streams are tagged like mp3 files
+	".flac": "flac",
+	".ogg":  "ogg",
+	".oga":  "ogg",
+	".opus": "ogg",
+	".m4a":  "mp4",
+	".m4b":  "mp4",
+	".wav":  "wav",
+}
+
+// errNotAudio is returned when a file cannot be read as the audio format its extension suggests.
+var errNotAudio = errors.New("not a supported audio file")
+
+// audioFormat returns the format of the audio file, or "" if it is not an audio file.
+func audioFormat(filePath string) string {
+	return audioFormats[strings.ToLower(filepath.Ext(filePath))]
+}
+
+// audioHasher hashes the audio in a file, ignoring its tags.
+// The checksum is the md5 of the audio payload, so only copies with the same encoded audio match.
+// The fingerprint describes how the audio sounds, so that re-encoded copies match as well,
+// and is only calculated for wav and flac files.
+type audioHasher struct {
+	fs          afero.Fs
+	fingerprint bool
+	logger      *slog.Logger
+}
+
+func (hasher audioHasher) hash(filePath string, fun func(f IndexedFile), errorFunc func(filePath string, err error), completeFun func()) {
+	format := audioFormat(filePath)
+	if "" == format || (hasher.fingerprint && !isPrintable(format)) {
+		completeFun()
+		return
+	}
+
+	// open file (and close it when done)
+	f, err := hasher.fs.Open(filePath)
+	if err != nil {
+		errorFunc(filePath, err)
+		completeFun()
+		return
+	}
+	defer f.Close()
+
+	hashed := IndexedFile{Path: filePath}
+	if hasher.fingerprint {
+		hashed.AudioPrint, err = audioPrint(f, format)
+	} else {
+		hashed.AudioChecksum, hashed.Size, err = audioChecksum(f, format)
+	}
+	if errors.Is(err, errNotAudio) {
+		hasher.logger.Debug("Skipping audio file, cannot read audio", "path", filePath, "error", err)
+	} else if nil != err {
+		errorFunc(filePath, err)
+	} else {
+		if 0 == hashed.Size {
+			if info, err := f.Stat(); nil == err {
+				hashed.Size = info.Size()
+			}
+		}
+		fun(hashed)
+	}
+
+	completeFun()
+}
+
+// audioChecksum returns the md5 checksum of the audio payload of the file, and the size of the file.
+func audioChecksum(f afero.File, format string) ([]byte, int64, error) {
+	r, size, err := seekable(f)
+	if nil != err {
+		return nil, 0, err
+	}
+
+	h := md5.New()
+	switch format {
+	case "mp3":
+		err = mp3Payload(r, size, h)
+	case "flac":
+		err = flacPayload(r, size, h)
+	case "ogg":
+		err = oggPayload(r, h)
+	case "mp4":
+		err = mp4Payload(r, size, h)
+	case "wav":
+		err = wavPayload(r, size, h)
+	}
+	if nil != err {
+		return nil, 0, err
+	}
+
+	return h.Sum(nil), size, nil
+}
+
+// seekable returns the file as a reader that can seek, and its size.
+// Files that cannot seek, like archive members, are read into memory.
+func seekable(f afero.File) (io.ReadSeeker, int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); nil != err {
+		data, err := io.ReadAll(f)
+		return bytes.NewReader(data), int64(len(data)), err
+	}
+
+	info, err := f.Stat()
+	if nil != err {
+		return nil, 0, err
+	}
+
+	return f, info.Size(), nil
+}
+
+// readAt reads len(p) bytes at offset off. Reading past the end is an errNotAudio, as the file is truncated.
+func readAt(r io.ReadSeeker, off int64, p []byte) error {
+	if _, err := r.Seek(off, io.SeekStart); nil != err {
+		return err
+	}
+	_, err := io.ReadFull(r, p)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: truncated at %v", errNotAudio, off)
+	}
+
+	return err
+}
+
+// copyRange writes n bytes from offset off to w.
+func copyRange(w io.Writer, r io.ReadSeeker, off int64, n int64) error {
+	if _, err := r.Seek(off, io.SeekStart); nil != err {
+		return err
+	}
+	_, err := io.CopyN(w, r, n)
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: truncated at %v", errNotAudio, off)
+	}
+
+	return err
+}
+
+// skipID3v2 returns the offset of the data after the ID3v2 tags at the start of the file, if any.
+func skipID3v2(r io.ReadSeeker, size int64) (int64, error) {
+	start := int64(0)
+	hdr := make([]byte, 10)
+	for start+10 <= size {
+		if err := readAt(r, start, hdr); nil != err {
+			return 0, err
+		}
+		if "ID3" != string(hdr[:3]) {
+			break
+		}
+		// the size excludes the header, and is stored in 4 bytes of 7 bits
+		tagSize := int64(hdr[6])<<21 | int64(hdr[7])<<14 | int64(hdr[8])<<7 | int64(hdr[9])
+		start += 10 + tagSize
+		if 0 != hdr[5]&0x10 {
+			// footer present
+			start += 10
+		}
+	}
+
+	return min(start, size), nil
+}
+
+// mp3Payload writes the audio frames, skipping ID3v2 tags at the start and APEv2 and ID3v1 tags at the end.
+func mp3Payload(r io.ReadSeeker, size int64, w io.Writer) error {
+	start, err := skipID3v2(r, size)
+	if nil != err {
+		return err
+	}
+
+	end := size
+	tag := make([]byte, 32)
+	if end-start >= 128 {
+		if err := readAt(r, end-128, tag[:3]); nil != err {
+			return err
+		}
+		if "TAG" == string(tag[:3]) {
+			end -= 128
+		}
+	}
+	if end-start >= 32 {
+		if err := readAt(r, end-32, tag); nil != err {
+			return err
+		}
+		if "APETAGEX" == string(tag[:8]) {
+			// the size includes the footer but not the header
+			tagSize := int64(binary.LittleEndian.Uint32(tag[12:16]))
+			if 0 != binary.LittleEndian.Uint32(tag[20:24])&(1<<31) {
+				tagSize += 32
+			}
+			end = max(start, end-tagSize)
+		}
+	}
+
+	return copyRange(w, r, start, end-start)
+}
+
+// flacPayload writes the audio frames, skipping all metadata blocks, including the Vorbis comments and pictures.
+func flacPayload(r io.ReadSeeker, size int64, w io.Writer) error {
+	pos, err := skipID3v2(r, size)
+	if nil != err {
+		return err
+	}
+	marker := make([]byte, 4)
+	if err := readAt(r, pos, marker); nil != err {
+		return err
+	}
+	if "fLaC" != string(marker) {
+		return fmt.Errorf("%w: no flac marker", errNotAudio)
+	}
+	pos += 4
+
+	hdr := make([]byte, 4)
+	for last := false; !last; {
+		if err := readAt(r, pos, hdr); nil != err {
+			return err
+		}
+		last = 0 != hdr[0]&0x80
+		pos += 4 + (int64(hdr[1])<<16 | int64(hdr[2])<<8 | int64(hdr[3]))
+	}
+	if pos > size {
+		return fmt.Errorf("%w: truncated metadata", errNotAudio)
+	}
+
+	return copyRange(w, r, pos, size-pos)
+}
+
+// oggPayload writes the packets of the first logical stream, except for the second packet: the comment header
+// of Vorbis and Opus streams. Pages are not written, as they change when the comments are edited.
+func oggPayload(r io.Reader, w io.Writer) error {
+	hdr := make([]byte, 27)
+	segments := make([]byte, 255)
+	var serial uint32
+	packet := 0
+	for page := 0; ; page++ {
+		_, err := io.ReadFull(r, hdr)
+		if 0 != page && io.EOF == err {
+			return nil
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: truncated page", errNotAudio)
+		} else if nil != err {
+			return err
+		}
+		if "OggS" != string(hdr[:4]) {
+			return fmt.Errorf("%w: no ogg page", errNotAudio)
+		}
+		if 0 == page {
+			serial = binary.LittleEndian.Uint32(hdr[14:18])
+		}
+
+		table := segments[:hdr[26]]
+		if _, err := io.ReadFull(r, table); nil != err {
+			return fmt.Errorf("%w: truncated page", errNotAudio)
+		}
+		length := 0
+		for _, l := range table {
+			length += int(l)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); nil != err {
+			return fmt.Errorf("%w: truncated page", errNotAudio)
+		}
+		if serial != binary.LittleEndian.Uint32(hdr[14:18]) {
+			continue
+		}
+
+		// a packet ends with a segment shorter than 255 bytes
+		off := 0
+		for _, l := range table {
+			if 1 != packet {
+				w.Write(payload[off : off+int(l)])
+			}
+			off += int(l)
+			if l < 255 {
+				packet++
+			}
+		}
+	}
+}
+
+// mp4Payload writes the content of the media data atoms. All other atoms are skipped, including the metadata in the
+// movie atom.
+func mp4Payload(r io.ReadSeeker, size int64, w io.Writer) error {
+	hdr := make([]byte, 16)
+	mdat := false
+	for pos := int64(0); pos < size; {
+		if err := readAt(r, pos, hdr[:8]); nil != err {
+			return err
+		}
+		boxType := string(hdr[4:8])
+		if 0 == pos && "ftyp" != boxType {
+			return fmt.Errorf("%w: no ftyp atom", errNotAudio)
+		}
+		boxSize := int64(binary.BigEndian.Uint32(hdr[:4]))
+		hdrSize := int64(8)
+		switch boxSize {
+		case 0:
+			// the last atom, up to the end of the file
+			boxSize = size - pos
+		case 1:
+			if err := readAt(r, pos+8, hdr[8:]); nil != err {
+				return err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(hdr[8:]))
+			hdrSize = 16
+		}
+		if boxSize < hdrSize || boxSize > size-pos {
+			return fmt.Errorf("%w: invalid size of %v atom", errNotAudio, boxType)
+		}
+
+		if "mdat" == boxType {
+			mdat = true
+			if err := copyRange(w, r, pos+hdrSize, boxSize-hdrSize); nil != err {
+				return err
+			}
+		}
+		pos += boxSize
+	}
+	if !mdat {
+		return fmt.Errorf("%w: no mdat atom", errNotAudio)
+	}
+
+	return nil
+}
+
+// wavPayload writes the format and data chunks, skipping all other chunks like LIST and id3 tags.
+func wavPayload(r io.ReadSeeker, size int64, w io.Writer) error {
+	return walkWav(r, size, func(id string, off int64, n int64) error {
+		if "fmt " == id || "data" == id {
+			return copyRange(w, r, off, n)
+		}
+		return nil
+	})
+}
+
+// walkWav calls fun with the offset and size of every chunk in the RIFF file.
+func walkWav(r io.ReadSeeker, size int64, fun func(id string, off int64, n int64) error) error {
+	hdr := make([]byte, 12)
+	if err := readAt(r, 0, hdr); nil != err {
+		return err
+	}
+	if "RIFF" != string(hdr[:4]) || "WAVE" != string(hdr[8:12]) {
+		return fmt.Errorf("%w: no wave header", errNotAudio)
+	}
+
+	for pos := int64(12); pos+8 <= size; {
+		if err := readAt(r, pos, hdr[:8]); nil != err {
+			return err
+		}
+		// the size of the data chunk is not always set when streaming
+		n := min(int64(binary.LittleEndian.Uint32(hdr[4:8])), size-pos-8)
+		if err := fun(string(hdr[:4]), pos+8, n); nil != err {
+			return err
+		}
+		// chunks are padded to an even size
+		pos += 8 + n + n&1
+	}
+
+	return nil
+}
+
+// audioHashFinder finds files with the same audio, regardless of their tags.
+type audioHashFinder struct {
+	index *Index
+}
+
+func (finder audioHashFinder) Find() ([]DuplicateGroup, error) {
+	dupes := make(map[string][]GroupMember)
+	keys := []string{}
+	hashed := []IndexedFile{}
+	for _, v := range finder.index.Files() {
+		if nil == v.AudioChecksum {
+			// not an audio file
+			continue
+		}
+		hashed = append(hashed, v)
+		key := string(v.AudioChecksum)
+		if _, found := dupes[key]; !found {
+			keys = append(keys, key)
+		}
+		dupes[key] = append(dupes[key], GroupMember{IndexedFile: v})
+	}
+
+	all := linkedGroups(StrategyAudioHash, hashed)
+	for _, key := range keys {
+		// hard links to the same file are a single copy
+		if members := collapseLinks(dupes[key]); len(members) > 1 {
+			all = append(all, newDuplicateGroup(StrategyAudioHash, hex.EncodeToString([]byte(key)), members))
+		}
+	}
+	sortGroups(all)
+
+	return all, nil
+}
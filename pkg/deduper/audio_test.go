@@ -0,0 +1,252 @@
+package deduper
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// audioFrames is the fake encoded audio in the test files
+var audioFrames = bytes.Repeat([]byte{0xff, 0xfb, 0x90, 0x64, 1, 2, 3}, 300)
+
+func id3v2(content string, footer bool) []byte {
+	tag := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, byte(len(content))}
+	if footer {
+		tag[5] = 0x10
+	}
+	tag = append(tag, content...)
+	if footer {
+		tag = append(tag, []byte{'3', 'D', 'I', 4, 0, 0x10, 0, 0, 0, byte(len(content))}...)
+	}
+
+	return tag
+}
+
+func id3v1(title string) []byte {
+	tag := make([]byte, 128)
+	copy(tag, "TAG"+title)
+
+	return tag
+}
+
+func apev2(content string) []byte {
+	header := make([]byte, 32)
+	copy(header, "APETAGEX")
+	binary.LittleEndian.PutUint32(header[12:16], uint32(len(content)+32))
+	binary.LittleEndian.PutUint32(header[20:24], 1<<31)
+	footer := bytes.Clone(header)
+
+	return append(append(header, content...), footer...)
+}
+
+func flacBlock(blockType byte, last bool, content []byte) []byte {
+	if last {
+		blockType |= 0x80
+	}
+	n := len(content)
+
+	return append([]byte{blockType, byte(n >> 16), byte(n >> 8), byte(n)}, content...)
+}
+
+// oggPage writes a page with the given packets, the last one is continued on the next page if open is set.
+func oggPage(serial uint32, open bool, packets ...[]byte) []byte {
+	hdr := make([]byte, 27)
+	copy(hdr, "OggS")
+	binary.LittleEndian.PutUint32(hdr[14:18], serial)
+	table := []byte{}
+	payload := []byte{}
+	for i, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			table = append(table, 255)
+		}
+		if !open || i != len(packets)-1 {
+			table = append(table, byte(n))
+		}
+		payload = append(payload, p...)
+	}
+	hdr[26] = byte(len(table))
+
+	return append(append(hdr, table...), payload...)
+}
+
+func mp4Box(boxType string, content []byte) []byte {
+	box := binary.BigEndian.AppendUint32(nil, uint32(len(content)+8))
+
+	return append(append(box, boxType...), content...)
+}
+
+func wavChunk(id string, content []byte) []byte {
+	chunk := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(content)))...)
+	chunk = append(chunk, content...)
+	if 1 == len(content)%2 {
+		chunk = append(chunk, 0)
+	}
+
+	return chunk
+}
+
+func riff(chunks ...[]byte) []byte {
+	content := []byte("WAVE")
+	for _, c := range chunks {
+		content = append(content, c...)
+	}
+
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(content)))...), content...)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// audioFixture has the same audio with different tags in each format, and a different track.
+func audioFixture(t *testing.T) afero.Fs {
+	fs := afero.NewMemMapFs()
+	streamInfo := make([]byte, 34)
+	longPacket := bytes.Repeat([]byte("vorbis"), 100)
+	wavFormat := []byte{1, 0, 1, 0, 0x44, 0xac, 0, 0, 0x88, 0x58, 1, 0, 2, 0, 16, 0}
+	ftyp := mp4Box("ftyp", []byte("M4A mp42isom"))
+	writeFiles(t, fs, map[string][]byte{
+		"music/track.mp3":          concat(id3v2("title=Track", false), audioFrames, id3v1("Track")),
+		"music/copy/track.MP3":     concat(id3v2("title=Track (remastered)", true), id3v2("more", false), audioFrames, apev2("artist=Someone"), id3v1("Track 2")),
+		"music/untagged.mp3":       audioFrames,
+		"music/other.mp3":          concat(id3v2("title=Track", false), audioFrames[1:]),
+		"music/track.flac":         concat([]byte("fLaC"), flacBlock(0, false, streamInfo), flacBlock(4, true, []byte("title=Track")), audioFrames),
+		"music/copy/track.flac":    concat(id3v2("old", false), []byte("fLaC"), flacBlock(0, false, streamInfo), flacBlock(4, false, []byte("title=Track 2")), flacBlock(6, false, []byte("picture")), flacBlock(1, true, make([]byte, 100)), audioFrames),
+		"music/track.ogg":          concat(oggPage(7, false, []byte("id")), oggPage(7, false, []byte("comment: Track")), oggPage(7, true, []byte("setup"), longPacket[:510]), oggPage(7, false, longPacket[510:], audioFrames[:200])),
+		"music/copy/track.ogg":     concat(oggPage(7, false, []byte("id")), oggPage(7, false, []byte("comment: longer title"), []byte("setup"), longPacket), oggPage(8, false, []byte("other stream")), oggPage(7, false, audioFrames[:200])),
+		"music/track.m4a":          concat(ftyp, mp4Box("moov", mp4Box("udta", []byte("title=Track"))), mp4Box("mdat", audioFrames)),
+		"music/copy/track.m4a":     concat(ftyp, mp4Box("mdat", audioFrames), mp4Box("moov", mp4Box("udta", []byte("title=Track (remastered)")))),
+		"music/track.wav":          riff(wavChunk("fmt ", wavFormat), wavChunk("LIST", []byte("INFOtitle")), wavChunk("data", audioFrames)),
+		"music/copy/track.wav":     riff(wavChunk("fmt ", wavFormat), wavChunk("data", audioFrames), wavChunk("id3 ", id3v2("title=Track", false))),
+		"music/broken.flac":        []byte("not flac"),
+		"music/broken.m4a":         concat(ftyp, mp4Box("moov", nil)),
+		"music/huge-atom.m4a":      concat(ftyp, []byte{0, 0, 0, 1}, []byte("moov"), binary.BigEndian.AppendUint64(nil, math.MaxInt64-8), mp4Box("mdat", audioFrames)),
+		"music/truncated.ogg":      oggPage(7, false, []byte("id"))[:20],
+		"music/not-audio/info.txt": []byte("not audio"),
+	})
+
+	return fs
+}
+
+func hashAudio(t *testing.T, hasher audioHasher, filePath string) (IndexedFile, bool) {
+	var hashed IndexedFile
+	found := false
+	completed := false
+	hasher.hash(filePath, func(f IndexedFile) {
+		hashed = f
+		found = true
+	}, func(filePath string, err error) {
+		assert.Fail(t, "unexpected error", err)
+	}, func() {
+		completed = true
+	})
+	assert.True(t, completed, filePath)
+
+	return hashed, found
+}
+
+func Test_Audio_Format(t *testing.T) {
+	assert.Equal(t, "mp3", audioFormat("track.MP3"))
+	assert.Equal(t, "ogg", audioFormat("track.opus"))
+	assert.Equal(t, "mp4", audioFormat("track.m4a"))
+	assert.Equal(t, "", audioFormat("track.mp4"))
+	assert.Equal(t, "", audioFormat("photo.jpg"))
+}
+
+func Test_Audio_Hasher_Ignores_Tags(t *testing.T) {
+	fs := audioFixture(t)
+	hasher := audioHasher{fs, false, discardLogger()}
+
+	for _, pair := range [][]string{
+		{"music/track.mp3", "music/copy/track.MP3"},
+		{"music/track.mp3", "music/untagged.mp3"},
+		{"music/track.flac", "music/copy/track.flac"},
+		{"music/track.ogg", "music/copy/track.ogg"},
+		{"music/track.m4a", "music/copy/track.m4a"},
+		{"music/track.wav", "music/copy/track.wav"},
+	} {
+		a, found := hashAudio(t, hasher, pair[0])
+		assert.True(t, found, pair[0])
+		b, found := hashAudio(t, hasher, pair[1])
+		assert.True(t, found, pair[1])
+		assert.Len(t, a.AudioChecksum, 16)
+		assert.Equal(t, a.AudioChecksum, b.AudioChecksum, pair)
+		assert.NotZero(t, a.Size)
+	}
+
+	track, _ := hashAudio(t, hasher, "music/track.mp3")
+	other, _ := hashAudio(t, hasher, "music/other.mp3")
+	assert.NotEqual(t, track.AudioChecksum, other.AudioChecksum)
+}
+
+func Test_Audio_Hasher_Skips(t *testing.T) {
+	fs := audioFixture(t)
+	hasher := audioHasher{fs, false, discardLogger()}
+
+	for _, filePath := range []string{"music/broken.flac", "music/broken.m4a", "music/huge-atom.m4a", "music/truncated.ogg", "music/not-audio/info.txt"} {
+		_, found := hashAudio(t, hasher, filePath)
+		assert.False(t, found, filePath)
+	}
+}
+
+func Test_Audio_Hasher_Error(t *testing.T) {
+	hasher := audioHasher{afero.NewMemMapFs(), false, discardLogger()}
+
+	var reported error
+	completed := false
+	hasher.hash("music/missing.mp3", func(f IndexedFile) {}, func(filePath string, err error) {
+		reported = err
+	}, func() {
+		completed = true
+	})
+
+	assert.Error(t, reported)
+	assert.False(t, errors.Is(reported, errNotAudio))
+	assert.True(t, completed)
+}
+
+func Test_Audio_Hasher_Archive_Member(t *testing.T) {
+	fs := audioFixture(t)
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, _ := zw.Create("track.mp3")
+	w.Write(concat(id3v2("title=Zipped", false), audioFrames))
+	assert.NoError(t, zw.Close())
+	afero.WriteFile(fs, "music.zip", buf.Bytes(), 0644)
+	hasher := audioHasher{newArchiveFs(fs), false, discardLogger()}
+
+	member, found := hashAudio(t, hasher, "music.zip!/track.mp3")
+	assert.True(t, found)
+	track, _ := hashAudio(t, hasher, "music/track.mp3")
+	assert.Equal(t, track.AudioChecksum, member.AudioChecksum)
+}
+
+func Test_Find_AudioHash(t *testing.T) {
+	fs := audioFixture(t)
+	o := defaultOptions()
+	WithHashers(StrategyAudioHash)(o)
+	index := newIndex([]IndexedFile{})
+
+	err := newIndexer(fs, "music", index, o).Create("music")
+	assert.NoError(t, err)
+	groups, err := newCompositeFinder(index, 0, DefaultImageHashKind, StrategyAudioHash).Find()
+
+	assert.NoError(t, err)
+	// the fixture has the same frames in mp3, flac and m4a files, the ogg and wav files have different audio
+	assert.Len(t, groups, 3)
+	assert.Equal(t, StrategyAudioHash, groups[0].Strategy)
+	assert.Len(t, groups[0].Members, 7)
+	assert.Equal(t, "music/track.flac", groups[0].Keeper)
+	assert.Equal(t, []string{"music/track.ogg", "music/copy/track.ogg"}, groups[1].Paths())
+	assert.Equal(t, []string{"music/track.wav", "music/copy/track.wav"}, groups[2].Paths())
+	for _, group := range groups {
+		assert.NotContains(t, group.Paths(), "music/other.mp3")
+	}
+}
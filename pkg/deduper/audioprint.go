@@ -0,0 +1,349 @@
+package deduper
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"math/cmplx"
+
+	"github.com/mewkiz/flac"
+)
+
+// The fingerprint has 32 bits for every frame of audio, resampled to printSampleRate.
+// A bit is set when the difference in energy between 2 neighbouring frequency bands increases from the previous
+// frame, which mostly survives re-encoding, resampling and changes in volume.
+const (
+	printSampleRate = 5512
+	printFrameSize  = 2048
+	printHop        = 512
+	// only the start of the audio is fingerprinted, which keeps the index small
+	printMaxSeconds = 120
+	printMinFreq    = 300
+	printMaxFreq    = 2000
+	// frames a copy may be shifted by, e.g. by an encoder adding silence at the start
+	printMaxShift = 3
+)
+
+// maxPrintDistance is the average number of bits, out of 32, that the fingerprints of duplicates may differ by.
+const maxPrintDistance = 5
+
+// isPrintable is true for the audio formats that are decoded to fingerprint them.
+func isPrintable(format string) bool {
+	return "wav" == format || "flac" == format
+}
+
+// audioPrint decodes the audio and returns its fingerprint.
+func audioPrint(r io.Reader, format string) ([]uint32, error) {
+	rs := &resampler{}
+	var err error
+	switch format {
+	case "wav":
+		err = decodeWav(r, rs.add)
+	case "flac":
+		err = decodeFlac(r, rs.add)
+	default:
+		return nil, fmt.Errorf("%w: cannot decode %v", errNotAudio, format)
+	}
+	if nil != err {
+		return nil, err
+	}
+
+	return fingerprint(rs.samples), nil
+}
+
+// decodeWav passes the samples of integer and float PCM wav files to fun, mixed to mono and scaled to -1..1.
+func decodeWav(r io.Reader, fun func(rate int, samples []float64) bool) error {
+	hdr := make([]byte, 12)
+	if _, err := io.ReadFull(r, hdr); nil != err {
+		return fmt.Errorf("%w: %v", errNotAudio, err)
+	}
+	if "RIFF" != string(hdr[:4]) || "WAVE" != string(hdr[8:12]) {
+		return fmt.Errorf("%w: no wave header", errNotAudio)
+	}
+
+	var format, channels, bitsPerSample int
+	var rate int
+	for {
+		if _, err := io.ReadFull(r, hdr[:8]); nil != err {
+			return fmt.Errorf("%w: no data chunk", errNotAudio)
+		}
+		n := int64(binary.LittleEndian.Uint32(hdr[4:8]))
+		switch string(hdr[:4]) {
+		case "fmt ":
+			// 16 bytes, up to 40 for the extensible format, checked before allocating the size in the file
+			if n < 16 || n > 40 {
+				return fmt.Errorf("%w: invalid format chunk", errNotAudio)
+			}
+			fmtChunk := make([]byte, n+n&1)
+			if _, err := io.ReadFull(r, fmtChunk); nil != err {
+				return fmt.Errorf("%w: invalid format chunk", errNotAudio)
+			}
+			format = int(binary.LittleEndian.Uint16(fmtChunk[0:2]))
+			channels = int(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+			rate = int(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+			bitsPerSample = int(binary.LittleEndian.Uint16(fmtChunk[14:16]))
+			if 0xfffe == format && n >= 26 {
+				// extensible format, the actual format is the start of the sub format
+				format = int(binary.LittleEndian.Uint16(fmtChunk[24:26]))
+			}
+		case "data":
+			return decodePcm(io.LimitReader(r, n), format, channels, rate, bitsPerSample, fun)
+		default:
+			if _, err := io.CopyN(io.Discard, r, n+n&1); nil != err {
+				return fmt.Errorf("%w: no data chunk", errNotAudio)
+			}
+		}
+	}
+}
+
+func decodePcm(r io.Reader, format int, channels int, rate int, bitsPerSample int, fun func(rate int, samples []float64) bool) error {
+	isInt := 1 == format && (8 == bitsPerSample || 16 == bitsPerSample || 24 == bitsPerSample || 32 == bitsPerSample)
+	isFloat := 3 == format && 32 == bitsPerSample
+	if (!isInt && !isFloat) || channels < 1 || rate < 1 {
+		return fmt.Errorf("%w: unsupported format %v with %v bits", errNotAudio, format, bitsPerSample)
+	}
+
+	size := bitsPerSample / 8
+	frame := size * channels
+	buf := make([]byte, frame*4096)
+	mono := make([]float64, 0, 4096)
+	for {
+		n, err := io.ReadFull(r, buf)
+		mono = mono[:0]
+		for off := 0; off+frame <= n; off += frame {
+			sum := 0.0
+			for c := 0; c < channels; c++ {
+				sum += pcmSample(buf[off+c*size:off+(c+1)*size], isFloat)
+			}
+			mono = append(mono, sum/float64(channels))
+		}
+		if !fun(rate, mono) {
+			return nil
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		} else if nil != err {
+			return err
+		}
+	}
+}
+
+// pcmSample returns a little endian sample scaled to -1..1. 8 bit samples are unsigned, all others signed.
+func pcmSample(b []byte, isFloat bool) float64 {
+	switch len(b) {
+	case 1:
+		return (float64(b[0]) - 128) / 128
+	case 2:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+	case 3:
+		return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+	default:
+		if isFloat {
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		}
+		return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+	}
+}
+
+// decodeFlac passes the samples of the flac stream to fun, mixed to mono and scaled to -1..1.
+func decodeFlac(r io.Reader, fun func(rate int, samples []float64) bool) error {
+	stream, err := flac.New(r)
+	if nil != err {
+		return fmt.Errorf("%w: %v", errNotAudio, err)
+	}
+
+	mono := []float64{}
+	for {
+		frame, err := stream.ParseNext()
+		if io.EOF == err {
+			return nil
+		} else if nil != err {
+			return fmt.Errorf("%w: %v", errNotAudio, err)
+		}
+
+		rate := int(frame.SampleRate)
+		if 0 == rate {
+			rate = int(stream.Info.SampleRate)
+		}
+		bitsPerSample := int(frame.BitsPerSample)
+		if 0 == bitsPerSample {
+			bitsPerSample = int(stream.Info.BitsPerSample)
+		}
+		scale := float64(int64(1) << (bitsPerSample - 1))
+		mono = mono[:0]
+		for i := 0; i < int(frame.BlockSize); i++ {
+			sum := 0.0
+			for _, subframe := range frame.Subframes {
+				sum += float64(subframe.Samples[i])
+			}
+			mono = append(mono, sum/float64(len(frame.Subframes))/scale)
+		}
+		if !fun(rate, mono) {
+			return nil
+		}
+	}
+}
+
+// resampler collects samples at printSampleRate, averaging samples of higher rates.
+type resampler struct {
+	samples []float64
+	sum     float64
+	n       int
+	pos     float64
+}
+
+// add resamples the samples, and returns false once enough samples are collected.
+func (rs *resampler) add(rate int, samples []float64) bool {
+	step := float64(printSampleRate) / float64(rate)
+	for _, s := range samples {
+		rs.sum += s
+		rs.n++
+		rs.pos += step
+		if rs.pos < 1 {
+			continue
+		}
+		for ; rs.pos >= 1; rs.pos-- {
+			rs.samples = append(rs.samples, rs.sum/float64(rs.n))
+		}
+		rs.sum, rs.n = 0, 0
+	}
+
+	return len(rs.samples) < printMaxSeconds*printSampleRate
+}
+
+// fingerprint returns 32 bits for every frame of the samples, after the first.
+func fingerprint(samples []float64) []uint32 {
+	window := make([]float64, printFrameSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(printFrameSize-1))
+	}
+	// bands are spaced logarithmically, as we hear them
+	edges := make([]int, 34)
+	for i := range edges {
+		freq := printMinFreq * math.Pow(float64(printMaxFreq)/printMinFreq, float64(i)/float64(len(edges)-1))
+		edges[i] = int(freq * printFrameSize / printSampleRate)
+	}
+
+	hashes := []uint32{}
+	var previous []float64
+	buf := make([]complex128, printFrameSize)
+	for start := 0; start+printFrameSize <= len(samples); start += printHop {
+		for i := range buf {
+			buf[i] = complex(samples[start+i]*window[i], 0)
+		}
+		fft(buf)
+		energy := make([]float64, len(edges)-1)
+		for b := range energy {
+			for i := edges[b]; i < max(edges[b+1], edges[b]+1); i++ {
+				energy[b] += real(buf[i])*real(buf[i]) + imag(buf[i])*imag(buf[i])
+			}
+		}
+
+		if nil != previous {
+			var word uint32
+			for b := 0; b < 32; b++ {
+				if energy[b]-energy[b+1]-(previous[b]-previous[b+1]) > 0 {
+					word |= 1 << b
+				}
+			}
+			hashes = append(hashes, word)
+		}
+		previous = energy
+	}
+
+	return hashes
+}
+
+// fft is an in place radix-2 fast fourier transform, the length of x must be a power of 2.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
+
+// printDistance is the average number of bits, out of 32, that the fingerprints differ by,
+// at the shift where they are most alike. Fingerprints of audio of a different length are 32 bits apart.
+func printDistance(a []uint32, b []uint32) int {
+	shorter, longer := min(len(a), len(b)), max(len(a), len(b))
+	if 0 == shorter || shorter*10 < longer*9 {
+		return 32
+	}
+
+	best := 32
+	for shift := -printMaxShift; shift <= printMaxShift; shift++ {
+		total, n := 0, 0
+		for i := max(0, -shift); i < len(a) && i+shift < len(b); i++ {
+			total += bits.OnesCount32(a[i] ^ b[i+shift])
+			n++
+		}
+		if 0 != n {
+			best = min(best, total/n)
+		}
+	}
+
+	return best
+}
+
+// audioPrintFinder finds audio files that sound the same, e.g. the same track in a different format or bitrate.
+type audioPrintFinder struct {
+	index *Index
+}
+
+func (finder audioPrintFinder) Find() ([]DuplicateGroup, error) {
+	hashed := []IndexedFile{}
+	for _, v := range finder.index.Files() {
+		if 0 != len(v.AudioPrint) {
+			hashed = append(hashed, v)
+		}
+	}
+
+	grouped := make(map[string]bool)
+	all := linkedGroups(StrategyAudioPrint, hashed)
+	for i, v := range hashed {
+		if grouped[v.Path] {
+			continue
+		}
+		members := []GroupMember{{IndexedFile: v}}
+		for _, vv := range hashed[i+1:] {
+			if !grouped[vv.Path] && printDistance(v.AudioPrint, vv.AudioPrint) <= maxPrintDistance {
+				members = append(members, GroupMember{IndexedFile: vv})
+				grouped[vv.Path] = true
+			}
+		}
+
+		// hard links to the same file are a single copy
+		if members = collapseLinks(members); len(members) > 1 {
+			group := newDuplicateGroup(StrategyAudioPrint, membersKey(members), members)
+			keeper := group.Members[0].AudioPrint
+			for j := range group.Members {
+				group.Members[j].Distance = printDistance(keeper, group.Members[j].AudioPrint)
+			}
+			all = append(all, group)
+		}
+	}
+	sortGroups(all)
+
+	return all, nil
+}
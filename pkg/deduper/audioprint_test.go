@@ -0,0 +1,242 @@
+package deduper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// tune returns a melody of random notes depending on the seed, as a function of time in seconds.
+func tune(seed int64) func(at float64) float64 {
+	r := rand.New(rand.NewSource(seed))
+	notes := make([][2]float64, 60)
+	for i := range notes {
+		notes[i] = [2]float64{300 + r.Float64()*1600, 300 + r.Float64()*1600}
+	}
+
+	return func(at float64) float64 {
+		note := notes[int(at*4)%len(notes)]
+		return 0.4*math.Sin(2*math.Pi*note[0]*at) + 0.3*math.Sin(2*math.Pi*note[1]*at)
+	}
+}
+
+func samples(signal func(at float64) float64, rate int, seconds float64, volume float64) []float64 {
+	s := make([]float64, int(float64(rate)*seconds))
+	for i := range s {
+		s[i] = volume * signal(float64(i)/float64(rate))
+	}
+
+	return s
+}
+
+// wavFile writes 16 bit PCM samples, with the same samples for every channel.
+func wavFile(rate int, channels int, s []float64) []byte {
+	format := binary.LittleEndian.AppendUint16(nil, 1)
+	format = binary.LittleEndian.AppendUint16(format, uint16(channels))
+	format = binary.LittleEndian.AppendUint32(format, uint32(rate))
+	format = binary.LittleEndian.AppendUint32(format, uint32(rate*channels*2))
+	format = binary.LittleEndian.AppendUint16(format, uint16(channels*2))
+	format = binary.LittleEndian.AppendUint16(format, 16)
+	data := []byte{}
+	for _, v := range s {
+		for c := 0; c < channels; c++ {
+			data = binary.LittleEndian.AppendUint16(data, uint16(int16(v*math.MaxInt16)))
+		}
+	}
+
+	return riff(wavChunk("fmt ", format), wavChunk("LIST", []byte("INFOtitle")), wavChunk("data", data))
+}
+
+// flacFile encodes 24 bit mono samples.
+func flacFile(t *testing.T, rate int, s []float64) []byte {
+	const blockSize = 4096
+	buf := &bytes.Buffer{}
+	info := &meta.StreamInfo{
+		BlockSizeMin:  blockSize,
+		BlockSizeMax:  blockSize,
+		SampleRate:    uint32(rate),
+		NChannels:     1,
+		BitsPerSample: 24,
+		NSamples:      uint64(len(s)),
+	}
+	enc, err := flac.NewEncoder(buf, info)
+	assert.NoError(t, err)
+	for start := 0; start < len(s); start += blockSize {
+		block := s[start:min(start+blockSize, len(s))]
+		encoded := make([]int32, len(block))
+		for i, v := range block {
+			encoded[i] = int32(v * (1<<23 - 1))
+		}
+		assert.NoError(t, enc.WriteFrame(&frame.Frame{
+			Header: frame.Header{
+				HasFixedBlockSize: true,
+				BlockSize:         uint16(len(block)),
+				SampleRate:        uint32(rate),
+				Channels:          frame.ChannelsMono,
+				BitsPerSample:     24,
+				Num:               uint64(start / blockSize),
+			},
+			Subframes: []*frame.Subframe{{
+				SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+				Samples:   encoded,
+				NSamples:  len(encoded),
+			}},
+		}))
+	}
+	assert.NoError(t, enc.Close())
+
+	return buf.Bytes()
+}
+
+func Test_Fft(t *testing.T) {
+	x := make([]complex128, 16)
+	for i := range x {
+		x[i] = complex(math.Sin(float64(i)), float64(i%3))
+	}
+	// compare with the discrete fourier transform
+	expected := make([]complex128, len(x))
+	for k := range expected {
+		for n, v := range x {
+			expected[k] += v * cmplx.Exp(complex(0, -2*math.Pi*float64(k*n)/float64(len(x))))
+		}
+	}
+
+	fft(x)
+
+	for k := range x {
+		assert.InDelta(t, real(expected[k]), real(x[k]), 1e-9)
+		assert.InDelta(t, imag(expected[k]), imag(x[k]), 1e-9)
+	}
+}
+
+func Test_Resampler(t *testing.T) {
+	rs := &resampler{}
+
+	assert.True(t, rs.add(printSampleRate*2, []float64{1, 3, 2, 2, 5}))
+	assert.Equal(t, []float64{2, 2}, rs.samples)
+
+	// lower rates repeat samples
+	rs = &resampler{}
+	rs.add(printSampleRate/2, []float64{1, 2})
+	assert.Equal(t, []float64{1, 1, 2, 2}, rs.samples)
+}
+
+func Test_Resampler_Stops(t *testing.T) {
+	rs := &resampler{}
+
+	assert.False(t, rs.add(printSampleRate, make([]float64, printMaxSeconds*printSampleRate)))
+}
+
+func Test_Print_Distance(t *testing.T) {
+	a := make([]uint32, 100)
+	for i := range a {
+		a[i] = uint32(i * 7919)
+	}
+	assert.Equal(t, 0, printDistance(a, a))
+	// shifted by a frame
+	assert.Equal(t, 0, printDistance(a, a[1:]))
+	b := flipBits(a, 0xf)
+	assert.Equal(t, 4, printDistance(a, b))
+	// much shorter audio is not a duplicate
+	assert.Equal(t, 32, printDistance(a, a[:50]))
+	assert.Equal(t, 32, printDistance(a, nil))
+}
+
+// flipBits returns a copy of the fingerprint with the mask flipped in every frame.
+func flipBits(a []uint32, mask uint32) []uint32 {
+	b := make([]uint32, len(a))
+	for i, v := range a {
+		b[i] = v ^ mask
+	}
+
+	return b
+}
+
+func Test_Audio_Print_Reencoded(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	song := tune(1)
+	afero.WriteFile(fs, "music/song.wav", wavFile(44100, 2, samples(song, 44100, 10, 1)), 0644)
+	afero.WriteFile(fs, "music/song-quiet.wav", wavFile(22050, 1, samples(song, 22050, 10, 0.3)), 0644)
+	afero.WriteFile(fs, "music/song.flac", flacFile(t, 48000, samples(song, 48000, 10, 0.8)), 0644)
+	afero.WriteFile(fs, "music/other.wav", wavFile(44100, 2, samples(tune(2), 44100, 10, 1)), 0644)
+	afero.WriteFile(fs, "music/song.mp3", concat(id3v2("title=Song", false), audioFrames), 0644)
+	hasher := audioHasher{fs, true, discardLogger()}
+	index := newIndex([]IndexedFile{})
+	for _, filePath := range []string{"music/song.wav", "music/song-quiet.wav", "music/song.flac", "music/other.wav", "music/song.mp3"} {
+		hasher.hash(filePath, index.updateIndex, func(filePath string, err error) {
+			assert.Fail(t, "unexpected error", err)
+		}, func() {})
+	}
+
+	// mp3 files are not decoded
+	assert.Equal(t, 4, index.Len())
+	song1 := index.ind[index.iMap["music/song.wav"]].AudioPrint
+	assert.Len(t, song1, (10*printSampleRate-printFrameSize)/printHop)
+
+	groups, err := newCompositeFinder(index, 0, DefaultImageHashKind, StrategyAudioPrint).Find()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, StrategyAudioPrint, groups[0].Strategy)
+	assert.ElementsMatch(t, []string{"music/song.wav", "music/song-quiet.wav", "music/song.flac"}, groups[0].Paths())
+	for _, m := range groups[0].Members {
+		assert.LessOrEqual(t, m.Distance, maxPrintDistance)
+	}
+	other := index.ind[index.iMap["music/other.wav"]].AudioPrint
+	assert.Greater(t, printDistance(song1, other), 10)
+}
+
+func Test_Find_Audio_Print_Unique_Keys(t *testing.T) {
+	a, b := make([]uint32, 100), make([]uint32, 100)
+	for i := 1; i < 100; i++ {
+		a[i], b[i] = uint32(i*7919), uint32(i*104729)
+	}
+	// the songs start the same, so the groups have the same first frame
+	index := newIndex([]IndexedFile{
+		{Path: "a.wav", AudioPrint: a},
+		{Path: "copy/a.wav", AudioPrint: a},
+		{Path: "b.wav", AudioPrint: b},
+		{Path: "copy/b.wav", AudioPrint: b},
+	})
+
+	groups, err := newCompositeFinder(index, 0, DefaultImageHashKind, StrategyAudioPrint).Find()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 2)
+	assert.NotEqual(t, groups[0].Key, groups[1].Key)
+}
+
+func Test_Audio_Print_Skips(t *testing.T) {
+	fs := audioFixture(t)
+	afero.WriteFile(fs, "music/float.wav", riff(wavChunk("fmt ", []byte{3, 0, 1, 0, 0x44, 0xac, 0, 0, 0, 0, 0, 0, 4, 0, 64, 0}), wavChunk("data", make([]byte, 64))), 0644)
+	hasher := audioHasher{fs, true, discardLogger()}
+
+	// no audio in the fixture flac, and 64 bit floats are not supported
+	for _, filePath := range []string{"music/track.flac", "music/broken.flac", "music/float.wav", "music/track.ogg"} {
+		_, found := hashAudio(t, hasher, filePath)
+		assert.False(t, found, filePath)
+	}
+}
+
+func Test_Decode_Wav_Invalid_Format_Chunk(t *testing.T) {
+	huge := concat([]byte("fmt "), binary.LittleEndian.AppendUint32(nil, math.MaxUint32))
+	for _, wav := range [][]byte{
+		riff(wavChunk("fmt ", make([]byte, 8)), wavChunk("data", make([]byte, 64))),
+		riff(wavChunk("fmt ", make([]byte, 42)), wavChunk("data", make([]byte, 64))),
+		// the size in the file is never allocated
+		concat([]byte("RIFF\xff\xff\xff\xffWAVE"), huge),
+	} {
+		err := decodeWav(bytes.NewReader(wav), func(rate int, samples []float64) bool { return true })
+
+		assert.ErrorIs(t, err, errNotAudio)
+	}
+}
@@ -2,11 +2,14 @@ package deduper
 
 import (
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/spf13/afero"
 )
@@ -15,57 +18,210 @@ type Deduper interface {
 	Indexer
 	Finder
 	IsDirExist(target string) error
-	MoveDuplicates(files [][]string, target string) error
+	MoveDuplicates(groups []DuplicateGroup, target string) error
+	DeleteDuplicates(groups []DuplicateGroup) error
+	// FindDirectories returns groups of directories with the same content, according to the md5 checksums in the index,
+	// and pairs of directories that share at least minOverlap (0 to 1) of their files.
+	FindDirectories(minOverlap float64) ([]DuplicateGroup, error)
+	Stats() (Stats, error)
+	// Lookup returns the indexed files that match the file at filePath, which does not need to be indexed.
+	Lookup(filePath string, maxDistance int) ([]Match, error)
+	// Watch indexes dir and keeps the index up to date while files change, until stop is closed.
+	Watch(dir string, stop <-chan struct{}, fun func(WatchEvent)) error
+	// Thumbnail returns a jpeg of at most size by size pixels of the indexed image at path.
+	Thumbnail(path string, size int) ([]byte, error)
+	// FindAll finds the duplicates with each strategy of the deduper in turn, whereas Find only supports 1 strategy.
+	FindAll() ([]DuplicateGroup, error)
+	// Prune removes the files that no longer exist from the loaded index, and saves it. Returns the removed paths.
+	Prune() ([]string, error)
+	// WriteScript writes a shell script that acts on the duplicates of the groups, rather than acting on them.
+	WriteScript(w io.Writer, groups []DuplicateGroup, script Script) error
 }
 
 type deduperImp struct {
-	fs        afero.Fs
-	indexPath string
+	fs             afero.Fs
+	indexPath      string
+	index          *Index
+	logger         *slog.Logger
+	pruneEmptyDirs bool
+	// options the deduper was created with, to hash files to look up
+	options *options
+	// updater updates the index when watching for changes, it is the indexer
+	updater fileUpdater
+	// thumbnails generated while indexing
+	thumbnails *ThumbnailCache
 	Indexer
 	Finder
 }
 
-func NewDeduper(fs afero.Fs, indexPath string, md5 bool, imageHash bool) Deduper {
-	ind := &Index{
-		iMap: make(map[string]int),
-		ind:  []IndexedFile{},
+// New creates a Deduper for the index at indexPath, configured using the given options.
+// By default files are hashed using md5.
+func New(fs afero.Fs, indexPath string, opts ...Option) Deduper {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
 	}
 
+	// just in memory dictionary for now - maybe need to do something better in the future
+	ind := newIndex([]IndexedFile{})
+
+	indexer := newIndexer(fs, indexPath, ind, o)
+
 	return &deduperImp{
 		fs,
 		indexPath,
-		newIndexer(
-			fs,
-			indexPath,
-			// just in memory dictionary for now - maybe need to do something better in the future
-			ind,
-			md5,
-			imageHash,
-		),
-		newCompositeFinder(md5, imageHash, ind)}
+		ind,
+		o.logger,
+		o.pruneEmptyDirs,
+		o,
+		indexer,
+		NewThumbnailCache(fs, indexPath),
+		indexer,
+		newCompositeFinder(ind, o.videoTolerance, o.imageHashKinds[0], o.strategies...)}
+}
+
+// NewDeduper creates a Deduper using md5 and/or image hashes.
+func NewDeduper(fs afero.Fs, indexPath string, md5 bool, imageHash bool, opts ...Option) Deduper {
+	strategies := []Strategy{}
+	if md5 {
+		strategies = append(strategies, StrategyMd5)
+	}
+	if imageHash {
+		strategies = append(strategies, StrategyImageHash)
+	}
+
+	return New(fs, indexPath, append([]Option{WithHashers(strategies...)}, opts...)...)
 }
 
 // duplicate this as we cannot easily serialise the private members, and so to maintain decoupling.
-//  TODO: combine md5 and image hash in generic dict of hashes
+//
+//	TODO: combine md5 and image hash in generic dict of hashes
 type ImageHash struct {
 	Kind int
 	Hash uint64
 }
 
+// ImageInfo is the size of an image, as displayed, and the EXIF metadata of the camera that took it, if any.
+type ImageInfo struct {
+	Width  int
+	Height int
+	// Exif is set when the image has EXIF metadata, which copies exported by editors often lack.
+	Exif        bool      `json:",omitempty"`
+	Orientation int       `json:",omitempty"`
+	Make        string    `json:",omitempty"`
+	Model       string    `json:",omitempty"`
+	Taken       time.Time `json:",omitzero"`
+	GPS         bool      `json:",omitempty"`
+}
+
 type IndexedFile struct {
-	Path        string
-	Md5Checksum []byte
-	ImageHash   ImageHash
+	Path            string
+	Md5Checksum     []byte
+	ImageHash       ImageHash
+	ImageTransforms []uint64         `json:",omitempty"` // image hashes of the image with EXIF orientations 2 to 8 applied
+	ImageHashes     []PerceptualHash `json:",omitempty"` // image hashes of other kinds than ImageHash
+	Image           *ImageInfo       `json:",omitempty"` // size and EXIF metadata of an image
+	Thumbnail       string           `json:",omitempty"` // key of the thumbnail of an image in the ThumbnailCache
+	PixelChecksum   []byte           `json:",omitempty"` // md5 checksum of the pixels of an image, as displayed
+	VideoHash       []uint64         `json:",omitempty"` // difference hashes of frames spread over the duration of a video
+	AudioChecksum   []byte           `json:",omitempty"` // md5 checksum of the audio, without its tags
+	AudioPrint      []uint32         `json:",omitempty"` // acoustic fingerprint of the start of the audio
+	Size            int64            `json:",omitempty"`
+	ModTime         time.Time        `json:",omitzero"`
+	Mode            os.FileMode      `json:",omitempty"`
+	// owner, device and inode numbers are only recorded on unix systems
+	Uid uint32 `json:",omitempty"`
+	Gid uint32 `json:",omitempty"`
+	Dev uint64 `json:",omitempty"`
+	Ino uint64 `json:",omitempty"`
+	// Symlink is set when the path is a symlink, LinkTarget is the path it links to.
+	// The hashes and size are those of the target if the symlink was followed, and are not set otherwise.
+	Symlink    bool   `json:",omitempty"`
+	LinkTarget string `json:",omitempty"`
+	// Archive is the path of the archive the file is a member of, if any. Archive members are never moved or deleted.
+	Archive string `json:",omitempty"`
+}
+
+// newFileMetadata creates an entry with the file's metadata, as found when walking the directory.
+func newFileMetadata(path string, info os.FileInfo) IndexedFile {
+	f := IndexedFile{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Mode:    info.Mode(),
+	}
+	f.Uid, f.Gid, f.Dev, f.Ino = ownerAndInode(info)
+	if member, ok := info.(archiveMemberInfo); ok {
+		f.Archive = member.archive
+	}
+
+	return f
+}
+
+// newSymlinkMetadata creates an entry for a symlink, with the metadata of the target if it was followed.
+// Pass the info of the link itself as target if it was not.
+func newSymlinkMetadata(fs afero.Fs, path string, link os.FileInfo, target os.FileInfo) IndexedFile {
+	f := newFileMetadata(path, target)
+	f.Mode = link.Mode()
+	f.Symlink = true
+	f.LinkTarget = readlink(fs, path)
+
+	return f
 }
 
 func (f *IndexedFile) merge(mf IndexedFile) {
 	if nil != mf.Md5Checksum {
 		f.Md5Checksum = mf.Md5Checksum
 	}
+	if (ImageHash{}) != mf.ImageHash {
+		f.ImageHash = mf.ImageHash
+	}
+	if nil != mf.ImageHashes {
+		f.ImageHashes = mergeImageHashes(f.ImageHashes, mf.ImageHashes)
+	}
+	if nil != mf.ImageTransforms {
+		f.ImageTransforms = mf.ImageTransforms
+	}
+	if nil != mf.Image {
+		f.Image = mf.Image
+	}
+	if "" != mf.Thumbnail {
+		f.Thumbnail = mf.Thumbnail
+	}
+	if nil != mf.PixelChecksum {
+		f.PixelChecksum = mf.PixelChecksum
+	}
+	if nil != mf.VideoHash {
+		f.VideoHash = mf.VideoHash
+	}
+	if nil != mf.AudioChecksum {
+		f.AudioChecksum = mf.AudioChecksum
+	}
+	if nil != mf.AudioPrint {
+		f.AudioPrint = mf.AudioPrint
+	}
+	if 0 != mf.Size {
+		f.Size = mf.Size
+	}
+	if !mf.ModTime.IsZero() {
+		f.ModTime = mf.ModTime
+	}
+	if 0 != mf.Mode {
+		f.Mode = mf.Mode
+	}
+	if 0 != mf.Ino {
+		f.Uid, f.Gid, f.Dev, f.Ino = mf.Uid, mf.Gid, mf.Dev, mf.Ino
+	}
+	if mf.Symlink {
+		f.Symlink, f.LinkTarget = mf.Symlink, mf.LinkTarget
+	}
+	if "" != mf.Archive {
+		f.Archive = mf.Archive
+	}
 }
 
 type Index struct {
-	mu   sync.Mutex
+	mu   sync.RWMutex
 	iMap map[string]int
 	ind  []IndexedFile
 }
@@ -80,34 +236,230 @@ func (d deduperImp) IsDirExist(target string) error {
 	return err
 }
 
-func (d deduperImp) MoveDuplicates(dupes [][]string, target string) error {
-	for _, files := range dupes {
-		// TODO: let user figure out which ones to delete and which to keep. For now, we keep the one that is nearest to the root
-		sort.SliceStable(files, func(i, j int) bool {
-			iDepth := strings.Count(files[i], "/")
-			jDepth := strings.Count(files[j], "/")
-			if iDepth == jDepth {
-				// same level -> first alphabetical
-				return strings.TrimSuffix(files[i], filepath.Ext(files[i])) < strings.TrimSuffix(files[j], filepath.Ext(files[j]))
-			}
-			return iDepth < jDepth
-		})
-		for _, file := range files[1:] {
-			newPath := filepath.Join(target, file[len(d.indexPath):])
-			newPathDir := filepath.Dir(newPath)
-			// create dir if needed
-			if _, err := d.fs.Stat(newPathDir); os.IsNotExist(err) {
-				fmt.Printf("Creating target directory %v\n", newPathDir)
-				d.fs.MkdirAll(newPathDir, os.ModePerm)
+// Stats calculates statistics for the loaded index.
+func (d deduperImp) Stats() (Stats, error) {
+	return IndexStats(d.fs, d.index)
+}
+
+// FindAll returns the groups of duplicates found with each strategy, in the order of the strategies.
+func (d deduperImp) FindAll() ([]DuplicateGroup, error) {
+	groups := []DuplicateGroup{}
+	for _, s := range d.options.strategies {
+		found, err := newCompositeFinder(d.index, d.options.videoTolerance, d.options.imageHashKinds[0], s).Find()
+		if nil != err {
+			return nil, err
+		}
+		groups = append(groups, found...)
+	}
+
+	return groups, nil
+}
+
+// Prune removes the files that no longer exist from the loaded index, e.g. after moving duplicates, and saves it.
+func (d deduperImp) Prune() ([]string, error) {
+	removed, err := PruneIndex(d.fs, d.index)
+	if nil != err {
+		return nil, err
+	}
+	if 0 == len(removed) {
+		return removed, nil
+	}
+	d.logger.Info("Pruned index", "removed", len(removed))
+
+	return removed, d.updater.save()
+}
+
+// FindDirectories returns groups of directories with the same content.
+func (d deduperImp) FindDirectories(minOverlap float64) ([]DuplicateGroup, error) {
+	return newDirFinder(d.index, minOverlap).Find()
+}
+
+// MoveDuplicates moves all duplicate files and directories, except the keepers, to target.
+// With WithDryRun, it only logs what it would move.
+func (d deduperImp) MoveDuplicates(groups []DuplicateGroup, target string) error {
+	root := d.root(groups)
+	moved := []string{}
+	for _, group := range FilterGroups(groups, CategoryDuplicate, CategoryDirectory) {
+		for _, dupe := range d.actionable(group) {
+			// move all hard links as well, otherwise no space is reclaimed
+			if err := d.moveFile(root, group, dupe.AllPaths(), target); nil != err {
+				return err
 			}
+			moved = append(moved, dupe.AllPaths()...)
+		}
+	}
+
+	return d.removeEmptyDirs(root, moved)
+}
 
-			fmt.Printf("Moving %v to %v\n", file, newPath)
-			err := d.fs.Rename(file, newPath)
-			if nil != err {
-				return fmt.Errorf("error moving %v to %v: %w\n", file, newPath, err)
+// DeleteDuplicates permanently removes all duplicate files and directories, keeping the keeper of each group.
+// With WithDryRun, it only logs what it would remove.
+func (d deduperImp) DeleteDuplicates(groups []DuplicateGroup) error {
+	root := d.root(groups)
+	deleted := []string{}
+	for _, group := range FilterGroups(groups, CategoryDuplicate, CategoryDirectory) {
+		remove := d.fs.Remove
+		if CategoryDirectory == group.Category {
+			remove = d.fs.RemoveAll
+		}
+		for _, dupe := range d.actionable(group) {
+			if CategoryDirectory == group.Category {
+				if err := d.checkIndexed(dupe.Path); nil != err {
+					return err
+				}
 			}
+			// delete all hard links as well, otherwise no space is reclaimed
+			for _, file := range dupe.AllPaths() {
+				if d.options.dryRun {
+					d.logger.Info("Would delete duplicate", "path", file, "keeper", group.Keeper)
+					continue
+				}
+				d.logger.Info("Deleting duplicate", "path", file, "keeper", group.Keeper)
+				if err := remove(file); nil != err {
+					return fmt.Errorf("error deleting %v: %w\n", file, err)
+				}
+				deleted = append(deleted, file)
+			}
+		}
+	}
+
+	return d.removeEmptyDirs(root, deleted)
+}
+
+// checkIndexed returns an error when the directory contains a file that is not in the index, or of which the size
+// changed since it was indexed, so that removing the directory never removes files that were not found to be duplicates.
+func (d deduperImp) checkIndexed(dir string) error {
+	return afero.Walk(d.fs, dir, func(path string, info os.FileInfo, err error) error {
+		if nil != err {
+			return fmt.Errorf("error checking %v before deleting it: %w\n", dir, err)
+		}
+		if info.IsDir() {
+			return nil
+		}
+		indexed, found := d.index.get(path)
+		if !found {
+			return fmt.Errorf("refusing to delete %v, %v is not in the index\n", dir, path)
+		}
+		if info.Mode().IsRegular() && indexed.Size != info.Size() {
+			return fmt.Errorf("refusing to delete %v, %v changed since it was indexed\n", dir, path)
+		}
+		return nil
+	})
+}
+
+func (d deduperImp) moveFile(root string, group DuplicateGroup, files []string, target string) error {
+	for _, file := range files {
+		newPath, err := movedPath(root, file, target)
+		if nil != err {
+			return err
+		}
+		if d.options.dryRun {
+			d.logger.Info("Would move duplicate", "path", file, "target", newPath, "keeper", group.Keeper)
+			continue
+		}
+		newPathDir := filepath.Dir(newPath)
+		// create dir if needed
+		if _, err := d.fs.Stat(newPathDir); os.IsNotExist(err) {
+			d.logger.Info("Creating target directory", "path", newPathDir)
+			d.fs.MkdirAll(newPathDir, os.ModePerm)
+		}
+
+		d.logger.Info("Moving duplicate", "path", file, "target", newPath, "keeper", group.Keeper)
+		err = d.fs.Rename(file, newPath)
+		if nil != err {
+			return fmt.Errorf("error moving %v to %v: %w\n", file, newPath, err)
+		}
+	}
+
+	return nil
+}
+
+// movedPath is the path that file is moved to in target, keeping its path relative to root.
+func movedPath(root string, file string, target string) (string, error) {
+	rel, err := filepath.Rel(root, file)
+	if nil != err {
+		return "", fmt.Errorf("error moving %v, not in %v: %w\n", file, root, err)
+	}
+
+	return filepath.Join(target, rel), nil
+}
+
+// actionable returns the duplicates in the group that can be moved or deleted, skipping archive members.
+func (d deduperImp) actionable(group DuplicateGroup) []GroupMember {
+	dupes := []GroupMember{}
+	for _, m := range group.Duplicates() {
+		if "" != m.Archive {
+			d.logger.Info("Skipping duplicate in archive", "path", m.Path, "keeper", group.Keeper)
+			continue
+		}
+		dupes = append(dupes, m)
+	}
+
+	return dupes
+}
+
+// removeEmptyDirs removes the directories of the removed files, and their parents, when they are left empty.
+// Directories are removed bottom-up, and only within root.
+func (d deduperImp) removeEmptyDirs(root string, removed []string) error {
+	// nothing was removed in a dry run
+	if !d.pruneEmptyDirs || d.options.dryRun {
+		return nil
+	}
+
+	for _, dir := range parentDirs(root, removed) {
+		empty, err := afero.IsEmpty(d.fs, dir)
+		if nil != err {
+			return fmt.Errorf("error checking %v is empty: %w\n", dir, err)
+		}
+		if !empty {
+			continue
+		}
+		d.logger.Info("Removing empty directory", "path", dir)
+		if err := d.fs.Remove(dir); nil != err {
+			return fmt.Errorf("error removing empty directory %v: %w\n", dir, err)
 		}
 	}
 
 	return nil
 }
+
+// parentDirs returns the directories of the removed files, and their parents, within root. The deepest come first,
+// so that a parent is only checked for being empty after its children.
+func parentDirs(root string, removed []string) []string {
+	dirs := []string{}
+	seen := make(map[string]bool)
+	for _, file := range removed {
+		for dir := filepath.Dir(file); dir != filepath.Clean(root) && isInDir(dir, root) && !seen[dir]; dir = filepath.Dir(dir) {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], string(filepath.Separator)) > strings.Count(dirs[j], string(filepath.Separator))
+	})
+
+	return dirs
+}
+
+// root is the directory that the files are moved relative to: the index path if files were indexed in it,
+// otherwise the directory that all indexed files are in.
+func (d deduperImp) root(groups []DuplicateGroup) string {
+	paths := []string{}
+	for _, f := range d.index.Files() {
+		paths = append(paths, f.Path)
+	}
+	if 0 == len(paths) {
+		// index not loaded, use the files that are acted on
+		for _, g := range groups {
+			paths = append(paths, g.Paths()...)
+		}
+	}
+
+	root := commonDir(paths)
+	indexPath := filepath.Clean(d.indexPath)
+	if isInDir(root, indexPath) {
+		return indexPath
+	}
+
+	return root
+}
@@ -1,8 +1,15 @@
 package deduper
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
@@ -37,6 +44,88 @@ func TestMerge_do_nothing_when_null(t *testing.T) {
 	assert.Equal(t, hash, f1.Md5Checksum)
 }
 
+func groupsOf(paths ...[]string) []DuplicateGroup {
+	groups := []DuplicateGroup{}
+	for _, p := range paths {
+		members := []GroupMember{}
+		for _, path := range p {
+			members = append(members, GroupMember{IndexedFile: IndexedFile{Path: path}})
+		}
+		groups = append(groups, newDuplicateGroup(StrategyMd5, "", members))
+	}
+
+	return groups
+}
+
+func TestMerge_image_info(t *testing.T) {
+	f1 := IndexedFile{Path: "hello.jpg", Md5Checksum: []byte("ABC")}
+	f2 := IndexedFile{Path: "hello.jpg", Image: &ImageInfo{Width: 10, Height: 20, Model: "NIKON D7100"}}
+
+	f1.merge(f2)
+	f1.merge(IndexedFile{Path: "hello.jpg"})
+
+	assert.Equal(t, []byte("ABC"), f1.Md5Checksum)
+	assert.Equal(t, "NIKON D7100", f1.Image.Model)
+}
+
+func TestMerge_metadata(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	f1 := IndexedFile{
+		Path:        "hello",
+		Md5Checksum: []byte("ABC"),
+	}
+	f2 := IndexedFile{
+		Path:    "hello",
+		Size:    42,
+		ModTime: modTime,
+		Mode:    0644,
+		Uid:     1000,
+		Gid:     100,
+		Dev:     1,
+		Ino:     1234,
+	}
+	f1.merge(f2)
+	assert.Equal(t, []byte("ABC"), f1.Md5Checksum)
+	assert.Equal(t, int64(42), f1.Size)
+	assert.Equal(t, modTime, f1.ModTime)
+	assert.Equal(t, os.FileMode(0644), f1.Mode)
+	assert.Equal(t, uint32(1000), f1.Uid)
+	assert.Equal(t, uint64(1234), f1.Ino)
+}
+
+func TestMergeSymlink(t *testing.T) {
+	f1 := IndexedFile{
+		Path:    "hello",
+		Symlink: true,
+	}
+	f2 := IndexedFile{
+		Path:        "hello",
+		Md5Checksum: []byte("ABC"),
+	}
+	f1.merge(f2)
+	assert.True(t, f1.Symlink)
+
+	f2.merge(IndexedFile{Path: "hello", Symlink: true, LinkTarget: "world"})
+	assert.True(t, f2.Symlink)
+	assert.Equal(t, "world", f2.LinkTarget)
+}
+
+func TestNewFileMetadata(t *testing.T) {
+	info, err := os.Stat("../../test/fred.txt")
+	assert.NoError(t, err)
+
+	f := newFileMetadata("fred.txt", info)
+
+	assert.Equal(t, "fred.txt", f.Path)
+	assert.Equal(t, info.Size(), f.Size)
+	assert.Equal(t, info.ModTime(), f.ModTime)
+	assert.Equal(t, info.Mode(), f.Mode)
+	if "windows" != runtime.GOOS {
+		assert.Equal(t, uint32(os.Getuid()), f.Uid)
+		assert.NotZero(t, f.Ino)
+	}
+}
+
 // NOTE: not really unit tests with the "in-memory" fs
 //  I should mock things out really, but given the code
 //  is so FS heavy, this will do.
@@ -88,7 +177,7 @@ func (suite *MemoryFsTestSuite) Test_MoveDuplicates_ok() {
 		}
 	}
 
-	err := deduper.MoveDuplicates([][]string{dupe1, dupe2}, target)
+	err := deduper.MoveDuplicates(groupsOf(dupe1, dupe2), target)
 
 	assert.Nil(suite.T(), err)
 	// boohoo, bad unit test, many asserts :(
@@ -106,6 +195,173 @@ func (suite *MemoryFsTestSuite) Test_MoveDuplicates_ok() {
 	assert.True(suite.T(), notmoved)
 }
 
+func (suite *MemoryFsTestSuite) Test_MoveDuplicates_prune_empty_dirs() {
+	deduper := NewDeduper(suite.fs, suite.indexPath, true, false, WithPruneEmptyDirs(true))
+	dupe := []string{"testDir/pictures/foo.txt", "testDir/pictures/a/b/foo.txt", "testDir/pictures/c/foo.txt"}
+	for _, f := range append(dupe, "testDir/pictures/c/bar.txt") {
+		afero.WriteFile(suite.fs, f, []byte("content"), 0644)
+	}
+
+	err := deduper.MoveDuplicates(groupsOf(dupe), "testDir/temp")
+
+	assert.Nil(suite.T(), err)
+	removed, _ := afero.DirExists(suite.fs, "testDir/pictures/a")
+	assert.False(suite.T(), removed)
+	// not empty
+	kept, _ := afero.DirExists(suite.fs, "testDir/pictures/c")
+	assert.True(suite.T(), kept)
+	kept, _ = afero.DirExists(suite.fs, "testDir/temp/a/b")
+	assert.True(suite.T(), kept)
+}
+
+func (suite *MemoryFsTestSuite) Test_MoveDuplicates_keeps_empty_dirs() {
+	deduper := NewDeduper(suite.fs, suite.indexPath, true, false)
+	dupe := []string{"testDir/pictures/foo.txt", "testDir/pictures/a/b/foo.txt"}
+	for _, f := range dupe {
+		afero.WriteFile(suite.fs, f, []byte("content"), 0644)
+	}
+
+	err := deduper.MoveDuplicates(groupsOf(dupe), "testDir/temp")
+
+	assert.Nil(suite.T(), err)
+	kept, _ := afero.DirExists(suite.fs, "testDir/pictures/a/b")
+	assert.True(suite.T(), kept)
+}
+
+func (suite *MemoryFsTestSuite) Test_DeleteDuplicates_ok() {
+	deduper := NewDeduper(suite.fs, suite.indexPath, true, false, WithPruneEmptyDirs(true))
+	for _, f := range []string{"foo.txt", "bob/foo.txt", "bob/foo-link.txt"} {
+		afero.WriteFile(suite.fs, "testDir/pictures/"+f, []byte("content"), 0644)
+	}
+	groups := []DuplicateGroup{
+		newDuplicateGroup(StrategyMd5, "", []GroupMember{
+			{IndexedFile: IndexedFile{Path: "testDir/pictures/foo.txt"}},
+			{IndexedFile: IndexedFile{Path: "testDir/pictures/bob/foo.txt"}, Links: []string{"testDir/pictures/bob/foo-link.txt"}},
+		}),
+		newEmptyGroup(StrategyMd5, "", []IndexedFile{{Path: "testDir/pictures/empty.txt"}}),
+	}
+	afero.WriteFile(suite.fs, "testDir/pictures/empty.txt", []byte{}, 0644)
+
+	err := deduper.DeleteDuplicates(groups)
+
+	assert.Nil(suite.T(), err)
+	kept, _ := afero.Exists(suite.fs, "testDir/pictures/foo.txt")
+	assert.True(suite.T(), kept)
+	// empty files are not acted on
+	kept, _ = afero.Exists(suite.fs, "testDir/pictures/empty.txt")
+	assert.True(suite.T(), kept)
+	deleted, _ := afero.Exists(suite.fs, "testDir/pictures/bob")
+	assert.False(suite.T(), deleted)
+	// never the root
+	kept, _ = afero.DirExists(suite.fs, "testDir/pictures")
+	assert.True(suite.T(), kept)
+}
+
+func (suite *MemoryFsTestSuite) Test_FindDirectories_and_act() {
+	for _, f := range []string{"holiday/a.jpg", "holiday/b.jpg", "holiday (1)/a.jpg", "holiday (1)/b.jpg"} {
+		afero.WriteFile(suite.fs, "testDir/pictures/"+f, []byte("content: "+filepath.Base(f)), 0644)
+	}
+	deduper := NewDeduper(suite.fs, suite.indexPath, true, false)
+	assert.NoError(suite.T(), deduper.Create(suite.indexPath))
+
+	groups, err := deduper.FindDirectories(1)
+
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), groups, 1)
+	assert.Equal(suite.T(), "testDir/pictures/holiday", groups[0].Keeper)
+
+	assert.NoError(suite.T(), deduper.MoveDuplicates(groups, "testDir/temp"))
+	moved, _ := afero.Exists(suite.fs, "testDir/temp/holiday (1)/b.jpg")
+	assert.True(suite.T(), moved)
+
+	// the moved directory is not in the index
+	moveGroups := []DuplicateGroup{
+		{Category: CategoryDirectory, Members: []GroupMember{
+			{IndexedFile: IndexedFile{Path: "testDir/pictures/holiday"}},
+			{IndexedFile: IndexedFile{Path: "testDir/temp/holiday (1)"}},
+		}, Keeper: "testDir/pictures/holiday"},
+	}
+	assert.ErrorContains(suite.T(), deduper.DeleteDuplicates(moveGroups), "not in the index")
+	assert.NoError(suite.T(), deduper.Create("testDir"))
+	_, err = deduper.Prune()
+	assert.NoError(suite.T(), err)
+	groups, err = deduper.FindDirectories(1)
+	assert.NoError(suite.T(), err)
+
+	assert.NoError(suite.T(), deduper.DeleteDuplicates(groups))
+	deleted, _ := afero.Exists(suite.fs, "testDir/temp/holiday (1)")
+	assert.False(suite.T(), deleted)
+	kept, _ := afero.Exists(suite.fs, "testDir/pictures/holiday/a.jpg")
+	assert.True(suite.T(), kept)
+}
+
+func (suite *MemoryFsTestSuite) Test_DeleteDuplicates_directory_changed() {
+	for _, f := range []string{"holiday/a.jpg", "holiday (1)/a.jpg"} {
+		afero.WriteFile(suite.fs, "testDir/pictures/"+f, []byte("content: "+filepath.Base(f)), 0644)
+	}
+	deduper := NewDeduper(suite.fs, suite.indexPath, true, false)
+	assert.NoError(suite.T(), deduper.Create(suite.indexPath))
+	groups, err := deduper.FindDirectories(1)
+	assert.NoError(suite.T(), err)
+	afero.WriteFile(suite.fs, "testDir/pictures/holiday (1)/new.jpg", []byte("new"), 0644)
+
+	assert.ErrorContains(suite.T(), deduper.DeleteDuplicates(groups), "new.jpg is not in the index")
+
+	assert.NoError(suite.T(), suite.fs.Remove("testDir/pictures/holiday (1)/new.jpg"))
+	afero.WriteFile(suite.fs, "testDir/pictures/holiday (1)/a.jpg", []byte("changed"), 0644)
+
+	assert.ErrorContains(suite.T(), deduper.DeleteDuplicates(groups), "a.jpg changed since it was indexed")
+	kept, _ := afero.Exists(suite.fs, "testDir/pictures/holiday (1)/a.jpg")
+	assert.True(suite.T(), kept)
+}
+
+func (suite *MemoryFsTestSuite) Test_MoveDuplicates_skips_archive_members() {
+	deduper := NewDeduper(suite.fs, suite.indexPath, true, false)
+	writeFiles(suite.T(), suite.fs, map[string][]byte{"testDir/pictures/backup.zip": zipArchive(suite.T())})
+	afero.WriteFile(suite.fs, "testDir/pictures/bar.txt", []byte("content: bar"), 0644)
+	afero.WriteFile(suite.fs, "testDir/pictures/bob/bar.txt", []byte("content: bar"), 0644)
+	groups := []DuplicateGroup{
+		newDuplicateGroup(StrategyMd5, "", []GroupMember{
+			{IndexedFile: IndexedFile{Path: "testDir/pictures/backup.zip!/bar.txt", Archive: "testDir/pictures/backup.zip"}},
+			{IndexedFile: IndexedFile{Path: "testDir/pictures/bar.txt"}},
+			{IndexedFile: IndexedFile{Path: "testDir/pictures/bob/bar.txt"}},
+		}),
+	}
+
+	err := deduper.MoveDuplicates(groups, "testDir/temp")
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "testDir/pictures/bar.txt", groups[0].Keeper)
+	moved, _ := afero.Exists(suite.fs, "testDir/temp/bob/bar.txt")
+	assert.True(suite.T(), moved)
+	kept, _ := afero.Exists(suite.fs, "testDir/pictures/backup.zip")
+	assert.True(suite.T(), kept)
+}
+
+func (suite *MemoryFsTestSuite) Test_Duplicates_dry_run() {
+	deduper := NewDeduper(suite.fs, suite.indexPath, true, false, WithPruneEmptyDirs(true), WithDryRun(true))
+	for _, f := range []string{"foo.txt", "bob/foo.txt"} {
+		afero.WriteFile(suite.fs, "testDir/pictures/"+f, []byte("content"), 0644)
+	}
+	groups := groupsOf([]string{"testDir/pictures/foo.txt", "testDir/pictures/bob/foo.txt"})
+
+	assert.NoError(suite.T(), deduper.DeleteDuplicates(groups))
+	assert.NoError(suite.T(), deduper.MoveDuplicates(groups, "testDir/temp"))
+
+	kept, _ := afero.Exists(suite.fs, "testDir/pictures/bob/foo.txt")
+	assert.True(suite.T(), kept)
+	moved, _ := afero.DirExists(suite.fs, "testDir/temp")
+	assert.False(suite.T(), moved)
+}
+
+func (suite *MemoryFsTestSuite) Test_DeleteDuplicates_error() {
+	deduper := NewDeduper(suite.fs, suite.indexPath, true, false)
+
+	err := deduper.DeleteDuplicates(groupsOf([]string{"testDir/pictures/foo.txt", "testDir/pictures/bar.txt"}))
+
+	assert.Error(suite.T(), err)
+}
+
 func (suite *MemoryFsTestSuite) Test_MoveDuplicates_error() {
 	deduper := NewDeduper(suite.fs, suite.indexPath, true, false)
 
@@ -117,7 +373,103 @@ func (suite *MemoryFsTestSuite) Test_MoveDuplicates_error() {
 		fmt.Errorf("failed to create test file %v: %w", "testDir/pictures/bar.txt", err)
 	}
 
-	err := deduper.MoveDuplicates([][]string{dupe1}, target)
+	err := deduper.MoveDuplicates(groupsOf(dupe1), target)
 
 	assert.Error(suite.T(), err)
 }
+
+func (suite *MemoryFsTestSuite) Test_MoveDuplicates_logs() {
+	out := &bytes.Buffer{}
+	deduper := NewDeduper(suite.fs, suite.indexPath, true, false, WithLogger(slog.New(slog.NewJSONHandler(out, nil))))
+
+	dupe := []string{"testDir/pictures/foo.txt", "testDir/pictures/bar.txt"}
+	for _, f := range dupe {
+		afero.WriteFile(suite.fs, f, []byte("content"), 0644)
+	}
+
+	err := deduper.MoveDuplicates(groupsOf(dupe), "testDir/temp")
+
+	assert.Nil(suite.T(), err)
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	var entry map[string]interface{}
+	assert.NoError(suite.T(), json.Unmarshal(lines[len(lines)-1], &entry))
+	assert.Equal(suite.T(), "Moving duplicate", entry["msg"])
+	assert.Equal(suite.T(), "testDir/pictures/foo.txt", entry["path"])
+	assert.Equal(suite.T(), "testDir/temp/foo.txt", entry["target"])
+}
+
+func (suite *MemoryFsTestSuite) Test_MoveDuplicates_relative_to_indexed_dir() {
+	// index stored outside of the indexed directory
+	deduper := NewDeduper(suite.fs, "index", true, false).(*deduperImp)
+	deduper.index.updateIndex(IndexedFile{Path: "testDir/pictures/foo.txt"})
+	deduper.index.updateIndex(IndexedFile{Path: "testDir/pictures/bob/foo.txt"})
+	afero.WriteFile(suite.fs, "testDir/pictures/foo.txt", []byte("content"), 0644)
+	afero.WriteFile(suite.fs, "testDir/pictures/bob/foo.txt", []byte("content"), 0644)
+
+	err := deduper.MoveDuplicates(groupsOf([]string{"testDir/pictures/foo.txt", "testDir/pictures/bob/foo.txt"}), "testDir/temp")
+
+	assert.Nil(suite.T(), err)
+	moved, _ := afero.Exists(suite.fs, "testDir/temp/bob/foo.txt")
+	assert.True(suite.T(), moved)
+}
+
+func (suite *MemoryFsTestSuite) Test_MoveDuplicates_hard_links() {
+	deduper := NewDeduper(suite.fs, suite.indexPath, true, false)
+	for _, f := range []string{"foo.txt", "bar.txt", "bar-link.txt", "fred.txt"} {
+		afero.WriteFile(suite.fs, "testDir/pictures/"+f, []byte("content"), 0644)
+	}
+	groups := []DuplicateGroup{
+		newDuplicateGroup(StrategyMd5, "", []GroupMember{
+			{IndexedFile: IndexedFile{Path: "testDir/pictures/foo.txt"}},
+			{IndexedFile: IndexedFile{Path: "testDir/pictures/fred.txt"}, Links: []string{"testDir/pictures/bar-link.txt"}},
+		}),
+		newLinkedGroup(StrategyMd5, []IndexedFile{{Path: "testDir/pictures/bar.txt"}, {Path: "testDir/pictures/foo.txt"}}),
+	}
+
+	err := deduper.MoveDuplicates(groups, "testDir/temp")
+
+	assert.Nil(suite.T(), err)
+	moved, _ := afero.Exists(suite.fs, "testDir/temp/fred.txt")
+	assert.True(suite.T(), moved)
+	moved, _ = afero.Exists(suite.fs, "testDir/temp/bar-link.txt")
+	assert.True(suite.T(), moved)
+	// linked files are left alone
+	notmoved, _ := afero.Exists(suite.fs, "testDir/pictures/bar.txt")
+	assert.True(suite.T(), notmoved)
+	notmoved, _ = afero.Exists(suite.fs, "testDir/pictures/foo.txt")
+	assert.True(suite.T(), notmoved)
+}
+
+func Test_Prune(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "pictures/foo.txt", []byte("content"), 0644)
+	afero.WriteFile(fs, "pictures/bar.txt", []byte("content"), 0644)
+	d := New(fs, "index")
+	assert.NoError(t, d.Create("pictures"))
+	assert.NoError(t, fs.Remove("pictures/bar.txt"))
+
+	removed, err := d.Prune()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pictures/bar.txt"}, removed)
+	saved, err := readIndex(fs, "index/"+INDEX_NAME)
+	assert.NoError(t, err)
+	assert.Len(t, saved, 1)
+}
+
+func Test_Find_All(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "pictures/foo.txt", []byte("content"), 0644)
+	afero.WriteFile(fs, "pictures/bar.txt", []byte("content"), 0644)
+	d := New(fs, "index", WithHashers(StrategyMd5, StrategyImageHash))
+	assert.NoError(t, d.Create("pictures"))
+
+	_, err := d.Find()
+	assert.Error(t, err)
+	groups, err := d.FindAll()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, StrategyMd5, groups[0].Strategy)
+	assert.Equal(t, []string{"pictures/bar.txt", "pictures/foo.txt"}, groups[0].Paths())
+}
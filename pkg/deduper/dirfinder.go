@@ -0,0 +1,255 @@
+package deduper
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"path/filepath"
+	"sort"
+)
+
+// dirFinder finds directories with the same content, using the md5 checksums of the files in the index.
+// Directories of which all content is the same are found, and when minOverlap is less than 1,
+// pairs of directories that share at least that fraction of their files.
+type dirFinder struct {
+	index      *Index
+	minOverlap float64
+}
+
+func newDirFinder(index *Index, minOverlap float64) Finder {
+	return &dirFinder{index, minOverlap}
+}
+
+// dirNode is a directory and its content, as far as it is in the index.
+type dirNode struct {
+	path    string
+	files   []IndexedFile
+	subdirs []string
+	// hash is the Merkle hash of the content, nil if not all files are hashed with md5
+	hash []byte
+	// checksums counts the md5 checksums of all files in the directory and its subdirectories
+	checksums map[string]int
+	count     int
+	size      int64
+}
+
+func (finder dirFinder) Find() ([]DuplicateGroup, error) {
+	dirs := finder.tree()
+
+	groups := append(identicalDirs(dirs), overlappingDirs(dirs, finder.minOverlap)...)
+	sortGroups(groups)
+
+	return groups, nil
+}
+
+// tree returns all directories that contain indexed files, up to the directory all files are in.
+func (finder dirFinder) tree() map[string]*dirNode {
+	files := []IndexedFile{}
+	for _, f := range finder.index.Files() {
+		// directories in archives cannot be acted on
+		if "" == f.Archive {
+			files = append(files, f)
+		}
+	}
+	dirs := make(map[string]*dirNode)
+	if 0 == len(files) {
+		return dirs
+	}
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.Path
+	}
+	root := commonDir(paths)
+
+	node := func(path string) *dirNode {
+		n, found := dirs[path]
+		if !found {
+			n = &dirNode{path: path, checksums: make(map[string]int)}
+			dirs[path] = n
+		}
+		return n
+	}
+	for _, f := range files {
+		dir := filepath.Dir(f.Path)
+		n := node(dir)
+		n.files = append(n.files, f)
+		// link the directory to its parents, up to the root
+		for dir != root && filepath.Dir(dir) != dir {
+			parent := node(filepath.Dir(dir))
+			if contains(parent.subdirs, dir) {
+				break
+			}
+			parent.subdirs = append(parent.subdirs, dir)
+			dir = parent.path
+		}
+	}
+
+	hashDir(dirs, root)
+
+	return dirs
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hashDir calculates the hash and content of the directory from those of its files and subdirectories.
+// The hash only depends on the content, not on the names of the files or the order they are in.
+func hashDir(dirs map[string]*dirNode, path string) {
+	n := dirs[path]
+	children := [][]byte{}
+	complete := true
+	for _, f := range n.files {
+		if nil == f.Md5Checksum {
+			complete = false
+			continue
+		}
+		children = append(children, f.Md5Checksum)
+		// files without content are the same as any other, so directories of only those are not the same
+		if bytes.Equal(emptyMd5[:], f.Md5Checksum) {
+			continue
+		}
+		n.checksums[string(f.Md5Checksum)]++
+		n.count++
+		n.size += f.Size
+	}
+	for _, sub := range n.subdirs {
+		hashDir(dirs, sub)
+		s := dirs[sub]
+		if nil == s.hash {
+			complete = false
+		}
+		// mark subdirectories, so they don't match a file with the same checksum
+		children = append(children, append([]byte("dir:"), s.hash...))
+		for k, v := range s.checksums {
+			n.checksums[k] += v
+		}
+		n.count += s.count
+		n.size += s.size
+	}
+	if !complete {
+		return
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return bytes.Compare(children[i], children[j]) < 0
+	})
+	h := md5.New()
+	for _, c := range children {
+		h.Write(c)
+	}
+	n.hash = h.Sum(nil)
+}
+
+// identicalDirs groups the directories with the same hash.
+// Directories in identical parents are not reported, as removing the parent removes them as well.
+func identicalDirs(dirs map[string]*dirNode) []DuplicateGroup {
+	byHash := make(map[string][]string)
+	for path, n := range dirs {
+		if nil != n.hash && 0 != n.count {
+			byHash[string(n.hash)] = append(byHash[string(n.hash)], path)
+		}
+	}
+
+	groups := []DuplicateGroup{}
+	for hash, paths := range byHash {
+		if len(paths) < 2 || identicalParents(dirs, paths) {
+			continue
+		}
+		members := make([]GroupMember, len(paths))
+		for i, p := range paths {
+			members[i] = GroupMember{IndexedFile: IndexedFile{Path: p, Size: dirs[p].size}}
+		}
+		group := newDuplicateGroup(StrategyMd5, hex.EncodeToString([]byte(hash)), members)
+		group.Category = CategoryDirectory
+		group.Overlap = 1
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+// identicalParents is true if the parents of the directories are different directories with the same hash.
+func identicalParents(dirs map[string]*dirNode, paths []string) bool {
+	parents := make(map[string]bool)
+	var hash []byte
+	for _, p := range paths {
+		parent, found := dirs[filepath.Dir(p)]
+		if !found || nil == parent.hash || parents[parent.path] || (nil != hash && !bytes.Equal(hash, parent.hash)) {
+			return false
+		}
+		parents[parent.path] = true
+		hash = parent.hash
+	}
+
+	return true
+}
+
+// overlappingDirs returns pairs of directories that share at least minOverlap of their files, but are not identical.
+// The overlap is the number of files in both directories, divided by the number of files in the largest one.
+// Pairs in overlapping parents are not reported.
+func overlappingDirs(dirs map[string]*dirNode, minOverlap float64) []DuplicateGroup {
+	if minOverlap >= 1 {
+		return []DuplicateGroup{}
+	}
+
+	// directories that contain each checksum
+	containing := make(map[string][]string)
+	for path, n := range dirs {
+		for k := range n.checksums {
+			containing[k] = append(containing[k], path)
+		}
+	}
+
+	overlaps := make(map[[2]string]float64)
+	for path, n := range dirs {
+		shared := make(map[string]int)
+		for k, count := range n.checksums {
+			for _, other := range containing[k] {
+				if other <= path || isInDir(other, path) || isInDir(path, other) {
+					continue
+				}
+				shared[other] += min(count, dirs[other].checksums[k])
+			}
+		}
+		for other, s := range shared {
+			o := dirs[other]
+			overlap := float64(s) / float64(max(n.count, o.count))
+			identical := nil != n.hash && bytes.Equal(n.hash, o.hash)
+			if overlap >= minOverlap && !identical {
+				overlaps[[2]string{path, other}] = overlap
+			}
+		}
+	}
+
+	groups := []DuplicateGroup{}
+	for pair, overlap := range overlaps {
+		parent0, parent1 := filepath.Dir(pair[0]), filepath.Dir(pair[1])
+		if _, found := overlaps[[2]string{parent0, parent1}]; found {
+			continue
+		}
+		if _, found := overlaps[[2]string{parent1, parent0}]; found {
+			continue
+		}
+		members := []GroupMember{
+			{IndexedFile: IndexedFile{Path: pair[0], Size: dirs[pair[0]].size}},
+			{IndexedFile: IndexedFile{Path: pair[1], Size: dirs[pair[1]].size}},
+		}
+		sortByKeeper(members)
+		groups = append(groups, DuplicateGroup{
+			Strategy: StrategyMd5,
+			Category: CategoryOverlap,
+			Members:  members,
+			Keeper:   members[0].Path,
+			Overlap:  overlap,
+		})
+	}
+
+	return groups
+}
@@ -0,0 +1,110 @@
+package deduper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Find_Directories_Identical(t *testing.T) {
+	index := newIndex([]IndexedFile{
+		{Path: "photos/Holiday 2019/a.jpg", Md5Checksum: []byte("a-md5"), Size: 10},
+		{Path: "photos/Holiday 2019/b.jpg", Md5Checksum: []byte("b-md5"), Size: 20},
+		{Path: "photos/Holiday 2019/day 1/c.jpg", Md5Checksum: []byte("c-md5"), Size: 30},
+		{Path: "photos/Holiday 2019 (1)/b.jpg", Md5Checksum: []byte("b-md5"), Size: 20},
+		{Path: "photos/Holiday 2019 (1)/a copy.jpg", Md5Checksum: []byte("a-md5"), Size: 10},
+		{Path: "photos/Holiday 2019 (1)/day 1/c.jpg", Md5Checksum: []byte("c-md5"), Size: 30},
+		{Path: "photos/other/a.jpg", Md5Checksum: []byte("a-md5"), Size: 10},
+	})
+
+	groups, err := newDirFinder(index, 1).Find()
+
+	assert.NoError(t, err)
+	// the identical "day 1" directories are not reported, as they are in identical directories
+	assert.Len(t, groups, 1)
+	assert.Equal(t, CategoryDirectory, groups[0].Category)
+	assert.Equal(t, StrategyMd5, groups[0].Strategy)
+	assert.Equal(t, []string{"photos/Holiday 2019", "photos/Holiday 2019 (1)"}, groups[0].Paths())
+	assert.Equal(t, "photos/Holiday 2019", groups[0].Keeper)
+	assert.Equal(t, int64(60), groups[0].Reclaimable)
+	assert.Equal(t, float64(1), groups[0].Overlap)
+}
+
+func Test_Find_Directories_Not_Hashed(t *testing.T) {
+	index := newIndex([]IndexedFile{
+		{Path: "foo/a.jpg", Md5Checksum: []byte("a-md5")},
+		{Path: "foo/b.jpg"},
+		{Path: "bar/a.jpg", Md5Checksum: []byte("a-md5")},
+		{Path: "bar/b.jpg"},
+	})
+
+	groups, err := newDirFinder(index, 1).Find()
+
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func Test_Find_Directories_Subdirectory_Is_Not_File(t *testing.T) {
+	index := newIndex([]IndexedFile{
+		{Path: "foo/a.jpg", Md5Checksum: []byte("a-md5")},
+		{Path: "bar/sub/a.jpg", Md5Checksum: []byte("a-md5")},
+	})
+
+	groups, err := newDirFinder(index, 1).Find()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, []string{"foo", "bar/sub"}, groups[0].Paths())
+}
+
+func Test_Find_Directories_Empty_Files(t *testing.T) {
+	empty := emptyMd5[:]
+	index := newIndex([]IndexedFile{
+		{Path: "foo/empty.txt", Md5Checksum: empty},
+		{Path: "bar/empty.txt", Md5Checksum: empty},
+		{Path: "baz/a.jpg", Md5Checksum: []byte("a-md5"), Size: 10},
+		{Path: "baz/empty.txt", Md5Checksum: empty},
+		{Path: "qux/a.jpg", Md5Checksum: []byte("a-md5"), Size: 10},
+		{Path: "qux/empty.txt", Md5Checksum: empty},
+	})
+
+	groups, err := newDirFinder(index, 0.5).Find()
+
+	assert.NoError(t, err)
+	// directories of only files without content are not the same
+	assert.Len(t, groups, 1)
+	assert.Equal(t, []string{"baz", "qux"}, groups[0].Paths())
+}
+
+func Test_Find_Directories_Overlap(t *testing.T) {
+	index := newIndex([]IndexedFile{
+		{Path: "photos/foo/a.jpg", Md5Checksum: []byte("a-md5")},
+		{Path: "photos/foo/b.jpg", Md5Checksum: []byte("b-md5")},
+		{Path: "photos/foo/c.jpg", Md5Checksum: []byte("c-md5")},
+		{Path: "photos/foo/d.jpg", Md5Checksum: []byte("d-md5")},
+		{Path: "photos/bar/a.jpg", Md5Checksum: []byte("a-md5")},
+		{Path: "photos/bar/b.jpg", Md5Checksum: []byte("b-md5")},
+		{Path: "photos/bar/c.jpg", Md5Checksum: []byte("c-md5")},
+		{Path: "photos/bar/e.jpg", Md5Checksum: []byte("e-md5")},
+	})
+
+	groups, err := newDirFinder(index, 0.7).Find()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, CategoryOverlap, groups[0].Category)
+	assert.Equal(t, []string{"photos/bar", "photos/foo"}, groups[0].Paths())
+	assert.Equal(t, 0.75, groups[0].Overlap)
+
+	groups, err = newDirFinder(index, 0.8).Find()
+
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func Test_Find_Directories_Empty_Index(t *testing.T) {
+	groups, err := newDirFinder(newIndex([]IndexedFile{}), 0.5).Find()
+
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
@@ -0,0 +1,214 @@
+package deduper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"time"
+)
+
+// exif tags that are read
+const (
+	exifMake        = 0x010f
+	exifModel       = 0x0110
+	exifOrientation = 0x0112
+	exifDateTime    = 0x0132
+	// pointers to the Exif and GPS directories
+	exifIfdPointer = 0x8769
+	exifGpsPointer = 0x8825
+	// in the Exif directory
+	exifDateTimeOriginal = 0x9003
+)
+
+// exifDateFormat is the format of dates in EXIF, which has no time zone.
+const exifDateFormat = "2006:01:02 15:04:05"
+
+// size in bytes of the types of EXIF values, by type
+var exifTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// exifInfo is the metadata read from the EXIF segment of a jpeg image.
+type exifInfo struct {
+	// Orientation is how the image should be transformed to display it, 1 to 8 as defined by EXIF. 1 is as stored.
+	Orientation int
+	Make        string
+	Model       string
+	// Taken is when the photo was taken, or when the file was changed if that is not known, in an unknown time zone.
+	Taken time.Time
+	// GPS is true when the location is recorded
+	GPS bool
+}
+
+var errNoExif = errors.New("no exif metadata")
+
+// readExif reads the EXIF metadata of a jpeg image, from the APP1 segment before the image data.
+func readExif(data []byte) (exifInfo, error) {
+	if len(data) < 4 || 0xff != data[0] || 0xd8 != data[1] {
+		return exifInfo{}, errNoExif
+	}
+
+	for pos := 2; pos+4 <= len(data); {
+		if 0xff != data[pos] {
+			return exifInfo{}, errNoExif
+		}
+		marker := data[pos+1]
+		if 0xda == marker || 0xd9 == marker {
+			// start of scan or end of image, no more metadata
+			return exifInfo{}, errNoExif
+		}
+		// the length includes the 2 bytes of the length itself
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return exifInfo{}, errNoExif
+		}
+		segment := data[pos+4 : end]
+		if 0xe1 == marker && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return parseTiff(segment[6:])
+		}
+		pos = end
+	}
+
+	return exifInfo{}, errNoExif
+}
+
+// parseTiff reads the TIFF structure that holds EXIF metadata: the first image file directory, and the Exif
+// directory it points to.
+func parseTiff(tiff []byte) (exifInfo, error) {
+	if len(tiff) < 8 {
+		return exifInfo{}, errNoExif
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return exifInfo{}, errNoExif
+	}
+
+	ifd0, ok := readIfd(tiff, order, order.Uint32(tiff[4:8]))
+	if !ok {
+		return exifInfo{}, errNoExif
+	}
+	info := exifInfo{
+		Orientation: 1,
+		Make:        exifString(ifd0[exifMake]),
+		Model:       exifString(ifd0[exifModel]),
+	}
+	if v := ifd0[exifOrientation]; len(v) >= 2 {
+		if o := int(order.Uint16(v)); o >= 1 && o <= 8 {
+			info.Orientation = o
+		}
+	}
+	info.Taken, _ = time.Parse(exifDateFormat, exifString(ifd0[exifDateTime]))
+	if v := ifd0[exifIfdPointer]; len(v) >= 4 {
+		if sub, ok := readIfd(tiff, order, order.Uint32(v)); ok {
+			if taken, err := time.Parse(exifDateFormat, exifString(sub[exifDateTimeOriginal])); nil == err {
+				info.Taken = taken
+			}
+		}
+	}
+	_, info.GPS = ifd0[exifGpsPointer]
+
+	return info, nil
+}
+
+// readIfd returns the values of the entries in the image file directory at the offset, by tag.
+// Entries with values outside of the TIFF structure are skipped.
+func readIfd(tiff []byte, order binary.ByteOrder, offset uint32) (map[uint16][]byte, bool) {
+	ifd := int(offset)
+	if ifd+2 > len(tiff) {
+		return nil, false
+	}
+
+	values := make(map[uint16][]byte)
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < entries; i++ {
+		// tag, type, count and value of 12 bytes, values of up to 4 bytes are stored in the entry
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		size := exifTypeSizes[order.Uint16(tiff[entry+2:entry+4])] * int(order.Uint32(tiff[entry+4:entry+8]))
+		start := entry + 8
+		if size > 4 {
+			start = int(order.Uint32(tiff[entry+8 : entry+12]))
+		}
+		if size < 0 || start+size > len(tiff) {
+			continue
+		}
+		values[order.Uint16(tiff[entry:entry+2])] = tiff[start : start+size]
+	}
+
+	return values, true
+}
+
+// exifString returns an ASCII value, which is terminated by a NUL and often padded with spaces.
+func exifString(v []byte) string {
+	if i := bytes.IndexByte(v, 0); i >= 0 {
+		v = v[:i]
+	}
+
+	return strings.TrimSpace(string(v))
+}
+
+// newImageInfo returns the size of the decoded image as displayed, and the EXIF metadata in the jpeg data.
+func newImageInfo(img image.Image, data []byte) *ImageInfo {
+	exif, err := readExif(data)
+	info := &ImageInfo{
+		Width:  img.Bounds().Dx(),
+		Height: img.Bounds().Dy(),
+	}
+	if nil != err {
+		return info
+	}
+
+	info.Width, info.Height, _ = orientedImage(img, exif.Orientation)
+	info.Exif = true
+	info.Orientation = exif.Orientation
+	info.Make = exif.Make
+	info.Model = exif.Model
+	info.Taken = exif.Taken
+	info.GPS = exif.GPS
+
+	return info
+}
+
+// orientedImage returns the size of the image as displayed with the EXIF orientation, and its pixels.
+func orientedImage(img image.Image, orientation int) (int, int, func(x int, y int) color.Color) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	at := func(x int, y int) color.Color {
+		return img.At(b.Min.X+x, b.Min.Y+y)
+	}
+
+	switch orientation {
+	case 2:
+		// mirrored horizontally
+		return w, h, func(x int, y int) color.Color { return at(w-1-x, y) }
+	case 3:
+		// rotated 180 degrees
+		return w, h, func(x int, y int) color.Color { return at(w-1-x, h-1-y) }
+	case 4:
+		// mirrored vertically
+		return w, h, func(x int, y int) color.Color { return at(x, h-1-y) }
+	case 5:
+		// mirrored along the top left to bottom right diagonal
+		return h, w, func(x int, y int) color.Color { return at(y, x) }
+	case 6:
+		// rotated 90 degrees clockwise
+		return h, w, func(x int, y int) color.Color { return at(y, h-1-x) }
+	case 7:
+		// mirrored along the top right to bottom left diagonal
+		return h, w, func(x int, y int) color.Color { return at(w-1-y, h-1-x) }
+	case 8:
+		// rotated 90 degrees anti-clockwise
+		return h, w, func(x int, y int) color.Color { return at(w-1-y, x) }
+	default:
+		return w, h, at
+	}
+}
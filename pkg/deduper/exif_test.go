@@ -0,0 +1,216 @@
+package deduper
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// exifSegment returns an APP1 segment with the orientation and another tag in the first directory.
+func exifSegment(order binary.AppendByteOrder, orientation uint16) []byte {
+	tiff := tiffHeader(order)
+	tiff = order.AppendUint16(tiff, 2)
+	// software, as an ascii string of 4 bytes stored in the entry
+	tiff = order.AppendUint16(tiff, 0x0131)
+	tiff = order.AppendUint16(tiff, 2)
+	tiff = order.AppendUint32(tiff, 4)
+	tiff = append(tiff, "app\x00"...)
+	tiff = ifdEntry(tiff, order, exifOrientation, 3, 1, uint32(orientation))
+	tiff = order.AppendUint32(tiff, 0)
+
+	return app1(tiff)
+}
+
+func tiffHeader(order binary.AppendByteOrder) []byte {
+	tiff := []byte("II")
+	if binary.BigEndian == order {
+		tiff = []byte("MM")
+	}
+	tiff = order.AppendUint16(tiff, 42)
+
+	return order.AppendUint32(tiff, 8)
+}
+
+func ifdEntry(tiff []byte, order binary.AppendByteOrder, tag uint16, typ uint16, count uint32, value uint32) []byte {
+	tiff = order.AppendUint16(tiff, tag)
+	tiff = order.AppendUint16(tiff, typ)
+	tiff = order.AppendUint32(tiff, count)
+	if 3 == typ && 1 == count {
+		// shorts are stored at the start of the value
+		tiff = order.AppendUint16(tiff, uint16(value))
+		return append(tiff, 0, 0)
+	}
+
+	return order.AppendUint32(tiff, value)
+}
+
+// cameraExif returns an APP1 segment with the metadata of a camera: strings stored outside of the directory,
+// and the date the photo was taken in the Exif directory.
+func cameraExif(order binary.AppendByteOrder, orientation uint16) []byte {
+	camera := []byte("Canon\x00")
+	model := []byte("Canon EOS 5D   \x00")
+	taken := []byte("2019:07:01 10:12:13\x00")
+	changed := []byte("2020:01:01 00:00:00\x00")
+	cameraAt := 8 + 2 + 6*12 + 4
+	modelAt := cameraAt + len(camera)
+	changedAt := modelAt + len(model)
+	exifAt := changedAt + len(changed)
+	takenAt := exifAt + 2 + 12 + 4
+	gpsAt := takenAt + len(taken)
+
+	tiff := tiffHeader(order)
+	tiff = order.AppendUint16(tiff, 6)
+	tiff = ifdEntry(tiff, order, exifMake, 2, uint32(len(camera)), uint32(cameraAt))
+	tiff = ifdEntry(tiff, order, exifModel, 2, uint32(len(model)), uint32(modelAt))
+	tiff = ifdEntry(tiff, order, exifOrientation, 3, 1, uint32(orientation))
+	tiff = ifdEntry(tiff, order, exifDateTime, 2, uint32(len(changed)), uint32(changedAt))
+	tiff = ifdEntry(tiff, order, exifIfdPointer, 4, 1, uint32(exifAt))
+	tiff = ifdEntry(tiff, order, exifGpsPointer, 4, 1, uint32(gpsAt))
+	tiff = order.AppendUint32(tiff, 0)
+	tiff = concat(tiff, camera, model, changed)
+	tiff = order.AppendUint16(tiff, 1)
+	tiff = ifdEntry(tiff, order, exifDateTimeOriginal, 2, uint32(len(taken)), uint32(takenAt))
+	tiff = order.AppendUint32(tiff, 0)
+	tiff = append(tiff, taken...)
+	// empty GPS directory
+	tiff = order.AppendUint16(tiff, 0)
+	tiff = order.AppendUint32(tiff, 0)
+
+	return app1(tiff)
+}
+
+// app1 returns the APP1 segment of a jpeg image, with the EXIF metadata in the TIFF structure.
+func app1(tiff []byte) []byte {
+	segment := []byte{0xff, 0xe1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+6+len(tiff)))
+	segment = append(segment, "Exif\x00\x00"...)
+
+	return append(segment, tiff...)
+}
+
+// withExif inserts the EXIF segment after the start of the jpeg image.
+func withExif(jpg []byte, segment []byte) []byte {
+	return concat(jpg[:2], segment, jpg[2:])
+}
+
+func Test_Read_Exif(t *testing.T) {
+	jpg := []byte{0xff, 0xd8, 0xff, 0xe0, 0, 4, 'J', 'F', 0xff, 0xda, 0, 2}
+
+	for _, order := range []binary.AppendByteOrder{binary.LittleEndian, binary.BigEndian} {
+		info, err := readExif(withExif(jpg, exifSegment(order, 6)))
+
+		assert.NoError(t, err)
+		assert.Equal(t, 6, info.Orientation)
+	}
+}
+
+func Test_Read_Exif_Camera(t *testing.T) {
+	for _, order := range []binary.AppendByteOrder{binary.LittleEndian, binary.BigEndian} {
+		info, err := readExif(withExif([]byte{0xff, 0xd8}, cameraExif(order, 8)))
+
+		assert.NoError(t, err)
+		assert.Equal(t, exifInfo{
+			Orientation: 8,
+			Make:        "Canon",
+			Model:       "Canon EOS 5D",
+			Taken:       time.Date(2019, 7, 1, 10, 12, 13, 0, time.UTC),
+			GPS:         true,
+		}, info)
+	}
+}
+
+func Test_Read_Exif_Date_Changed(t *testing.T) {
+	// without the Exif directory, the date the file was changed is used
+	order := binary.LittleEndian
+	changed := []byte("2020:01:01 00:00:00\x00")
+	tiff := tiffHeader(order)
+	tiff = order.AppendUint16(tiff, 1)
+	tiff = ifdEntry(tiff, order, exifDateTime, 2, uint32(len(changed)), 8+2+12+4)
+	tiff = order.AppendUint32(tiff, 0)
+	tiff = append(tiff, changed...)
+
+	info, err := readExif(withExif([]byte{0xff, 0xd8}, app1(tiff)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), info.Taken)
+	assert.Equal(t, "", info.Make)
+	assert.False(t, info.GPS)
+}
+
+func Test_New_Image_Info(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 30, 20))
+
+	info := newImageInfo(img, withExif([]byte{0xff, 0xd8}, cameraExif(binary.BigEndian, 6)))
+
+	// rotated, so as displayed it is higher than it is wide
+	assert.Equal(t, 20, info.Width)
+	assert.Equal(t, 30, info.Height)
+	assert.True(t, info.Exif)
+	assert.Equal(t, "Canon EOS 5D", info.Model)
+	assert.True(t, info.GPS)
+
+	info = newImageInfo(img, []byte{0xff, 0xd8})
+
+	assert.Equal(t, &ImageInfo{Width: 30, Height: 20}, info)
+}
+
+func Test_Read_Exif_Invalid_Orientation(t *testing.T) {
+	info, err := readExif(withExif([]byte{0xff, 0xd8}, exifSegment(binary.LittleEndian, 9)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, info.Orientation)
+}
+
+func Test_Read_Exif_None(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte("\x89PNG"),
+		{0xff, 0xd8, 0xff, 0xda, 0, 2},
+		// truncated segment
+		{0xff, 0xd8, 0xff, 0xe1, 0x10, 0},
+		// segment lengths too short to include the length itself
+		{0xff, 0xd8, 0xff, 0xe1, 0, 0, 0xff, 0xe1},
+		{0xff, 0xd8, 0xff, 0xe1, 0, 1, 0xff, 0xe1},
+		withExif([]byte{0xff, 0xd8}, exifSegment(binary.LittleEndian, 6))[:20],
+	} {
+		_, err := readExif(data)
+
+		assert.ErrorIs(t, err, errNoExif)
+	}
+}
+
+func Test_Oriented_Image(t *testing.T) {
+	// 3x2 image, with the pixel value being its stored position
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			img.SetGray(x, y, color.Gray{uint8(10*y + x)})
+		}
+	}
+
+	for orientation, expected := range map[int][][]uint8{
+		1: {{0, 1, 2}, {10, 11, 12}},
+		2: {{2, 1, 0}, {12, 11, 10}},
+		3: {{12, 11, 10}, {2, 1, 0}},
+		4: {{10, 11, 12}, {0, 1, 2}},
+		5: {{0, 10}, {1, 11}, {2, 12}},
+		6: {{10, 0}, {11, 1}, {12, 2}},
+		7: {{12, 2}, {11, 1}, {10, 0}},
+		8: {{2, 12}, {1, 11}, {0, 10}},
+	} {
+		w, h, at := orientedImage(img, orientation)
+
+		displayed := [][]uint8{}
+		for y := 0; y < h; y++ {
+			row := []uint8{}
+			for x := 0; x < w; x++ {
+				row = append(row, at(x, y).(color.Gray).Y)
+			}
+			displayed = append(displayed, row)
+		}
+		assert.Equal(t, expected, displayed, orientation)
+	}
+}
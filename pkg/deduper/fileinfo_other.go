@@ -0,0 +1,12 @@
+//go:build !unix
+
+package deduper
+
+import (
+	"os"
+)
+
+// ownerAndInode is not supported on this platform.
+func ownerAndInode(info os.FileInfo) (uid uint32, gid uint32, dev uint64, ino uint64) {
+	return 0, 0, 0, 0
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package deduper
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownerAndInode returns the owner, device and inode of the file, if the file system provides them.
+func ownerAndInode(info os.FileInfo) (uid uint32, gid uint32, dev uint64, ino uint64) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Uid, st.Gid, uint64(st.Dev), uint64(st.Ino)
+	}
+
+	return 0, 0, 0, 0
+}
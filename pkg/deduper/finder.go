@@ -2,139 +2,188 @@ package deduper
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
+	"slices"
+)
 
-	"github.com/corona10/goimagehash"
+// Strategy identifies the hash used to find duplicates.
+type Strategy string
+
+const (
+	StrategyMd5       Strategy = "md5"
+	StrategyImageHash Strategy = "imagehash"
+	// StrategyPixelHash hashes the pixels of images, to find exactly the same images with different metadata
+	StrategyPixelHash Strategy = "pixelhash"
+	StrategyVideoHash Strategy = "videohash"
+	StrategyAudioHash Strategy = "audiohash"
+	// StrategyAudioPrint uses acoustic fingerprints, to find audio that sounds the same
+	StrategyAudioPrint Strategy = "audioprint"
 )
 
 type Finder interface {
-	// return list of duplicate (list of list)
-	Find() ([][]string, error)
+	// return groups of duplicates, sorted by keeper
+	Find() ([]DuplicateGroup, error)
 }
 
 type CompositeFinder struct {
-	md5       *md5Finder
-	imageHash *imageHashFinder
+	finders []Finder
 }
 
-func newCompositeFinder(md5 bool, imageHash bool, index *Index) Finder {
-	var md5F *md5Finder
-	var imageHashF *imageHashFinder
-	if md5 {
-		md5F = &md5Finder{index}
-	}
-
-	if imageHash {
-		imageHashF = &imageHashFinder{index}
+func newCompositeFinder(index *Index, videoTolerance int, imageKind ImageHashKind, strategies ...Strategy) Finder {
+	finders := []Finder{}
+	for _, s := range strategies {
+		switch s {
+		case StrategyMd5:
+			finders = append(finders, &md5Finder{index})
+		case StrategyImageHash:
+			finders = append(finders, &imageHashFinder{index, imageKind})
+		case StrategyPixelHash:
+			finders = append(finders, &pixelHashFinder{index})
+		case StrategyVideoHash:
+			finders = append(finders, &videoHashFinder{index, videoTolerance})
+		case StrategyAudioHash:
+			finders = append(finders, &audioHashFinder{index})
+		case StrategyAudioPrint:
+			finders = append(finders, &audioPrintFinder{index})
+		}
 	}
 
-	return &CompositeFinder{
-		md5F,
-		imageHashF,
-	}
+	return &CompositeFinder{finders}
 }
 
-func (finder CompositeFinder) Find() ([][]string, error) {
-	if nil == finder.md5 && nil == finder.imageHash {
-		return nil, errors.New("Finder type must be specified (md5 or imagehash)")
-	}
-
-	if nil != finder.md5 && nil != finder.imageHash {
-		return nil, errors.New("Finder only supports 1 type of hash at a time (md5 or imagehash)")
+func (finder CompositeFinder) Find() ([]DuplicateGroup, error) {
+	if 0 == len(finder.finders) {
+		return nil, errors.New("Finder type must be specified (md5, imagehash, pixelhash, videohash, audiohash or audioprint)")
 	}
 
-	if nil != finder.md5 {
-		return finder.md5.Find()
+	if len(finder.finders) > 1 {
+		return nil, errors.New("Finder only supports 1 type of hash at a time (md5, imagehash, pixelhash, videohash, audiohash or audioprint)")
 	}
 
-	if nil != finder.imageHash {
-		return finder.imageHash.Find()
-	}
-
-	panic("Oops")
+	return finder.finders[0].Find()
 }
 
 type md5Finder struct {
 	index *Index
 }
 
-func (finder md5Finder) Find() ([][]string, error) {
-	dupes := make(map[string][]string)
-	skip := make(map[string]bool)
-	cnt := 0
-	for i, v := range finder.index.ind {
-		key := string(v.Md5Checksum)
-		if _, found := skip[key]; found {
-			// already considered this duplicate
+// emptyMd5 is the md5 checksum of a file without content.
+var emptyMd5 = md5.Sum(nil)
+
+func (finder md5Finder) Find() ([]DuplicateGroup, error) {
+	dupes := make(map[string][]GroupMember)
+	keys := []string{}
+	hashed := []IndexedFile{}
+	empty := []IndexedFile{}
+	for _, v := range finder.index.Files() {
+		if nil == v.Md5Checksum {
+			// not hashed with md5
 			continue
 		}
-		for _, vv := range finder.index.ind[i+1:] {
-			if bytes.Compare(v.Md5Checksum, vv.Md5Checksum) == 0 {
-				if _, ok := dupes[key]; !ok {
-					skip[key] = true
-					dupes[key] = []string{v.Path}
-				}
-
-				dupes[key] = append(dupes[key], vv.Path)
-
-				cnt++
-			}
+		if bytes.Equal(emptyMd5[:], v.Md5Checksum) {
+			// all empty files have the same checksum, but are not copies of each other
+			empty = append(empty, v)
+			continue
+		}
+		hashed = append(hashed, v)
+		key := string(v.Md5Checksum)
+		if _, found := dupes[key]; !found {
+			keys = append(keys, key)
 		}
+		dupes[key] = append(dupes[key], GroupMember{IndexedFile: v})
 	}
 
-	all := make([][]string, len(dupes))
-	i := 0
-	for _, v := range dupes {
-		all[i] = v
-		i++
+	all := linkedGroups(StrategyMd5, hashed)
+	for _, key := range keys {
+		// hard links to the same file are a single copy
+		if members := collapseLinks(dupes[key]); len(members) > 1 {
+			all = append(all, newDuplicateGroup(StrategyMd5, hex.EncodeToString([]byte(key)), members))
+		}
+	}
+	if 0 != len(empty) {
+		all = append(all, newEmptyGroup(StrategyMd5, hex.EncodeToString(emptyMd5[:]), empty))
 	}
+	sortGroups(all)
 
 	return all, nil
 }
 
 type imageHashFinder struct {
 	index *Index
+	// kind of hash to compare, images that are not hashed with it are ignored
+	kind ImageHashKind
 }
 
-func (finder imageHashFinder) Find() ([][]string, error) {
-	dupes := make(map[uint64][]string)
-	skip := make(map[uint64]bool)
-	cnt := 0
-	for i, v := range finder.index.ind {
-		key := v.ImageHash.Hash
-		if _, found := skip[key]; found {
+// Find groups images with the same hash of the kind of the finder, hashes of other kinds are never compared.
+// Images indexed with their transforms also match rotated and flipped copies,
+// and the members that are transformed copies of the keeper have the transform set.
+func (finder imageHashFinder) Find() ([]DuplicateGroup, error) {
+	hashed := []IndexedFile{}
+	hashes := [][]uint64{}
+	for _, v := range finder.index.Files() {
+		if h, ok := v.imageHash(finder.kind); ok {
+			hashed = append(hashed, v)
+			hashes = append(hashes, h)
+		}
+	}
+	// transforms are only hashed with the default kind
+	transforms := DefaultImageHashKind == finder.kind
+
+	dupes := make(map[string][]GroupMember)
+	keys := []string{}
+	grouped := make(map[string]bool)
+	// the orientation that transforms the image a member matched into the member
+	matched := make(map[string]int)
+	for i, v := range hashed {
+		if grouped[v.Path] {
 			// already considered this duplicate
 			continue
 		}
 
-		hash := goimagehash.NewImageHash(v.ImageHash.Hash, goimagehash.Kind(v.ImageHash.Kind))
-		for _, vv := range finder.index.ind[i+1:] {
-			hash2 := goimagehash.NewImageHash(vv.ImageHash.Hash, goimagehash.Kind(vv.ImageHash.Kind))
-			distance, err := hash.Distance(hash2)
-
-			if nil != err {
-				return nil, err
+		key := imageHashKey(hashes[i])
+		for j, vv := range hashed[i+1:] {
+			if grouped[vv.Path] {
+				continue
 			}
-
-			if distance == 0 {
+			orientation := 0
+			if slices.Equal(hashes[i], hashes[i+1+j]) {
+				orientation = 1
+			} else if transforms {
+				orientation = imageTransform(v, vv)
+			}
+			if 0 != orientation {
 				if _, ok := dupes[key]; !ok {
-					skip[key] = true
-					dupes[key] = []string{v.Path}
+					keys = append(keys, key)
+					dupes[key] = []GroupMember{{IndexedFile: v}}
+					matched[v.Path] = 1
 				}
 
-				dupes[key] = append(dupes[key], vv.Path)
-
-				cnt++
+				dupes[key] = append(dupes[key], GroupMember{IndexedFile: vv})
+				grouped[vv.Path] = true
+				matched[vv.Path] = orientation
 			}
 		}
 	}
 
-	all := make([][]string, len(dupes))
-	i := 0
-	for _, v := range dupes {
-		all[i] = v
-		i++
+	all := linkedGroups(StrategyImageHash, hashed)
+	for _, key := range keys {
+		// hard links to the same file are a single copy
+		if members := collapseLinks(dupes[key]); len(members) > 1 {
+			group := newDuplicateGroup(StrategyImageHash, key, members)
+			if transforms {
+				// all members matched the same image, which the keeper need not be: undo the keeper's orientation
+				// and apply the member's
+				undo := inverseOrientation(matched[group.Members[0].Path])
+				for i, m := range group.Members[1:] {
+					group.Members[i+1].Transform = transformNames[composeOrientations(undo, matched[m.Path])]
+				}
+			}
+			all = append(all, group)
+		}
 	}
+	sortGroups(all)
 
 	return all, nil
 }
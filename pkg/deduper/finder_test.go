@@ -1,6 +1,7 @@
 package deduper
 
 import (
+	"crypto/md5"
 	"sync"
 	"testing"
 
@@ -8,7 +9,7 @@ import (
 )
 
 func Test_No_Finders(t *testing.T) {
-	finder := newCompositeFinder(false, false, &Index{})
+	finder := newCompositeFinder(&Index{}, 0, DefaultImageHashKind)
 
 	_, err := finder.Find()
 
@@ -16,7 +17,7 @@ func Test_No_Finders(t *testing.T) {
 }
 
 func Test_Multiple_Finders(t *testing.T) {
-	finder := newCompositeFinder(true, true, &Index{})
+	finder := newCompositeFinder(&Index{}, 0, DefaultImageHashKind, StrategyMd5, StrategyImageHash)
 
 	_, err := finder.Find()
 
@@ -25,7 +26,7 @@ func Test_Multiple_Finders(t *testing.T) {
 
 func Test_Find_Md5(t *testing.T) {
 	index := &Index{
-		sync.Mutex{},
+		sync.RWMutex{},
 		map[string]int{},
 		[]IndexedFile{
 			{
@@ -38,16 +39,19 @@ func Test_Find_Md5(t *testing.T) {
 			},
 		},
 	}
-	finder := newCompositeFinder(true, false, index)
+	finder := newCompositeFinder(index, 0, DefaultImageHashKind, StrategyMd5)
 
 	dupes, _ := finder.Find()
 
-	assert.Equal(t, []string{"foo", "bar"}, dupes[0])
+	assert.Equal(t, []string{"bar", "foo"}, dupes[0].Paths())
+	assert.Equal(t, "bar", dupes[0].Keeper)
+	assert.Equal(t, StrategyMd5, dupes[0].Strategy)
+	assert.Equal(t, "666f6f2d6d6435", dupes[0].Key)
 }
 
 func Test_Find_ImageHash(t *testing.T) {
 	index := &Index{
-		sync.Mutex{},
+		sync.RWMutex{},
 		map[string]int{},
 		[]IndexedFile{
 			{
@@ -66,9 +70,68 @@ func Test_Find_ImageHash(t *testing.T) {
 			},
 		},
 	}
-	finder := newCompositeFinder(false, true, index)
+	finder := newCompositeFinder(index, 0, DefaultImageHashKind, StrategyImageHash)
 
 	dupes, _ := finder.Find()
 
-	assert.Equal(t, []string{"foo", "bar"}, dupes[0])
+	assert.Equal(t, []string{"bar", "foo"}, dupes[0].Paths())
+	assert.Equal(t, StrategyImageHash, dupes[0].Strategy)
+	assert.Equal(t, "c0a0b0f0f0f8c0c0", dupes[0].Key)
+}
+
+func Test_Find_Md5_Hard_Links(t *testing.T) {
+	index := newIndex([]IndexedFile{
+		{Path: "foo", Md5Checksum: []byte("foo-md5"), Size: 10, Dev: 1, Ino: 100},
+		{Path: "dir/foo-link", Md5Checksum: []byte("foo-md5"), Size: 10, Dev: 1, Ino: 100},
+		{Path: "bar", Md5Checksum: []byte("foo-md5"), Size: 10, Dev: 1, Ino: 200},
+	})
+	finder := newCompositeFinder(index, 0, DefaultImageHashKind, StrategyMd5)
+
+	groups, err := finder.Find()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 2)
+	dupes := groups[0]
+	assert.Equal(t, CategoryDuplicate, dupes.Category)
+	assert.Equal(t, []string{"bar", "foo"}, dupes.Paths())
+	assert.Equal(t, []string{"dir/foo-link"}, dupes.Members[1].Links)
+	assert.Equal(t, int64(10), dupes.Reclaimable)
+	linked := groups[1]
+	assert.Equal(t, CategoryLinked, linked.Category)
+	assert.Equal(t, []string{"foo", "dir/foo-link"}, linked.Paths())
+	assert.Equal(t, int64(0), linked.Reclaimable)
+}
+
+func Test_Find_Md5_Only_Hard_Links(t *testing.T) {
+	index := newIndex([]IndexedFile{
+		{Path: "foo", Md5Checksum: []byte("foo-md5"), Size: 10, Dev: 1, Ino: 100},
+		{Path: "foo-link", Md5Checksum: []byte("foo-md5"), Size: 10, Dev: 1, Ino: 100},
+	})
+	finder := newCompositeFinder(index, 0, DefaultImageHashKind, StrategyMd5)
+
+	groups, _ := finder.Find()
+
+	assert.Len(t, groups, 1)
+	assert.Equal(t, CategoryLinked, groups[0].Category)
+}
+
+func Test_Find_Md5_Empty_Files(t *testing.T) {
+	empty := md5.Sum(nil)
+	index := newIndex([]IndexedFile{
+		{Path: "foo", Md5Checksum: empty[:]},
+		{Path: "bar", Md5Checksum: empty[:]},
+		{Path: "fred", Md5Checksum: []byte("fred-md5")},
+		{Path: "bob/fred", Md5Checksum: []byte("fred-md5")},
+	})
+	finder := newCompositeFinder(index, 0, DefaultImageHashKind, StrategyMd5)
+
+	groups, err := finder.Find()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 2)
+	assert.Equal(t, CategoryDuplicate, groups[0].Category)
+	assert.Equal(t, []string{"fred", "bob/fred"}, groups[0].Paths())
+	assert.Equal(t, CategoryEmpty, groups[1].Category)
+	assert.Equal(t, []string{"bar", "foo"}, groups[1].Paths())
+	assert.Empty(t, groups[1].Keeper)
 }
@@ -0,0 +1,310 @@
+package deduper
+
+import (
+	"cmp"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Category describes how the members of a group relate to each other.
+type Category string
+
+const (
+	// CategoryDuplicate members are separate copies of the same content, of which all but the keeper can be removed.
+	CategoryDuplicate Category = "duplicate"
+	// CategoryLinked members are hard links, or followed symlinks, to the same file. Removing any of them reclaims no space.
+	CategoryLinked Category = "linked"
+	// CategoryDirectory members are directories with the same content, of which all but the keeper can be removed.
+	CategoryDirectory Category = "directory"
+	// CategoryOverlap members are 2 directories that share some of their content. They are reported rather than acted on.
+	CategoryOverlap Category = "overlap"
+	// CategoryEmpty members are all files without content. They are reported rather than acted on, and have no keeper.
+	CategoryEmpty Category = "empty"
+)
+
+// categoryRank orders groups by category, for groups that can be acted on first.
+var categoryRank = map[Category]int{
+	CategoryDuplicate: 0,
+	CategoryDirectory: 1,
+	CategoryOverlap:   2,
+	CategoryLinked:    3,
+	CategoryEmpty:     4,
+}
+
+// GroupMember is a file in a group of duplicates.
+type GroupMember struct {
+	IndexedFile
+	// Distance between the hash of this file and the hash of the keeper, 0 for an exact match.
+	Distance int
+	// Transform is how an image is rotated or flipped compared to the keeper, if it is, e.g. "rotated 90° clockwise".
+	Transform string `json:",omitempty"`
+	// Links are the other paths that are hard links, or followed symlinks, to this file.
+	Links []string `json:",omitempty"`
+}
+
+// AllPaths returns the path of the member and all paths linked to it.
+func (m GroupMember) AllPaths() []string {
+	return append([]string{m.Path}, m.Links...)
+}
+
+// DuplicateGroup is a group of files that are duplicates of each other according to a strategy.
+// Members are sorted so that the keeper comes first.
+type DuplicateGroup struct {
+	Strategy Strategy
+	Category Category
+	// Key is the (hex encoded) hash the members matched on, or device and inode for linked files. Members of groups
+	// of similar files match on different hashes, so their key is a hash of the member paths instead.
+	Key     string
+	Members []GroupMember
+	// Keeper is the path of the member to keep when acting on the duplicates.
+	Keeper string
+	// Reclaimable is the number of bytes freed by removing all members except the keeper and archive members.
+	Reclaimable int64
+	// Overlap is the fraction of files that directories have in common, only set for directories.
+	Overlap float64 `json:",omitempty"`
+}
+
+func newDuplicateGroup(strategy Strategy, key string, members []GroupMember) DuplicateGroup {
+	sortByKeeper(members)
+	group := DuplicateGroup{
+		Strategy: strategy,
+		Category: CategoryDuplicate,
+		Key:      key,
+		Members:  members,
+		Keeper:   members[0].Path,
+	}
+	for _, m := range group.Duplicates() {
+		if "" == m.Archive {
+			group.Reclaimable += m.Size
+		}
+	}
+
+	return group
+}
+
+// newLinkedGroup creates a group of paths that are all hard links to the same file.
+func newLinkedGroup(strategy Strategy, files []IndexedFile) DuplicateGroup {
+	members := make([]GroupMember, len(files))
+	for i, f := range files {
+		members[i] = GroupMember{IndexedFile: f}
+	}
+	sortByKeeper(members)
+
+	return DuplicateGroup{
+		Strategy: strategy,
+		Category: CategoryLinked,
+		Key:      inodeKey(files[0]),
+		Members:  members,
+		Keeper:   members[0].Path,
+	}
+}
+
+// newEmptyGroup creates a group of all files without content.
+func newEmptyGroup(strategy Strategy, key string, files []IndexedFile) DuplicateGroup {
+	members := make([]GroupMember, len(files))
+	for i, f := range files {
+		members[i] = GroupMember{IndexedFile: f}
+	}
+	sortByKeeper(members)
+
+	return DuplicateGroup{
+		Strategy: strategy,
+		Category: CategoryEmpty,
+		Key:      key,
+		Members:  members,
+	}
+}
+
+// membersKey is a key of the members of a group of similar files, that is unique and the same as long as the
+// members are.
+func membersKey(members []GroupMember) string {
+	paths := make([]string, len(members))
+	for i, m := range members {
+		paths[i] = m.Path
+	}
+	sort.Strings(paths)
+	h := md5.New()
+	for _, p := range paths {
+		// paths never contain a nul, so the separator keeps a/b, c different from a, b/c
+		h.Write([]byte(p + "\x00"))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func inodeKey(f IndexedFile) string {
+	return fmt.Sprintf("%v:%v", f.Dev, f.Ino)
+}
+
+// isLinkable is true when the device and inode of the file are known, so hard links can be detected.
+func (f IndexedFile) isLinkable() bool {
+	return 0 != f.Ino
+}
+
+// collapseLinks merges members that are hard links to the same file into a single member.
+func collapseLinks(members []GroupMember) []GroupMember {
+	collapsed := []GroupMember{}
+	byInode := make(map[string][]GroupMember)
+	keys := []string{}
+	for _, m := range members {
+		if !m.isLinkable() {
+			collapsed = append(collapsed, m)
+			continue
+		}
+		key := inodeKey(m.IndexedFile)
+		if _, found := byInode[key]; !found {
+			keys = append(keys, key)
+		}
+		byInode[key] = append(byInode[key], m)
+	}
+
+	for _, key := range keys {
+		links := byInode[key]
+		sortByKeeper(links)
+		m := links[0]
+		for _, l := range links[1:] {
+			m.Links = append(m.Links, l.Path)
+		}
+		collapsed = append(collapsed, m)
+	}
+
+	return collapsed
+}
+
+// linkedGroups returns a group for every file that has more than 1 path in the given files.
+func linkedGroups(strategy Strategy, files []IndexedFile) []DuplicateGroup {
+	byInode := make(map[string][]IndexedFile)
+	keys := []string{}
+	for _, f := range files {
+		if !f.isLinkable() {
+			continue
+		}
+		key := inodeKey(f)
+		if _, found := byInode[key]; !found {
+			keys = append(keys, key)
+		}
+		byInode[key] = append(byInode[key], f)
+	}
+
+	groups := []DuplicateGroup{}
+	for _, key := range keys {
+		if len(byInode[key]) > 1 {
+			groups = append(groups, newLinkedGroup(strategy, byInode[key]))
+		}
+	}
+
+	return groups
+}
+
+// Paths returns the paths of all members, starting with the keeper.
+func (g DuplicateGroup) Paths() []string {
+	paths := make([]string, len(g.Members))
+	for i, m := range g.Members {
+		paths[i] = m.Path
+	}
+
+	return paths
+}
+
+// Duplicates returns all members except the keeper.
+func (g DuplicateGroup) Duplicates() []GroupMember {
+	dupes := []GroupMember{}
+	for _, m := range g.Members {
+		if m.Path != g.Keeper {
+			dupes = append(dupes, m)
+		}
+	}
+
+	return dupes
+}
+
+// Size is the total number of bytes of all members.
+func (g DuplicateGroup) Size() int64 {
+	var size int64
+	for _, m := range g.Members {
+		size += m.Size
+	}
+
+	return size
+}
+
+// sortByKeeper sorts the members so that the one to keep comes first: a file rather than an archive member,
+// a regular file rather than a symlink, then the original of an image (see compareImages), then the one nearest to the root,
+// and the first alphabetical if on the same level.
+// Never keeping a symlink means acting on the duplicates does not leave a symlink to a removed file.
+// Never keeping an archive member means a file is not removed because it is also in an archive.
+// TODO: let user figure out which ones to delete and which to keep.
+func sortByKeeper(members []GroupMember) {
+	sort.SliceStable(members, func(i, j int) bool {
+		if ("" == members[i].Archive) != ("" == members[j].Archive) {
+			return "" == members[i].Archive
+		}
+		if members[i].Symlink != members[j].Symlink {
+			return !members[i].Symlink
+		}
+		if c := compareImages(members[i].Image, members[j].Image); 0 != c {
+			return c < 0
+		}
+		iPath := members[i].Path
+		jPath := members[j].Path
+		iDepth := strings.Count(iPath, "/")
+		jDepth := strings.Count(jPath, "/")
+		if iDepth == jDepth {
+			// same level -> first alphabetical
+			return strings.TrimSuffix(iPath, filepath.Ext(iPath)) < strings.TrimSuffix(jPath, filepath.Ext(jPath))
+		}
+		return iDepth < jDepth
+	})
+}
+
+// compareImages is negative when image a is more likely the original from the camera than b, and positive when b is:
+// the one with the highest resolution, then the one with EXIF metadata, and then the one taken first.
+// It is 0 when they cannot be told apart, or when either is not an image.
+func compareImages(a *ImageInfo, b *ImageInfo) int {
+	if nil == a || nil == b {
+		return 0
+	}
+	if c := cmp.Compare(b.Width*b.Height, a.Width*a.Height); 0 != c {
+		return c
+	}
+	if a.Exif != b.Exif {
+		if a.Exif {
+			return -1
+		}
+		return 1
+	}
+	if !a.Taken.IsZero() && !b.Taken.IsZero() {
+		return a.Taken.Compare(b.Taken)
+	}
+
+	return 0
+}
+
+// sortGroups sorts the groups that can be acted on first, see categoryRank, and then by keeper.
+func sortGroups(groups []DuplicateGroup) {
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Category != groups[j].Category {
+			return categoryRank[groups[i].Category] < categoryRank[groups[j].Category]
+		}
+		return groups[i].Keeper < groups[j].Keeper
+	})
+}
+
+// FilterGroups returns the groups of any of the given categories.
+func FilterGroups(groups []DuplicateGroup, categories ...Category) []DuplicateGroup {
+	filtered := []DuplicateGroup{}
+	for _, g := range groups {
+		for _, category := range categories {
+			if category == g.Category {
+				filtered = append(filtered, g)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
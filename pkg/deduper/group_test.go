@@ -0,0 +1,99 @@
+package deduper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_New_Duplicate_Group(t *testing.T) {
+	group := newDuplicateGroup(StrategyMd5, "abc", []GroupMember{
+		{IndexedFile: IndexedFile{Path: "pictures/copy/foo.jpg", Size: 100}},
+		{IndexedFile: IndexedFile{Path: "pictures/foo (1).jpg", Size: 100}},
+		{IndexedFile: IndexedFile{Path: "pictures/foo.jpg", Size: 100}},
+	})
+
+	assert.Equal(t, "pictures/foo.jpg", group.Keeper)
+	assert.Equal(t, []string{"pictures/foo.jpg", "pictures/foo (1).jpg", "pictures/copy/foo.jpg"}, group.Paths())
+	assert.Len(t, group.Duplicates(), 2)
+	assert.Equal(t, int64(200), group.Reclaimable)
+	assert.Equal(t, int64(300), group.Size())
+}
+
+func Test_New_Duplicate_Group_Never_Keeps_Symlink(t *testing.T) {
+	group := newDuplicateGroup(StrategyMd5, "abc", []GroupMember{
+		{IndexedFile: IndexedFile{Path: "pictures/foo.jpg", Size: 100, Symlink: true, LinkTarget: "/tmp/foo.jpg"}},
+		{IndexedFile: IndexedFile{Path: "pictures/copy/foo.jpg", Size: 100}},
+	})
+
+	assert.Equal(t, "pictures/copy/foo.jpg", group.Keeper)
+	assert.Equal(t, "pictures/foo.jpg", group.Duplicates()[0].Path)
+}
+
+func Test_New_Duplicate_Group_Keeps_Original_Image(t *testing.T) {
+	taken := time.Date(2019, 7, 1, 10, 12, 13, 0, time.UTC)
+	original := &ImageInfo{Width: 4000, Height: 3000, Exif: true, Taken: taken}
+	for _, tc := range []struct {
+		name string
+		copy *ImageInfo
+	}{
+		{"smaller", &ImageInfo{Width: 2000, Height: 1500, Exif: true, Taken: taken}},
+		{"without exif", &ImageInfo{Width: 4000, Height: 3000}},
+		{"taken later", &ImageInfo{Width: 4000, Height: 3000, Exif: true, Taken: taken.Add(time.Hour)}},
+	} {
+		group := newDuplicateGroup(StrategyImageHash, "abc", []GroupMember{
+			{IndexedFile: IndexedFile{Path: "export/photo.jpg", Image: tc.copy}},
+			{IndexedFile: IndexedFile{Path: "camera/2019/07/photo.jpg", Image: original}},
+		})
+
+		assert.Equal(t, "camera/2019/07/photo.jpg", group.Keeper, tc.name)
+	}
+}
+
+func Test_New_Duplicate_Group_Same_Image(t *testing.T) {
+	info := &ImageInfo{Width: 4000, Height: 3000, Exif: true}
+	group := newDuplicateGroup(StrategyImageHash, "abc", []GroupMember{
+		{IndexedFile: IndexedFile{Path: "camera/2019/07/photo.jpg", Image: info}},
+		{IndexedFile: IndexedFile{Path: "photo.jpg", Image: info}},
+		{IndexedFile: IndexedFile{Path: "unknown.jpg"}},
+	})
+
+	// falls back to the one nearest to the root
+	assert.Equal(t, "photo.jpg", group.Keeper)
+}
+
+func Test_Members_Key(t *testing.T) {
+	key := membersKey([]GroupMember{{IndexedFile: IndexedFile{Path: "a/b"}}, {IndexedFile: IndexedFile{Path: "c"}}})
+
+	assert.Len(t, key, 32)
+	// the same members in any order
+	assert.Equal(t, key, membersKey([]GroupMember{{IndexedFile: IndexedFile{Path: "c"}}, {IndexedFile: IndexedFile{Path: "a/b"}}}))
+	assert.NotEqual(t, key, membersKey([]GroupMember{{IndexedFile: IndexedFile{Path: "a"}}, {IndexedFile: IndexedFile{Path: "b/c"}}}))
+	assert.NotEqual(t, key, membersKey([]GroupMember{{IndexedFile: IndexedFile{Path: "a/b"}}}))
+}
+
+func Test_Sort_Groups_By_Category(t *testing.T) {
+	groups := []DuplicateGroup{
+		{Category: CategoryEmpty, Keeper: ""},
+		{Category: CategoryLinked, Keeper: "a"},
+		{Category: CategoryDuplicate, Keeper: "b"},
+		{Category: CategoryDuplicate, Keeper: "a"},
+	}
+
+	sortGroups(groups)
+
+	assert.Equal(t, []DuplicateGroup{
+		{Category: CategoryDuplicate, Keeper: "a"},
+		{Category: CategoryDuplicate, Keeper: "b"},
+		{Category: CategoryLinked, Keeper: "a"},
+		{Category: CategoryEmpty, Keeper: ""},
+	}, groups)
+}
+
+func Test_Common_Dir(t *testing.T) {
+	assert.Equal(t, "/tmp/test", commonDir([]string{"/tmp/test/foo.txt", "/tmp/test/bob/bar.txt"}))
+	assert.Equal(t, "/tmp", commonDir([]string{"/tmp/test/foo.txt", "/tmp/testing/bar.txt"}))
+	assert.Equal(t, "pictures", commonDir([]string{"pictures/foo.txt"}))
+	assert.Equal(t, "/", commonDir([]string{"/foo.txt", "/tmp/bar.txt"}))
+}
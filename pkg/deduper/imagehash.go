@@ -0,0 +1,123 @@
+package deduper
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/corona10/goimagehash"
+)
+
+// ImageAlgorithm is a perceptual hash algorithm, to find images that look alike.
+type ImageAlgorithm string
+
+const (
+	// ImageAlgorithmAverage compares the pixels of a thumbnail to their average brightness.
+	ImageAlgorithmAverage ImageAlgorithm = "average"
+	// ImageAlgorithmDifference compares the brightness of neighbouring pixels of a thumbnail.
+	ImageAlgorithmDifference ImageAlgorithm = "difference"
+	// ImageAlgorithmPerception compares the low frequencies of a thumbnail, which is the most robust to edits.
+	ImageAlgorithmPerception ImageAlgorithm = "perception"
+)
+
+// ImageHashKind is a perceptual hash algorithm and the size of the hash, in bits per side.
+type ImageHashKind struct {
+	Algorithm ImageAlgorithm
+	Size      int
+}
+
+// DefaultImageHashKind is the 8x8 difference hash, which is stored as the ImageHash of a file.
+var DefaultImageHashKind = ImageHashKind{ImageAlgorithmDifference, 8}
+
+// NewImageHashKind returns the kind of hash for the algorithm (average, difference or perception) and size.
+// The size is a power of 2 from 4 to 16: 8 for the standard 64 bit hashes, 16 for 256 bit hashes.
+func NewImageHashKind(algorithm string, size int) (ImageHashKind, error) {
+	kind := ImageHashKind{ImageAlgorithm(strings.ToLower(algorithm)), size}
+	switch kind.Algorithm {
+	case ImageAlgorithmAverage, ImageAlgorithmDifference, ImageAlgorithmPerception:
+	default:
+		return ImageHashKind{}, fmt.Errorf("unknown image hash algorithm %q, use average, difference or perception", algorithm)
+	}
+	if size < 4 || size > 16 || 0 != size&(size-1) {
+		return ImageHashKind{}, fmt.Errorf("image hash size %v is not 4, 8 or 16", size)
+	}
+
+	return kind, nil
+}
+
+func (k ImageHashKind) String() string {
+	if 8 == k.Size {
+		return string(k.Algorithm)
+	}
+
+	return fmt.Sprintf("%v %vx%v", k.Algorithm, k.Size, k.Size)
+}
+
+// PerceptualHash is an image hash of another kind than the default, stored side by side with the other kinds
+// the image was hashed with.
+type PerceptualHash struct {
+	ImageHashKind
+	Hash []uint64
+}
+
+// perceptualHash hashes the image with the kind of hash.
+func perceptualHash(img image.Image, kind ImageHashKind) (PerceptualHash, error) {
+	var h *goimagehash.ExtImageHash
+	var err error
+	switch kind.Algorithm {
+	case ImageAlgorithmAverage:
+		h, err = goimagehash.ExtAverageHash(img, kind.Size, kind.Size)
+	case ImageAlgorithmPerception:
+		h, err = goimagehash.ExtPerceptionHash(img, kind.Size, kind.Size)
+	default:
+		h, err = goimagehash.ExtDifferenceHash(img, kind.Size, kind.Size)
+	}
+	if nil != err {
+		return PerceptualHash{}, err
+	}
+
+	return PerceptualHash{kind, h.GetHash()}, nil
+}
+
+// imageHash returns the hash of the kind, if the file was hashed with it.
+func (f IndexedFile) imageHash(kind ImageHashKind) ([]uint64, bool) {
+	if DefaultImageHashKind == kind {
+		if (ImageHash{}) == f.ImageHash || int(goimagehash.DHash) != f.ImageHash.Kind {
+			return nil, false
+		}
+		return []uint64{f.ImageHash.Hash}, true
+	}
+	for _, h := range f.ImageHashes {
+		if kind == h.ImageHashKind {
+			return h.Hash, true
+		}
+	}
+
+	return nil, false
+}
+
+// mergeImageHashes replaces the hashes of the kinds in hashes, and keeps the hashes of other kinds.
+func mergeImageHashes(existing []PerceptualHash, hashes []PerceptualHash) []PerceptualHash {
+	merged := append([]PerceptualHash{}, hashes...)
+	for _, h := range existing {
+		found := false
+		for _, hh := range hashes {
+			found = found || h.ImageHashKind == hh.ImageHashKind
+		}
+		if !found {
+			merged = append(merged, h)
+		}
+	}
+
+	return merged
+}
+
+// imageHashKey is the hex encoded hash, as the key of a group.
+func imageHashKey(hash []uint64) string {
+	key := ""
+	for _, h := range hash {
+		key += fmt.Sprintf("%016x", h)
+	}
+
+	return key
+}
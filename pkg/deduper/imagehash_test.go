@@ -0,0 +1,110 @@
+package deduper
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_New_Image_Hash_Kind(t *testing.T) {
+	kind, err := NewImageHashKind("Perception", 16)
+
+	assert.NoError(t, err)
+	assert.Equal(t, ImageHashKind{ImageAlgorithmPerception, 16}, kind)
+	assert.Equal(t, "perception 16x16", kind.String())
+	assert.Equal(t, "difference", DefaultImageHashKind.String())
+
+	for _, size := range []int{0, 2, 6, 32} {
+		_, err = NewImageHashKind("average", size)
+		assert.Error(t, err, size)
+	}
+	_, err = NewImageHashKind("wavelet", 8)
+	assert.Error(t, err)
+}
+
+func Test_Image_Hasher_Kinds(t *testing.T) {
+	fs := transformFixture(t)
+	kinds := []ImageHashKind{
+		DefaultImageHashKind,
+		{ImageAlgorithmAverage, 8},
+		{ImageAlgorithmPerception, 16},
+		{ImageAlgorithmDifference, 4},
+	}
+
+	photo := hashImage(t, imageHasher{fs, kinds, false, discardLogger(), nil}, "photos/photo.jpg")
+
+	assert.Equal(t, 3, photo.ImageHash.Kind)
+	assert.Len(t, photo.ImageHashes, 3)
+	for _, kind := range kinds {
+		h, ok := photo.imageHash(kind)
+		assert.True(t, ok, kind)
+		// at least 1 word, of 64 bits
+		assert.Len(t, h, (kind.Size*kind.Size+63)/64, kind)
+	}
+	_, ok := photo.imageHash(ImageHashKind{ImageAlgorithmAverage, 16})
+	assert.False(t, ok)
+
+	// without the default kind there is no image hash
+	other := hashImage(t, imageHasher{fs, kinds[1:2], true, discardLogger(), nil}, "photos/other.jpg")
+	assert.Equal(t, ImageHash{}, other.ImageHash)
+	assert.Nil(t, other.ImageTransforms)
+	assert.Len(t, other.ImageHashes, 1)
+}
+
+func Test_Merge_Image_Hashes(t *testing.T) {
+	average := ImageHashKind{ImageAlgorithmAverage, 8}
+	perception := ImageHashKind{ImageAlgorithmPerception, 8}
+
+	merged := mergeImageHashes(
+		[]PerceptualHash{{average, []uint64{1}}, {perception, []uint64{2}}},
+		[]PerceptualHash{{average, []uint64{3}}})
+
+	assert.Equal(t, []PerceptualHash{{average, []uint64{3}}, {perception, []uint64{2}}}, merged)
+}
+
+func Test_Find_ImageHash_Kind(t *testing.T) {
+	average := ImageHashKind{ImageAlgorithmAverage, 8}
+	perception := ImageHashKind{ImageAlgorithmPerception, 16}
+	index := newIndex([]IndexedFile{
+		{Path: "a.jpg", ImageHash: ImageHash{Kind: 3, Hash: 1}, ImageHashes: []PerceptualHash{{perception, []uint64{1, 2, 3, 4}}}},
+		{Path: "b.jpg", ImageHash: ImageHash{Kind: 3, Hash: 2}, ImageHashes: []PerceptualHash{{perception, []uint64{1, 2, 3, 4}}}},
+		// same hash values, but of other kinds
+		{Path: "c.jpg", ImageHash: ImageHash{Kind: 1, Hash: 1}, ImageHashes: []PerceptualHash{{average, []uint64{1, 2, 3, 4}}}},
+		{Path: "d.jpg", ImageHashes: []PerceptualHash{{ImageHashKind{ImageAlgorithmPerception, 8}, []uint64{1}}}},
+	})
+
+	groups, err := newCompositeFinder(index, 0, perception, StrategyImageHash).Find()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, []string{"a.jpg", "b.jpg"}, groups[0].Paths())
+	assert.Equal(t, "0000000000000001000000000000000200000000000000030000000000000004", groups[0].Key)
+
+	// kinds are never compared with each other, rather than failing
+	groups, err = newCompositeFinder(index, 0, DefaultImageHashKind, StrategyImageHash).Find()
+
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func Test_Find_ImageHash_Perception(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	photo := encodeJpeg(t, waveImage(1))
+	assert.NoError(t, afero.WriteFile(fs, "photos/photo.jpg", photo, 0644))
+	assert.NoError(t, afero.WriteFile(fs, "photos/copy/photo.jpg", encodeJpeg(t, rotated(t, photo, 1)), 0644))
+	assert.NoError(t, afero.WriteFile(fs, "photos/other.jpg", encodeJpeg(t, waveImage(2)), 0644))
+	kind := ImageHashKind{ImageAlgorithmPerception, 16}
+	o := defaultOptions()
+	WithHashers(StrategyImageHash)(o)
+	WithImageHashes(kind)(o)
+	index := newIndex([]IndexedFile{})
+
+	err := newIndexer(fs, "photos", index, o).Create("photos")
+	assert.NoError(t, err)
+	groups, err := newCompositeFinder(index, 0, kind, StrategyImageHash).Find()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, []string{"photos/photo.jpg", "photos/copy/photo.jpg"}, groups[0].Paths())
+}
@@ -0,0 +1,355 @@
+package deduper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+func newIndex(files []IndexedFile) *Index {
+	iMap := make(map[string]int)
+	for i, v := range files {
+		iMap[v.Path] = i
+	}
+
+	return &Index{
+		iMap: iMap,
+		ind:  files,
+	}
+}
+
+// Files returns a copy of all files in the index.
+func (i *Index) Files() []IndexedFile {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	files := make([]IndexedFile, len(i.ind))
+	copy(files, i.ind)
+
+	return files
+}
+
+// Len returns the number of files in the index.
+func (i *Index) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return len(i.ind)
+}
+
+func (i *Index) get(path string) (IndexedFile, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if k, found := i.iMap[path]; found {
+		return i.ind[k], true
+	}
+
+	return IndexedFile{}, false
+}
+
+// inPath is true when the file is path, is in the directory path, or is a member of the archive path.
+func (f IndexedFile) inPath(path string) bool {
+	return isInDir(f.Path, path) || ("" != f.Archive && isInDir(f.Archive, path))
+}
+
+// paths returns the paths of the entries of path, and of all files in it.
+func (i *Index) paths(path string) []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	paths := []string{}
+	for _, f := range i.ind {
+		if f.inPath(path) {
+			paths = append(paths, f.Path)
+		}
+	}
+
+	return paths
+}
+
+// remove removes the entries of path, and of all files in it. Returns the paths that were removed.
+func (i *Index) remove(path string) []string {
+	return i.removeIf(func(f IndexedFile) bool {
+		return f.inPath(path)
+	})
+}
+
+// removeUnseen removes the entries of path, and of all files in it, that are not in seen. Returns the paths that
+// were removed.
+func (i *Index) removeUnseen(path string, seen map[string]bool) []string {
+	return i.removeIf(func(f IndexedFile) bool {
+		return f.inPath(path) && !seen[f.Path]
+	})
+}
+
+// removeIf removes the entries for which fun is true. Returns the paths that were removed.
+func (i *Index) removeIf(fun func(f IndexedFile) bool) []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	removed := []string{}
+	kept := []IndexedFile{}
+	for _, f := range i.ind {
+		if fun(f) {
+			removed = append(removed, f.Path)
+		} else {
+			kept = append(kept, f)
+		}
+	}
+	if 0 != len(removed) {
+		rebuilt := newIndex(kept)
+		i.ind = rebuilt.ind
+		i.iMap = rebuilt.iMap
+	}
+
+	return removed
+}
+
+// rename moves the entries of path from, and of all files in it, to path to, keeping their hashes.
+// Entries that were at to are replaced. Returns the new paths.
+func (i *Index) rename(from string, to string) []string {
+	i.remove(to)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	from, to = filepath.Clean(from), filepath.Clean(to)
+	renamed := []string{}
+	for k, f := range i.ind {
+		if !f.inPath(from) {
+			continue
+		}
+		delete(i.iMap, f.Path)
+		f.Path = to + strings.TrimPrefix(f.Path, from)
+		if "" != f.Archive {
+			f.Archive = to + strings.TrimPrefix(f.Archive, from)
+		}
+		i.ind[k] = f
+		i.iMap[f.Path] = k
+		renamed = append(renamed, f.Path)
+	}
+
+	return renamed
+}
+
+// indexFilePath resolves an index location: a directory contains an index called INDEX_NAME, anything else
+// is the index file itself. This allows index files to be renamed when copied between machines.
+func indexFilePath(fs afero.Fs, path string) string {
+	if isDir, _ := afero.IsDir(fs, path); isDir {
+		return filepath.Join(path, INDEX_NAME)
+	}
+
+	return path
+}
+
+func readIndex(fs afero.Fs, fp string) ([]IndexedFile, error) {
+	jsonFile, err := fs.Open(fp)
+	if nil != err {
+		return nil, fmt.Errorf("error loading index file: %w\n", err)
+	}
+	defer jsonFile.Close()
+
+	byteValue, err := ioutil.ReadAll(jsonFile)
+	if nil != err {
+		return nil, fmt.Errorf("error reading index file: %w\n", err)
+	}
+
+	var ind []IndexedFile
+	err = json.Unmarshal(byteValue, &ind)
+	if nil != err {
+		return nil, fmt.Errorf("error parsing index file: %w\n", err)
+	}
+
+	return ind, nil
+}
+
+func writeIndex(fs afero.Fs, fp string, ind []IndexedFile) error {
+	file, err := json.MarshalIndent(ind, "", " ")
+	if nil != err {
+		return fmt.Errorf("error creating index file: %w\n", err)
+	}
+	err = afero.WriteFile(fs, fp, file, 0644)
+	if nil != err {
+		return fmt.Errorf("error saving index file to %v: %w\n", fp, err)
+	}
+
+	return nil
+}
+
+// LoadIndex reads the index at path, which is either an index file or a directory containing one.
+func LoadIndex(fs afero.Fs, path string) (*Index, error) {
+	ind, err := readIndex(fs, indexFilePath(fs, path))
+	if nil != err {
+		return nil, err
+	}
+
+	return newIndex(ind), nil
+}
+
+// SaveIndex writes the index to path, which is either an index file or a directory to create one in.
+func SaveIndex(fs afero.Fs, path string, index *Index) error {
+	return writeIndex(fs, indexFilePath(fs, path), index.Files())
+}
+
+// MergeIndexes combines the given indexes into a new one.
+// When a path is in more than one index, hashes from the later index take precedence.
+func MergeIndexes(indexes ...*Index) *Index {
+	merged := newIndex([]IndexedFile{})
+	for _, index := range indexes {
+		for _, f := range index.Files() {
+			merged.updateIndex(f)
+		}
+	}
+
+	return merged
+}
+
+// FileChange is a file that exists in both indexes being compared, but with different hashes.
+type FileChange struct {
+	Old IndexedFile
+	New IndexedFile
+}
+
+// IndexDiff is the difference between 2 snapshots of an index.
+type IndexDiff struct {
+	Added   []IndexedFile
+	Removed []IndexedFile
+	Changed []FileChange
+}
+
+// IsEmpty is true when both snapshots contain the same files with the same hashes.
+func (d IndexDiff) IsEmpty() bool {
+	return 0 == len(d.Added) && 0 == len(d.Removed) && 0 == len(d.Changed)
+}
+
+// DiffIndexes compares an old and a new snapshot of an index. Results are sorted by path.
+func DiffIndexes(old *Index, new *Index) IndexDiff {
+	diff := IndexDiff{}
+	oldFiles := newIndex(old.Files())
+	newFiles := newIndex(new.Files())
+
+	for _, f := range newFiles.ind {
+		of, found := oldFiles.get(f.Path)
+		if !found {
+			diff.Added = append(diff.Added, f)
+		} else if !f.sameContent(of) {
+			diff.Changed = append(diff.Changed, FileChange{of, f})
+		}
+	}
+
+	for _, f := range oldFiles.ind {
+		if _, found := newFiles.get(f.Path); !found {
+			diff.Removed = append(diff.Removed, f)
+		}
+	}
+
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].Path < diff.Added[j].Path })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].Path < diff.Removed[j].Path })
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].New.Path < diff.Changed[j].New.Path })
+
+	return diff
+}
+
+// PruneIndex removes all entries from the index for which the file no longer exists.
+// Returns the paths that were removed.
+func PruneIndex(fs afero.Fs, index *Index) ([]string, error) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	removed := []string{}
+	kept := []IndexedFile{}
+	for _, f := range index.ind {
+		// a symlink is kept as long as the link exists, even when its target does not
+		// and an archive member as long as the archive exists
+		path := f.Path
+		if "" != f.Archive {
+			path = f.Archive
+		}
+		_, err := lstat(fs, path)
+		if nil != err && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error checking %v: %w\n", f.Path, err)
+		}
+
+		if nil == err {
+			kept = append(kept, f)
+		} else {
+			removed = append(removed, f.Path)
+		}
+	}
+
+	pruned := newIndex(kept)
+	index.ind = pruned.ind
+	index.iMap = pruned.iMap
+
+	return removed, nil
+}
+
+// sameContent is true when both entries have the same size, link target and hashes of every strategy.
+func (f IndexedFile) sameContent(other IndexedFile) bool {
+	return f.Size == other.Size &&
+		f.LinkTarget == other.LinkTarget &&
+		bytes.Equal(f.Md5Checksum, other.Md5Checksum) &&
+		f.ImageHash == other.ImageHash &&
+		slices.Equal(f.ImageTransforms, other.ImageTransforms) &&
+		sameImageHashes(f.ImageHashes, other.ImageHashes) &&
+		bytes.Equal(f.PixelChecksum, other.PixelChecksum) &&
+		slices.Equal(f.VideoHash, other.VideoHash) &&
+		bytes.Equal(f.AudioChecksum, other.AudioChecksum) &&
+		slices.Equal(f.AudioPrint, other.AudioPrint)
+}
+
+// sameImageHashes is true when both have hashes of the same kinds, with the same values, in any order.
+func sameImageHashes(hashes []PerceptualHash, others []PerceptualHash) bool {
+	if len(hashes) != len(others) {
+		return false
+	}
+	for _, h := range hashes {
+		if !slices.ContainsFunc(others, func(o PerceptualHash) bool {
+			return h.ImageHashKind == o.ImageHashKind && slices.Equal(h.Hash, o.Hash)
+		}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// commonDir returns the deepest directory containing all paths.
+func commonDir(paths []string) string {
+	if 0 == len(paths) {
+		return "."
+	}
+
+	dir := filepath.Dir(paths[0])
+	for _, p := range paths[1:] {
+		for !isInDir(p, dir) {
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+
+	return dir
+}
+
+// isInDir is true when path is dir or is inside dir.
+func isInDir(path string, dir string) bool {
+	path = filepath.Clean(path)
+	dir = filepath.Clean(dir)
+	if "." == dir && !filepath.IsAbs(path) && !strings.HasPrefix(path, "..") {
+		return true
+	}
+
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
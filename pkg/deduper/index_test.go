@@ -0,0 +1,265 @@
+package deduper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Merge_Indexes(t *testing.T) {
+	first := newIndex([]IndexedFile{
+		{
+			Path:        "foo",
+			Md5Checksum: []byte("foo-md5"),
+		},
+		{
+			Path:        "bar",
+			Md5Checksum: []byte("bar-md5"),
+		},
+	})
+	second := newIndex([]IndexedFile{
+		{
+			Path:        "bar",
+			Md5Checksum: []byte("bar-md5-new"),
+			ImageHash: ImageHash{
+				Kind: 3,
+				Hash: 1234,
+			},
+		},
+		{
+			Path:        "fred",
+			Md5Checksum: []byte("fred-md5"),
+		},
+	})
+
+	merged := MergeIndexes(first, second)
+
+	assert.Equal(t, 3, merged.Len())
+	bar, _ := merged.get("bar")
+	assert.Equal(t, []byte("bar-md5-new"), bar.Md5Checksum)
+	assert.Equal(t, uint64(1234), bar.ImageHash.Hash)
+	fred, found := merged.get("fred")
+	assert.True(t, found)
+	assert.Equal(t, []byte("fred-md5"), fred.Md5Checksum)
+}
+
+func Test_Diff_Indexes(t *testing.T) {
+	old := newIndex([]IndexedFile{
+		{
+			Path:        "foo",
+			Md5Checksum: []byte("foo-md5"),
+		},
+		{
+			Path:        "bar",
+			Md5Checksum: []byte("bar-md5"),
+		},
+		{
+			Path:        "jo",
+			Md5Checksum: []byte("jo-md5"),
+		},
+	})
+	new := newIndex([]IndexedFile{
+		{
+			Path:        "bar",
+			Md5Checksum: []byte("bar-md5-new"),
+		},
+		{
+			Path:        "fred",
+			Md5Checksum: []byte("fred-md5"),
+		},
+		{
+			Path:        "jo",
+			Md5Checksum: []byte("jo-md5"),
+		},
+	})
+
+	diff := DiffIndexes(old, new)
+
+	assert.False(t, diff.IsEmpty())
+	assert.Len(t, diff.Added, 1)
+	assert.Equal(t, "fred", diff.Added[0].Path)
+	assert.Len(t, diff.Removed, 1)
+	assert.Equal(t, "foo", diff.Removed[0].Path)
+	assert.Len(t, diff.Changed, 1)
+	assert.Equal(t, []byte("bar-md5"), diff.Changed[0].Old.Md5Checksum)
+	assert.Equal(t, []byte("bar-md5-new"), diff.Changed[0].New.Md5Checksum)
+}
+
+func Test_Diff_Indexes_Content(t *testing.T) {
+	perception := ImageHashKind{ImageAlgorithmPerception, 8}
+	average := ImageHashKind{ImageAlgorithmAverage, 8}
+	file := IndexedFile{
+		Path:        "song.wav",
+		Md5Checksum: []byte("md5"),
+		ImageHashes: []PerceptualHash{{perception, []uint64{1}}, {average, []uint64{2}}},
+		AudioPrint:  []uint32{1, 2},
+		Size:        10,
+	}
+	changed := []IndexedFile{file, file, file, file, file}
+	changed[0].Size = 11
+	changed[1].PixelChecksum = []byte("pixels")
+	changed[2].VideoHash = []uint64{1}
+	changed[3].AudioPrint = []uint32{1, 3}
+	changed[4].ImageHashes = []PerceptualHash{{perception, []uint64{1}}, {average, []uint64{3}}}
+
+	for _, f := range changed {
+		diff := DiffIndexes(newIndex([]IndexedFile{file}), newIndex([]IndexedFile{f}))
+		assert.Len(t, diff.Changed, 1)
+	}
+
+	// the order of the image hashes, and the time the file was modified, don't matter
+	same := file
+	same.ImageHashes = []PerceptualHash{{average, []uint64{2}}, {perception, []uint64{1}}}
+	same.ModTime = time.Now()
+	assert.True(t, DiffIndexes(newIndex([]IndexedFile{file}), newIndex([]IndexedFile{same})).IsEmpty())
+}
+
+func Test_Diff_Indexes_Same(t *testing.T) {
+	files := []IndexedFile{
+		{
+			Path:        "foo",
+			Md5Checksum: []byte("foo-md5"),
+		},
+	}
+
+	diff := DiffIndexes(newIndex(files), newIndex(files))
+
+	assert.True(t, diff.IsEmpty())
+}
+
+func Test_Prune_Index(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "pictures/foo.txt", []byte("content: foo"), 0644)
+	index := newIndex([]IndexedFile{
+		{
+			Path:        "pictures/foo.txt",
+			Md5Checksum: []byte("foo-md5"),
+		},
+		{
+			Path:        "pictures/bar.txt",
+			Md5Checksum: []byte("bar-md5"),
+		},
+	})
+
+	removed, err := PruneIndex(fs, index)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pictures/bar.txt"}, removed)
+	assert.Equal(t, 1, index.Len())
+	assert.Equal(t, 0, index.iMap["pictures/foo.txt"])
+}
+
+func Test_Prune_Index_Keeps_Dangling_Symlink(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(dir, "foo-link.txt")
+	if err := os.Symlink("foo.txt", link); nil != err {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	index := newIndex([]IndexedFile{{Path: link, Symlink: true, LinkTarget: "foo.txt"}})
+
+	removed, err := PruneIndex(afero.NewOsFs(), index)
+
+	assert.NoError(t, err)
+	assert.Empty(t, removed)
+	assert.Equal(t, 1, index.Len())
+}
+
+func Test_Prune_Index_Archive_Members(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "backup.zip", []byte("zip"), 0644)
+	index := newIndex([]IndexedFile{
+		{Path: "backup.zip!/foo.txt", Archive: "backup.zip"},
+		{Path: "old.zip!/foo.txt", Archive: "old.zip"},
+	})
+
+	removed, err := PruneIndex(fs, index)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"old.zip!/foo.txt"}, removed)
+}
+
+func Test_Remove_From_Index(t *testing.T) {
+	index := newIndex([]IndexedFile{
+		{Path: "pictures/foo.txt"},
+		{Path: "pictures/old/bar.txt"},
+		{Path: "pictures/old.zip!/baz.txt", Archive: "pictures/old.zip"},
+		{Path: "pictures/older/qux.txt"},
+	})
+
+	removed := index.remove("pictures/old")
+
+	assert.Equal(t, []string{"pictures/old/bar.txt"}, removed)
+	assert.Equal(t, []string{"pictures/old.zip!/baz.txt"}, index.remove("pictures/old.zip"))
+	assert.Equal(t, 2, index.Len())
+	_, found := index.get("pictures/older/qux.txt")
+	assert.True(t, found)
+}
+
+func Test_Rename_In_Index(t *testing.T) {
+	index := newIndex([]IndexedFile{
+		{Path: "pictures/old/bar.txt", Md5Checksum: []byte("bar-md5")},
+		{Path: "pictures/old/backup.zip!/baz.txt", Archive: "pictures/old/backup.zip"},
+		{Path: "pictures/new/foo.txt"},
+	})
+
+	renamed := index.rename("pictures/old", "pictures/new")
+
+	assert.ElementsMatch(t, []string{"pictures/new/bar.txt", "pictures/new/backup.zip!/baz.txt"}, renamed)
+	assert.Equal(t, 2, index.Len())
+	bar, found := index.get("pictures/new/bar.txt")
+	assert.True(t, found)
+	assert.Equal(t, []byte("bar-md5"), bar.Md5Checksum)
+	baz, _ := index.get("pictures/new/backup.zip!/baz.txt")
+	assert.Equal(t, "pictures/new/backup.zip", baz.Archive)
+	_, found = index.get("pictures/new/foo.txt")
+	assert.False(t, found)
+}
+
+func Test_Save_And_Load_Index_File(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	index := newIndex([]IndexedFile{
+		{
+			Path:        "foo",
+			Md5Checksum: []byte("foo-md5"),
+		},
+	})
+
+	err := SaveIndex(fs, "laptop.json", index)
+	assert.NoError(t, err)
+
+	loaded, err := LoadIndex(fs, "laptop.json")
+	assert.NoError(t, err)
+	assert.Equal(t, index.Files(), loaded.Files())
+}
+
+func Test_Save_And_Load_Index_Dir(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	fs.MkdirAll("index", 0755)
+	index := newIndex([]IndexedFile{
+		{
+			Path:        "foo",
+			Md5Checksum: []byte("foo-md5"),
+		},
+	})
+
+	err := SaveIndex(fs, "index", index)
+	assert.NoError(t, err)
+
+	exists, _ := afero.Exists(fs, "index/"+INDEX_NAME)
+	assert.True(t, exists)
+	loaded, err := LoadIndex(fs, "index")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, loaded.iMap["foo"])
+}
+
+func Test_Load_Index_No_File(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	_, err := LoadIndex(fs, "index")
+
+	assert.Error(t, err)
+}
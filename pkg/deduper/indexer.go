@@ -1,12 +1,16 @@
 package deduper
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"crypto/md5"
-	"encoding/json"
+	"encoding/hex"
 	"fmt"
+	"image"
 	"image/jpeg"
 	"io"
-	"io/ioutil"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
@@ -20,6 +24,8 @@ const INDEX_NAME = ".duplicate-index.json"
 
 type Indexer interface {
 	Create(dir string) error
+	// CreateContext creates the index like Create, and gives up when ctx is done, without saving the index.
+	CreateContext(ctx context.Context, dir string) error
 	Load() error
 }
 
@@ -27,29 +33,55 @@ type indexerImp struct {
 	fs        afero.Fs
 	indexPath string
 	index     *Index
+	progress  ProgressReporter
+	logger    *slog.Logger
+	workers   int
+	filters   []Filter
+	// followSymlinks hashes the targets of symlinks, rather than only recording the links
+	followSymlinks bool
 	fileWalker
 	fileHasher
 	saver
 	loader
 }
 
-func newIndexer(fs afero.Fs, indexPath string, index *Index, md5 bool, imageHash bool) Indexer {
+func newIndexer(fs afero.Fs, indexPath string, index *Index, o *options) *indexerImp {
+	var s saver = &indexSaver{
+		index,
+		indexPath,
+		fs,
+	}
+	var l loader = &indexLoader{
+		index,
+		indexPath,
+		fs,
+	}
+	if nil != o.store {
+		s = &storeAdapter{index, o.store}
+		l = &storeAdapter{index, o.store}
+	}
+	hasherFs := fs
+	if o.archives {
+		hasherFs = newArchiveFs(fs)
+	}
+	var thumbnails *thumbnailWriter
+	if o.thumbnailSize > 0 {
+		thumbnails = &thumbnailWriter{NewThumbnailCache(fs, indexPath), o.thumbnailSize}
+	}
+
 	return &indexerImp{
 		fs,
 		indexPath,
 		index,
-		&fileSystemWalker{fs},
-		newCompositeHasher(fs, md5, imageHash),
-		&indexSaver{
-			index,
-			indexPath,
-			fs,
-		},
-		&indexLoader{
-			index,
-			indexPath,
-			fs,
-		},
+		o.progress,
+		o.logger,
+		o.workers,
+		o.filters,
+		o.followSymlinks,
+		&fileSystemWalker{fs, o.followSymlinks, o.archives, o.logger},
+		newCompositeHasher(hasherFs, o, thumbnails),
+		s,
+		l,
 	}
 }
 
@@ -65,39 +97,154 @@ func (hasher compositeHasher) hash(filePath string, fun func(f IndexedFile), err
 	completeFun()
 }
 
-func newCompositeHasher(fs afero.Fs, md5 bool, imageHash bool) fileHasher {
+// newCompositeHasher creates the hashers of the strategies. Images are cached as thumbnails when thumbnails is not nil.
+func newCompositeHasher(fs afero.Fs, o *options, thumbnails *thumbnailWriter) fileHasher {
+	logger := o.logger
 	hashers := []fileHasher{}
-	if md5 {
-		hashers = append(hashers, &mdFiver{fs})
-	}
-	if imageHash {
-		hashers = append(hashers, &imageHasher{fs})
+	for _, s := range o.strategies {
+		switch s {
+		case StrategyMd5:
+			hashers = append(hashers, &mdFiver{fs})
+		case StrategyImageHash:
+			hashers = append(hashers, &imageHasher{fs, o.imageHashKinds, o.imageTransforms, logger, thumbnails})
+		case StrategyPixelHash:
+			hashers = append(hashers, &pixelHasher{fs, logger})
+		case StrategyVideoHash:
+			hashers = append(hashers, &videoHasher{fs, ffmpegExtractor{}, logger})
+		case StrategyAudioHash:
+			hashers = append(hashers, &audioHasher{fs, false, logger})
+		case StrategyAudioPrint:
+			hashers = append(hashers, &audioHasher{fs, true, logger})
+		}
 	}
 
 	return &compositeHasher{hashers}
 }
 
+type indexJob struct {
+	path string
+	info os.FileInfo
+	// link is the info of the symlink itself when following a symlink, nil otherwise
+	link os.FileInfo
+}
+
+// metadata returns the entry for the file, as found when walking the directory.
+func (job indexJob) metadata(fs afero.Fs) IndexedFile {
+	if nil == job.link {
+		return newFileMetadata(job.path, job.info)
+	}
+
+	return newSymlinkMetadata(fs, job.path, job.link, job.info)
+}
+
 func (i indexerImp) Create(dir string) error {
-	start := time.Now()
+	return i.CreateContext(context.Background(), dir)
+}
 
-	// find all files
-	var wg sync.WaitGroup
+func (i indexerImp) CreateContext(ctx context.Context, dir string) error {
+	i.logger.Info("Indexing", "dir", dir, "index", i.indexPath, "workers", i.workers)
+
+	p, seen, err := i.hashTree(ctx, dir)
+	if nil != ctx.Err() {
+		i.logger.Info("Indexing cancelled", "dir", dir)
+		return ctx.Err()
+	}
+	if nil != err {
+		i.logger.Error("Indexing failed", "dir", dir, "error", err)
+		return err
+	}
+	// the entries of files that no longer exist, or are no longer indexed, would otherwise be found as duplicates
+	for _, path := range i.index.removeUnseen(dir, seen) {
+		i.logger.Debug("Removed from index", "path", path)
+	}
+	i.progress.Progress(p)
+	i.logger.Info("Done indexing", "dir", dir, "files", p.FilesHashed, "bytes", p.BytesHashed, "duration", p.Elapsed)
+
+	// save index
+	return i.save()
+}
+
+// hashTree hashes all files in dir, or dir itself if it is a file, and stores them in the index. Returns the paths
+// of the files stored. Files that are being hashed when ctx is done are still stored, but no other files are.
+func (i indexerImp) hashTree(ctx context.Context, dir string) (Progress, map[string]bool, error) {
+	tracker := newProgressTracker()
+	// only written by the walker, which is done before the workers are
+	seen := make(map[string]bool)
 	doneChannel := make(chan bool)
 	errorChannel := make(chan error)
-	fileCount := 0
-	i.walk(dir, func(filePath string) {
-		fileCount++
-		// using routines to create md5 hashes of the files and store in index when done.
+	// closed when giving up, so that walker and workers don't block
+	stopChannel := make(chan bool)
+	fail := func(err error) {
+		select {
+		case errorChannel <- err:
+		case <-stopChannel:
+		}
+	}
+
+	// find all files
+	jobs := make(chan indexJob)
+	go func() {
+		defer close(jobs)
+		err := i.walk(dir, func(filePath string, info os.FileInfo) {
+			select {
+			case <-stopChannel:
+				// walk the rest of the tree without doing anything
+				return
+			default:
+			}
+			job := indexJob{path: filePath, info: info}
+			if isSymlink(info) {
+				target, err := i.fs.Stat(filePath)
+				if !i.followSymlinks || nil != err || target.IsDir() {
+					// only record the link itself, its target is never hashed or acted on
+					if accepted(i.filters, filePath, info) {
+						i.logger.Debug("Not following symlink", "path", filePath)
+						i.index.updateIndex(newSymlinkMetadata(i.fs, filePath, info, info))
+						seen[filePath] = true
+					}
+					return
+				}
+				job.info, job.link = target, info
+			}
+			if !accepted(i.filters, filePath, job.info) {
+				i.logger.Debug("Skipping filtered file", "path", filePath)
+				return
+			}
+			tracker.discovered(job.info.Size())
+			seen[filePath] = true
+			select {
+			case jobs <- job:
+			case <-stopChannel:
+			case <-ctx.Done():
+			}
+		})
+		if nil != err {
+			fail(err)
+		}
+		tracker.walked()
+	}()
+
+	// using a pool of workers to hash the files and store them in the index when done.
+	var wg sync.WaitGroup
+	for w := 0; w < i.workers; w++ {
 		wg.Add(1)
-		go i.hash(filePath,
-			func(f IndexedFile) {
-				i.index.updateIndex(f)
-			}, func(filePath string, err error) {
-				errorChannel <- fmt.Errorf("error hashing file %v: %w\n", filePath, err)
-			}, func() {
-				defer wg.Done()
-			})
-	})
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				tracker.hashing(job.path)
+				i.hash(job.path,
+					func(f IndexedFile) {
+						i.logger.Debug("Hashed file", f.logAttrs()...)
+						i.index.updateIndex(job.metadata(i.fs))
+						i.index.updateIndex(f)
+					}, func(filePath string, err error) {
+						fail(fmt.Errorf("error hashing file %v: %w\n", filePath, err))
+					}, func() {
+						tracker.hashed(job.info.Size())
+					})
+			}
+		}()
+	}
 
 	// signal for done
 	go func() {
@@ -105,27 +252,30 @@ func (i indexerImp) Create(dir string) error {
 		close(doneChannel)
 	}()
 
-	//w wait for everything to finish or an error happens
-	updateTimer := time.Now()
+	// stop makes the walker and workers give up, and waits for them, so that the index is no longer updated once
+	// hashTree returns
+	stop := func() {
+		close(stopChannel)
+		<-doneChannel
+	}
+
+	// wait for everything to finish or an error happens
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case err := <-errorChannel:
 			// give up when we encounter an error
-			return err
+			stop()
+			return Progress{}, nil, err
+		case <-ctx.Done():
+			stop()
+			return Progress{}, nil, ctx.Err()
 		case <-doneChannel:
-			fmt.Printf("Done indexing %v files in %v\n", len(i.index.ind), time.Since(start))
-			// save index
-			if err := i.save(); nil != err {
-				return err
-			}
-
-			return nil
-		default:
-			if time.Since(updateTimer).Seconds() > 5 {
-				fmt.Printf("Indexed %v/%v files in %v\n", len(i.index.ind), fileCount, time.Since(start))
-				updateTimer = time.Now()
-			}
-			time.Sleep(100 * time.Millisecond)
+			close(stopChannel)
+			return tracker.snapshot(true), seen, nil
+		case <-ticker.C:
+			i.progress.Progress(tracker.snapshot(false))
 		}
 	}
 }
@@ -152,33 +302,68 @@ type indexLoader struct {
 }
 
 func (i indexLoader) Load() error {
-	fp := filepath.Join(i.indexPath, INDEX_NAME)
-	jsonFile, err := i.Open(fp)
-	if nil != err {
-		return fmt.Errorf("error loading index file: %w\n", err)
-	}
-	byteValue, err := ioutil.ReadAll(jsonFile)
+	ind, err := readIndex(i.Fs, filepath.Join(i.indexPath, INDEX_NAME))
 	if nil != err {
-		return fmt.Errorf("error reading index file: %w\n", err)
+		return err
 	}
 
-	var ind []IndexedFile
-	err = json.Unmarshal(byteValue, &ind)
-	if nil != err {
-		return fmt.Errorf("error parsing index file: %w\n", err)
-	}
+	loaded := newIndex(ind)
+	i.mu.Lock()
+	i.ind = loaded.ind
+	i.iMap = loaded.iMap
+	i.mu.Unlock()
+
+	return nil
+}
+
+// Store loads and saves the files in an index, e.g. to use a database rather than an index file.
+type Store interface {
+	Load() ([]IndexedFile, error)
+	Save(files []IndexedFile) error
+}
+
+// NewFileStore stores the index as JSON in the file at path, or in a file called INDEX_NAME if path is a directory.
+func NewFileStore(fs afero.Fs, path string) Store {
+	return &fileStore{fs, path}
+}
+
+type fileStore struct {
+	fs   afero.Fs
+	path string
+}
+
+func (s fileStore) Load() ([]IndexedFile, error) {
+	return readIndex(s.fs, indexFilePath(s.fs, s.path))
+}
+
+func (s fileStore) Save(files []IndexedFile) error {
+	return writeIndex(s.fs, indexFilePath(s.fs, s.path), files)
+}
+
+type storeAdapter struct {
+	index *Index
+	store Store
+}
 
-	iMap := make(map[string]int)
-	for i, v := range ind {
-		iMap[v.Path] = i
+func (s storeAdapter) Load() error {
+	ind, err := s.store.Load()
+	if nil != err {
+		return err
 	}
 
-	i.ind = ind
-	i.iMap = iMap
+	loaded := newIndex(ind)
+	s.index.mu.Lock()
+	s.index.ind = loaded.ind
+	s.index.iMap = loaded.iMap
+	s.index.mu.Unlock()
 
 	return nil
 }
 
+func (s storeAdapter) save() error {
+	return s.store.Save(s.index.Files())
+}
+
 type saver interface {
 	save() error
 }
@@ -190,17 +375,32 @@ type indexSaver struct {
 }
 
 func (i indexSaver) save() error {
-	file, err := json.MarshalIndent(i.ind, "", " ")
-	if nil != err {
-		return fmt.Errorf("error creating index file: %w\n", err)
+	return writeIndex(i.Fs, filepath.Join(i.indexPath, INDEX_NAME), i.Files())
+}
+
+// logAttrs returns the path and hashes of the file as log fields
+func (f IndexedFile) logAttrs() []any {
+	attrs := []any{"path", f.Path}
+	if nil != f.Md5Checksum {
+		attrs = append(attrs, "md5", hex.EncodeToString(f.Md5Checksum))
 	}
-	fp := filepath.Join(i.indexPath, INDEX_NAME)
-	err = afero.WriteFile(i.Fs, fp, file, 0644)
-	if nil != err {
-		return fmt.Errorf("error saving index file to %v: %w\n", fp, err)
+	if (ImageHash{}) != f.ImageHash {
+		attrs = append(attrs, "imagehash", fmt.Sprintf("%016x", f.ImageHash.Hash))
+	}
+	if nil != f.PixelChecksum {
+		attrs = append(attrs, "pixelhash", hex.EncodeToString(f.PixelChecksum))
+	}
+	if nil != f.VideoHash {
+		attrs = append(attrs, "videohash", fmt.Sprintf("%016x", f.VideoHash))
+	}
+	if nil != f.AudioChecksum {
+		attrs = append(attrs, "audiohash", hex.EncodeToString(f.AudioChecksum))
+	}
+	if nil != f.AudioPrint {
+		attrs = append(attrs, "audioprint", fmt.Sprintf("%08x", f.AudioPrint[0]))
 	}
 
-	return err
+	return attrs
 }
 
 type fileHasher interface {
@@ -222,7 +422,8 @@ func (fiver mdFiver) hash(filePath string, fun func(f IndexedFile), errorFunc fu
 
 	// hash of the file
 	h := md5.New()
-	if _, err := io.Copy(h, f); err != nil {
+	size, err := io.Copy(h, f)
+	if err != nil {
 		errorFunc(filePath, err)
 		return
 	}
@@ -231,6 +432,7 @@ func (fiver mdFiver) hash(filePath string, fun func(f IndexedFile), errorFunc fu
 	fun(IndexedFile{
 		Path:        filePath,
 		Md5Checksum: h.Sum(nil),
+		Size:        size,
 	})
 
 	completeFun()
@@ -238,6 +440,13 @@ func (fiver mdFiver) hash(filePath string, fun func(f IndexedFile), errorFunc fu
 
 type imageHasher struct {
 	fs afero.Fs
+	// kinds of hashes to compute, the default kind is stored as the ImageHash
+	kinds []ImageHashKind
+	// also hash the image rotated and flipped
+	transforms bool
+	logger     *slog.Logger
+	// caches thumbnails of the images, if not nil
+	thumbnails *thumbnailWriter
 }
 
 func (hasher imageHasher) hash(filePath string, fun func(f IndexedFile), errorFunc func(filePath string, err error), completeFun func()) {
@@ -250,53 +459,180 @@ func (hasher imageHasher) hash(filePath string, fun func(f IndexedFile), errorFu
 	}
 	defer f.Close()
 
-	// assume jpeg for now
-	jpg, err := jpeg.Decode(f)
+	// assume jpeg for now, and only read files that look like one
+	r := bufio.NewReader(f)
+	if start, err := r.Peek(len(jpegMagic)); nil != err || !bytes.Equal(jpegMagic, start) {
+		hasher.logger.Debug("Skipping file, only supporting jpeg images", "path", filePath)
+		completeFun()
+		return
+	}
+	data, err := io.ReadAll(r)
+	if nil != err {
+		errorFunc(filePath, err)
+		completeFun()
+		return
+	}
+
+	jpg, err := jpeg.Decode(bytes.NewReader(data))
 	if _, isFormatError := err.(jpeg.FormatError); isFormatError {
-		fmt.Printf("Skipping '%s', only supporting jpeg images.\n", filePath)
+		hasher.logger.Debug("Skipping file, only supporting jpeg images", "path", filePath)
 	} else if nil != err {
 		// todo figure out error when not jpg and try something else
 		errorFunc(filePath, err)
+	} else if hashed, err := hasher.hashImage(jpg); nil != err {
+		errorFunc(filePath, err)
 	} else {
-		// hash of the file
-		if h, err := goimagehash.DifferenceHash(jpg); nil != err {
-			errorFunc(filePath, err)
-		} else {
-			fun(IndexedFile{
-				Path: filePath,
-				ImageHash: ImageHash{
-					Kind: int(h.GetKind()),
-					Hash: h.GetHash(),
-				},
-			})
+		hashed.Path = filePath
+		hashed.Image = newImageInfo(jpg, data)
+		hashed.Size = int64(len(data))
+		if nil != hasher.thumbnails {
+			// a missing thumbnail is not worth failing the index for
+			if key, err := hasher.thumbnails.write(jpg, data, hashed.Image.Orientation); nil != err {
+				hasher.logger.Warn("Failed caching thumbnail", "path", filePath, "error", err)
+			} else {
+				hashed.Thumbnail = key
+			}
 		}
+		fun(hashed)
 	}
 
 	completeFun()
 }
 
+// hashImage hashes the image with every kind of hash of the hasher.
+func (hasher imageHasher) hashImage(img image.Image) (IndexedFile, error) {
+	hashed := IndexedFile{}
+	for _, kind := range hasher.kinds {
+		if DefaultImageHashKind != kind {
+			h, err := perceptualHash(img, kind)
+			if nil != err {
+				return IndexedFile{}, err
+			}
+			hashed.ImageHashes = append(hashed.ImageHashes, h)
+			continue
+		}
+
+		h, err := goimagehash.DifferenceHash(img)
+		if nil != err {
+			return IndexedFile{}, err
+		}
+		hashed.ImageHash = ImageHash{
+			Kind: int(h.GetKind()),
+			Hash: h.GetHash(),
+		}
+		if hashed.ImageTransforms, err = hasher.transformHashes(img); nil != err {
+			return IndexedFile{}, err
+		}
+	}
+
+	return hashed, nil
+}
+
 type fileWalker interface {
-	walk(dir string, fun func(string)) error
+	walk(dir string, fun func(path string, info os.FileInfo)) error
 }
 
 type fileSystemWalker struct {
-	fs afero.Fs
+	fs             afero.Fs
+	followSymlinks bool
+	archives       bool
+	logger         *slog.Logger
 }
 
-func (fw fileSystemWalker) walk(dir string, fun func(string)) error {
-	// find all files
-	err := afero.Walk(fw.fs, dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return fmt.Errorf("error accessing a path %q: %w\n", path, err)
+// walk calls fun for all files in dir, in lexical order. Symlinks are passed as found, without following them,
+// unless followSymlinks is set, in which case symlinked directories are walked as well.
+// If archives is set, the members of archives are passed after the archive itself.
+// Every directory is walked only once, so that symlink cycles terminate and files are not found twice.
+func (fw fileSystemWalker) walk(dir string, fun func(path string, info os.FileInfo)) error {
+	info, err := lstat(fw.fs, dir)
+	if err != nil {
+		return fmt.Errorf("error walking the path %q: %w\n", dir, err)
+	}
+	if err := fw.walkPath(dir, info, make(map[string]bool), fun); err != nil {
+		return fmt.Errorf("error walking the path %q: %w\n", dir, err)
+	}
+
+	return nil
+}
+
+func (fw fileSystemWalker) walkPath(path string, info os.FileInfo, visited map[string]bool, fun func(path string, info os.FileInfo)) error {
+	if isSymlink(info) {
+		target, err := fw.fs.Stat(path)
+		if !fw.followSymlinks || nil != err || !target.IsDir() {
+			// the indexer decides what to do with links to files and dangling links
+			fun(path, info)
+			return nil
 		}
-		if !info.IsDir() {
-			fun(path)
+		info = target
+	}
+	if !info.IsDir() {
+		fun(path, info)
+		if fw.archives && info.Mode().IsRegular() && isArchive(path) {
+			err := walkArchive(fw.fs, path, func(member string, memberInfo os.FileInfo) {
+				fun(archiveMemberPath(path, member), memberInfo)
+			})
+			if nil != err {
+				// still index the rest
+				fw.logger.Warn("Failed reading archive", "path", path, "error", err)
+			}
 		}
 		return nil
-	})
+	}
+
+	if THUMBNAIL_DIR == info.Name() {
+		fw.logger.Debug("Skipping thumbnail cache", "path", path)
+		return nil
+	}
+	key := dirKey(path, info)
+	if visited[key] {
+		fw.logger.Warn("Skipping directory, already walked", "path", path)
+		return nil
+	}
+	visited[key] = true
+
+	entries, err := afero.ReadDir(fw.fs, path)
 	if err != nil {
-		return fmt.Errorf("error walking the path %q: %w\n", dir, err)
+		return fmt.Errorf("error accessing a path %q: %w\n", path, err)
+	}
+	for _, entry := range entries {
+		if err := fw.walkPath(filepath.Join(path, entry.Name()), entry, visited, fun); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
+
+// dirKey identifies a directory by device and inode if known, so that it is recognised when reached through a symlink.
+func dirKey(path string, info os.FileInfo) string {
+	if _, _, dev, ino := ownerAndInode(info); 0 != ino {
+		return fmt.Sprintf("%v:%v", dev, ino)
+	}
+
+	return filepath.Clean(path)
+}
+
+func isSymlink(info os.FileInfo) bool {
+	return 0 != info.Mode()&os.ModeSymlink
+}
+
+// lstat returns the info of the symlink rather than its target, if supported by the file system.
+func lstat(fs afero.Fs, path string) (os.FileInfo, error) {
+	if lstater, ok := fs.(afero.Lstater); ok {
+		info, _, err := lstater.LstatIfPossible(path)
+		return info, err
+	}
+
+	return fs.Stat(path)
+}
+
+// readlink returns the target of the symlink, or an empty string if it cannot be read.
+func readlink(fs afero.Fs, path string) string {
+	if reader, ok := fs.(afero.LinkReader); ok {
+		if target, err := reader.ReadlinkIfPossible(path); nil == err {
+			return target
+		}
+	}
+
+	return ""
+}
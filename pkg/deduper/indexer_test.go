@@ -1,11 +1,16 @@
 package deduper
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
@@ -14,7 +19,7 @@ import (
 
 func Test_Update_Index_New(t *testing.T) {
 	index := &Index{
-		sync.Mutex{},
+		sync.RWMutex{},
 		map[string]int{
 			"foo": 0,
 		},
@@ -43,7 +48,7 @@ func Test_Update_Index_New(t *testing.T) {
 
 func Test_Update_Index_Update(t *testing.T) {
 	index := &Index{
-		sync.Mutex{},
+		sync.RWMutex{},
 		map[string]int{
 			"foo": 0,
 		},
@@ -72,14 +77,15 @@ func Test_Update_Index_Update(t *testing.T) {
 
 func Test_FileWalker_Walk_Ok(t *testing.T) {
 	fs := afero.NewMemMapFs()
-	walker := fileSystemWalker{fs}
+	walker := fileSystemWalker{fs: fs, logger: discardLogger()}
 	if err := afero.WriteFile(fs, "hello/foo/bar.txt", []byte("content: bar"), 0644); nil != err {
 		fmt.Errorf("failed to create test file %v: %w", "foo/bar.txt", err)
 	}
 
 	found := false
-	err := walker.walk("hello", func(s string) {
+	err := walker.walk("hello", func(s string, info os.FileInfo) {
 		assert.Equal(t, "hello/foo/bar.txt", s)
+		assert.Equal(t, int64(12), info.Size())
 		found = true
 	})
 
@@ -89,16 +95,110 @@ func Test_FileWalker_Walk_Ok(t *testing.T) {
 
 func Test_FileWalker_Walk_Error(t *testing.T) {
 	fs := afero.NewMemMapFs()
-	walker := fileSystemWalker{fs}
+	walker := fileSystemWalker{fs: fs, logger: discardLogger()}
 	if err := afero.WriteFile(fs, "hello/foo/bar.txt", []byte("content: bar"), 0644); nil != err {
 		fmt.Errorf("failed to create test file %v: %w", "hello/foo/bar", err)
 	}
 
-	err := walker.walk("not-valid", func(s string) {})
+	err := walker.walk("not-valid", func(s string, info os.FileInfo) {})
 
 	assert.Error(t, err)
 }
 
+// symlinkTree creates a directory with symlinks to a file, to an outside directory, to a parent directory
+// and to a file that does not exist.
+func symlinkTree(t *testing.T) string {
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.Mkdir(filepath.Join(root, "a"), 0755)
+	afero.WriteFile(afero.NewOsFs(), filepath.Join(root, "a", "foo.txt"), []byte("content: foo"), 0644)
+	afero.WriteFile(afero.NewOsFs(), filepath.Join(outside, "bar.txt"), []byte("content: bar"), 0644)
+	links := map[string]string{
+		"a/loop":       "..",
+		"b":            "a",
+		"c":            outside,
+		"dangling":     "missing.txt",
+		"foo-link.txt": filepath.Join("a", "foo.txt"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, link)); nil != err {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+
+	return root
+}
+
+func walkedPaths(t *testing.T, walker fileSystemWalker, root string) []string {
+	paths := []string{}
+	err := walker.walk(root, func(path string, info os.FileInfo) {
+		rel, _ := filepath.Rel(root, path)
+		paths = append(paths, rel)
+	})
+	assert.NoError(t, err)
+
+	return paths
+}
+
+func Test_FileWalker_Walk_No_Follow_Symlinks(t *testing.T) {
+	root := symlinkTree(t)
+	walker := fileSystemWalker{fs: afero.NewOsFs(), logger: discardLogger()}
+
+	paths := walkedPaths(t, walker, root)
+
+	assert.Equal(t, []string{"a/foo.txt", "a/loop", "b", "c", "dangling", "foo-link.txt"}, paths)
+}
+
+func Test_FileWalker_Walk_Follow_Symlinks(t *testing.T) {
+	root := symlinkTree(t)
+	walker := fileSystemWalker{fs: afero.NewOsFs(), followSymlinks: true, logger: discardLogger()}
+
+	paths := walkedPaths(t, walker, root)
+
+	// a/loop and b link to directories that are already walked, c is walked through the link
+	assert.Equal(t, []string{"a/foo.txt", "c/bar.txt", "dangling", "foo-link.txt"}, paths)
+}
+
+func Test_Create_Follow_Symlinks(t *testing.T) {
+	root := symlinkTree(t)
+	o := defaultOptions()
+	WithFollowSymlinks(true)(o)
+	index := newIndex([]IndexedFile{})
+
+	err := newIndexer(afero.NewOsFs(), root, index, o).Create(root)
+
+	assert.NoError(t, err)
+	foo := index.ind[index.iMap[filepath.Join(root, "a", "foo.txt")]]
+	link := index.ind[index.iMap[filepath.Join(root, "foo-link.txt")]]
+	assert.True(t, link.Symlink)
+	assert.Equal(t, filepath.Join("a", "foo.txt"), link.LinkTarget)
+	assert.Equal(t, foo.Md5Checksum, link.Md5Checksum)
+	assert.Equal(t, foo.Size, link.Size)
+	dangling := index.ind[index.iMap[filepath.Join(root, "dangling")]]
+	assert.True(t, dangling.Symlink)
+	assert.Nil(t, dangling.Md5Checksum)
+
+	// a followed symlink is the same file as its target, rather than a duplicate
+	groups, err := newCompositeFinder(index, 0, DefaultImageHashKind, StrategyMd5).Find()
+	assert.NoError(t, err)
+	assert.Empty(t, FilterGroups(groups, CategoryDuplicate))
+	assert.Len(t, FilterGroups(groups, CategoryLinked), 1)
+}
+
+func Test_Create_No_Follow_Symlinks(t *testing.T) {
+	root := symlinkTree(t)
+	index := newIndex([]IndexedFile{})
+
+	err := newIndexer(afero.NewOsFs(), root, index, defaultOptions()).Create(root)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 6, index.Len())
+	link := index.ind[index.iMap[filepath.Join(root, "foo-link.txt")]]
+	assert.True(t, link.Symlink)
+	assert.Nil(t, link.Md5Checksum)
+	assert.Equal(t, "a", index.ind[index.iMap[filepath.Join(root, "b")]].LinkTarget)
+}
+
 func Test_MdFiver_Hash_Ok(t *testing.T) {
 	fs := afero.NewMemMapFs()
 	hasher := mdFiver{fs}
@@ -133,7 +233,7 @@ func Test_MdFiver_Hash_No_file(t *testing.T) {
 
 func Test_ImageFiver_Hash_Ok(t *testing.T) {
 	fs := afero.NewMemMapFs()
-	hasher := imageHasher{fs}
+	hasher := imageHasher{fs, []ImageHashKind{DefaultImageHashKind}, false, discardLogger(), nil}
 
 	const fileName = "test.jpg"
 	// HACK: bit of a hack with loading img from disk
@@ -148,6 +248,16 @@ func Test_ImageFiver_Hash_Ok(t *testing.T) {
 		assert.Equal(t, fileName, f.Path)
 		assert.Equal(t, 3, f.ImageHash.Kind)
 		assert.Equal(t, uint64(0xc0a0b0f0f0f8c0c0), f.ImageHash.Hash, func() {})
+		assert.Equal(t, &ImageInfo{
+			Width:       3500,
+			Height:      2334,
+			Exif:        true,
+			Orientation: 1,
+			Make:        "NIKON CORPORATION",
+			Model:       "NIKON D7100",
+			Taken:       time.Date(2018, 3, 3, 10, 25, 38, 0, time.UTC),
+		}, f.Image)
+		assert.Equal(t, int64(len(dat)), f.Size)
 
 		complete = true
 	}, func(filePath string, err error) {
@@ -159,7 +269,7 @@ func Test_ImageFiver_Hash_Ok(t *testing.T) {
 
 func Test_ImageFiver_Hash_Wrong_Filetype(t *testing.T) {
 	fs := afero.NewMemMapFs()
-	hasher := imageHasher{fs}
+	hasher := imageHasher{fs, []ImageHashKind{DefaultImageHashKind}, false, discardLogger(), nil}
 
 	const fileName = "bar.txt"
 	if err := afero.WriteFile(fs, fileName, []byte("content: bar"), 0644); nil != err {
@@ -186,7 +296,7 @@ func Test_ImageFiver_Hash_Wrong_Filetype(t *testing.T) {
 
 func Test_ImageFiver_Hash_No_file(t *testing.T) {
 	fs := afero.NewMemMapFs()
-	hasher := imageHasher{fs}
+	hasher := imageHasher{fs, []ImageHashKind{DefaultImageHashKind}, false, discardLogger(), nil}
 
 	hasher.hash("bar.jpg", func(f IndexedFile) {
 		assert.Fail(t, "Should not complete")
@@ -225,7 +335,7 @@ func Test_Composite_Hasher_All(t *testing.T) {
 
 func Test_Saver_Ok(t *testing.T) {
 	index := &Index{
-		sync.Mutex{},
+		sync.RWMutex{},
 		map[string]int{
 			"foo": 0,
 			"bar": 1,
@@ -270,7 +380,7 @@ func Test_Saver_Ok(t *testing.T) {
 
 func Test_Loader_Ok(t *testing.T) {
 	index := &Index{
-		sync.Mutex{},
+		sync.RWMutex{},
 		map[string]int{
 			"foo": 0,
 		},
@@ -303,7 +413,7 @@ func Test_Loader_Ok(t *testing.T) {
 
 func Test_Loader_Invalid_Json(t *testing.T) {
 	index := &Index{
-		sync.Mutex{},
+		sync.RWMutex{},
 		map[string]int{},
 		[]IndexedFile{},
 	}
@@ -320,7 +430,7 @@ func Test_Loader_Invalid_Json(t *testing.T) {
 
 func Test_Loader_No_File(t *testing.T) {
 	index := &Index{
-		sync.Mutex{},
+		sync.RWMutex{},
 		map[string]int{},
 		[]IndexedFile{},
 	}
@@ -336,12 +446,24 @@ func Test_Loader_No_File(t *testing.T) {
 
 type mockFileSystemWalker struct{}
 
-var walkerMock func(dir string, fun func(string)) error
+var walkerMock func(dir string, fun func(string, os.FileInfo)) error
 
-func (m mockFileSystemWalker) walk(dir string, fun func(string)) error {
+func (m mockFileSystemWalker) walk(dir string, fun func(string, os.FileInfo)) error {
 	return walkerMock(dir, fun)
 }
 
+type mockFileInfo struct {
+	name string
+	size int64
+}
+
+func (m mockFileInfo) Name() string       { return m.name }
+func (m mockFileInfo) Size() int64        { return m.size }
+func (m mockFileInfo) Mode() os.FileMode  { return 0644 }
+func (m mockFileInfo) ModTime() time.Time { return time.Time{} }
+func (m mockFileInfo) IsDir() bool        { return false }
+func (m mockFileInfo) Sys() interface{}   { return nil }
+
 type mockFileHasher struct{}
 
 var hasherMock func(filePath string, fun func(f IndexedFile), errorFunc func(filePath string, err error), completeFun func())
@@ -386,7 +508,7 @@ func (suite *IndexerTestSuite) SetupTest() {
 	fs := afero.NewMemMapFs()
 	indexPath := "index"
 	suite.Index = &Index{
-		sync.Mutex{},
+		sync.RWMutex{},
 		map[string]int{},
 		[]IndexedFile{},
 	}
@@ -395,8 +517,8 @@ func (suite *IndexerTestSuite) SetupTest() {
 	suite.hash = []byte("foo")
 
 	suite.walker = &mockFileSystemWalker{}
-	walkerMock = func(dir string, fun func(string)) error {
-		fun(suite.path)
+	walkerMock = func(dir string, fun func(string, os.FileInfo)) error {
+		fun(suite.path, mockFileInfo{suite.path, 42})
 		return nil
 	}
 	suite.hasher = &mockFileHasher{}
@@ -418,13 +540,16 @@ func (suite *IndexerTestSuite) SetupTest() {
 	suite.loader = &mockLoader{}
 
 	suite.Indexer = &indexerImp{
-		fs,
-		indexPath,
-		suite.Index,
-		suite.walker,
-		suite.hasher,
-		suite.saver,
-		suite.loader,
+		fs:         fs,
+		indexPath:  indexPath,
+		index:      suite.Index,
+		progress:   nopProgress{},
+		logger:     discardLogger(),
+		workers:    2,
+		fileWalker: suite.walker,
+		fileHasher: suite.hasher,
+		saver:      suite.saver,
+		loader:     suite.loader,
 	}
 }
 
@@ -442,6 +567,8 @@ func (suite *IndexerTestSuite) Test_Create_Ok() {
 	assert.Equal(suite.T(), 0, suite.iMap[suite.path])
 	assert.Equal(suite.T(), suite.path, suite.ind[0].Path)
 	assert.Equal(suite.T(), suite.hash, suite.ind[0].Md5Checksum)
+	assert.Equal(suite.T(), int64(42), suite.ind[0].Size)
+	assert.Equal(suite.T(), os.FileMode(0644), suite.ind[0].Mode)
 }
 
 func (suite *IndexerTestSuite) Test_Create_Hash_Error() {
@@ -472,3 +599,134 @@ func (suite *IndexerTestSuite) Test_Create_Save_Error() {
 	assert.Error(suite.T(), err)
 	assert.Equal(suite.T(), err, raisedError)
 }
+
+func (suite *IndexerTestSuite) Test_Create_Reports_Progress() {
+	var last Progress
+	suite.Indexer.(*indexerImp).progress = ProgressFunc(func(p Progress) {
+		last = p
+	})
+
+	suite.Indexer.Create("dir")
+
+	assert.True(suite.T(), last.Done)
+	assert.True(suite.T(), last.DiscoveryDone)
+	assert.Equal(suite.T(), int64(1), last.FilesDiscovered)
+	assert.Equal(suite.T(), int64(1), last.FilesHashed)
+	assert.Equal(suite.T(), int64(42), last.BytesHashed)
+	assert.Equal(suite.T(), suite.path, last.CurrentFile)
+}
+
+func (suite *IndexerTestSuite) Test_Create_Walk_Error() {
+	raisedError := errors.New("Walking failed")
+
+	walkerMock = func(dir string, fun func(string, os.FileInfo)) error {
+		return raisedError
+	}
+
+	err := suite.Indexer.Create("dir")
+
+	assert.Equal(suite.T(), raisedError, err)
+}
+
+func (suite *IndexerTestSuite) Test_Create_Filtered() {
+	suite.Indexer.(*indexerImp).filters = []Filter{ExcludeFilter("*.txt")}
+
+	suite.Indexer.Create("dir")
+
+	assert.Empty(suite.T(), suite.ind)
+}
+
+func (suite *IndexerTestSuite) Test_Create_Workers() {
+	paths := []string{"foo.txt", "bar.txt", "fred.txt", "jo.txt"}
+	walkerMock = func(dir string, fun func(string, os.FileInfo)) error {
+		for _, p := range paths {
+			fun(p, mockFileInfo{p, 1})
+		}
+		return nil
+	}
+	hasherMock = func(filePath string, fun func(f IndexedFile), errorFunc func(filePath string, err error), completeFunc func()) {
+		fun(IndexedFile{
+			Path:        filePath,
+			Md5Checksum: []byte(filePath),
+		})
+		completeFunc()
+	}
+
+	err := suite.Indexer.Create("dir")
+
+	assert.NoError(suite.T(), err)
+	assert.Len(suite.T(), suite.ind, len(paths))
+}
+
+func (suite *IndexerTestSuite) Test_Create_Removes_Files_Not_Found() {
+	suite.path = "dir/foo.txt"
+	suite.updateIndex(IndexedFile{Path: "dir/gone.txt", Md5Checksum: []byte("gone")})
+	suite.updateIndex(IndexedFile{Path: "dir/backup.zip!/gone.txt", Archive: "dir/backup.zip"})
+	suite.updateIndex(IndexedFile{Path: "other/bar.txt", Md5Checksum: []byte("bar")})
+
+	err := suite.Indexer.Create("dir")
+
+	assert.NoError(suite.T(), err)
+	// files outside the indexed directory are kept
+	assert.ElementsMatch(suite.T(), []string{"dir/foo.txt", "other/bar.txt"}, suite.paths("."))
+}
+
+func Test_Store_Adapter(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	store := NewFileStore(fs, "my-index.json")
+	index := newIndex([]IndexedFile{
+		{
+			Path:        "foo",
+			Md5Checksum: []byte("foo-md5"),
+		},
+	})
+
+	err := storeAdapter{index, store}.save()
+	assert.NoError(t, err)
+
+	loaded := newIndex([]IndexedFile{})
+	err = storeAdapter{loaded, store}.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, index.Files(), loaded.Files())
+}
+
+func (suite *IndexerTestSuite) Test_Create_Cancelled() {
+	isSaved := false
+	saverMock = func() error {
+		isSaved = true
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := suite.Indexer.CreateContext(ctx, "dir")
+
+	assert.ErrorIs(suite.T(), err, context.Canceled)
+	assert.False(suite.T(), isSaved, "Expected the index not to be saved")
+}
+
+func (suite *IndexerTestSuite) Test_Create_Failed_Waits_For_Workers() {
+	walkerMock = func(dir string, fun func(string, os.FileInfo)) error {
+		for n := range 100 {
+			path := fmt.Sprintf("dir/%v.txt", n)
+			fun(path, mockFileInfo{path, 42})
+		}
+		return nil
+	}
+	var returned, hashedAfterReturn atomic.Bool
+	hasherMock = func(filePath string, fun func(f IndexedFile), errorFunc func(filePath string, err error), completeFunc func()) {
+		if returned.Load() {
+			hashedAfterReturn.Store(true)
+		}
+		errorFunc(filePath, errors.New("unreadable"))
+		completeFunc()
+	}
+
+	err := suite.Indexer.Create("dir")
+	returned.Store(true)
+	// give leaked workers a chance to hash another file
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Error(suite.T(), err)
+	assert.False(suite.T(), hashedAfterReturn.Load(), "Expected no files to be hashed after Create returned")
+}
@@ -0,0 +1,187 @@
+package deduper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+var errNotHashed = errors.New("file has no hashes of the strategies")
+
+// Match is an indexed file that matches a sample file according to a strategy.
+type Match struct {
+	Strategy Strategy
+	IndexedFile
+	// Distance between the hash of the indexed file and the hash of the sample, 0 for an exact match.
+	Distance int
+	// Transform is how an image is rotated or flipped compared to the sample, if it is, e.g. "rotated 90° clockwise".
+	Transform string `json:",omitempty"`
+}
+
+// Lookup hashes the file at filePath with the strategies of the deduper, or with all strategies the index has hashes
+// for if none are set, and returns the indexed files that match it. Perceptual hashes of images match within
+// maxDistance bits, videos within the video tolerance and audio fingerprints within the fingerprint threshold.
+// Matches are sorted by distance, and the library is not walked again.
+func (d deduperImp) Lookup(filePath string, maxDistance int) ([]Match, error) {
+	o := *d.options
+	files := d.index.Files()
+	if 0 == len(o.strategies) {
+		o.strategies = indexedStrategies(files)
+	}
+	// the sample is hashed with the kinds of image hashes the index has, whichever the deduper indexes with
+	if kinds := indexedImageHashKinds(files); 0 != len(kinds) {
+		o.imageHashKinds = kinds
+	}
+	// rotated copies of a sample image are always looked up
+	o.imageTransforms = true
+
+	sample, err := hashSample(newCompositeHasher(d.fs, &o, nil), filePath)
+	if nil != err {
+		return nil, err
+	}
+	d.logger.Debug("Looking up file", sample.logAttrs()...)
+
+	matches := []Match{}
+	for _, strategy := range o.strategies {
+		for _, f := range files {
+			if m, ok := matchSample(strategy, &o, sample, f, maxDistance); ok {
+				matches = append(matches, m)
+			}
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Path < matches[j].Path
+	})
+
+	return matches, nil
+}
+
+// hashSample hashes a single file, failing if it cannot be hashed with any of the strategies.
+func hashSample(hasher fileHasher, filePath string) (IndexedFile, error) {
+	sample := IndexedFile{Path: filePath}
+	var hashErr error
+	hasher.hash(filePath, func(f IndexedFile) {
+		sample.merge(f)
+	}, func(filePath string, err error) {
+		hashErr = err
+	}, func() {})
+	if nil != hashErr {
+		return IndexedFile{}, fmt.Errorf("error hashing %v: %w\n", filePath, hashErr)
+	}
+	if 0 == len(indexedStrategies([]IndexedFile{sample})) {
+		return IndexedFile{}, fmt.Errorf("error hashing %v: %w\n", filePath, errNotHashed)
+	}
+
+	return sample, nil
+}
+
+// indexedStrategies returns the strategies that any of the files have hashes for.
+func indexedStrategies(files []IndexedFile) []Strategy {
+	found := make(map[Strategy]bool)
+	for _, f := range files {
+		found[StrategyMd5] = found[StrategyMd5] || nil != f.Md5Checksum
+		found[StrategyImageHash] = found[StrategyImageHash] || (ImageHash{}) != f.ImageHash || 0 != len(f.ImageHashes)
+		found[StrategyPixelHash] = found[StrategyPixelHash] || nil != f.PixelChecksum
+		found[StrategyVideoHash] = found[StrategyVideoHash] || nil != f.VideoHash
+		found[StrategyAudioHash] = found[StrategyAudioHash] || nil != f.AudioChecksum
+		found[StrategyAudioPrint] = found[StrategyAudioPrint] || nil != f.AudioPrint
+	}
+
+	strategies := []Strategy{}
+	for _, s := range []Strategy{StrategyMd5, StrategyImageHash, StrategyPixelHash, StrategyVideoHash, StrategyAudioHash, StrategyAudioPrint} {
+		if found[s] {
+			strategies = append(strategies, s)
+		}
+	}
+
+	return strategies
+}
+
+// indexedImageHashKinds returns the kinds of image hashes that any of the files have, the default kind first.
+func indexedImageHashKinds(files []IndexedFile) []ImageHashKind {
+	kinds := []ImageHashKind{}
+	found := make(map[ImageHashKind]bool)
+	for _, f := range files {
+		if _, ok := f.imageHash(DefaultImageHashKind); ok {
+			found[DefaultImageHashKind] = true
+		}
+		for _, h := range f.ImageHashes {
+			if !found[h.ImageHashKind] && DefaultImageHashKind != h.ImageHashKind {
+				kinds = append(kinds, h.ImageHashKind)
+			}
+			found[h.ImageHashKind] = true
+		}
+	}
+	if found[DefaultImageHashKind] {
+		kinds = append([]ImageHashKind{DefaultImageHashKind}, kinds...)
+	}
+
+	return kinds
+}
+
+// matchSample compares the hashes of the strategy of the sample and an indexed file.
+func matchSample(strategy Strategy, o *options, sample IndexedFile, f IndexedFile, maxDistance int) (Match, bool) {
+	m := Match{Strategy: strategy, IndexedFile: f}
+	switch strategy {
+	case StrategyMd5:
+		return m, nil != sample.Md5Checksum && bytes.Equal(sample.Md5Checksum, f.Md5Checksum)
+	case StrategyImageHash:
+		for _, kind := range o.imageHashKinds {
+			if distance, orientation, ok := imageDistance(kind, sample, f); ok && distance <= maxDistance {
+				m.Distance = distance
+				m.Transform = transformNames[orientation]
+				return m, true
+			}
+		}
+	case StrategyPixelHash:
+		return m, nil != sample.PixelChecksum && bytes.Equal(sample.PixelChecksum, f.PixelChecksum)
+	case StrategyVideoHash:
+		if nil != sample.VideoHash && nil != f.VideoHash {
+			m.Distance = videoDistance(sample.VideoHash, f.VideoHash)
+			return m, m.Distance <= o.videoTolerance
+		}
+	case StrategyAudioHash:
+		return m, nil != sample.AudioChecksum && bytes.Equal(sample.AudioChecksum, f.AudioChecksum)
+	case StrategyAudioPrint:
+		if nil != sample.AudioPrint && nil != f.AudioPrint {
+			m.Distance = printDistance(sample.AudioPrint, f.AudioPrint)
+			return m, m.Distance <= maxPrintDistance
+		}
+	}
+
+	return m, false
+}
+
+// imageDistance is the number of bits the image hashes of the kind of the sample and the file differ by, if both have
+// one. With the default kind, the sample is also compared rotated and flipped, returning the closest orientation.
+func imageDistance(kind ImageHashKind, sample IndexedFile, f IndexedFile) (int, int, bool) {
+	a, ok := sample.imageHash(kind)
+	if !ok {
+		return 0, 0, false
+	}
+	b, ok := f.imageHash(kind)
+	if !ok || len(a) != len(b) {
+		return 0, 0, false
+	}
+
+	distance := 0
+	for i := range a {
+		distance += bits.OnesCount64(a[i] ^ b[i])
+	}
+	orientation := 1
+	if DefaultImageHashKind == kind {
+		for i, h := range sample.ImageTransforms {
+			if d := bits.OnesCount64(h ^ b[0]); d < distance {
+				distance = d
+				orientation = i + 2
+			}
+		}
+	}
+
+	return distance, orientation, true
+}
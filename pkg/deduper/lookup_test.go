@@ -0,0 +1,143 @@
+package deduper
+
+import (
+	"testing"
+
+	"github.com/corona10/goimagehash"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// lookupFixture indexes the transform fixture, and adds samples outside of the indexed directory.
+func lookupFixture(t *testing.T, opts ...Option) (afero.Fs, Deduper) {
+	fs := transformFixture(t)
+	photo, err := afero.ReadFile(fs, "photos/photo.jpg")
+	assert.NoError(t, err)
+	writeFiles(t, fs, map[string][]byte{
+		"samples/photo.jpg":   photo,
+		"samples/rotated.jpg": encodeJpeg(t, rotated(t, photo, 8)),
+		"samples/notes.txt":   []byte("notes"),
+	})
+
+	d := New(fs, "index", append([]Option{WithHashers(StrategyMd5, StrategyImageHash)}, opts...)...)
+	assert.NoError(t, d.Create("photos"))
+
+	return fs, d
+}
+
+func matchedPaths(matches []Match) map[Strategy][]string {
+	paths := make(map[Strategy][]string)
+	for _, m := range matches {
+		paths[m.Strategy] = append(paths[m.Strategy], m.Path)
+	}
+
+	return paths
+}
+
+func Test_Lookup(t *testing.T) {
+	_, d := lookupFixture(t)
+
+	matches, err := d.Lookup("samples/photo.jpg", 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[Strategy][]string{
+		StrategyMd5:       {"photos/photo.jpg"},
+		StrategyImageHash: {"photos/flipped/photo.jpg", "photos/photo.jpg", "photos/rotated/photo.jpg", "photos/rotated/upside-down.jpg"},
+	}, matchedPaths(matches))
+	for _, m := range matches {
+		assert.Equal(t, 0, m.Distance)
+		if "photos/rotated/photo.jpg" == m.Path {
+			assert.Equal(t, "rotated 90° clockwise", m.Transform)
+		}
+	}
+}
+
+func Test_Lookup_Rotated_Sample(t *testing.T) {
+	_, d := lookupFixture(t)
+
+	matches, err := d.Lookup("samples/rotated.jpg", 0)
+
+	assert.NoError(t, err)
+	transforms := map[string]string{}
+	for _, m := range matches {
+		assert.Equal(t, StrategyImageHash, m.Strategy)
+		transforms[m.Path] = m.Transform
+	}
+	// the sample is rotated anti-clockwise, so the original is rotated clockwise compared to it
+	assert.Equal(t, "rotated 90° clockwise", transforms["photos/photo.jpg"])
+	assert.Equal(t, "rotated 180°", transforms["photos/rotated/photo.jpg"])
+}
+
+func Test_Lookup_Distance(t *testing.T) {
+	_, d := lookupFixture(t, WithHashers(StrategyImageHash))
+
+	exact, err := d.Lookup("samples/photo.jpg", 0)
+	assert.NoError(t, err)
+	all, err := d.Lookup("samples/photo.jpg", 64)
+	assert.NoError(t, err)
+
+	assert.Len(t, exact, 4)
+	assert.Len(t, all, 5)
+	assert.Equal(t, "photos/other.jpg", all[4].Path)
+	assert.Greater(t, all[4].Distance, 0)
+}
+
+func Test_Lookup_Index_Strategies(t *testing.T) {
+	fs, _ := lookupFixture(t)
+
+	// without strategies, the sample is hashed with those of the index
+	loaded := New(fs, "index", WithHashers())
+	assert.NoError(t, loaded.Load())
+	matches, err := loaded.Lookup("samples/photo.jpg", 0)
+
+	assert.NoError(t, err)
+	assert.Len(t, matchedPaths(matches)[StrategyMd5], 1)
+	assert.Len(t, matchedPaths(matches)[StrategyImageHash], 4)
+}
+
+func Test_Lookup_Not_Hashed(t *testing.T) {
+	_, d := lookupFixture(t, WithHashers(StrategyImageHash))
+
+	_, err := d.Lookup("samples/notes.txt", 0)
+	assert.ErrorIs(t, err, errNotHashed)
+
+	_, err = d.Lookup("samples/missing.jpg", 0)
+	assert.Error(t, err)
+}
+
+func Test_Indexed_Strategies(t *testing.T) {
+	strategies := indexedStrategies([]IndexedFile{
+		{Path: "a", AudioPrint: []uint32{1}},
+		{Path: "b", Md5Checksum: []byte("md5")},
+		{Path: "c", ImageHashes: []PerceptualHash{{DefaultImageHashKind, []uint64{1}}}},
+	})
+
+	assert.Equal(t, []Strategy{StrategyMd5, StrategyImageHash, StrategyAudioPrint}, strategies)
+}
+
+func Test_Lookup_Index_Image_Hash_Kinds(t *testing.T) {
+	perception := ImageHashKind{ImageAlgorithmPerception, 16}
+	fs, _ := lookupFixture(t, WithHashers(StrategyImageHash), WithImageHashes(perception))
+
+	// the sample is hashed with the kinds of the index, rather than the default kind of the deduper
+	loaded := New(fs, "index", WithHashers(StrategyImageHash))
+	assert.NoError(t, loaded.Load())
+	matches, err := loaded.Lookup("samples/photo.jpg", 0)
+
+	assert.NoError(t, err)
+	assert.Contains(t, matchedPaths(matches)[StrategyImageHash], "photos/photo.jpg")
+}
+
+func Test_Indexed_Image_Hash_Kinds(t *testing.T) {
+	average := ImageHashKind{ImageAlgorithmAverage, 16}
+	perception := ImageHashKind{ImageAlgorithmPerception, 8}
+
+	kinds := indexedImageHashKinds([]IndexedFile{
+		{Path: "a", ImageHashes: []PerceptualHash{{average, []uint64{1, 2, 3, 4}}}},
+		{Path: "b", ImageHash: ImageHash{Hash: 1, Kind: int(goimagehash.DHash)}, ImageHashes: []PerceptualHash{{perception, []uint64{1}}, {average, []uint64{1, 2, 3, 4}}}},
+		{Path: "c", Md5Checksum: []byte("md5")},
+	})
+
+	assert.Equal(t, []ImageHashKind{DefaultImageHashKind, average, perception}, kinds)
+	assert.Empty(t, indexedImageHashKinds([]IndexedFile{{Path: "c", Md5Checksum: []byte("md5")}}))
+}
@@ -0,0 +1,215 @@
+package deduper
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+type options struct {
+	strategies []Strategy
+	progress   ProgressReporter
+	logger     *slog.Logger
+	workers    int
+	filters    []Filter
+	store      Store
+	// follow symlinks when walking, rather than only recording them
+	followSymlinks bool
+	// remove directories emptied by moving or deleting duplicates
+	pruneEmptyDirs bool
+	// only log the files that moving or deleting duplicates would change
+	dryRun bool
+	// index the members of archives
+	archives bool
+	// average number of bits the frame hashes of duplicate videos may differ by
+	videoTolerance int
+	// also hash images rotated and flipped, to find rotated copies
+	imageTransforms bool
+	// kinds of perceptual hashes of images, of which duplicates are found with the first
+	imageHashKinds []ImageHashKind
+	// width and height of the thumbnails of images cached while indexing, 0 to not cache thumbnails
+	thumbnailSize int
+}
+
+func defaultOptions() *options {
+	return &options{
+		strategies:     []Strategy{StrategyMd5},
+		progress:       nopProgress{},
+		logger:         discardLogger(),
+		workers:        runtime.NumCPU(),
+		videoTolerance: defaultVideoTolerance,
+		imageHashKinds: []ImageHashKind{DefaultImageHashKind},
+	}
+}
+
+// Option configures optional behaviour of a Deduper.
+type Option func(*options)
+
+// WithHashers sets the strategies used to hash files when indexing, and to find duplicates.
+func WithHashers(strategies ...Strategy) Option {
+	return func(o *options) {
+		o.strategies = strategies
+	}
+}
+
+// WithProgress reports progress to the given reporter while creating an index.
+func WithProgress(reporter ProgressReporter) Option {
+	return func(o *options) {
+		o.progress = reporter
+	}
+}
+
+// WithLogger logs to the given logger. Nothing is logged by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// WithWorkers sets the number of files hashed concurrently. Defaults to the number of CPUs.
+func WithWorkers(workers int) Option {
+	return func(o *options) {
+		if workers > 0 {
+			o.workers = workers
+		}
+	}
+}
+
+// WithFilters only indexes files that are accepted by all filters.
+func WithFilters(filters ...Filter) Option {
+	return func(o *options) {
+		o.filters = append(o.filters, filters...)
+	}
+}
+
+// WithStore loads and saves the index using the given store rather than the index file in the index path.
+func WithStore(store Store) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
+
+// WithFollowSymlinks walks symlinked directories and hashes the targets of symlinked files when follow is set.
+// By default symlinks are recorded in the index without following them.
+func WithFollowSymlinks(follow bool) Option {
+	return func(o *options) {
+		o.followSymlinks = follow
+	}
+}
+
+// WithPruneEmptyDirs removes directories that are left empty after moving or deleting duplicates.
+// Only directories within the indexed root are removed, never the root itself.
+func WithPruneEmptyDirs(prune bool) Option {
+	return func(o *options) {
+		o.pruneEmptyDirs = prune
+	}
+}
+
+// WithDryRun makes moving and deleting duplicates only log the files they would move or delete, without changing them.
+func WithDryRun(dryRun bool) Option {
+	return func(o *options) {
+		o.dryRun = dryRun
+	}
+}
+
+// WithArchives indexes the members of zip, tar and gzipped tar files as well as the archives themselves.
+// Members are indexed with a path like backup.zip!/photos/a.jpg, and are never moved or deleted.
+func WithArchives(archives bool) Option {
+	return func(o *options) {
+		o.archives = archives
+	}
+}
+
+// WithVideoTolerance sets the average number of bits, out of 64, that the hashes of the frames of 2 videos
+// may differ by, for them to be duplicates. Use 0 for videos that look exactly the same.
+func WithVideoTolerance(bits int) Option {
+	return func(o *options) {
+		if bits >= 0 {
+			o.videoTolerance = bits
+		}
+	}
+}
+
+// WithImageTransforms also hashes images rotated by 90, 180 and 270 degrees and flipped, when indexing with the image hash,
+// so that rotated and mirrored copies of an image are found as duplicates.
+func WithImageTransforms(transforms bool) Option {
+	return func(o *options) {
+		o.imageTransforms = transforms
+	}
+}
+
+// WithImageHashes sets the kinds of perceptual hashes images are hashed with when indexing with the image hash,
+// which are stored side by side in the index. Duplicates are found with the first kind.
+// Defaults to the 8x8 difference hash, which is the only kind that rotated and flipped images are hashed with.
+func WithImageHashes(kinds ...ImageHashKind) Option {
+	return func(o *options) {
+		if 0 != len(kinds) {
+			o.imageHashKinds = kinds
+		}
+	}
+}
+
+// WithThumbnails caches thumbnails of at most size by size pixels of the images decoded when indexing with the image hash,
+// in a cache next to the index. See ThumbnailCache. Use 0, the default, to not cache thumbnails.
+func WithThumbnails(size int) Option {
+	return func(o *options) {
+		if size >= 0 {
+			o.thumbnailSize = size
+		}
+	}
+}
+
+// Filter decides whether a file found while walking the directory is indexed.
+type Filter func(path string, info os.FileInfo) bool
+
+func accepted(filters []Filter, path string, info os.FileInfo) bool {
+	for _, f := range filters {
+		if !f(path, info) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MinSizeFilter only accepts files of at least size bytes.
+func MinSizeFilter(size int64) Filter {
+	return func(path string, info os.FileInfo) bool {
+		return info.Size() >= size
+	}
+}
+
+// ExtensionFilter only accepts files with one of the given extensions, e.g. ".jpg". Case insensitive.
+func ExtensionFilter(extensions ...string) Filter {
+	return func(path string, info os.FileInfo) bool {
+		ext := filepath.Ext(path)
+		for _, e := range extensions {
+			if strings.EqualFold(e, ext) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// ExcludeFilter rejects files of which the name matches any of the given patterns, e.g. "*.tmp".
+// See filepath.Match for the pattern syntax.
+func ExcludeFilter(patterns ...string) Filter {
+	return func(path string, info os.FileInfo) bool {
+		for _, p := range patterns {
+			if matched, _ := filepath.Match(p, filepath.Base(path)); matched {
+				return false
+			}
+		}
+
+		return true
+	}
+}
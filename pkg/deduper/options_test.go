@@ -0,0 +1,101 @@
+package deduper
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_New_Defaults(t *testing.T) {
+	d := New(afero.NewMemMapFs(), "index").(*deduperImp)
+
+	indexer := d.Indexer.(*indexerImp)
+	assert.Greater(t, indexer.workers, 0)
+	assert.Len(t, indexer.fileHasher.(*compositeHasher).hashers, 1)
+	assert.IsType(t, &mdFiver{}, indexer.fileHasher.(*compositeHasher).hashers[0])
+	assert.Len(t, d.Finder.(*CompositeFinder).finders, 1)
+	assert.IsType(t, &md5Finder{}, d.Finder.(*CompositeFinder).finders[0])
+}
+
+func Test_New_Options(t *testing.T) {
+	store := NewFileStore(afero.NewMemMapFs(), "index.json")
+	d := New(afero.NewMemMapFs(), "index",
+		WithHashers(StrategyImageHash),
+		WithWorkers(3),
+		WithFilters(MinSizeFilter(10)),
+		WithStore(store),
+	).(*deduperImp)
+
+	indexer := d.Indexer.(*indexerImp)
+	assert.Equal(t, 3, indexer.workers)
+	assert.Len(t, indexer.filters, 1)
+	assert.IsType(t, &imageHasher{}, indexer.fileHasher.(*compositeHasher).hashers[0])
+	assert.Equal(t, store, indexer.saver.(*storeAdapter).store)
+	assert.Len(t, d.Finder.(*CompositeFinder).finders, 1)
+	assert.IsType(t, &imageHashFinder{}, d.Finder.(*CompositeFinder).finders[0])
+}
+
+func Test_New_Video_Options(t *testing.T) {
+	d := New(afero.NewMemMapFs(), "index",
+		WithHashers(StrategyVideoHash),
+		WithVideoTolerance(3),
+	).(*deduperImp)
+
+	assert.IsType(t, &videoHasher{}, d.Indexer.(*indexerImp).fileHasher.(*compositeHasher).hashers[0])
+	assert.Equal(t, 3, d.Finder.(*CompositeFinder).finders[0].(*videoHashFinder).tolerance)
+}
+
+func Test_New_Image_Transforms_Option(t *testing.T) {
+	d := New(afero.NewMemMapFs(), "index", WithHashers(StrategyImageHash), WithImageTransforms(true)).(*deduperImp)
+
+	assert.True(t, d.Indexer.(*indexerImp).fileHasher.(*compositeHasher).hashers[0].(*imageHasher).transforms)
+}
+
+func Test_New_Thumbnails_Option(t *testing.T) {
+	d := New(afero.NewMemMapFs(), "index", WithHashers(StrategyImageHash), WithThumbnails(64)).(*deduperImp)
+
+	writer := d.Indexer.(*indexerImp).fileHasher.(*compositeHasher).hashers[0].(*imageHasher).thumbnails
+	assert.Equal(t, 64, writer.size)
+	assert.Equal(t, d.thumbnails, writer.cache)
+
+	d = New(afero.NewMemMapFs(), "index", WithHashers(StrategyImageHash)).(*deduperImp)
+	assert.Nil(t, d.Indexer.(*indexerImp).fileHasher.(*compositeHasher).hashers[0].(*imageHasher).thumbnails)
+}
+
+func Test_New_Image_Hashes_Option(t *testing.T) {
+	kinds := []ImageHashKind{{ImageAlgorithmPerception, 16}, DefaultImageHashKind}
+	d := New(afero.NewMemMapFs(), "index", WithHashers(StrategyImageHash), WithImageHashes(kinds...)).(*deduperImp)
+
+	assert.Equal(t, kinds, d.Indexer.(*indexerImp).fileHasher.(*compositeHasher).hashers[0].(*imageHasher).kinds)
+	assert.Equal(t, kinds[0], d.Finder.(*CompositeFinder).finders[0].(*imageHashFinder).kind)
+}
+
+func Test_New_Audio_Options(t *testing.T) {
+	d := New(afero.NewMemMapFs(), "index", WithHashers(StrategyAudioHash, StrategyAudioPrint)).(*deduperImp)
+
+	hashers := d.Indexer.(*indexerImp).fileHasher.(*compositeHasher).hashers
+	assert.Equal(t, &audioHasher{d.fs, false, discardLogger()}, hashers[0])
+	assert.True(t, hashers[1].(*audioHasher).fingerprint)
+	assert.IsType(t, &audioHashFinder{}, d.Finder.(*CompositeFinder).finders[0])
+	assert.IsType(t, &audioPrintFinder{}, d.Finder.(*CompositeFinder).finders[1])
+}
+
+func Test_New_Deduper_Wrapper(t *testing.T) {
+	d := NewDeduper(afero.NewMemMapFs(), "index", true, true).(*deduperImp)
+
+	assert.Len(t, d.Indexer.(*indexerImp).fileHasher.(*compositeHasher).hashers, 2)
+}
+
+func Test_Filters(t *testing.T) {
+	small := mockFileInfo{"foo.txt", 5}
+	large := mockFileInfo{"foo.JPG", 50}
+
+	assert.False(t, MinSizeFilter(10)("foo.txt", small))
+	assert.True(t, MinSizeFilter(10)("foo.JPG", large))
+	assert.True(t, ExtensionFilter(".jpg", ".png")("dir/foo.JPG", large))
+	assert.False(t, ExtensionFilter(".jpg", ".png")("dir/foo.txt", small))
+	assert.False(t, ExcludeFilter("*.tmp", ".duplicate-index.json")("dir/.duplicate-index.json", small))
+	assert.True(t, ExcludeFilter("*.tmp")("dir/foo.txt", small))
+	assert.False(t, accepted([]Filter{MinSizeFilter(10), ExtensionFilter(".txt")}, "foo.txt", small))
+}
@@ -0,0 +1,148 @@
+package deduper
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+
+	"github.com/spf13/afero"
+)
+
+var jpegMagic = []byte{0xff, 0xd8}
+
+// magic numbers at the start of the image formats that are decoded
+var imageMagic = [][]byte{
+	jpegMagic,
+	[]byte("\x89PNG"),
+	[]byte("GIF8"),
+}
+
+// pixelHasher hashes the pixels of jpeg, png and gif images, as they are displayed, ignoring all metadata.
+// Images only match if every pixel is the same, so unlike the image hash it never matches images that merely
+// look alike.
+type pixelHasher struct {
+	fs     afero.Fs
+	logger *slog.Logger
+}
+
+func (hasher pixelHasher) hash(filePath string, fun func(f IndexedFile), errorFunc func(filePath string, err error), completeFun func()) {
+	// open file (and close it when done)
+	f, err := hasher.fs.Open(filePath)
+	if err != nil {
+		errorFunc(filePath, err)
+		completeFun()
+		return
+	}
+	defer f.Close()
+
+	// only read files that look like images
+	r := bufio.NewReader(f)
+	if !isImage(r) {
+		completeFun()
+		return
+	}
+	data, err := io.ReadAll(r)
+	if nil != err {
+		errorFunc(filePath, err)
+		completeFun()
+		return
+	}
+
+	if checksum, err := pixelChecksum(data); nil != err {
+		hasher.logger.Debug("Skipping image, cannot decode it", "path", filePath, "error", err)
+	} else {
+		fun(IndexedFile{
+			Path:          filePath,
+			PixelChecksum: checksum,
+			Size:          int64(len(data)),
+		})
+	}
+
+	completeFun()
+}
+
+func isImage(r *bufio.Reader) bool {
+	for _, magic := range imageMagic {
+		if start, err := r.Peek(len(magic)); nil == err && bytes.Equal(magic, start) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pixelChecksum is the md5 checksum of the size and the 16 bit RGBA values of the pixels of the image,
+// after applying its EXIF orientation.
+func pixelChecksum(data []byte) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if nil != err {
+		return nil, err
+	}
+	orientation := 1
+	if exif, err := readExif(data); nil == err {
+		orientation = exif.Orientation
+	}
+
+	w, h, at := orientedImage(img, orientation)
+	hash := md5.New()
+	buf := binary.BigEndian.AppendUint32(nil, uint32(w))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(h))
+	hash.Write(buf)
+	row := make([]byte, 0, w*8)
+	for y := 0; y < h; y++ {
+		row = row[:0]
+		for x := 0; x < w; x++ {
+			c := color.NRGBA64Model.Convert(at(x, y)).(color.NRGBA64)
+			row = binary.BigEndian.AppendUint16(row, c.R)
+			row = binary.BigEndian.AppendUint16(row, c.G)
+			row = binary.BigEndian.AppendUint16(row, c.B)
+			row = binary.BigEndian.AppendUint16(row, c.A)
+		}
+		hash.Write(row)
+	}
+
+	return hash.Sum(nil), nil
+}
+
+// pixelHashFinder finds images with exactly the same pixels.
+type pixelHashFinder struct {
+	index *Index
+}
+
+func (finder pixelHashFinder) Find() ([]DuplicateGroup, error) {
+	dupes := make(map[string][]GroupMember)
+	keys := []string{}
+	hashed := []IndexedFile{}
+	for _, v := range finder.index.Files() {
+		if nil == v.PixelChecksum {
+			// not an image
+			continue
+		}
+		hashed = append(hashed, v)
+		key := string(v.PixelChecksum)
+		if _, found := dupes[key]; !found {
+			keys = append(keys, key)
+		}
+		dupes[key] = append(dupes[key], GroupMember{IndexedFile: v})
+	}
+
+	all := linkedGroups(StrategyPixelHash, hashed)
+	for _, key := range keys {
+		// hard links to the same file are a single copy
+		if members := collapseLinks(dupes[key]); len(members) > 1 {
+			all = append(all, newDuplicateGroup(StrategyPixelHash, hex.EncodeToString([]byte(key)), members))
+		}
+	}
+	sortGroups(all)
+
+	return all, nil
+}
@@ -0,0 +1,158 @@
+package deduper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func testImage(seed int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 24))
+	for x := 0; x < 32; x++ {
+		for y := 0; y < 24; y++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 8), uint8(y * 10), uint8((x + y) * seed), 255})
+		}
+	}
+
+	return img
+}
+
+func encodeJpeg(t *testing.T, img image.Image) []byte {
+	buf := &bytes.Buffer{}
+	assert.NoError(t, jpeg.Encode(buf, img, nil))
+
+	return buf.Bytes()
+}
+
+func encodePng(t *testing.T, img image.Image) []byte {
+	buf := &bytes.Buffer{}
+	assert.NoError(t, png.Encode(buf, img))
+
+	return buf.Bytes()
+}
+
+// writeFiles writes the files, by path, to the fs.
+func writeFiles(t *testing.T, fs afero.Fs, files map[string][]byte) {
+	for name, content := range files {
+		assert.NoError(t, afero.WriteFile(fs, name, content, 0644))
+	}
+}
+
+// rotated returns the image as displayed with the EXIF orientation, with 16 bits per channel.
+func rotated(t *testing.T, data []byte, orientation int) image.Image {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	w, h, at := orientedImage(img, orientation)
+	out := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			out.Set(x, y, at(x, y))
+		}
+	}
+
+	return out
+}
+
+func hashPixels(t *testing.T, hasher pixelHasher, filePath string) (IndexedFile, bool) {
+	var hashed IndexedFile
+	found := false
+	completed := false
+	hasher.hash(filePath, func(f IndexedFile) {
+		hashed = f
+		found = true
+	}, func(filePath string, err error) {
+		assert.Fail(t, "unexpected error", err)
+	}, func() {
+		completed = true
+	})
+	assert.True(t, completed, filePath)
+
+	return hashed, found
+}
+
+func pixelFixture(t *testing.T) afero.Fs {
+	fs := afero.NewMemMapFs()
+	photo := encodeJpeg(t, testImage(1))
+	writeFiles(t, fs, map[string][]byte{
+		"photos/photo.jpg":            photo,
+		"photos/edited/photo.jpg":     withExif(photo, exifSegment(binary.BigEndian, 1)),
+		"photos/rotated.jpg":          withExif(photo, exifSegment(binary.LittleEndian, 6)),
+		"photos/rotated.png":          encodePng(t, rotated(t, photo, 6)),
+		"photos/other.jpg":            encodeJpeg(t, testImage(2)),
+		"photos/broken.jpg":           photo[:100],
+		"photos/not-an-image.jpg":     []byte("not an image"),
+		"photos/transparent/logo.png": encodePng(t, image.NewNRGBA(image.Rect(0, 0, 4, 4))),
+	})
+
+	return fs
+}
+
+func Test_Pixel_Hasher_Ignores_Metadata(t *testing.T) {
+	hasher := pixelHasher{pixelFixture(t), discardLogger()}
+
+	photo, found := hashPixels(t, hasher, "photos/photo.jpg")
+	assert.True(t, found)
+	assert.Len(t, photo.PixelChecksum, 16)
+	edited, _ := hashPixels(t, hasher, "photos/edited/photo.jpg")
+	assert.Equal(t, photo.PixelChecksum, edited.PixelChecksum)
+	assert.Greater(t, edited.Size, photo.Size)
+
+	other, _ := hashPixels(t, hasher, "photos/other.jpg")
+	assert.NotEqual(t, photo.PixelChecksum, other.PixelChecksum)
+}
+
+func Test_Pixel_Hasher_Applies_Orientation(t *testing.T) {
+	hasher := pixelHasher{pixelFixture(t), discardLogger()}
+
+	photo, _ := hashPixels(t, hasher, "photos/photo.jpg")
+	rotatedJpg, _ := hashPixels(t, hasher, "photos/rotated.jpg")
+	rotatedPng, found := hashPixels(t, hasher, "photos/rotated.png")
+
+	assert.True(t, found)
+	assert.NotEqual(t, photo.PixelChecksum, rotatedJpg.PixelChecksum)
+	assert.Equal(t, rotatedPng.PixelChecksum, rotatedJpg.PixelChecksum)
+}
+
+func Test_Pixel_Hasher_Skips(t *testing.T) {
+	hasher := pixelHasher{pixelFixture(t), discardLogger()}
+
+	for _, filePath := range []string{"photos/broken.jpg", "photos/not-an-image.jpg"} {
+		_, found := hashPixels(t, hasher, filePath)
+		assert.False(t, found, filePath)
+	}
+}
+
+func Test_Pixel_Hasher_Error(t *testing.T) {
+	hasher := pixelHasher{afero.NewMemMapFs(), discardLogger()}
+
+	var reported error
+	hasher.hash("photos/missing.jpg", func(f IndexedFile) {}, func(filePath string, err error) {
+		reported = err
+	}, func() {})
+
+	assert.Error(t, reported)
+}
+
+func Test_Find_PixelHash(t *testing.T) {
+	fs := pixelFixture(t)
+	o := defaultOptions()
+	WithHashers(StrategyPixelHash)(o)
+	index := newIndex([]IndexedFile{})
+
+	err := newIndexer(fs, "photos", index, o).Create("photos")
+	assert.NoError(t, err)
+	groups, err := newCompositeFinder(index, 0, DefaultImageHashKind, StrategyPixelHash).Find()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 2)
+	assert.Equal(t, StrategyPixelHash, groups[0].Strategy)
+	assert.Equal(t, []string{"photos/photo.jpg", "photos/edited/photo.jpg"}, groups[0].Paths())
+	assert.Equal(t, []string{"photos/rotated.jpg", "photos/rotated.png"}, groups[1].Paths())
+}
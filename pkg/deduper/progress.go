@@ -0,0 +1,105 @@
+package deduper
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// how often progress is reported while creating an index
+const progressInterval = 200 * time.Millisecond
+
+// Progress is a snapshot of the progress of creating an index.
+type Progress struct {
+	FilesDiscovered int64
+	FilesHashed     int64
+	BytesDiscovered int64
+	BytesHashed     int64
+	// Throughput in bytes hashed per second
+	Throughput float64
+	Elapsed    time.Duration
+	// ETA is only known once all files have been discovered, and 0 until then.
+	ETA         time.Duration
+	CurrentFile string
+	// DiscoveryDone is true once the directory has been walked completely.
+	DiscoveryDone bool
+	// Done is true for the last update, when all files are hashed.
+	Done bool
+}
+
+// ProgressReporter receives progress updates while an index is created.
+// Updates are delivered one at a time, from the goroutine calling Create.
+type ProgressReporter interface {
+	Progress(p Progress)
+}
+
+// ProgressFunc is a function that can be used as a ProgressReporter.
+type ProgressFunc func(p Progress)
+
+func (f ProgressFunc) Progress(p Progress) {
+	f(p)
+}
+
+type nopProgress struct{}
+
+func (nopProgress) Progress(p Progress) {}
+
+type progressTracker struct {
+	start           time.Time
+	filesDiscovered int64
+	filesHashed     int64
+	bytesDiscovered int64
+	bytesHashed     int64
+	discoveryDone   int32
+	mu              sync.Mutex
+	currentFile     string
+}
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{start: time.Now()}
+}
+
+func (t *progressTracker) discovered(size int64) {
+	atomic.AddInt64(&t.filesDiscovered, 1)
+	atomic.AddInt64(&t.bytesDiscovered, size)
+}
+
+func (t *progressTracker) walked() {
+	atomic.StoreInt32(&t.discoveryDone, 1)
+}
+
+func (t *progressTracker) hashing(filePath string) {
+	t.mu.Lock()
+	t.currentFile = filePath
+	t.mu.Unlock()
+}
+
+func (t *progressTracker) hashed(size int64) {
+	atomic.AddInt64(&t.filesHashed, 1)
+	atomic.AddInt64(&t.bytesHashed, size)
+}
+
+func (t *progressTracker) snapshot(done bool) Progress {
+	p := Progress{
+		FilesDiscovered: atomic.LoadInt64(&t.filesDiscovered),
+		FilesHashed:     atomic.LoadInt64(&t.filesHashed),
+		BytesDiscovered: atomic.LoadInt64(&t.bytesDiscovered),
+		BytesHashed:     atomic.LoadInt64(&t.bytesHashed),
+		Elapsed:         time.Since(t.start),
+		DiscoveryDone:   1 == atomic.LoadInt32(&t.discoveryDone),
+		Done:            done,
+	}
+	t.mu.Lock()
+	p.CurrentFile = t.currentFile
+	t.mu.Unlock()
+
+	if seconds := p.Elapsed.Seconds(); seconds > 0 {
+		p.Throughput = float64(p.BytesHashed) / seconds
+	}
+	if p.DiscoveryDone && !done && p.Throughput > 0 {
+		remaining := float64(p.BytesDiscovered - p.BytesHashed)
+		p.ETA = time.Duration(remaining / p.Throughput * float64(time.Second))
+	}
+
+	return p
+}
@@ -0,0 +1,42 @@
+package deduper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Progress_Snapshot(t *testing.T) {
+	tracker := newProgressTracker()
+	tracker.start = time.Now().Add(-10 * time.Second)
+	tracker.discovered(100)
+	tracker.discovered(300)
+	tracker.hashing("foo")
+	tracker.hashed(100)
+
+	p := tracker.snapshot(false)
+
+	assert.Equal(t, int64(2), p.FilesDiscovered)
+	assert.Equal(t, int64(1), p.FilesHashed)
+	assert.Equal(t, int64(400), p.BytesDiscovered)
+	assert.Equal(t, int64(100), p.BytesHashed)
+	assert.Equal(t, "foo", p.CurrentFile)
+	assert.InDelta(t, 10, p.Throughput, 0.1)
+	// no ETA until all files are discovered
+	assert.Equal(t, time.Duration(0), p.ETA)
+	assert.False(t, p.Done)
+}
+
+func Test_Progress_Snapshot_ETA(t *testing.T) {
+	tracker := newProgressTracker()
+	tracker.start = time.Now().Add(-10 * time.Second)
+	tracker.discovered(400)
+	tracker.hashed(100)
+	tracker.walked()
+
+	p := tracker.snapshot(false)
+
+	assert.True(t, p.DiscoveryDone)
+	assert.InDelta(t, 30*time.Second, p.ETA, float64(time.Second))
+}
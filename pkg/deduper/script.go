@@ -0,0 +1,207 @@
+package deduper
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// ScriptShell is the shell that WriteScript writes a script for.
+type ScriptShell string
+
+const (
+	ShellBash       ScriptShell = "bash"
+	ShellPowerShell ScriptShell = "powershell"
+)
+
+// ScriptAction is what a script written by WriteScript does with the duplicates.
+type ScriptAction string
+
+const (
+	// ScriptMove moves the duplicates to the target directory, like MoveDuplicates.
+	ScriptMove ScriptAction = "move"
+	// ScriptDelete permanently deletes the duplicates, like DeleteDuplicates.
+	ScriptDelete ScriptAction = "delete"
+	// ScriptLink replaces the duplicates with hard links to the keeper. Directories are not linked.
+	ScriptLink ScriptAction = "link"
+)
+
+// Script configures the script written by WriteScript.
+type Script struct {
+	Shell  ScriptShell
+	Action ScriptAction
+	// Target is the directory to move the duplicates to, only for ScriptMove.
+	Target string
+}
+
+// shellSyntax has the commands of a shell, as format strings of quoted paths.
+type shellSyntax struct {
+	header []string
+	quote  func(s string) string
+	mkdir  string
+	// move from to
+	move      string
+	remove    string
+	removeDir string
+	// link keeper path
+	link          string
+	removeIfEmpty string
+}
+
+var shellSyntaxes = map[ScriptShell]shellSyntax{
+	ShellBash: {
+		header: []string{"#!/usr/bin/env bash", "set -euo pipefail"},
+		quote:  bashQuote,
+		mkdir:  "mkdir -p -- %v",
+		// never overwrite a file moved before
+		move:      "mv -n -- %v %v",
+		remove:    "rm -f -- %v",
+		removeDir: "rm -rf -- %v",
+		// -n replaces a symlink to a directory, rather than linking in the directory
+		link:          "ln -fn -- %v %v",
+		removeIfEmpty: "rmdir -- %v 2>/dev/null || true",
+	},
+	ShellPowerShell: {
+		header: []string{"$ErrorActionPreference = 'Stop'"},
+		quote:  powerShellQuote,
+		// not New-Item, as its -Path treats [ and ] as wildcards. Relative paths are resolved from the location,
+		// like the other commands do, rather than from the directory PowerShell started in.
+		mkdir: "[System.IO.Directory]::CreateDirectory($ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath(%v)) | Out-Null",
+		// -LiteralPath, as -Path treats [ and ] as wildcards
+		move:          "Move-Item -LiteralPath %v -Destination %v",
+		remove:        "Remove-Item -LiteralPath %v -Force",
+		removeDir:     "Remove-Item -LiteralPath %v -Recurse -Force",
+		link:          "Remove-Item -LiteralPath %[2]v -Force; New-Item -ItemType HardLink -Path %[2]v -Target %[1]v | Out-Null",
+		removeIfEmpty: "if (-not (Get-ChildItem -LiteralPath %[1]v -Force)) { Remove-Item -LiteralPath %[1]v }",
+	},
+}
+
+// bashQuote quotes s in single quotes, in which nothing but the single quote itself is special.
+func bashQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// powerShellQuote quotes s in single quotes, doubling the quotes that PowerShell treats as single quotes.
+func powerShellQuote(s string) string {
+	return "'" + strings.NewReplacer("'", "''", "‘", "‘‘", "’", "’’", "‚", "‚‚", "‛", "‛‛").Replace(s) + "'"
+}
+
+// scriptComment is a comment of text, in which line breaks and other control characters are replaced, so that
+// a file name never ends the comment.
+func scriptComment(text string) string {
+	return "# " + strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || '\u2028' == r || '\u2029' == r {
+			return '?'
+		}
+		return r
+	}, text)
+}
+
+// WriteScript writes a script that moves, deletes or links the duplicates of the groups the same way MoveDuplicates
+// and DeleteDuplicates would, to review before running it. Every group starts with a comment listing its hash and
+// keeper. Files are only changed when the script is run.
+func (d deduperImp) WriteScript(w io.Writer, groups []DuplicateGroup, script Script) error {
+	syntax, ok := shellSyntaxes[script.Shell]
+	if !ok {
+		return fmt.Errorf("unknown shell %v\n", script.Shell)
+	}
+	var description string
+	switch script.Action {
+	case ScriptMove:
+		if "" == script.Target {
+			return fmt.Errorf("a target directory is required to move duplicates\n")
+		}
+		description = "Moves the duplicates found by deduplicater to " + script.Target + ", keeping 1 file of each group."
+	case ScriptDelete:
+		description = "Permanently deletes the duplicates found by deduplicater, keeping 1 file of each group."
+	case ScriptLink:
+		description = "Replaces the duplicates found by deduplicater with hard links to the 1 file kept of each group."
+	default:
+		return fmt.Errorf("unknown script action %v\n", script.Action)
+	}
+
+	root := d.root(groups)
+	lines := append([]string{}, syntax.header...)
+	lines = append(lines,
+		scriptComment(description),
+		scriptComment("Review it before running it. Files in archives are never changed."),
+	)
+	if !filepath.IsAbs(root) {
+		lines = append(lines, scriptComment("Run it from the directory deduplicater was run from, as paths are relative."))
+	}
+
+	created := make(map[string]bool)
+	removed := []string{}
+	for _, group := range FilterGroups(groups, CategoryDuplicate, CategoryDirectory) {
+		lines = append(lines, "",
+			scriptComment(fmt.Sprintf("[%v %v] %v members, %v bytes reclaimable", group.Strategy, group.Key, len(group.Members), group.Reclaimable)),
+			scriptComment("keep "+syntax.quote(group.Keeper)),
+		)
+		if ScriptLink == script.Action && CategoryDirectory == group.Category {
+			lines = append(lines, scriptComment("skip, directories are not linked"))
+			continue
+		}
+		if ScriptLink == script.Action && keeperInArchive(group) {
+			lines = append(lines, scriptComment("skip, files are not linked to a file in an archive"))
+			continue
+		}
+
+		for _, dupe := range group.Duplicates() {
+			if "" != dupe.Archive {
+				lines = append(lines, scriptComment("skip "+syntax.quote(dupe.Path)+", in archive"))
+				continue
+			}
+			// all hard links as well, otherwise no space is reclaimed
+			for _, file := range dupe.AllPaths() {
+				switch script.Action {
+				case ScriptMove:
+					newPath, err := movedPath(root, file, script.Target)
+					if nil != err {
+						return err
+					}
+					if dir := filepath.Dir(newPath); !created[dir] {
+						created[dir] = true
+						lines = append(lines, fmt.Sprintf(syntax.mkdir, syntax.quote(dir)))
+					}
+					lines = append(lines, fmt.Sprintf(syntax.move, syntax.quote(file), syntax.quote(newPath)))
+					removed = append(removed, file)
+				case ScriptDelete:
+					remove := syntax.remove
+					if CategoryDirectory == group.Category {
+						remove = syntax.removeDir
+					}
+					lines = append(lines, fmt.Sprintf(remove, syntax.quote(file)))
+					removed = append(removed, file)
+				case ScriptLink:
+					lines = append(lines, fmt.Sprintf(syntax.link, syntax.quote(group.Keeper), syntax.quote(file)))
+				}
+			}
+		}
+	}
+
+	if d.pruneEmptyDirs && 0 != len(removed) {
+		lines = append(lines, "", scriptComment("remove directories left empty"))
+		for _, dir := range parentDirs(root, removed) {
+			lines = append(lines, fmt.Sprintf(syntax.removeIfEmpty, syntax.quote(dir)))
+		}
+	}
+
+	if _, err := io.WriteString(w, strings.Join(lines, "\n")+"\n"); nil != err {
+		return fmt.Errorf("error writing script: %w\n", err)
+	}
+
+	return nil
+}
+
+// keeperInArchive is true when the keeper of the group is a member of an archive.
+func keeperInArchive(group DuplicateGroup) bool {
+	for _, m := range group.Members {
+		if m.Path == group.Keeper {
+			return "" != m.Archive
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,173 @@
+package deduper
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+var scriptGroups = []DuplicateGroup{
+	newDuplicateGroup(StrategyMd5, "5d41402abc4b2a76b9719d911017c592", []GroupMember{
+		{IndexedFile: IndexedFile{Path: "pictures/a.jpg", Size: 5}},
+		{IndexedFile: IndexedFile{Path: "pictures/old/it's a.jpg", Size: 5}, Links: []string{"pictures/old/link.jpg"}},
+		{IndexedFile: IndexedFile{Path: "pictures/backup.zip!/a.jpg", Size: 5, Archive: "pictures/backup.zip"}},
+	}),
+	newEmptyGroup(StrategyMd5, "d41d8cd98f00b204e9800998ecf8427e", []IndexedFile{{Path: "pictures/empty.txt"}}),
+}
+
+func dirGroup(key string, members []GroupMember) DuplicateGroup {
+	group := newDuplicateGroup(StrategyMd5, key, members)
+	group.Category = CategoryDirectory
+
+	return group
+}
+
+func Test_Write_Script_Bash_Move(t *testing.T) {
+	d := New(afero.NewMemMapFs(), "pictures", WithPruneEmptyDirs(true))
+	out := &bytes.Buffer{}
+
+	err := d.WriteScript(out, scriptGroups, Script{ShellBash, ScriptMove, "/duplicates"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `#!/usr/bin/env bash
+set -euo pipefail
+# Moves the duplicates found by deduplicater to /duplicates, keeping 1 file of each group.
+# Review it before running it. Files in archives are never changed.
+# Run it from the directory deduplicater was run from, as paths are relative.
+
+# [md5 5d41402abc4b2a76b9719d911017c592] 3 members, 5 bytes reclaimable
+# keep 'pictures/a.jpg'
+mkdir -p -- '/duplicates/old'
+mv -n -- 'pictures/old/it'\''s a.jpg' '/duplicates/old/it'\''s a.jpg'
+mv -n -- 'pictures/old/link.jpg' '/duplicates/old/link.jpg'
+# skip 'pictures/backup.zip!/a.jpg', in archive
+
+# remove directories left empty
+rmdir -- 'pictures/old' 2>/dev/null || true
+`, out.String())
+}
+
+func Test_Write_Script_PowerShell_Delete(t *testing.T) {
+	d := New(afero.NewMemMapFs(), "/pictures")
+	groups := []DuplicateGroup{dirGroup("764efa883dda1e11db47671c4a3bbd9e", []GroupMember{
+		{IndexedFile: IndexedFile{Path: "/pictures/2020", Size: 10}},
+		{IndexedFile: IndexedFile{Path: "/pictures/copy of 2020 [‘1’]", Size: 10}},
+	})}
+	out := &bytes.Buffer{}
+
+	err := d.WriteScript(out, groups, Script{ShellPowerShell, ScriptDelete, ""})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `$ErrorActionPreference = 'Stop'
+# Permanently deletes the duplicates found by deduplicater, keeping 1 file of each group.
+# Review it before running it. Files in archives are never changed.
+
+# [md5 764efa883dda1e11db47671c4a3bbd9e] 2 members, 10 bytes reclaimable
+# keep '/pictures/2020'
+Remove-Item -LiteralPath '/pictures/copy of 2020 [‘‘1’’]' -Recurse -Force
+`, out.String())
+}
+
+func Test_Write_Script_PowerShell_Move_Brackets(t *testing.T) {
+	d := New(afero.NewMemMapFs(), "pictures")
+	out := &bytes.Buffer{}
+
+	err := d.WriteScript(out, scriptGroups, Script{ShellPowerShell, ScriptMove, "duplicates [2020]"})
+
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "\n[System.IO.Directory]::CreateDirectory($ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath('duplicates [2020]/old')) | Out-Null\n")
+	assert.Contains(t, out.String(), "\nMove-Item -LiteralPath 'pictures/old/it''s a.jpg' -Destination 'duplicates [2020]/old/it''s a.jpg'\n")
+	assert.NotContains(t, out.String(), "-Path ")
+}
+
+func Test_Write_Script_Link(t *testing.T) {
+	d := New(afero.NewMemMapFs(), "pictures", WithPruneEmptyDirs(true))
+	groups := append([]DuplicateGroup{dirGroup("764efa883dda1e11db47671c4a3bbd9e", []GroupMember{
+		{IndexedFile: IndexedFile{Path: "pictures/2020"}},
+		{IndexedFile: IndexedFile{Path: "pictures/copy"}},
+	})}, scriptGroups...)
+	bash, powerShell := &bytes.Buffer{}, &bytes.Buffer{}
+
+	assert.NoError(t, d.WriteScript(bash, groups, Script{Shell: ShellBash, Action: ScriptLink}))
+	assert.NoError(t, d.WriteScript(powerShell, groups, Script{Shell: ShellPowerShell, Action: ScriptLink}))
+
+	assert.Contains(t, bash.String(), "# skip, directories are not linked\n")
+	assert.Contains(t, bash.String(), "ln -fn -- 'pictures/a.jpg' 'pictures/old/it'\\''s a.jpg'\nln -fn -- 'pictures/a.jpg' 'pictures/old/link.jpg'\n")
+	// nothing is removed, so no directories are left empty
+	assert.NotContains(t, bash.String(), "rmdir")
+	assert.Contains(t, powerShell.String(), "Remove-Item -LiteralPath 'pictures/old/link.jpg' -Force; New-Item -ItemType HardLink -Path 'pictures/old/link.jpg' -Target 'pictures/a.jpg' | Out-Null\n")
+}
+
+func Test_Write_Script_Link_Archive_Keeper(t *testing.T) {
+	d := New(afero.NewMemMapFs(), "pictures")
+	group := newDuplicateGroup(StrategyMd5, "5d41402abc4b2a76b9719d911017c592", []GroupMember{
+		{IndexedFile: IndexedFile{Path: "pictures/a.jpg", Size: 5}},
+		{IndexedFile: IndexedFile{Path: "pictures/backup.zip!/a.jpg", Size: 5, Archive: "pictures/backup.zip"}},
+	})
+	group.Keeper = "pictures/backup.zip!/a.jpg"
+	out := &bytes.Buffer{}
+
+	assert.NoError(t, d.WriteScript(out, []DuplicateGroup{group}, Script{Shell: ShellBash, Action: ScriptLink}))
+
+	assert.Contains(t, out.String(), "# skip, files are not linked to a file in an archive\n")
+	assert.NotContains(t, out.String(), "ln ")
+}
+
+func Test_Write_Script_Invalid(t *testing.T) {
+	d := New(afero.NewMemMapFs(), "pictures")
+
+	assert.Error(t, d.WriteScript(&bytes.Buffer{}, scriptGroups, Script{Shell: "cmd", Action: ScriptDelete}))
+	assert.Error(t, d.WriteScript(&bytes.Buffer{}, scriptGroups, Script{Shell: ShellBash, Action: "copy"}))
+	assert.Error(t, d.WriteScript(&bytes.Buffer{}, scriptGroups, Script{Shell: ShellBash, Action: ScriptMove}))
+}
+
+func Test_Script_Comment(t *testing.T) {
+	assert.Equal(t, "# keep 'a?rm -rf ~?b'", scriptComment("keep 'a\nrm -rf ~ b'"))
+}
+
+func Test_Quote(t *testing.T) {
+	assert.Equal(t, `'it'\''s $(pwd)'`, bashQuote("it's $(pwd)"))
+	assert.Equal(t, `'it''s ’’$(pwd)'`, powerShellQuote("it's ’$(pwd)"))
+}
+
+// Test_Run_Bash_Script runs the script on files with names that need quoting.
+func Test_Run_Bash_Script(t *testing.T) {
+	if _, err := exec.LookPath("bash"); nil != err {
+		t.Skip("bash is not installed")
+	}
+	dir := t.TempDir()
+	root := filepath.Join(dir, "pictures")
+	names := []string{"a.jpg", "-n it's \"a\" $(touch pwned) *.jpg", "new\nline/a.jpg"}
+	members := []GroupMember{}
+	for _, name := range names {
+		path := filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte("content"), 0644))
+		members = append(members, GroupMember{IndexedFile: IndexedFile{Path: path, Size: 7}})
+	}
+	d := New(afero.NewOsFs(), root, WithPruneEmptyDirs(true))
+	script := filepath.Join(dir, "script.sh")
+	out := &bytes.Buffer{}
+	group := newDuplicateGroup(StrategyMd5, "", members)
+	group.Keeper = filepath.Join(root, "a.jpg")
+	assert.NoError(t, d.WriteScript(out, []DuplicateGroup{group}, Script{ShellBash, ScriptMove, filepath.Join(dir, "moved")}))
+	assert.NoError(t, os.WriteFile(script, out.Bytes(), 0755))
+
+	cmd := exec.Command("bash", script)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+
+	assert.NoError(t, err, string(output))
+	assert.FileExists(t, filepath.Join(root, "a.jpg"))
+	for _, name := range names[1:] {
+		assert.NoFileExists(t, filepath.Join(root, name))
+		assert.FileExists(t, filepath.Join(dir, "moved", name))
+	}
+	assert.NoDirExists(t, filepath.Join(root, "new\nline"))
+	assert.NoFileExists(t, filepath.Join(dir, "pwned"))
+}
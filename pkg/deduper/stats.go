@@ -0,0 +1,197 @@
+package deduper
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// Breakdown is the number of files and bytes for a directory or file extension.
+type Breakdown struct {
+	Key   string
+	Files int
+	Bytes int64
+}
+
+// StrategyStats describes the duplicates found using a single strategy.
+// The breakdowns only count the duplicate files that would be removed.
+type StrategyStats struct {
+	Strategy Strategy
+	// Groups of duplicates, excluding linked and empty files
+	Groups []DuplicateGroup
+	// Linked is the number of paths of files that have more than 1 path
+	Linked int
+	// Empty is the number of files without content
+	Empty int
+	// Duplicates is the number of files that would be removed, InArchives the number of duplicates in archives,
+	// which are never removed
+	Duplicates  int
+	InArchives  int
+	Reclaimable int64
+	ByDirectory []Breakdown
+	ByExtension []Breakdown
+}
+
+// Stats describes the files in an index and the space that can be reclaimed by removing duplicates.
+// Groups and breakdowns are sorted largest first.
+type Stats struct {
+	Files int
+	// Bytes of the files, not counting archive members, as those are counted in the size of their archive
+	Bytes       int64
+	Strategies  []StrategyStats
+	ByDirectory []Breakdown
+	ByExtension []Breakdown
+}
+
+type breakdowns struct {
+	byDir map[string]*Breakdown
+	byExt map[string]*Breakdown
+}
+
+func newBreakdowns() breakdowns {
+	return breakdowns{
+		make(map[string]*Breakdown),
+		make(map[string]*Breakdown),
+	}
+}
+
+func (b breakdowns) add(path string, size int64) {
+	addTo(b.byDir, filepath.Dir(path), size)
+	addTo(b.byExt, strings.ToLower(filepath.Ext(path)), size)
+}
+
+func addTo(m map[string]*Breakdown, key string, size int64) {
+	if _, found := m[key]; !found {
+		m[key] = &Breakdown{Key: key}
+	}
+	m[key].Files++
+	m[key].Bytes += size
+}
+
+func sortedBreakdown(m map[string]*Breakdown) []Breakdown {
+	all := make([]Breakdown, 0, len(m))
+	for _, v := range m {
+		all = append(all, *v)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Bytes == all[j].Bytes {
+			return all[i].Key < all[j].Key
+		}
+		return all[i].Bytes > all[j].Bytes
+	})
+
+	return all
+}
+
+// IndexStats calculates statistics for all strategies the index contains hashes for.
+// Files indexed without their size are looked up on the file system.
+func IndexStats(fs afero.Fs, index *Index) (Stats, error) {
+	files := index.Files()
+	stats := Stats{Files: len(files)}
+	all := newBreakdowns()
+	hasMd5 := false
+	hasImageHash := false
+	// the default kind of image hash, or else the first other kind found
+	imageKind := ImageHashKind{}
+	hasPixelHash := false
+	hasVideoHash := false
+	hasAudioHash := false
+	hasAudioPrint := false
+	for i, f := range files {
+		if 0 == f.Size {
+			if info, err := fs.Stat(f.Path); nil == err {
+				files[i].Size = info.Size()
+			}
+		}
+		size := files[i].Size
+		if "" != f.Archive {
+			size = 0
+		}
+		stats.Bytes += size
+		all.add(f.Path, size)
+
+		hasMd5 = hasMd5 || nil != f.Md5Checksum
+		if _, ok := f.imageHash(DefaultImageHashKind); ok {
+			hasImageHash = true
+		} else if !hasImageHash && 0 != len(f.ImageHashes) && (ImageHashKind{}) == imageKind {
+			imageKind = f.ImageHashes[0].ImageHashKind
+		}
+		hasPixelHash = hasPixelHash || nil != f.PixelChecksum
+		hasVideoHash = hasVideoHash || nil != f.VideoHash
+		hasAudioHash = hasAudioHash || nil != f.AudioChecksum
+		hasAudioPrint = hasAudioPrint || nil != f.AudioPrint
+	}
+	stats.ByDirectory = sortedBreakdown(all.byDir)
+	stats.ByExtension = sortedBreakdown(all.byExt)
+
+	// find duplicates in a copy of the index that has all sizes
+	sized := newIndex(files)
+	finders := make(map[Strategy]Finder)
+	if hasMd5 {
+		finders[StrategyMd5] = &md5Finder{sized}
+	}
+	if hasImageHash {
+		finders[StrategyImageHash] = &imageHashFinder{sized, DefaultImageHashKind}
+	} else if (ImageHashKind{}) != imageKind {
+		finders[StrategyImageHash] = &imageHashFinder{sized, imageKind}
+	}
+	if hasPixelHash {
+		finders[StrategyPixelHash] = &pixelHashFinder{sized}
+	}
+	if hasVideoHash {
+		finders[StrategyVideoHash] = &videoHashFinder{sized, defaultVideoTolerance}
+	}
+	if hasAudioHash {
+		finders[StrategyAudioHash] = &audioHashFinder{sized}
+	}
+	if hasAudioPrint {
+		finders[StrategyAudioPrint] = &audioPrintFinder{sized}
+	}
+
+	for _, strategy := range []Strategy{StrategyMd5, StrategyImageHash, StrategyPixelHash, StrategyVideoHash, StrategyAudioHash, StrategyAudioPrint} {
+		finder, found := finders[strategy]
+		if !found {
+			continue
+		}
+		groups, err := finder.Find()
+		if nil != err {
+			return stats, err
+		}
+		stats.Strategies = append(stats.Strategies, strategyStats(strategy, groups))
+	}
+
+	return stats, nil
+}
+
+func strategyStats(strategy Strategy, groups []DuplicateGroup) StrategyStats {
+	stats := StrategyStats{Strategy: strategy, Groups: FilterGroups(groups, CategoryDuplicate)}
+	for _, group := range FilterGroups(groups, CategoryLinked) {
+		stats.Linked += len(group.Members)
+	}
+	for _, group := range FilterGroups(groups, CategoryEmpty) {
+		stats.Empty += len(group.Members)
+	}
+
+	removed := newBreakdowns()
+	for _, group := range stats.Groups {
+		for _, m := range group.Duplicates() {
+			if "" != m.Archive {
+				stats.InArchives++
+				continue
+			}
+			removed.add(m.Path, m.Size)
+			stats.Duplicates++
+		}
+		stats.Reclaimable += group.Reclaimable
+	}
+
+	sort.SliceStable(stats.Groups, func(i, j int) bool {
+		return stats.Groups[i].Reclaimable > stats.Groups[j].Reclaimable
+	})
+	stats.ByDirectory = sortedBreakdown(removed.byDir)
+	stats.ByExtension = sortedBreakdown(removed.byExt)
+
+	return stats
+}
@@ -0,0 +1,161 @@
+package deduper
+
+import (
+	"crypto/md5"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Index_Stats_Md5(t *testing.T) {
+	index := newIndex([]IndexedFile{
+		{
+			Path:        "pictures/foo.jpg",
+			Md5Checksum: []byte("foo-md5"),
+			Size:        100,
+		},
+		{
+			Path:        "pictures/copy/foo.jpg",
+			Md5Checksum: []byte("foo-md5"),
+			Size:        100,
+		},
+		{
+			Path:        "pictures/copy/foo (1).jpg",
+			Md5Checksum: []byte("foo-md5"),
+			Size:        100,
+		},
+		{
+			Path:        "pictures/bar.txt",
+			Md5Checksum: []byte("bar-md5"),
+			Size:        10,
+		},
+	})
+
+	stats, err := IndexStats(afero.NewMemMapFs(), index)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, stats.Files)
+	assert.Equal(t, int64(310), stats.Bytes)
+	assert.Equal(t, []Breakdown{{"pictures/copy", 2, 200}, {"pictures", 2, 110}}, stats.ByDirectory)
+	assert.Equal(t, []Breakdown{{".jpg", 3, 300}, {".txt", 1, 10}}, stats.ByExtension)
+
+	assert.Len(t, stats.Strategies, 1)
+	md5 := stats.Strategies[0]
+	assert.Equal(t, StrategyMd5, md5.Strategy)
+	assert.Equal(t, 2, md5.Duplicates)
+	assert.Equal(t, int64(200), md5.Reclaimable)
+	assert.Len(t, md5.Groups, 1)
+	assert.Equal(t, "pictures/foo.jpg", md5.Groups[0].Keeper)
+	assert.Equal(t, int64(300), md5.Groups[0].Size())
+	assert.Equal(t, []Breakdown{{"pictures/copy", 2, 200}}, md5.ByDirectory)
+}
+
+func Test_Index_Stats_Size_From_Fs(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "foo.txt", []byte("content: foo"), 0644)
+	index := newIndex([]IndexedFile{
+		{
+			Path:        "foo.txt",
+			Md5Checksum: []byte("foo-md5"),
+		},
+	})
+
+	stats, err := IndexStats(fs, index)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), stats.Bytes)
+}
+
+func Test_Index_Stats_All_Strategies(t *testing.T) {
+	index := newIndex([]IndexedFile{
+		{
+			Path:        "foo.jpg",
+			Md5Checksum: []byte("foo-md5"),
+			ImageHash:   ImageHash{Kind: 3, Hash: 1234},
+			Size:        100,
+		},
+		{
+			Path:        "bar.jpg",
+			Md5Checksum: []byte("bar-md5"),
+			ImageHash:   ImageHash{Kind: 3, Hash: 1234},
+			Size:        80,
+		},
+		{
+			Path:        "fred.txt",
+			Md5Checksum: []byte("fred-md5"),
+			Size:        10,
+		},
+	})
+
+	stats, err := IndexStats(afero.NewMemMapFs(), index)
+
+	assert.NoError(t, err)
+	assert.Len(t, stats.Strategies, 2)
+	assert.Equal(t, StrategyMd5, stats.Strategies[0].Strategy)
+	assert.Equal(t, 0, stats.Strategies[0].Duplicates)
+	assert.Equal(t, StrategyImageHash, stats.Strategies[1].Strategy)
+	assert.Equal(t, 1, stats.Strategies[1].Duplicates)
+	// bar sorts before foo on the same level, so foo is reclaimable
+	assert.Equal(t, int64(100), stats.Strategies[1].Reclaimable)
+}
+
+func Test_Index_Stats_Audio(t *testing.T) {
+	index := newIndex([]IndexedFile{
+		{Path: "music/track.mp3", AudioChecksum: []byte("track-md5"), Size: 100},
+		{Path: "music/copy/track.mp3", AudioChecksum: []byte("track-md5"), Size: 110},
+		{Path: "music/track.wav", AudioPrint: []uint32{1, 2, 3}, Size: 1000},
+		{Path: "music/track.flac", AudioPrint: []uint32{1, 2, 3}, Size: 500},
+	})
+
+	stats, err := IndexStats(afero.NewMemMapFs(), index)
+
+	assert.NoError(t, err)
+	assert.Len(t, stats.Strategies, 2)
+	assert.Equal(t, StrategyAudioHash, stats.Strategies[0].Strategy)
+	assert.Equal(t, int64(110), stats.Strategies[0].Reclaimable)
+	assert.Equal(t, StrategyAudioPrint, stats.Strategies[1].Strategy)
+	// the names only differ by extension, so the first one is kept
+	assert.Equal(t, int64(500), stats.Strategies[1].Reclaimable)
+}
+
+func Test_Index_Stats_Linked_And_Empty(t *testing.T) {
+	empty := md5.Sum(nil)
+	index := newIndex([]IndexedFile{
+		{Path: "foo.txt", Md5Checksum: []byte("foo-md5"), Size: 10, Dev: 1, Ino: 2},
+		{Path: "foo-link.txt", Md5Checksum: []byte("foo-md5"), Size: 10, Dev: 1, Ino: 2},
+		{Path: "bar.txt", Md5Checksum: empty[:]},
+		{Path: "fred.txt", Md5Checksum: empty[:]},
+	})
+
+	stats, err := IndexStats(afero.NewMemMapFs(), index)
+
+	assert.NoError(t, err)
+	md5 := stats.Strategies[0]
+	assert.Empty(t, md5.Groups)
+	assert.Equal(t, 0, md5.Duplicates)
+	// both paths of the linked file
+	assert.Equal(t, 2, md5.Linked)
+	assert.Equal(t, 2, md5.Empty)
+}
+
+func Test_Index_Stats_Archives(t *testing.T) {
+	index := newIndex([]IndexedFile{
+		{Path: "photos/a.jpg", Md5Checksum: []byte("a-md5"), Size: 10},
+		{Path: "photos/copy/a.jpg", Md5Checksum: []byte("a-md5"), Size: 10},
+		{Path: "photos/backup.zip!/a.jpg", Md5Checksum: []byte("a-md5"), Size: 10, Archive: "photos/backup.zip"},
+	})
+
+	stats, err := IndexStats(afero.NewMemMapFs(), index)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stats.Files)
+	// the size of members is counted in the size of their archive
+	assert.Equal(t, int64(20), stats.Bytes)
+	md5 := stats.Strategies[0]
+	// archive members are never removed, so are counted apart
+	assert.Equal(t, 1, md5.Duplicates)
+	assert.Equal(t, 1, md5.InArchives)
+	assert.Equal(t, int64(10), md5.Reclaimable)
+	assert.Equal(t, []Breakdown{{Key: "photos/copy", Files: 1, Bytes: 10}}, md5.ByDirectory)
+}
@@ -0,0 +1,94 @@
+package deduper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+
+	"github.com/nfnt/resize"
+	"github.com/spf13/afero"
+)
+
+var (
+	// ErrNotIndexed is returned for files that are not in the loaded index.
+	ErrNotIndexed = errors.New("file is not in the index")
+	// ErrNotImage is returned for thumbnails of files that are not jpeg, png or gif images.
+	ErrNotImage = errors.New("file is not an image")
+)
+
+// Thumbnail returns a jpeg of the indexed image at path, as displayed with its EXIF orientation, scaled down to at
+// most size pixels wide and high. The thumbnail cached while indexing is used if it is large enough, otherwise
+// the image is read. Only files in the loaded index are read, including archive members.
+func (d deduperImp) Thumbnail(path string, size int) ([]byte, error) {
+	f, found := d.index.get(path)
+	if !found {
+		return nil, fmt.Errorf("error creating thumbnail of %v: %w\n", path, ErrNotIndexed)
+	}
+	if cached, ok := d.cachedThumbnail(f, size); ok {
+		return cached, nil
+	}
+
+	data, err := afero.ReadFile(newArchiveFs(d.fs), f.Path)
+	if nil != err {
+		return nil, fmt.Errorf("error creating thumbnail of %v: %w\n", path, err)
+	}
+
+	return thumbnail(data, size)
+}
+
+// cachedThumbnail returns the cached thumbnail of the file scaled down to size, unless it is not cached, or it is
+// smaller than size while the image is not.
+func (d deduperImp) cachedThumbnail(f IndexedFile, size int) ([]byte, bool) {
+	if "" == f.Thumbnail {
+		return nil, false
+	}
+	data, err := d.thumbnails.Get(f.Thumbnail)
+	if nil != err {
+		d.logger.Debug("Thumbnail not cached", "path", f.Path, "error", err)
+		return nil, false
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if nil != err {
+		return nil, false
+	}
+
+	cachedSize := max(config.Width, config.Height)
+	if cachedSize < size && (nil == f.Image || cachedSize < max(f.Image.Width, f.Image.Height)) {
+		return nil, false
+	}
+	if cachedSize <= size {
+		return data, true
+	}
+	// the cached thumbnail is oriented already
+	scaled, err := thumbnail(data, size)
+
+	return scaled, nil == err
+}
+
+func thumbnail(data []byte, size int) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if nil != err {
+		return nil, fmt.Errorf("error decoding image: %w\n", errors.Join(ErrNotImage, err))
+	}
+	orientation := 1
+	if exif, err := readExif(data); nil == err {
+		orientation = exif.Orientation
+	}
+
+	return encodeThumbnail(img, size, orientation)
+}
+
+// encodeThumbnail scales the image down to at most size by size pixels, applies the EXIF orientation and encodes it
+// as jpeg.
+func encodeThumbnail(img image.Image, size int, orientation int) ([]byte, error) {
+	// scale before rotating, which is much cheaper on the smaller image
+	scaled := resize.Thumbnail(uint(size), uint(size), img, resize.Bilinear)
+	out := &bytes.Buffer{}
+	if err := jpeg.Encode(out, transformImage(scaled, orientation), &jpeg.Options{Quality: 80}); nil != err {
+		return nil, fmt.Errorf("error encoding thumbnail: %w\n", err)
+	}
+
+	return out.Bytes(), nil
+}
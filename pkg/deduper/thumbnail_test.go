@@ -0,0 +1,76 @@
+package deduper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func thumbnailFixture(t *testing.T) Deduper {
+	fs := afero.NewMemMapFs()
+	jpg := encodeJpeg(t, testImage(1))
+	writeFiles(t, fs, map[string][]byte{
+		"photos/photo.jpg":   jpg,
+		"photos/rotated.jpg": withExif(jpg, exifSegment(binary.BigEndian, 6)),
+		"photos/photo.png":   encodePng(t, testImage(2)),
+		"photos/notes.txt":   []byte("notes"),
+		"other/photo.jpg":    jpg,
+	})
+
+	d := New(fs, "index")
+	assert.NoError(t, d.Create("photos"))
+
+	return d
+}
+
+func thumbnailSize(t *testing.T, data []byte) image.Point {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+
+	return img.Bounds().Size()
+}
+
+func Test_Thumbnail(t *testing.T) {
+	d := thumbnailFixture(t)
+
+	thumbnail, err := d.Thumbnail("photos/photo.jpg", 16)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(16, 12), thumbnailSize(t, thumbnail))
+
+	thumbnail, err = d.Thumbnail("photos/photo.png", 16)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(16, 12), thumbnailSize(t, thumbnail))
+}
+
+func Test_Thumbnail_Not_Scaled_Up(t *testing.T) {
+	d := thumbnailFixture(t)
+
+	thumbnail, err := d.Thumbnail("photos/photo.jpg", 256)
+
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(32, 24), thumbnailSize(t, thumbnail))
+}
+
+func Test_Thumbnail_Oriented(t *testing.T) {
+	d := thumbnailFixture(t)
+
+	thumbnail, err := d.Thumbnail("photos/rotated.jpg", 16)
+
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(12, 16), thumbnailSize(t, thumbnail))
+}
+
+func Test_Thumbnail_Errors(t *testing.T) {
+	d := thumbnailFixture(t)
+
+	_, err := d.Thumbnail("other/photo.jpg", 16)
+	assert.ErrorIs(t, err, ErrNotIndexed)
+
+	_, err = d.Thumbnail("photos/notes.txt", 16)
+	assert.ErrorIs(t, err, ErrNotImage)
+}
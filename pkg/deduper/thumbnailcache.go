@@ -0,0 +1,116 @@
+package deduper
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// THUMBNAIL_DIR is the directory next to the index file that thumbnails are cached in.
+const THUMBNAIL_DIR = ".duplicate-thumbnails"
+
+// DefaultThumbnailSize is the width and height, in pixels, that thumbnails are generated with by default.
+const DefaultThumbnailSize = 256
+
+// ThumbnailCache stores jpeg thumbnails of images by the md5 checksum of the image file, so that copies of an image
+// share a thumbnail. The key of the thumbnail of an indexed image is its IndexedFile.Thumbnail.
+type ThumbnailCache struct {
+	fs  afero.Fs
+	dir string
+}
+
+// NewThumbnailCache returns the cache of the index at indexPath, in the THUMBNAIL_DIR directory next to the index file.
+func NewThumbnailCache(fs afero.Fs, indexPath string) *ThumbnailCache {
+	dir := indexPath
+	if isDir, err := afero.IsDir(fs, indexPath); nil == err && !isDir {
+		// the index file itself
+		dir = filepath.Dir(indexPath)
+	}
+
+	return &ThumbnailCache{fs, filepath.Join(dir, THUMBNAIL_DIR)}
+}
+
+// path spreads the thumbnails over directories named after the first 2 characters of their key, to keep
+// directories small.
+func (c ThumbnailCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".jpg")
+}
+
+// Get returns the thumbnail with the key.
+func (c ThumbnailCache) Get(key string) ([]byte, error) {
+	if len(key) < 2 || strings.ContainsAny(key, `/\.`) {
+		return nil, fmt.Errorf("invalid thumbnail key %q", key)
+	}
+
+	return afero.ReadFile(c.fs, c.path(key))
+}
+
+func (c ThumbnailCache) has(key string) bool {
+	exists, _ := afero.Exists(c.fs, c.path(key))
+
+	return exists
+}
+
+// put stores the thumbnail, writing it to a temporary file first so that a thumbnail is never read half written.
+func (c ThumbnailCache) put(key string, data []byte) error {
+	path := c.path(key)
+	if err := c.fs.MkdirAll(filepath.Dir(path), os.ModePerm); nil != err {
+		return fmt.Errorf("error creating thumbnail directory: %w\n", err)
+	}
+	// a temporary file of its own, as workers may cache copies of an image at the same time
+	tmp, err := afero.TempFile(c.fs, filepath.Dir(path), key+".*.tmp")
+	if nil != err {
+		return fmt.Errorf("error writing thumbnail %v: %w\n", path, err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); nil == err {
+		err = closeErr
+	}
+	if nil == err {
+		err = c.fs.Chmod(tmp.Name(), 0644)
+	}
+	if nil == err {
+		err = c.fs.Rename(tmp.Name(), path)
+	}
+	if nil != err {
+		c.fs.Remove(tmp.Name())
+		return fmt.Errorf("error writing thumbnail %v: %w\n", path, err)
+	}
+
+	return nil
+}
+
+// isThumbnailPath is true when path is in a thumbnail cache, which is never indexed.
+func isThumbnailPath(path string) bool {
+	return slices.Contains(strings.Split(filepath.ToSlash(path), "/"), THUMBNAIL_DIR)
+}
+
+// thumbnailWriter caches thumbnails of the images decoded while hashing them.
+type thumbnailWriter struct {
+	cache *ThumbnailCache
+	size  int
+}
+
+// write caches the thumbnail of the decoded image, as displayed with the EXIF orientation, unless a copy of
+// the image file was cached already. Returns the key of the thumbnail.
+func (w thumbnailWriter) write(img image.Image, data []byte, orientation int) (string, error) {
+	sum := md5.Sum(data)
+	key := hex.EncodeToString(sum[:])
+	if w.cache.has(key) {
+		return key, nil
+	}
+
+	thumbnail, err := encodeThumbnail(img, w.size, orientation)
+	if nil != err {
+		return "", err
+	}
+
+	return key, w.cache.put(key, thumbnail)
+}
@@ -0,0 +1,157 @@
+package deduper
+
+import (
+	"encoding/binary"
+	"image"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// thumbnailCacheFixture indexes photos with the image hash, caching thumbnails of at most 16 pixels.
+func thumbnailCacheFixture(t *testing.T, indexPath string) (afero.Fs, *deduperImp) {
+	fs := afero.NewMemMapFs()
+	jpg := encodeJpeg(t, testImage(1))
+	writeFiles(t, fs, map[string][]byte{
+		"photos/photo.jpg":      jpg,
+		"photos/copy/photo.jpg": jpg,
+		"photos/rotated.jpg":    withExif(jpg, exifSegment(binary.BigEndian, 6)),
+	})
+
+	d := New(fs, indexPath, WithHashers(StrategyImageHash), WithThumbnails(16)).(*deduperImp)
+	assert.NoError(t, d.Create("photos"))
+
+	return fs, d
+}
+
+func Test_Index_Caches_Thumbnails(t *testing.T) {
+	fs, d := thumbnailCacheFixture(t, "index")
+
+	photo, _ := d.index.get("photos/photo.jpg")
+	photoCopy, _ := d.index.get("photos/copy/photo.jpg")
+	rotatedPhoto, _ := d.index.get("photos/rotated.jpg")
+	assert.Len(t, photo.Thumbnail, 32)
+	// copies share the thumbnail
+	assert.Equal(t, photo.Thumbnail, photoCopy.Thumbnail)
+	assert.NotEqual(t, photo.Thumbnail, rotatedPhoto.Thumbnail)
+
+	cached, err := afero.ReadFile(fs, filepath.Join("index", THUMBNAIL_DIR, photo.Thumbnail[:2], photo.Thumbnail+".jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(16, 12), thumbnailSize(t, cached))
+	cached, err = NewThumbnailCache(fs, "index").Get(rotatedPhoto.Thumbnail)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(12, 16), thumbnailSize(t, cached))
+}
+
+func Test_Index_Without_Thumbnails(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs, "photos/photo.jpg", encodeJpeg(t, testImage(1)), 0644))
+	d := New(fs, "index", WithHashers(StrategyImageHash)).(*deduperImp)
+
+	assert.NoError(t, d.Create("photos"))
+
+	photo, _ := d.index.get("photos/photo.jpg")
+	assert.Empty(t, photo.Thumbnail)
+	exists, _ := afero.DirExists(fs, filepath.Join("index", THUMBNAIL_DIR))
+	assert.False(t, exists)
+}
+
+func Test_Index_Skips_Thumbnail_Cache(t *testing.T) {
+	// index, and its thumbnails, in the indexed directory
+	_, d := thumbnailCacheFixture(t, "photos")
+
+	assert.NoError(t, d.Create("photos"))
+
+	assert.ElementsMatch(t, []string{"photos/photo.jpg", "photos/copy/photo.jpg", "photos/rotated.jpg"}, indexedPaths(d))
+}
+
+func Test_Thumbnail_From_Cache(t *testing.T) {
+	fs, d := thumbnailCacheFixture(t, "index")
+	// the original is not read again
+	assert.NoError(t, fs.Remove("photos/photo.jpg"))
+
+	thumbnail, err := d.Thumbnail("photos/photo.jpg", 16)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(16, 12), thumbnailSize(t, thumbnail))
+
+	thumbnail, err = d.Thumbnail("photos/photo.jpg", 8)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(8, 6), thumbnailSize(t, thumbnail))
+
+	// larger than cached, so the original is read
+	_, err = d.Thumbnail("photos/photo.jpg", 32)
+	assert.Error(t, err)
+	thumbnail, err = d.Thumbnail("photos/copy/photo.jpg", 32)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(32, 24), thumbnailSize(t, thumbnail))
+}
+
+func Test_Thumbnail_Cache_Missing(t *testing.T) {
+	fs, d := thumbnailCacheFixture(t, "index")
+	assert.NoError(t, fs.RemoveAll(filepath.Join("index", THUMBNAIL_DIR)))
+
+	thumbnail, err := d.Thumbnail("photos/photo.jpg", 16)
+
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(16, 12), thumbnailSize(t, thumbnail))
+}
+
+func Test_Thumbnail_Cache_Location(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs, "indexes/photos.json", []byte("[]"), 0644))
+
+	assert.Equal(t, filepath.Join("indexes", THUMBNAIL_DIR), NewThumbnailCache(fs, "indexes/photos.json").dir)
+	assert.Equal(t, filepath.Join("indexes", THUMBNAIL_DIR), NewThumbnailCache(fs, "indexes").dir)
+	assert.Equal(t, filepath.Join("new", THUMBNAIL_DIR), NewThumbnailCache(fs, "new").dir)
+}
+
+func Test_Thumbnail_Cache_Invalid_Key(t *testing.T) {
+	cache := NewThumbnailCache(afero.NewMemMapFs(), "index")
+
+	for _, key := range []string{"", "a", "../../etc/passwd", "ab/cd"} {
+		_, err := cache.Get(key)
+		assert.Error(t, err, key)
+	}
+}
+
+func Test_Is_Thumbnail_Path(t *testing.T) {
+	assert.True(t, isThumbnailPath(filepath.Join("photos", THUMBNAIL_DIR, "ab", "abcd.jpg")))
+	assert.True(t, isThumbnailPath(filepath.Join("photos", THUMBNAIL_DIR)))
+	assert.False(t, isThumbnailPath(filepath.Join("photos", THUMBNAIL_DIR+"-old", "a.jpg")))
+}
+
+// interleavingFs runs before once, just before the first rename, as if another worker renamed its file first.
+type interleavingFs struct {
+	afero.Fs
+	before *func()
+}
+
+func (f interleavingFs) Rename(oldname string, newname string) error {
+	if before := *f.before; nil != before {
+		*f.before = nil
+		before()
+	}
+	return f.Fs.Rename(oldname, newname)
+}
+
+func Test_Thumbnail_Cache_Concurrent_Put(t *testing.T) {
+	var before func()
+	fs := interleavingFs{afero.NewMemMapFs(), &before}
+	cache := NewThumbnailCache(fs, "index")
+	key := "abcdef"
+	before = func() {
+		assert.NoError(t, cache.put(key, []byte("other")))
+	}
+
+	assert.NoError(t, cache.put(key, []byte("thumbnail")))
+
+	cached, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("thumbnail"), cached)
+	// no temporary files are left behind
+	files, err := afero.ReadDir(fs, filepath.Dir(cache.path(key)))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
@@ -0,0 +1,117 @@
+package deduper
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/corona10/goimagehash"
+	"github.com/nfnt/resize"
+)
+
+// transformNames describes an image compared to the keeper it is a duplicate of, by the EXIF orientation that
+// transforms the keeper into the image.
+var transformNames = map[int]string{
+	2: "flipped horizontally",
+	3: "rotated 180°",
+	4: "flipped vertically",
+	5: "transposed",
+	6: "rotated 90° clockwise",
+	7: "transversed",
+	8: "rotated 90° anti-clockwise",
+}
+
+// inverseOrientation is the EXIF orientation that undoes the given one: only the rotations by 90° undo each other,
+// the rest undo themselves.
+func inverseOrientation(orientation int) int {
+	switch orientation {
+	case 6:
+		return 8
+	case 8:
+		return 6
+	default:
+		return orientation
+	}
+}
+
+// transformHashes returns the difference hashes of the image with EXIF orientations 2 to 8 applied, when the hasher
+// hashes transforms, and nil otherwise.
+func (hasher imageHasher) transformHashes(img image.Image) ([]uint64, error) {
+	if !hasher.transforms {
+		return nil, nil
+	}
+
+	// the difference hash scales the image to 9x8 pixels, so transform it at that size, rather than at full size
+	scaled := resize.Resize(9, 8, img, resize.Bilinear)
+	// the orientations from 5 on swap width and height
+	swapped := resize.Resize(8, 9, img, resize.Bilinear)
+	hashes := make([]uint64, 0, 7)
+	for orientation := 2; orientation <= 8; orientation++ {
+		small := scaled
+		if orientation >= 5 {
+			small = swapped
+		}
+		h, err := goimagehash.DifferenceHash(transformImage(small, orientation))
+		if nil != err {
+			return nil, err
+		}
+		hashes = append(hashes, h.GetHash())
+	}
+
+	return hashes, nil
+}
+
+// transformImage returns a copy of the image with the EXIF orientation applied.
+func transformImage(img image.Image, orientation int) image.Image {
+	w, h, at := orientedImage(img, orientation)
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			out.Set(x, y, at(x, y))
+		}
+	}
+
+	return out
+}
+
+// composeOrientations returns the EXIF orientation that transforms an image like applying orientation first, and then
+// orientation then.
+func composeOrientations(first int, then int) int {
+	// an image without symmetry, of which every orientation differs
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	composed := transformImage(transformImage(img, first), then).(*image.RGBA)
+	for orientation := 1; orientation <= 8; orientation++ {
+		transformed := transformImage(img, orientation).(*image.RGBA)
+		if transformed.Rect == composed.Rect && bytes.Equal(transformed.Pix, composed.Pix) {
+			return orientation
+		}
+	}
+
+	return 0
+}
+
+// imageTransform returns the EXIF orientation that transforms image a into image b when b is a rotated or flipped copy
+// of a: 1 when they match as they are, and 0 when they do not match.
+func imageTransform(a IndexedFile, b IndexedFile) int {
+	if a.ImageHash == b.ImageHash {
+		return 1
+	}
+	if a.ImageHash.Kind != b.ImageHash.Kind {
+		return 0
+	}
+	// b is a transformed, or a is b transformed back
+	for i, h := range a.ImageTransforms {
+		if h == b.ImageHash.Hash {
+			return i + 2
+		}
+	}
+	for i, h := range b.ImageTransforms {
+		if h == a.ImageHash.Hash {
+			return inverseOrientation(i + 2)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,180 @@
+package deduper
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/corona10/goimagehash"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// waveImage returns an image without symmetry, of which the image hash differs when it is rotated or flipped.
+func waveImage(seed float64) image.Image {
+	img := image.NewGray(image.Rect(0, 0, 96, 64))
+	for x := 0; x < 96; x++ {
+		for y := 0; y < 64; y++ {
+			v := 128 + 70*math.Sin(float64(x)*0.09*seed+float64(y)*0.05) + 50*math.Cos(float64(y)*0.13-float64(x)*0.02*seed)
+			img.SetGray(x, y, color.Gray{uint8(v)})
+		}
+	}
+
+	return img
+}
+
+func transformFixture(t *testing.T) afero.Fs {
+	fs := afero.NewMemMapFs()
+	photo := encodeJpeg(t, waveImage(1))
+	writeFiles(t, fs, map[string][]byte{
+		"photos/photo.jpg":               photo,
+		"photos/rotated/photo.jpg":       encodeJpeg(t, rotated(t, photo, 6)),
+		"photos/flipped/photo.jpg":       encodeJpeg(t, rotated(t, photo, 2)),
+		"photos/rotated/upside-down.jpg": encodeJpeg(t, rotated(t, photo, 3)),
+		"photos/other.jpg":               encodeJpeg(t, waveImage(2)),
+	})
+
+	return fs
+}
+
+func hashImage(t *testing.T, hasher imageHasher, filePath string) IndexedFile {
+	var hashed IndexedFile
+	hasher.hash(filePath, func(f IndexedFile) {
+		hashed = f
+	}, func(filePath string, err error) {
+		assert.Fail(t, "unexpected error", err)
+	}, func() {})
+
+	return hashed
+}
+
+func Test_Image_Hasher_Transforms(t *testing.T) {
+	fs := transformFixture(t)
+
+	photo := hashImage(t, imageHasher{fs, []ImageHashKind{DefaultImageHashKind}, true, discardLogger(), nil}, "photos/photo.jpg")
+	rotatedPhoto := hashImage(t, imageHasher{fs, []ImageHashKind{DefaultImageHashKind}, true, discardLogger(), nil}, "photos/rotated/photo.jpg")
+
+	assert.Len(t, photo.ImageTransforms, 7)
+	assert.NotEqual(t, photo.ImageHash, rotatedPhoto.ImageHash)
+	assert.Equal(t, 6, imageTransform(photo, rotatedPhoto))
+	assert.Equal(t, 8, imageTransform(rotatedPhoto, photo))
+
+	plain := hashImage(t, imageHasher{fs, []ImageHashKind{DefaultImageHashKind}, false, discardLogger(), nil}, "photos/photo.jpg")
+	assert.Nil(t, plain.ImageTransforms)
+	assert.Equal(t, photo.ImageHash, plain.ImageHash)
+}
+
+func Test_Transform_Hashes_Scale_First(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 2000, 1500))
+	hasher := imageHasher{transforms: true}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	hashes, err := hasher.transformHashes(img)
+
+	runtime.ReadMemStats(&after)
+	assert.NoError(t, err)
+	assert.Len(t, hashes, 7)
+	// less than a single copy of the image at full size
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(2000*1500))
+}
+
+func Test_Image_Transform(t *testing.T) {
+	a := IndexedFile{ImageHash: ImageHash{Kind: 2, Hash: 1}, ImageTransforms: []uint64{2, 3, 4, 5, 6, 7, 8}}
+
+	assert.Equal(t, 1, imageTransform(a, IndexedFile{ImageHash: ImageHash{Kind: 2, Hash: 1}}))
+	assert.Equal(t, 6, imageTransform(a, IndexedFile{ImageHash: ImageHash{Kind: 2, Hash: 6}}))
+	// only b has transforms, so it is matched by undoing them
+	assert.Equal(t, 8, imageTransform(IndexedFile{ImageHash: ImageHash{Kind: 2, Hash: 6}}, a))
+	assert.Equal(t, 3, imageTransform(IndexedFile{ImageHash: ImageHash{Kind: 2, Hash: 3}}, a))
+	assert.Equal(t, 0, imageTransform(a, IndexedFile{ImageHash: ImageHash{Kind: 2, Hash: 9}}))
+	assert.Equal(t, 0, imageTransform(a, IndexedFile{ImageHash: ImageHash{Kind: 1, Hash: 6}}))
+}
+
+func Test_Inverse_Orientation(t *testing.T) {
+	img := testImage(1)
+	for orientation := 1; orientation <= 8; orientation++ {
+		undone := transformImage(transformImage(img, orientation), inverseOrientation(orientation))
+
+		assert.Equal(t, img.Bounds(), undone.Bounds(), orientation)
+		for _, p := range []image.Point{{0, 0}, {3, 7}, {31, 2}, {5, 23}} {
+			assert.Equal(t, color.RGBAModel.Convert(img.At(p.X, p.Y)), undone.At(p.X, p.Y), orientation)
+		}
+	}
+}
+
+func Test_Find_ImageHash_Transforms(t *testing.T) {
+	fs := transformFixture(t)
+	o := defaultOptions()
+	WithHashers(StrategyImageHash)(o)
+	WithImageTransforms(true)(o)
+	index := newIndex([]IndexedFile{})
+
+	err := newIndexer(fs, "photos", index, o).Create("photos")
+	assert.NoError(t, err)
+	groups, err := newCompositeFinder(index, 0, DefaultImageHashKind, StrategyImageHash).Find()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, "photos/photo.jpg", groups[0].Keeper)
+	transforms := map[string]string{}
+	for _, m := range groups[0].Members {
+		transforms[m.Path] = m.Transform
+	}
+	assert.Equal(t, map[string]string{
+		"photos/photo.jpg":               "",
+		"photos/flipped/photo.jpg":       "flipped horizontally",
+		"photos/rotated/photo.jpg":       "rotated 90° clockwise",
+		"photos/rotated/upside-down.jpg": "rotated 180°",
+	}, transforms)
+}
+
+func Test_Compose_Orientations(t *testing.T) {
+	assert.Equal(t, 3, composeOrientations(6, 6))
+	assert.Equal(t, 1, composeOrientations(2, 2))
+	assert.Equal(t, 6, composeOrientations(1, 6))
+	for orientation := 1; orientation <= 8; orientation++ {
+		assert.Equal(t, 1, composeOrientations(orientation, inverseOrientation(orientation)), orientation)
+	}
+}
+
+func Test_Find_ImageHash_Transforms_Of_Keeper(t *testing.T) {
+	// the copies only match the photo, which is not kept as the rotated copy is larger
+	index := newIndex([]IndexedFile{
+		{Path: "photo.jpg", ImageHash: ImageHash{Kind: int(goimagehash.DHash), Hash: 1}, ImageTransforms: []uint64{2, 3, 4, 5, 6, 7, 8}, Image: &ImageInfo{Width: 150, Height: 100}},
+		{Path: "rotated.jpg", ImageHash: ImageHash{Kind: int(goimagehash.DHash), Hash: 6}, Image: &ImageInfo{Width: 200, Height: 300}},
+		{Path: "flipped.jpg", ImageHash: ImageHash{Kind: int(goimagehash.DHash), Hash: 2}, Image: &ImageInfo{Width: 150, Height: 100}},
+	})
+
+	groups, err := newCompositeFinder(index, 0, DefaultImageHashKind, StrategyImageHash).Find()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, "rotated.jpg", groups[0].Keeper)
+	transforms := map[string]string{}
+	for _, m := range groups[0].Members {
+		transforms[m.Path] = m.Transform
+	}
+	// undoing the rotation of the keeper, and then flipping it
+	assert.Equal(t, map[string]string{
+		"rotated.jpg": "",
+		"photo.jpg":   "rotated 90° anti-clockwise",
+		"flipped.jpg": "transversed",
+	}, transforms)
+}
+
+func Test_Find_ImageHash_Without_Transforms(t *testing.T) {
+	fs := transformFixture(t)
+	o := defaultOptions()
+	WithHashers(StrategyImageHash)(o)
+	index := newIndex([]IndexedFile{})
+
+	err := newIndexer(fs, "photos", index, o).Create("photos")
+	assert.NoError(t, err)
+	groups, err := newCompositeFinder(index, 0, DefaultImageHashKind, StrategyImageHash).Find()
+
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
@@ -0,0 +1,197 @@
+package deduper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log/slog"
+	"math/bits"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/corona10/goimagehash"
+	"github.com/spf13/afero"
+)
+
+// number of frames hashed for each video, evenly spread over its duration
+const videoFrames = 8
+
+// defaultVideoTolerance is the average number of bits that the frame hashes of 2 videos may differ by,
+// for them to be duplicates.
+const defaultVideoTolerance = 8
+
+var videoExtensions = []string{".mp4", ".m4v", ".mov", ".mkv", ".avi", ".webm"}
+
+// errNoFrames is returned by a frameExtractor when the file is not a video it can decode.
+var errNoFrames = errors.New("cannot extract frames")
+
+func isVideo(filePath string) bool {
+	ext := filepath.Ext(filePath)
+	for _, e := range videoExtensions {
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// frameExtractor extracts count frames from a video, evenly spread over its duration.
+type frameExtractor interface {
+	frames(fs afero.Fs, filePath string, count int) ([]image.Image, error)
+}
+
+type videoHasher struct {
+	fs        afero.Fs
+	extractor frameExtractor
+	logger    *slog.Logger
+}
+
+func (hasher videoHasher) hash(filePath string, fun func(f IndexedFile), errorFunc func(filePath string, err error), completeFun func()) {
+	if !isVideo(filePath) {
+		completeFun()
+		return
+	}
+
+	frames, err := hasher.extractor.frames(hasher.fs, filePath, videoFrames)
+	if errors.Is(err, errNoFrames) {
+		hasher.logger.Debug("Skipping video, cannot extract frames", "path", filePath, "error", err)
+	} else if nil != err {
+		errorFunc(filePath, err)
+	} else {
+		hashes := make([]uint64, 0, len(frames))
+		for _, frame := range frames {
+			h, err := goimagehash.DifferenceHash(frame)
+			if nil != err {
+				errorFunc(filePath, err)
+				completeFun()
+				return
+			}
+			hashes = append(hashes, h.GetHash())
+		}
+		var size int64
+		if info, err := hasher.fs.Stat(filePath); nil == err {
+			size = info.Size()
+		}
+		fun(IndexedFile{
+			Path:      filePath,
+			VideoHash: hashes,
+			Size:      size,
+		})
+	}
+
+	completeFun()
+}
+
+// ffmpegExtractor extracts frames using ffprobe and ffmpeg, which must be on the path.
+// Only files on the local file system are supported.
+type ffmpegExtractor struct{}
+
+func (ffmpegExtractor) frames(fs afero.Fs, filePath string, count int) ([]image.Image, error) {
+	// ffmpeg reads the files itself, which are on the file system the archives are on, unless they are members
+	if archives, isArchive := fs.(archiveFs); isArchive {
+		if _, _, isMember := archives.member(filePath); isMember {
+			return nil, fmt.Errorf("%w: %v is in an archive", errNoFrames, filePath)
+		}
+		fs = archives.Fs
+	}
+	if _, isOs := fs.(*afero.OsFs); !isOs {
+		return nil, fmt.Errorf("%w: %v is not on the local file system", errNoFrames, filePath)
+	}
+
+	// the file protocol, so that a file name starting with a dash or a protocol name is never taken for either
+	input := "file:" + filePath
+	out, err := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "csv=p=0", input).Output()
+	if _, isExit := err.(*exec.ExitError); isExit {
+		return nil, fmt.Errorf("%w: %v", errNoFrames, err)
+	} else if nil != err {
+		return nil, fmt.Errorf("error running ffprobe: %w\n", err)
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if nil != err {
+		return nil, fmt.Errorf("%w: unknown duration: %v", errNoFrames, err)
+	}
+
+	frames := make([]image.Image, 0, count)
+	for i := 0; i < count; i++ {
+		at := duration * (float64(i) + 0.5) / float64(count)
+		// small frames are enough for hashing, and quicker to transfer
+		out, err := exec.Command("ffmpeg", "-v", "error", "-ss", strconv.FormatFloat(at, 'f', 3, 64), "-i", input,
+			"-frames:v", "1", "-vf", "scale=160:-2", "-f", "image2pipe", "-vcodec", "png", "-").Output()
+		if _, isExit := err.(*exec.ExitError); isExit {
+			return nil, fmt.Errorf("%w: %v", errNoFrames, err)
+		} else if nil != err {
+			return nil, fmt.Errorf("error running ffmpeg: %w\n", err)
+		}
+		frame, err := png.Decode(bytes.NewReader(out))
+		if nil != err {
+			return nil, fmt.Errorf("%w: %v", errNoFrames, err)
+		}
+		frames = append(frames, frame)
+	}
+
+	return frames, nil
+}
+
+// videoDistance is the average number of bits the frame hashes differ by, comparing frames at the same position.
+func videoDistance(a []uint64, b []uint64) int {
+	n := min(len(a), len(b))
+	if 0 == n {
+		return 64
+	}
+
+	total := 0
+	for i := 0; i < n; i++ {
+		total += bits.OnesCount64(a[i] ^ b[i])
+	}
+
+	return total / n
+}
+
+// videoHashFinder finds videos of which the frame hashes differ by at most tolerance bits on average,
+// e.g. the same video in a different container or bitrate.
+type videoHashFinder struct {
+	index     *Index
+	tolerance int
+}
+
+func (finder videoHashFinder) Find() ([]DuplicateGroup, error) {
+	hashed := []IndexedFile{}
+	for _, v := range finder.index.Files() {
+		if 0 != len(v.VideoHash) {
+			hashed = append(hashed, v)
+		}
+	}
+
+	grouped := make(map[string]bool)
+	all := linkedGroups(StrategyVideoHash, hashed)
+	for i, v := range hashed {
+		if grouped[v.Path] {
+			continue
+		}
+		members := []GroupMember{{IndexedFile: v}}
+		for _, vv := range hashed[i+1:] {
+			if !grouped[vv.Path] && videoDistance(v.VideoHash, vv.VideoHash) <= finder.tolerance {
+				members = append(members, GroupMember{IndexedFile: vv})
+				grouped[vv.Path] = true
+			}
+		}
+
+		// hard links to the same file are a single copy
+		if members = collapseLinks(members); len(members) > 1 {
+			group := newDuplicateGroup(StrategyVideoHash, membersKey(members), members)
+			keeper := group.Members[0].VideoHash
+			for j := range group.Members {
+				group.Members[j].Distance = videoDistance(keeper, group.Members[j].VideoHash)
+			}
+			all = append(all, group)
+		}
+	}
+	sortGroups(all)
+
+	return all, nil
+}
@@ -0,0 +1,238 @@
+package deduper
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeExtractor struct {
+	videos map[string][]image.Image
+	err    error
+}
+
+func (e fakeExtractor) frames(fs afero.Fs, filePath string, count int) ([]image.Image, error) {
+	if nil != e.err {
+		return nil, e.err
+	}
+	frames, found := e.videos[filePath]
+	if !found {
+		return nil, errNoFrames
+	}
+
+	return frames, nil
+}
+
+// testVideo returns frames with a pattern depending on the seed, and brightness added to all pixels.
+func testVideo(seed int, brightness int) []image.Image {
+	frames := []image.Image{}
+	for f := 0; f < videoFrames; f++ {
+		img := image.NewGray(image.Rect(0, 0, 64, 64))
+		for x := 0; x < 64; x++ {
+			for y := 0; y < 64; y++ {
+				v := (x*(seed+f) + y*(seed+3)) % 200
+				img.SetGray(x, y, color.Gray{uint8(v + brightness)})
+			}
+		}
+		frames = append(frames, img)
+	}
+
+	return frames
+}
+
+func Test_Is_Video(t *testing.T) {
+	assert.True(t, isVideo("holiday.MP4"))
+	assert.True(t, isVideo("holiday.mkv"))
+	assert.False(t, isVideo("holiday.jpg"))
+}
+
+func Test_Video_Hasher_Ok(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "holiday.mp4", []byte("video"), 0644)
+	hasher := videoHasher{fs, fakeExtractor{videos: map[string][]image.Image{"holiday.mp4": testVideo(1, 0)}}, discardLogger()}
+
+	var hashed IndexedFile
+	completed := false
+	hasher.hash("holiday.mp4", func(f IndexedFile) {
+		hashed = f
+	}, func(filePath string, err error) {
+		assert.Fail(t, "unexpected error", err)
+	}, func() {
+		completed = true
+	})
+
+	assert.True(t, completed)
+	assert.Equal(t, "holiday.mp4", hashed.Path)
+	assert.Len(t, hashed.VideoHash, videoFrames)
+	assert.Equal(t, int64(5), hashed.Size)
+}
+
+func Test_Video_Hasher_Skips(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	hasher := videoHasher{fs, fakeExtractor{}, discardLogger()}
+
+	for _, filePath := range []string{"holiday.jpg", "broken.mp4"} {
+		completed := false
+		hasher.hash(filePath, func(f IndexedFile) {
+			assert.Fail(t, "unexpected hash", filePath)
+		}, func(filePath string, err error) {
+			assert.Fail(t, "unexpected error", err)
+		}, func() {
+			completed = true
+		})
+
+		assert.True(t, completed)
+	}
+}
+
+func Test_Video_Hasher_Error(t *testing.T) {
+	raisedError := errors.New("ffmpeg not found")
+	hasher := videoHasher{afero.NewMemMapFs(), fakeExtractor{err: raisedError}, discardLogger()}
+
+	var reported error
+	hasher.hash("holiday.mp4", func(f IndexedFile) {}, func(filePath string, err error) {
+		reported = err
+	}, func() {})
+
+	assert.Equal(t, raisedError, reported)
+}
+
+func Test_Ffmpeg_Extractor_Not_Local(t *testing.T) {
+	_, err := ffmpegExtractor{}.frames(afero.NewMemMapFs(), "holiday.mp4", videoFrames)
+
+	assert.ErrorIs(t, err, errNoFrames)
+}
+
+// fakeFfmpeg puts ffprobe and ffmpeg commands on the path that record their input in the returned file, and
+// return a video of 10 seconds of the same frame.
+func fakeFfmpeg(t *testing.T) string {
+	if _, err := exec.LookPath("sh"); nil != err || "windows" == runtime.GOOS {
+		t.Skip("sh is not installed")
+	}
+	bin := t.TempDir()
+	args := filepath.Join(bin, "args")
+	frame := filepath.Join(bin, "frame.png")
+	assert.NoError(t, os.WriteFile(frame, encodePng(t, image.NewGray(image.Rect(0, 0, 16, 16))), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(bin, "ffprobe"), []byte("#!/bin/sh\necho \"$7\" >> '"+args+"'\necho 10.0\n"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte("#!/bin/sh\necho \"$6\" >> '"+args+"'\ncat '"+frame+"'\n"), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return args
+}
+
+func Test_Ffmpeg_Extractor_Input(t *testing.T) {
+	args := fakeFfmpeg(t)
+
+	frames, err := ffmpegExtractor{}.frames(afero.NewOsFs(), "-i holiday.mp4", 1)
+
+	assert.NoError(t, err)
+	assert.Len(t, frames, 1)
+	recorded, err := os.ReadFile(args)
+	assert.NoError(t, err)
+	assert.Equal(t, "file:-i holiday.mp4\nfile:-i holiday.mp4\n", string(recorded))
+}
+
+func Test_Create_Video_Hash_With_Archives(t *testing.T) {
+	args := fakeFfmpeg(t)
+	dir := t.TempDir()
+	fs := afero.NewOsFs()
+	assert.NoError(t, afero.WriteFile(fs, filepath.Join(dir, "holiday.mp4"), []byte("video"), 0644))
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	w, err := zw.Create("clip.mp4")
+	assert.NoError(t, err)
+	w.Write([]byte("video"))
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, afero.WriteFile(fs, filepath.Join(dir, "backup.zip"), buf.Bytes(), 0644))
+	d := New(fs, t.TempDir(), WithHashers(StrategyVideoHash), WithArchives(true)).(*deduperImp)
+
+	assert.NoError(t, d.Create(dir))
+
+	// files outside archives are hashed, members are not passed to ffmpeg
+	video, _ := d.index.get(filepath.Join(dir, "holiday.mp4"))
+	assert.Len(t, video.VideoHash, videoFrames)
+	recorded, err := os.ReadFile(args)
+	assert.NoError(t, err)
+	assert.Contains(t, string(recorded), "holiday.mp4")
+	assert.NotContains(t, string(recorded), "clip.mp4")
+}
+
+func Test_Video_Distance(t *testing.T) {
+	assert.Equal(t, 0, videoDistance([]uint64{1, 2}, []uint64{1, 2}))
+	assert.Equal(t, 1, videoDistance([]uint64{0, 0}, []uint64{1, 3}))
+	// only compares the frames both have
+	assert.Equal(t, 0, videoDistance([]uint64{1, 2}, []uint64{1}))
+	assert.Equal(t, 64, videoDistance([]uint64{}, []uint64{1}))
+}
+
+func Test_Find_VideoHash(t *testing.T) {
+	index := newIndex([]IndexedFile{
+		{Path: "holiday.mp4", VideoHash: []uint64{0x0f, 0xff}, Size: 100},
+		{Path: "copy/holiday.mkv", VideoHash: []uint64{0x0f, 0xf0}, Size: 50},
+		{Path: "other.mp4", VideoHash: []uint64{0xf000, 0xff00}},
+		{Path: "photo.jpg", Md5Checksum: []byte("photo-md5")},
+	})
+
+	groups, err := newCompositeFinder(index, 2, DefaultImageHashKind, StrategyVideoHash).Find()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, StrategyVideoHash, groups[0].Strategy)
+	assert.Equal(t, []string{"holiday.mp4", "copy/holiday.mkv"}, groups[0].Paths())
+	assert.Equal(t, membersKey(groups[0].Members), groups[0].Key)
+	assert.Equal(t, 0, groups[0].Members[0].Distance)
+	assert.Equal(t, 2, groups[0].Members[1].Distance)
+	assert.Equal(t, int64(50), groups[0].Reclaimable)
+
+	groups, err = newCompositeFinder(index, 1, DefaultImageHashKind, StrategyVideoHash).Find()
+
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func Test_Find_VideoHash_Unique_Keys(t *testing.T) {
+	// the videos start the same, so the groups have the same first frame
+	index := newIndex([]IndexedFile{
+		{Path: "a.mp4", VideoHash: []uint64{0x0f, 0}},
+		{Path: "copy/a.mp4", VideoHash: []uint64{0x0f, 0}},
+		{Path: "b.mp4", VideoHash: []uint64{0x0f, math.MaxUint64}},
+		{Path: "copy/b.mp4", VideoHash: []uint64{0x0f, math.MaxUint64}},
+	})
+
+	groups, err := newCompositeFinder(index, 2, DefaultImageHashKind, StrategyVideoHash).Find()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 2)
+	assert.NotEqual(t, groups[0].Key, groups[1].Key)
+}
+
+func Test_Find_VideoHash_Reencoded(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	videos := map[string][]image.Image{
+		"holiday.mp4":      testVideo(1, 0),
+		"holiday-low.mov":  testVideo(1, 20),
+		"other-video.webm": testVideo(7, 0),
+	}
+	hasher := videoHasher{fs, fakeExtractor{videos: videos}, discardLogger()}
+	index := newIndex([]IndexedFile{})
+	for filePath := range videos {
+		hasher.hash(filePath, index.updateIndex, func(filePath string, err error) {}, func() {})
+	}
+
+	groups, err := newCompositeFinder(index, defaultVideoTolerance, DefaultImageHashKind, StrategyVideoHash).Find()
+
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, []string{"holiday.mp4", "holiday-low.mov"}, groups[0].Paths())
+}
@@ -0,0 +1,212 @@
+package deduper
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+var errWatchNotSupported = errors.New("watching for changes is only supported on linux")
+
+// WatchEvent describes how the index changed after files changed in the watched directory.
+type WatchEvent struct {
+	// Updated are the files that were hashed again, because they were created or changed.
+	Updated []string `json:",omitempty"`
+	// Removed are the files that are no longer in the index.
+	Removed []string `json:",omitempty"`
+	// Renamed are the new paths of files that were renamed, by their old path. Their hashes are kept.
+	Renamed map[string]string `json:",omitempty"`
+	// Groups are the groups of duplicates that an updated or renamed file is in.
+	Groups []DuplicateGroup `json:",omitempty"`
+}
+
+// IsEmpty is true when the index did not change.
+func (e WatchEvent) IsEmpty() bool {
+	return 0 == len(e.Updated) && 0 == len(e.Removed) && 0 == len(e.Renamed)
+}
+
+// fileChange is a path that was created, changed or removed in a watched directory.
+// From is set when the path was renamed, and is the path it was renamed from.
+type fileChange struct {
+	path string
+	from string
+}
+
+// changeWatcher reports the changes to the files in a directory and all its subdirectories.
+type changeWatcher interface {
+	changes() <-chan fileChange
+	errors() <-chan error
+	close() error
+}
+
+// fileUpdater updates the entries of files that changed since the index was created, without walking
+// the whole directory again.
+type fileUpdater interface {
+	// update hashes the file at path, or all files in it if it is a directory, replacing their entries.
+	// Entries of files that no longer exist are removed. Returns the updated and removed paths.
+	update(path string) ([]string, []string, error)
+	// rename moves the entries of a renamed file or directory. Returns the new paths of the entries.
+	rename(from string, to string) ([]string, error)
+	save() error
+}
+
+func (i indexerImp) update(path string) ([]string, []string, error) {
+	before := i.index.remove(path)
+	if _, err := lstat(i.fs, path); os.IsNotExist(err) {
+		return []string{}, before, nil
+	} else if nil != err {
+		return nil, before, err
+	}
+	if _, _, err := i.hashTree(context.Background(), path); nil != err {
+		return nil, before, err
+	}
+
+	updated := i.index.paths(path)
+	removed := []string{}
+	for _, p := range before {
+		if !slices.Contains(updated, p) {
+			removed = append(removed, p)
+		}
+	}
+
+	return updated, removed, nil
+}
+
+func (i indexerImp) rename(from string, to string) ([]string, error) {
+	if 0 != len(i.filters) {
+		// the new name may not be accepted by the filters
+		i.index.remove(from)
+		updated, _, err := i.update(to)
+		return updated, err
+	}
+
+	return i.index.rename(from, to), nil
+}
+
+// Watch indexes dir, and then keeps the index up to date while files in it change, until stop is closed.
+// Changed files are hashed again, removed files are removed from the index and renamed files keep their hashes.
+// The index is saved after every change, and fun is called with the changes and the duplicates of changed files.
+// Only supported on linux.
+func (d deduperImp) Watch(dir string, stop <-chan struct{}, fun func(WatchEvent)) error {
+	// watch before indexing, so that no change is missed
+	w, err := newChangeWatcher(dir, d.logger)
+	if nil != err {
+		return err
+	}
+	defer w.close()
+
+	if err := d.Create(dir); nil != err {
+		return err
+	}
+	d.logger.Info("Watching for changes", "dir", dir)
+
+	return d.watchChanges(w, stop, fun)
+}
+
+// watchChanges applies changes until stop is closed, or the watcher fails.
+func (d deduperImp) watchChanges(w changeWatcher, stop <-chan struct{}, fun func(WatchEvent)) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err := <-w.errors():
+			return err
+		case change := <-w.changes():
+			changes := []fileChange{change}
+			// changes that happened together are applied together
+			for drained := false; !drained; {
+				select {
+				case c := <-w.changes():
+					changes = append(changes, c)
+				default:
+					drained = true
+				}
+			}
+
+			event, err := d.applyChanges(changes)
+			if nil != err {
+				return err
+			}
+			if !event.IsEmpty() {
+				fun(event)
+			}
+		}
+	}
+}
+
+// applyChanges updates the index for the changes and saves it. Files that cannot be hashed are logged and skipped.
+func (d deduperImp) applyChanges(changes []fileChange) (WatchEvent, error) {
+	event := WatchEvent{Updated: []string{}, Removed: []string{}, Renamed: map[string]string{}}
+	for _, c := range changes {
+		if INDEX_NAME == filepath.Base(c.path) || isThumbnailPath(c.path) {
+			// saving the index, or caching thumbnails while updating it, is not a change
+			continue
+		}
+
+		if "" != c.from {
+			renamed, err := d.updater.rename(c.from, c.path)
+			if nil != err {
+				d.logger.Warn("Failed updating renamed file", "path", c.path, "from", c.from, "error", err)
+				continue
+			}
+			d.logger.Info("Renamed", "path", c.path, "from", c.from, "files", len(renamed))
+			if 0 != len(renamed) {
+				event.Renamed[c.from] = c.path
+			}
+			continue
+		}
+
+		updated, removed, err := d.updater.update(c.path)
+		if nil != err {
+			d.logger.Warn("Failed updating changed file", "path", c.path, "error", err)
+		}
+		d.logger.Info("Updated", "path", c.path, "updated", len(updated), "removed", len(removed))
+		event.Updated = append(event.Updated, updated...)
+		event.Removed = append(event.Removed, removed...)
+	}
+	if event.IsEmpty() {
+		return event, nil
+	}
+	sort.Strings(event.Updated)
+	sort.Strings(event.Removed)
+
+	if err := d.updater.save(); nil != err {
+		return event, err
+	}
+
+	groups, err := d.changedGroups(event)
+	if nil != err {
+		return event, err
+	}
+	event.Groups = groups
+
+	return event, nil
+}
+
+// changedGroups finds the duplicates with all strategies of the deduper, and returns the groups that an updated or
+// renamed file is in.
+func (d deduperImp) changedGroups(event WatchEvent) ([]DuplicateGroup, error) {
+	changed := []string{}
+	changed = append(changed, event.Updated...)
+	for _, to := range event.Renamed {
+		changed = append(changed, to)
+	}
+
+	found, err := d.FindAll()
+	if nil != err {
+		return nil, err
+	}
+	groups := []DuplicateGroup{}
+	for _, g := range FilterGroups(found, CategoryDuplicate) {
+		if slices.ContainsFunc(g.Members, func(m GroupMember) bool {
+			return slices.ContainsFunc(changed, func(path string) bool { return m.inPath(path) })
+		}) {
+			groups = append(groups, g)
+		}
+	}
+
+	return groups, nil
+}
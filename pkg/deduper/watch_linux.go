@@ -0,0 +1,207 @@
+//go:build linux
+
+package deduper
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// inotifyMask selects the events of the files in a watched directory: closed after writing, created, deleted and
+// renamed.
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+var errEventsLost = errors.New("too many changes at once, re-index to catch up")
+
+// inotifyWatcher watches a directory and all its subdirectories using inotify.
+type inotifyWatcher struct {
+	fd int
+	// file reads the events of fd, and can be closed while reading
+	file   *os.File
+	logger *slog.Logger
+	// dirs are the watched directories, by watch descriptor
+	dirs    map[int32]string
+	changed chan fileChange
+	failed  chan error
+	done    chan struct{}
+}
+
+func newChangeWatcher(dir string, logger *slog.Logger) (changeWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if nil != err {
+		return nil, fmt.Errorf("error watching %v: %w\n", dir, err)
+	}
+
+	w := &inotifyWatcher{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		logger:  logger,
+		dirs:    make(map[int32]string),
+		changed: make(chan fileChange),
+		failed:  make(chan error, 1),
+		done:    make(chan struct{}),
+	}
+	if err := w.addTree(dir); nil != err {
+		w.file.Close()
+		return nil, err
+	}
+	go w.read()
+
+	return w, nil
+}
+
+func (w *inotifyWatcher) changes() <-chan fileChange {
+	return w.changed
+}
+
+func (w *inotifyWatcher) errors() <-chan error {
+	return w.failed
+}
+
+func (w *inotifyWatcher) close() error {
+	close(w.done)
+
+	return w.file.Close()
+}
+
+// addTree watches dir and all directories in it.
+func (w *inotifyWatcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if nil != err {
+			if path == dir {
+				return fmt.Errorf("error watching %v: %w\n", dir, err)
+			}
+			// removed while walking
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if nil != err {
+			return fmt.Errorf("error watching %v: %w\n", path, err)
+		}
+		w.dirs[int32(wd)] = path
+
+		return nil
+	})
+}
+
+// removeTree stops watching dir and all directories in it, after it was moved away.
+func (w *inotifyWatcher) removeTree(dir string) {
+	for wd, path := range w.dirs {
+		if isInDir(path, dir) {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+func (w *inotifyWatcher) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if nil != err {
+			if !errors.Is(err, os.ErrClosed) {
+				w.failed <- fmt.Errorf("error reading changes: %w\n", err)
+			}
+			return
+		}
+
+		// a rename is a moved from and a moved to event with the same cookie
+		movedFrom := make(map[uint32]string)
+		cookies := []uint32{}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[offset:]))
+			mask := binary.NativeEndian.Uint32(buf[offset+4:])
+			cookie := binary.NativeEndian.Uint32(buf[offset+8:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			name := strings.TrimRight(string(buf[offset+syscall.SizeofInotifyEvent:offset+syscall.SizeofInotifyEvent+nameLen]), "\x00")
+			offset += syscall.SizeofInotifyEvent + nameLen
+
+			if 0 != mask&syscall.IN_Q_OVERFLOW {
+				w.failed <- errEventsLost
+				return
+			}
+			if 0 != mask&syscall.IN_IGNORED {
+				// the directory was removed
+				delete(w.dirs, wd)
+				continue
+			}
+			dir, found := w.dirs[wd]
+			if !found {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			isDir := 0 != mask&syscall.IN_ISDIR
+
+			switch {
+			case 0 != mask&syscall.IN_MOVED_FROM:
+				if isDir {
+					w.removeTree(path)
+				}
+				movedFrom[cookie] = path
+				cookies = append(cookies, cookie)
+			case 0 != mask&syscall.IN_MOVED_TO:
+				if isDir {
+					w.watchNew(path)
+				}
+				from, renamed := movedFrom[cookie]
+				delete(movedFrom, cookie)
+				if !w.send(fileChange{path: path, from: from}) {
+					return
+				}
+				if renamed {
+					w.logger.Debug("Renamed", "path", path, "from", from)
+				}
+			case 0 != mask&syscall.IN_CREATE:
+				// files are changed once closed after writing, but directories and symlinks are never written
+				if isDir {
+					w.watchNew(path)
+				} else if info, err := os.Lstat(path); nil != err || !isSymlink(info) {
+					continue
+				}
+				if !w.send(fileChange{path: path}) {
+					return
+				}
+			default:
+				if !w.send(fileChange{path: path}) {
+					return
+				}
+			}
+		}
+
+		// moved out of the watched directory
+		for _, cookie := range cookies {
+			if from, found := movedFrom[cookie]; found && !w.send(fileChange{path: from}) {
+				return
+			}
+		}
+	}
+}
+
+// watchNew watches a directory that was created or moved into a watched directory.
+func (w *inotifyWatcher) watchNew(dir string) {
+	if err := w.addTree(dir); nil != err {
+		w.logger.Warn("Failed watching new directory", "path", dir, "error", err)
+	}
+}
+
+// send reports a change, returning false when the watcher was closed.
+func (w *inotifyWatcher) send(change fileChange) bool {
+	select {
+	case w.changed <- change:
+		return true
+	case <-w.done:
+		return false
+	}
+}
@@ -0,0 +1,56 @@
+//go:build linux
+
+package deduper
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func nextChange(t *testing.T, w changeWatcher) fileChange {
+	select {
+	case c := <-w.changes():
+		return c
+	case err := <-w.errors():
+		t.Fatalf("watcher failed: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change reported")
+	}
+
+	return fileChange{}
+}
+
+func Test_Inotify_Watcher(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	w, err := newChangeWatcher(dir, slog.Default())
+	assert.NoError(t, err)
+	defer w.close()
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "a.txt"), []byte("a"), 0644))
+	assert.Equal(t, fileChange{path: filepath.Join(dir, "sub", "a.txt")}, nextChange(t, w))
+
+	assert.NoError(t, os.Rename(filepath.Join(dir, "sub", "a.txt"), filepath.Join(dir, "b.txt")))
+	assert.Equal(t, fileChange{path: filepath.Join(dir, "b.txt"), from: filepath.Join(dir, "sub", "a.txt")},
+		nextChange(t, w))
+
+	assert.NoError(t, os.Remove(filepath.Join(dir, "b.txt")))
+	assert.Equal(t, fileChange{path: filepath.Join(dir, "b.txt")}, nextChange(t, w))
+
+	// new directories are watched too
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "new"), 0755))
+	assert.Equal(t, fileChange{path: filepath.Join(dir, "new")}, nextChange(t, w))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "new", "c.txt"), []byte("c"), 0644))
+	assert.Equal(t, fileChange{path: filepath.Join(dir, "new", "c.txt")}, nextChange(t, w))
+}
+
+func Test_Inotify_Watcher_Missing_Dir(t *testing.T) {
+	_, err := newChangeWatcher(filepath.Join(t.TempDir(), "missing"), slog.Default())
+
+	assert.Error(t, err)
+}
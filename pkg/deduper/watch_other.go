@@ -0,0 +1,12 @@
+//go:build !linux
+
+package deduper
+
+import (
+	"log/slog"
+)
+
+// newChangeWatcher is not supported on this platform.
+func newChangeWatcher(dir string, logger *slog.Logger) (changeWatcher, error) {
+	return nil, errWatchNotSupported
+}
@@ -0,0 +1,174 @@
+package deduper
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeWatcher reports the changes sent to it.
+type fakeWatcher struct {
+	changed chan fileChange
+	failed  chan error
+}
+
+func newFakeWatcher() *fakeWatcher {
+	return &fakeWatcher{make(chan fileChange), make(chan error, 1)}
+}
+
+func (w *fakeWatcher) changes() <-chan fileChange {
+	return w.changed
+}
+
+func (w *fakeWatcher) errors() <-chan error {
+	return w.failed
+}
+
+func (w *fakeWatcher) close() error {
+	return nil
+}
+
+func watchFixture(t *testing.T, opts ...Option) (afero.Fs, *deduperImp) {
+	fs := afero.NewMemMapFs()
+	writeFiles(t, fs, map[string][]byte{
+		"photos/a.jpg":         []byte("a"),
+		"photos/b.jpg":         []byte("b"),
+		"photos/holiday/c.jpg": []byte("c"),
+		"photos/holiday/d.jpg": []byte("d"),
+	})
+
+	d := New(fs, "index", opts...).(*deduperImp)
+	assert.NoError(t, d.Create("photos"))
+
+	return fs, d
+}
+
+func indexedPaths(d *deduperImp) []string {
+	paths := []string{}
+	for _, f := range d.index.Files() {
+		paths = append(paths, f.Path)
+	}
+
+	return paths
+}
+
+func Test_Apply_Changes_Created(t *testing.T) {
+	fs, d := watchFixture(t)
+	assert.NoError(t, afero.WriteFile(fs, "photos/copy of a.jpg", []byte("a"), 0644))
+
+	event, err := d.applyChanges([]fileChange{{path: "photos/copy of a.jpg"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"photos/copy of a.jpg"}, event.Updated)
+	assert.Empty(t, event.Removed)
+	assert.Len(t, event.Groups, 1)
+	assert.Equal(t, []string{"photos/a.jpg", "photos/copy of a.jpg"}, event.Groups[0].Paths())
+
+	// the index is saved
+	saved, err := readIndex(fs, "index/"+INDEX_NAME)
+	assert.NoError(t, err)
+	assert.Len(t, saved, 5)
+}
+
+func Test_Apply_Changes_Modified(t *testing.T) {
+	fs, d := watchFixture(t)
+	assert.NoError(t, afero.WriteFile(fs, "photos/b.jpg", []byte("a"), 0644))
+
+	event, err := d.applyChanges([]fileChange{{path: "photos/b.jpg"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"photos/b.jpg"}, event.Updated)
+	assert.Len(t, event.Groups, 1)
+	b, _ := d.index.get("photos/b.jpg")
+	a, _ := d.index.get("photos/a.jpg")
+	assert.Equal(t, a.Md5Checksum, b.Md5Checksum)
+}
+
+func Test_Apply_Changes_Removed(t *testing.T) {
+	fs, d := watchFixture(t)
+	assert.NoError(t, fs.RemoveAll("photos/holiday"))
+	assert.NoError(t, fs.Remove("photos/a.jpg"))
+
+	event, err := d.applyChanges([]fileChange{{path: "photos/holiday"}, {path: "photos/a.jpg"}})
+
+	assert.NoError(t, err)
+	assert.Empty(t, event.Updated)
+	assert.Equal(t, []string{"photos/a.jpg", "photos/holiday/c.jpg", "photos/holiday/d.jpg"}, event.Removed)
+	assert.Empty(t, event.Groups)
+	assert.Equal(t, []string{"photos/b.jpg"}, indexedPaths(d))
+}
+
+func Test_Apply_Changes_Renamed(t *testing.T) {
+	fs, d := watchFixture(t)
+	assert.NoError(t, fs.Rename("photos/holiday", "photos/2020"))
+	before, _ := d.index.get("photos/holiday/c.jpg")
+
+	event, err := d.applyChanges([]fileChange{{path: "photos/2020", from: "photos/holiday"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"photos/holiday": "photos/2020"}, event.Renamed)
+	assert.Empty(t, event.Updated)
+	after, found := d.index.get("photos/2020/c.jpg")
+	assert.True(t, found)
+	assert.Equal(t, before.Md5Checksum, after.Md5Checksum)
+	assert.ElementsMatch(t, []string{"photos/a.jpg", "photos/b.jpg", "photos/2020/c.jpg", "photos/2020/d.jpg"}, indexedPaths(d))
+}
+
+func Test_Apply_Changes_Renamed_Filtered(t *testing.T) {
+	fs, d := watchFixture(t, WithFilters(ExtensionFilter(".jpg")))
+	assert.NoError(t, fs.Rename("photos/a.jpg", "photos/a.txt"))
+
+	event, err := d.applyChanges([]fileChange{{path: "photos/a.txt", from: "photos/a.jpg"}})
+
+	assert.NoError(t, err)
+	assert.True(t, event.IsEmpty())
+	_, found := d.index.get("photos/a.jpg")
+	assert.False(t, found)
+	_, found = d.index.get("photos/a.txt")
+	assert.False(t, found)
+}
+
+func Test_Apply_Changes_Ignores_Index(t *testing.T) {
+	_, d := watchFixture(t)
+
+	event, err := d.applyChanges([]fileChange{{path: "photos/" + INDEX_NAME}})
+
+	assert.NoError(t, err)
+	assert.True(t, event.IsEmpty())
+}
+
+func Test_Watch_Changes(t *testing.T) {
+	fs, d := watchFixture(t)
+	w := newFakeWatcher()
+	stop := make(chan struct{})
+	events := make(chan WatchEvent)
+	done := make(chan error)
+	go func() {
+		done <- d.watchChanges(w, stop, func(e WatchEvent) {
+			events <- e
+		})
+	}()
+
+	assert.NoError(t, afero.WriteFile(fs, "photos/e.jpg", []byte("c"), 0644))
+	w.changed <- fileChange{path: "photos/e.jpg"}
+	event := <-events
+	assert.Equal(t, []string{"photos/e.jpg"}, event.Updated)
+	assert.Equal(t, []string{"photos/e.jpg", "photos/holiday/c.jpg"}, event.Groups[0].Paths())
+
+	close(stop)
+	assert.NoError(t, <-done)
+}
+
+func Test_Watch_Changes_Error(t *testing.T) {
+	_, d := watchFixture(t)
+	w := newFakeWatcher()
+	w.failed <- errEventsLostForTest
+
+	err := d.watchChanges(w, make(chan struct{}), func(e WatchEvent) {})
+
+	assert.ErrorIs(t, err, errEventsLostForTest)
+}
+
+var errEventsLostForTest = errors.New("events lost")
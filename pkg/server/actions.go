@@ -0,0 +1,155 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+const (
+	ActionMove   = "move"
+	ActionDelete = "delete"
+)
+
+// ActionPlan is what to do with the duplicates of groups, as chosen in the UI.
+type ActionPlan struct {
+	// Action is move or delete.
+	Action string
+	// Target is the directory to move the duplicates to, only for move.
+	Target string `json:",omitempty"`
+	// Groups to act on.
+	Groups []PlannedGroup
+	// DryRun returns the files that would be moved or deleted, without moving or deleting them.
+	DryRun bool `json:",omitempty"`
+}
+
+// PlannedGroup selects a group of duplicates by the strategy and key it has in the groups returned by the API.
+type PlannedGroup struct {
+	Strategy deduper.Strategy
+	Key      string
+	// Keeper is the path of the member to keep, to keep another member than the group's keeper.
+	Keeper string `json:",omitempty"`
+}
+
+// ActionResult lists the files that were moved or deleted, and the number of bytes that freed.
+type ActionResult struct {
+	Files     []string
+	Reclaimed int64
+	DryRun    bool `json:",omitempty"`
+}
+
+// resolve returns the groups of the plan, with the keepers of the plan.
+func (p ActionPlan) resolve(groups []deduper.DuplicateGroup) ([]deduper.DuplicateGroup, error) {
+	resolved := []deduper.DuplicateGroup{}
+	for _, planned := range p.Groups {
+		matched := []deduper.DuplicateGroup{}
+		for _, g := range groups {
+			if planned.Strategy == g.Strategy && planned.Key == g.Key {
+				matched = append(matched, g)
+			}
+		}
+		switch len(matched) {
+		case 0:
+			return nil, fmt.Errorf("no %v group with key %v", planned.Strategy, planned.Key)
+		case 1:
+		default:
+			// acting on any of them could remove files that were not reviewed
+			return nil, fmt.Errorf("%v %v groups with key %v", len(matched), planned.Strategy, planned.Key)
+		}
+		g := matched[0]
+		if deduper.CategoryDuplicate != g.Category && deduper.CategoryDirectory != g.Category {
+			return nil, fmt.Errorf("cannot act on the %v group with key %v", g.Category, g.Key)
+		}
+		if "" != planned.Keeper {
+			i := slices.Index(g.Paths(), planned.Keeper)
+			if i < 0 {
+				return nil, fmt.Errorf("keeper %v is not in the %v group with key %v", planned.Keeper, g.Strategy, g.Key)
+			}
+			// the duplicates would be removed while the archive may be changed or removed as a whole
+			if "" != g.Members[i].Archive {
+				return nil, fmt.Errorf("keeper %v is in archive %v, files in archives cannot be kept", planned.Keeper, g.Members[i].Archive)
+			}
+			g.Keeper = planned.Keeper
+		}
+		resolved = append(resolved, g)
+	}
+
+	return resolved, nil
+}
+
+// actionResult lists the files that acting on the groups moves or deletes. Like the deduper, it skips archive members
+// and includes all hard links.
+func actionResult(groups []deduper.DuplicateGroup, dryRun bool) ActionResult {
+	result := ActionResult{Files: []string{}, DryRun: dryRun}
+	for _, g := range groups {
+		for _, m := range g.Duplicates() {
+			if "" != m.Archive {
+				continue
+			}
+			result.Files = append(result.Files, m.AllPaths()...)
+			result.Reclaimed += m.Size
+		}
+	}
+
+	return result
+}
+
+// applyPlan moves or deletes the duplicates of the groups in the plan, and removes them from the index.
+func (s *Server) applyPlan(w http.ResponseWriter, r *http.Request) {
+	var plan ActionPlan
+	if err := readJson(r, &plan); nil != err {
+		s.writeError(w, readJsonStatus(err), fmt.Errorf("invalid action plan: %w", err))
+		return
+	}
+	if ActionMove != plan.Action && ActionDelete != plan.Action {
+		s.writeError(w, http.StatusBadRequest, errUnknownAction)
+		return
+	}
+	if ActionMove == plan.Action && "" == plan.Target {
+		s.writeError(w, http.StatusBadRequest, errors.New("invalid action plan: Target is required to move"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nil != s.running() {
+		s.writeError(w, http.StatusConflict, errJobRunning)
+		return
+	}
+	groups, err := s.findGroups()
+	if nil != err {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	planned, err := plan.resolve(groups)
+	if nil != err {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result := actionResult(planned, plan.DryRun)
+	if plan.DryRun {
+		writeJson(w, http.StatusOK, result)
+		return
+	}
+
+	if ActionMove == plan.Action {
+		err = s.dedup.MoveDuplicates(planned, plan.Target)
+	} else {
+		err = s.dedup.DeleteDuplicates(planned)
+	}
+	// some files may have been moved or deleted before failing
+	s.groups = nil
+	if _, pruneErr := s.dedup.Prune(); nil != pruneErr {
+		err = errors.Join(err, pruneErr)
+	}
+	if nil != err {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, result)
+}
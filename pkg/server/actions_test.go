@@ -0,0 +1,142 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+// photoGroup selects the group of the 3 copies of the photo.
+func photoGroup(t *testing.T, s *Server) PlannedGroup {
+	var page GroupPage
+	request(t, s, http.MethodGet, "/api/groups?limit=1", nil, &page)
+
+	return PlannedGroup{Strategy: page.Groups[0].Strategy, Key: page.Groups[0].Key}
+}
+
+func Test_Apply_Plan_Move(t *testing.T) {
+	fs, s := indexedServer(t)
+	planned := photoGroup(t, s)
+	planned.Keeper = "photos/2020/a.jpg"
+
+	var result ActionResult
+	rec := request(t, s, http.MethodPost, "/api/actions", ActionPlan{
+		Action: ActionMove,
+		Target: "trash",
+		Groups: []PlannedGroup{planned},
+	}, &result)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"photos/a.jpg", "photos/2021/a.jpg"}, result.Files)
+	assert.Equal(t, 2*int64(len(testJpeg(t))), result.Reclaimed)
+	for _, path := range []string{"trash/a.jpg", "trash/2021/a.jpg", "photos/2020/a.jpg"} {
+		exists, _ := afero.Exists(fs, path)
+		assert.True(t, exists, path)
+	}
+
+	// the moved files are removed from the index
+	var page GroupPage
+	request(t, s, http.MethodGet, "/api/groups", nil, &page)
+	assert.Equal(t, []string{"photos/notes.txt"}, groupKeepers(page))
+	index, err := deduper.LoadIndex(fs, "index")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, index.Len())
+}
+
+func Test_Apply_Plan_Delete(t *testing.T) {
+	fs, s := indexedServer(t)
+
+	var result ActionResult
+	rec := request(t, s, http.MethodPost, "/api/actions", ActionPlan{
+		Action: ActionDelete,
+		Groups: []PlannedGroup{photoGroup(t, s)},
+	}, &result)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"photos/2020/a.jpg", "photos/2021/a.jpg"}, result.Files)
+	exists, _ := afero.Exists(fs, "photos/2020/a.jpg")
+	assert.False(t, exists)
+	exists, _ = afero.Exists(fs, "photos/a.jpg")
+	assert.True(t, exists)
+}
+
+func Test_Apply_Plan_Dry_Run(t *testing.T) {
+	fs, s := indexedServer(t)
+
+	var result ActionResult
+	rec := request(t, s, http.MethodPost, "/api/actions", ActionPlan{
+		Action: ActionDelete,
+		Groups: []PlannedGroup{photoGroup(t, s)},
+		DryRun: true,
+	}, &result)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, result.DryRun)
+	assert.Equal(t, []string{"photos/2020/a.jpg", "photos/2021/a.jpg"}, result.Files)
+	exists, _ := afero.Exists(fs, "photos/2020/a.jpg")
+	assert.True(t, exists)
+}
+
+func Test_Apply_Plan_Invalid(t *testing.T) {
+	fs, s := indexedServer(t)
+	planned := photoGroup(t, s)
+	tests := map[string]ActionPlan{
+		"unknown action":  {Action: "copy", Groups: []PlannedGroup{planned}},
+		"no target":       {Action: ActionMove, Groups: []PlannedGroup{planned}},
+		"unknown group":   {Action: ActionDelete, Groups: []PlannedGroup{{Strategy: deduper.StrategyMd5, Key: "unknown"}}},
+		"unknown keeper":  {Action: ActionDelete, Groups: []PlannedGroup{{Strategy: planned.Strategy, Key: planned.Key, Keeper: "photos/notes.txt"}}},
+		"other strategy":  {Action: ActionDelete, Groups: []PlannedGroup{{Strategy: deduper.StrategyImageHash, Key: planned.Key}}},
+		"nothing planned": {},
+	}
+
+	for name, plan := range tests {
+		rec := request(t, s, http.MethodPost, "/api/actions", plan, nil)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code, name)
+	}
+	exists, _ := afero.Exists(fs, "photos/2020/a.jpg")
+	assert.True(t, exists)
+}
+
+func Test_Resolve_Ambiguous_Group(t *testing.T) {
+	groups := []deduper.DuplicateGroup{}
+	for _, dir := range []string{"2020", "2021"} {
+		groups = append(groups, deduper.DuplicateGroup{
+			Strategy: deduper.StrategyVideoHash,
+			Category: deduper.CategoryDuplicate,
+			Key:      "000000000000000f",
+			Members: []deduper.GroupMember{
+				{IndexedFile: deduper.IndexedFile{Path: dir + "/a.mp4"}},
+				{IndexedFile: deduper.IndexedFile{Path: dir + "/copy.mp4"}},
+			},
+			Keeper: dir + "/a.mp4",
+		})
+	}
+	plan := ActionPlan{Action: ActionDelete, Groups: []PlannedGroup{{Strategy: deduper.StrategyVideoHash, Key: "000000000000000f"}}}
+
+	_, err := plan.resolve(groups)
+
+	assert.ErrorContains(t, err, "2 videohash groups with key 000000000000000f")
+}
+
+func Test_Resolve_Archive_Keeper(t *testing.T) {
+	groups := []deduper.DuplicateGroup{{
+		Strategy: deduper.StrategyMd5,
+		Category: deduper.CategoryDuplicate,
+		Key:      "5d41402abc4b2a76b9719d911017c592",
+		Members: []deduper.GroupMember{
+			{IndexedFile: deduper.IndexedFile{Path: "photos/a.jpg"}},
+			{IndexedFile: deduper.IndexedFile{Path: "backup.zip!/a.jpg", Archive: "backup.zip"}},
+		},
+		Keeper: "photos/a.jpg",
+	}}
+	plan := ActionPlan{Action: ActionDelete, Groups: []PlannedGroup{{Strategy: deduper.StrategyMd5, Key: "5d41402abc4b2a76b9719d911017c592", Keeper: "backup.zip!/a.jpg"}}}
+
+	_, err := plan.resolve(groups)
+
+	assert.ErrorContains(t, err, "files in archives cannot be kept")
+}
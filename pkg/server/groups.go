@@ -0,0 +1,178 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+	// defaultThumbnailSize is the width and height of thumbnails, in pixels, if not requested
+	defaultThumbnailSize = 256
+	maxThumbnailSize     = 1024
+)
+
+// GroupPage is a page of the groups of duplicates that match the filters of a query.
+type GroupPage struct {
+	// Total is the number of groups that match the filters, on all pages.
+	Total  int
+	Offset int
+	Limit  int
+	Groups []deduper.DuplicateGroup
+}
+
+// groupFilter selects the groups returned by a query.
+type groupFilter struct {
+	strategy deduper.Strategy
+	category deduper.Category
+	// dir only selects groups with a member in this directory
+	dir string
+	// minSize only selects groups that reclaim at least this many bytes
+	minSize int64
+}
+
+func (f groupFilter) accepts(g deduper.DuplicateGroup) bool {
+	if "" != f.strategy && f.strategy != g.Strategy {
+		return false
+	}
+	if "" != f.category && f.category != g.Category {
+		return false
+	}
+	if g.Reclaimable < f.minSize {
+		return false
+	}
+	if "" == f.dir {
+		return true
+	}
+	for _, m := range g.Members {
+		if m.Path == f.dir || strings.HasPrefix(m.Path, f.dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// queryInt parses the query parameter name as a number of at least 0, or returns def if it is not set.
+func queryInt(r *http.Request, name string, def int64) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if "" == value {
+		return def, nil
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if nil != err || i < 0 {
+		return 0, fmt.Errorf("invalid %v: %v", name, value)
+	}
+
+	return i, nil
+}
+
+// findGroups returns the groups of duplicates in the index, finding them when the index changed.
+// Must be called holding s.mu.
+func (s *Server) findGroups() ([]deduper.DuplicateGroup, error) {
+	if nil != s.groups {
+		return s.groups, nil
+	}
+
+	groups, err := s.dedup.FindAll()
+	if nil != err {
+		return nil, err
+	}
+	s.groups = groups
+
+	return groups, nil
+}
+
+// getGroups returns a page of groups, filtered by the strategy, category, dir and min-size query parameters,
+// and paged using offset and limit.
+func (s *Server) getGroups(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := groupFilter{
+		strategy: deduper.Strategy(query.Get("strategy")),
+		category: deduper.Category(query.Get("category")),
+	}
+	if dir := query.Get("dir"); "" != dir {
+		filter.dir = filepath.Clean(dir)
+	}
+	minSize, err := queryInt(r, "min-size", 0)
+	if nil != err {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.minSize = minSize
+	offset, err := queryInt(r, "offset", 0)
+	if nil != err {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultPageSize)
+	if nil != err || 0 == limit || limit > maxPageSize {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit, use 1 to %v", maxPageSize))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nil != s.running() {
+		s.writeError(w, http.StatusConflict, errJobRunning)
+		return
+	}
+	groups, err := s.findGroups()
+	if nil != err {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	filtered := []deduper.DuplicateGroup{}
+	for _, g := range groups {
+		if filter.accepts(g) {
+			filtered = append(filtered, g)
+		}
+	}
+	start := min(int(offset), len(filtered))
+	end := min(start+int(limit), len(filtered))
+
+	writeJson(w, http.StatusOK, GroupPage{
+		Total:  len(filtered),
+		Offset: int(offset),
+		Limit:  int(limit),
+		Groups: filtered[start:end],
+	})
+}
+
+// getThumbnail returns a jpeg thumbnail of the indexed image in the path query parameter, of at most size pixels.
+func (s *Server) getThumbnail(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	size, err := queryInt(r, "size", defaultThumbnailSize)
+	if nil != err || 0 == size || size > maxThumbnailSize {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid size, use 1 to %v", maxThumbnailSize))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nil != s.running() {
+		s.writeError(w, http.StatusConflict, errJobRunning)
+		return
+	}
+	thumbnail, err := s.dedup.Thumbnail(path, int(size))
+	switch {
+	case errors.Is(err, deduper.ErrNotIndexed):
+		s.writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, deduper.ErrNotImage):
+		s.writeError(w, http.StatusUnsupportedMediaType, err)
+	case nil != err:
+		s.writeError(w, http.StatusInternalServerError, err)
+	default:
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		w.Write(thumbnail)
+	}
+}
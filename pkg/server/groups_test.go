@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+func groupKeepers(page GroupPage) []string {
+	keepers := []string{}
+	for _, g := range page.Groups {
+		keepers = append(keepers, g.Keeper)
+	}
+
+	return keepers
+}
+
+func Test_Get_Groups(t *testing.T) {
+	_, s := indexedServer(t)
+	var page GroupPage
+
+	rec := request(t, s, http.MethodGet, "/api/groups", nil, &page)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, page.Total)
+	assert.Equal(t, 0, page.Offset)
+	assert.Equal(t, 50, page.Limit)
+	assert.Equal(t, []string{"photos/a.jpg", "photos/notes.txt"}, groupKeepers(page))
+	assert.Equal(t, []string{"photos/a.jpg", "photos/2020/a.jpg", "photos/2021/a.jpg"}, page.Groups[0].Paths())
+}
+
+func Test_Get_Groups_Paged(t *testing.T) {
+	_, s := indexedServer(t)
+
+	var page GroupPage
+	request(t, s, http.MethodGet, "/api/groups?offset=1&limit=1", nil, &page)
+	assert.Equal(t, 2, page.Total)
+	assert.Equal(t, []string{"photos/notes.txt"}, groupKeepers(page))
+
+	request(t, s, http.MethodGet, "/api/groups?offset=5", nil, &page)
+	assert.Equal(t, 2, page.Total)
+	assert.Empty(t, page.Groups)
+}
+
+func Test_Get_Groups_Filtered(t *testing.T) {
+	_, s := indexedServer(t, deduper.WithHashers(deduper.StrategyMd5, deduper.StrategyImageHash))
+	tests := []struct {
+		query   string
+		keepers []string
+	}{
+		{"strategy=imagehash", []string{"photos/a.jpg"}},
+		{"strategy=md5&dir=photos/2021", []string{"photos/a.jpg"}},
+		{"dir=photos/2020/", []string{"photos/a.jpg", "photos/notes.txt", "photos/a.jpg"}},
+		{"dir=photos/202", []string{}},
+		{"category=linked", []string{}},
+		{"strategy=md5&min-size=100", []string{"photos/a.jpg"}},
+	}
+
+	for _, test := range tests {
+		var page GroupPage
+		rec := request(t, s, http.MethodGet, "/api/groups?"+test.query, nil, &page)
+
+		assert.Equal(t, http.StatusOK, rec.Code, test.query)
+		assert.Equal(t, test.keepers, groupKeepers(page), test.query)
+		assert.Equal(t, len(test.keepers), page.Total, test.query)
+	}
+}
+
+func Test_Get_Groups_Invalid(t *testing.T) {
+	_, s := indexedServer(t)
+
+	for _, query := range []string{"offset=-1", "limit=0", "limit=1001", "min-size=big"} {
+		rec := request(t, s, http.MethodGet, "/api/groups?"+query, nil, nil)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
+
+func Test_Get_Thumbnail(t *testing.T) {
+	_, s := indexedServer(t)
+
+	rec := request(t, s, http.MethodGet, "/api/thumbnail?path=photos/2020/a.jpg&size=16", nil, nil)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/jpeg", rec.Header().Get("Content-Type"))
+	img, _, err := image.Decode(bytes.NewReader(rec.Body.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(16, 12), img.Bounds().Size())
+}
+
+func Test_Get_Thumbnail_Errors(t *testing.T) {
+	_, s := indexedServer(t)
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"path=photos/missing.jpg", http.StatusNotFound},
+		{"path=/etc/passwd", http.StatusNotFound},
+		{"path=photos/notes.txt", http.StatusUnsupportedMediaType},
+		{"path=photos/a.jpg&size=0", http.StatusBadRequest},
+		{fmt.Sprintf("path=photos/a.jpg&size=%v", maxThumbnailSize+1), http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		rec := request(t, s, http.MethodGet, "/api/thumbnail?"+test.query, nil, nil)
+
+		assert.Equal(t, test.status, rec.Code, test.query)
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+// JobState is the state of an index job.
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobDone      JobState = "done"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// Job is the status of an index job.
+type Job struct {
+	ID       int
+	Dir      string
+	State    JobState
+	Error    string `json:",omitempty"`
+	Started  time.Time
+	Progress deduper.Progress
+}
+
+// indexJob indexes a directory in the background.
+type indexJob struct {
+	status Job
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	// updated is closed and replaced whenever the job changes, to wake up the event streams
+	updated chan struct{}
+}
+
+// jobRequest is the body to start an index job.
+type jobRequest struct {
+	Dir string
+}
+
+// snapshot returns a copy of the job, and a channel that is closed when it changes.
+func (j *indexJob) snapshot() (Job, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.status, j.updated
+}
+
+func (j *indexJob) update(fun func(status *Job)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	fun(&j.status)
+	close(j.updated)
+	j.updated = make(chan struct{})
+}
+
+// progress receives the progress of the running job.
+func (s *Server) progress(p deduper.Progress) {
+	s.mu.Lock()
+	job := s.job
+	s.mu.Unlock()
+
+	job.update(func(j *Job) { j.Progress = p })
+}
+
+// running returns the running job, or nil.
+func (s *Server) running() *indexJob {
+	if nil == s.job {
+		return nil
+	}
+	if state, _ := s.job.snapshot(); JobRunning != state.State {
+		return nil
+	}
+
+	return s.job
+}
+
+func (s *Server) startJob(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	if err := readJson(r, &req); nil != err {
+		s.writeError(w, readJsonStatus(err), fmt.Errorf("invalid index job: %w", err))
+		return
+	}
+	if "" == req.Dir {
+		s.writeError(w, http.StatusBadRequest, errors.New("invalid index job: Dir is required"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nil != s.running() {
+		s.writeError(w, http.StatusConflict, errJobRunning)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	id := 1
+	if nil != s.job {
+		last, _ := s.job.snapshot()
+		id = last.ID + 1
+	}
+	s.job = &indexJob{
+		status:  Job{ID: id, Dir: req.Dir, State: JobRunning, Started: time.Now()},
+		cancel:  cancel,
+		updated: make(chan struct{}),
+	}
+	s.groups = nil
+	go s.runJob(ctx, s.job, req.Dir)
+
+	job, _ := s.job.snapshot()
+	writeJson(w, http.StatusAccepted, job)
+}
+
+func (s *Server) runJob(ctx context.Context, job *indexJob, dir string) {
+	defer job.cancel()
+
+	err := s.dedup.CreateContext(ctx, dir)
+	job.update(func(j *Job) {
+		switch {
+		case errors.Is(err, context.Canceled):
+			j.State = JobCancelled
+		case nil != err:
+			j.State = JobFailed
+			j.Error = strings.TrimSpace(err.Error())
+		default:
+			j.State = JobDone
+		}
+	})
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if nil == s.job {
+		s.writeError(w, http.StatusNotFound, errNoJob)
+		return
+	}
+
+	job, _ := s.job.snapshot()
+	writeJson(w, http.StatusOK, job)
+}
+
+func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	running := s.running()
+	if nil == running {
+		s.writeError(w, http.StatusConflict, errNoJobRunning)
+		return
+	}
+
+	running.cancel()
+	job, _ := running.snapshot()
+	writeJson(w, http.StatusAccepted, job)
+}
+
+// jobEvents streams the progress of the last job as server-sent events: a progress event whenever it changes, and
+// a done, failed or cancelled event with the job when it ends, after which the stream is closed.
+func (s *Server) jobEvents(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	job := s.job
+	s.mu.Unlock()
+	if nil == job {
+		s.writeError(w, http.StatusNotFound, errNoJob)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for {
+		snapshot, updated := job.snapshot()
+		if JobRunning == snapshot.State {
+			writeEvent(w, "progress", snapshot.Progress)
+		} else {
+			writeEvent(w, string(snapshot.State), snapshot)
+		}
+		flusher.Flush()
+		if JobRunning != snapshot.State {
+			return
+		}
+
+		select {
+		case <-updated:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(w, "event: %v\ndata: %s\n\n", event, data)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+// blockingFs blocks opening files until released, to keep index jobs running.
+type blockingFs struct {
+	afero.Fs
+	opened  chan string
+	release chan struct{}
+}
+
+func newBlockingFs(fs afero.Fs) *blockingFs {
+	return &blockingFs{fs, make(chan string, 1), make(chan struct{})}
+}
+
+func (b *blockingFs) Open(name string) (afero.File, error) {
+	select {
+	case b.opened <- name:
+	default:
+	}
+	<-b.release
+
+	return b.Fs.Open(name)
+}
+
+// event is a server-sent event.
+type event struct {
+	name string
+	data string
+}
+
+// jobEvents streams the events of the last job, until it ends.
+func jobEvents(t *testing.T, s *Server) []event {
+	rec := request(t, s, http.MethodGet, "/api/index/events", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+
+	events := []event{}
+	for _, chunk := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+		lines := strings.Split(chunk, "\n")
+		assert.Len(t, lines, 2)
+		events = append(events, event{strings.TrimPrefix(lines[0], "event: "), strings.TrimPrefix(lines[1], "data: ")})
+	}
+
+	return events
+}
+
+func Test_Index_Job(t *testing.T) {
+	s := New(testFs(t), "index", testLogger())
+
+	var job Job
+	rec := request(t, s, http.MethodPost, "/api/index", jobRequest{"photos"}, &job)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, 1, job.ID)
+	assert.Equal(t, "photos", job.Dir)
+	assert.Equal(t, JobRunning, job.State)
+
+	events := jobEvents(t, s)
+	last := events[len(events)-1]
+	assert.Equal(t, "done", last.name)
+	assert.Contains(t, last.data, `"FilesHashed":6`)
+	for _, e := range events[:len(events)-1] {
+		assert.Equal(t, "progress", e.name)
+	}
+
+	request(t, s, http.MethodGet, "/api/index", nil, &job)
+	assert.Equal(t, JobDone, job.State)
+	assert.True(t, job.Progress.Done)
+
+	var page GroupPage
+	request(t, s, http.MethodGet, "/api/groups", nil, &page)
+	assert.Equal(t, 2, page.Total)
+
+	// the next job gets the next id
+	request(t, s, http.MethodPost, "/api/index", jobRequest{"photos/2020"}, &job)
+	assert.Equal(t, 2, job.ID)
+	jobEvents(t, s)
+}
+
+func Test_Index_Job_Failed(t *testing.T) {
+	s := New(testFs(t), "index", testLogger())
+
+	request(t, s, http.MethodPost, "/api/index", jobRequest{"missing"}, nil)
+	events := jobEvents(t, s)
+
+	assert.Equal(t, "failed", events[len(events)-1].name)
+	var job Job
+	request(t, s, http.MethodGet, "/api/index", nil, &job)
+	assert.Equal(t, JobFailed, job.State)
+	assert.Equal(t, `error walking the path "missing": open missing: file does not exist`, job.Error)
+}
+
+func Test_Index_Job_Cancelled(t *testing.T) {
+	fs := newBlockingFs(testFs(t))
+	s := New(fs, "index", testLogger(), deduper.WithWorkers(1))
+
+	request(t, s, http.MethodPost, "/api/index", jobRequest{"photos"}, nil)
+	<-fs.opened
+
+	// only 1 job runs at a time, and groups and thumbnails are not available while indexing
+	rec := request(t, s, http.MethodPost, "/api/index", jobRequest{"photos"}, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = request(t, s, http.MethodGet, "/api/groups", nil, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = request(t, s, http.MethodGet, "/api/thumbnail?path=photos/a.jpg", nil, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	var job Job
+	rec = request(t, s, http.MethodDelete, "/api/index", nil, &job)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	// the job ends once the file being hashed is read
+	close(fs.release)
+	events := jobEvents(t, s)
+
+	assert.Equal(t, "cancelled", events[len(events)-1].name)
+	request(t, s, http.MethodGet, "/api/index", nil, &job)
+	assert.Equal(t, JobCancelled, job.State)
+	rec = request(t, s, http.MethodDelete, "/api/index", nil, nil)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func Test_Index_Job_Invalid(t *testing.T) {
+	s := New(testFs(t), "index", testLogger())
+
+	rec := request(t, s, http.MethodPost, "/api/index", map[string]string{}, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = request(t, s, http.MethodPost, "/api/index", map[string]string{"Directory": "photos"}, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func Test_No_Index_Job(t *testing.T) {
+	s := New(testFs(t), "index", testLogger())
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		rec := request(t, s, method, "/api/index", nil, nil)
+		assert.NotEqual(t, http.StatusOK, rec.Code, method)
+	}
+	rec := request(t, s, http.MethodGet, "/api/index/events", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
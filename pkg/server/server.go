@@ -0,0 +1,151 @@
+// Package server serves a Deduper over a local HTTP/JSON API, and a web UI to review duplicates using it.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+var (
+	errJobRunning    = errors.New("an index job is running")
+	errNoJobRunning  = errors.New("no index job is running")
+	errNoJob         = errors.New("no index job was started")
+	errUnknownAction = errors.New("unknown action, use move or delete")
+	errNotJson       = errors.New("the content type must be application/json")
+	errForeignHost   = errors.New("requests must be sent to the address the server listens on")
+	errForeignOrigin = errors.New("requests from other sites are not allowed")
+)
+
+// Server serves the API for a single index. Only one index job runs at a time, and groups cannot be queried or acted
+// on while it runs.
+type Server struct {
+	dedup  deduper.Deduper
+	logger *slog.Logger
+	mux    *http.ServeMux
+
+	mu sync.Mutex
+	// job is the running index job, or the last one
+	job *indexJob
+	// groups are the duplicates in the index, nil until found and after the index changed
+	groups []deduper.DuplicateGroup
+}
+
+// New creates a Server for the index at indexPath, configured using the given options.
+// Progress of index jobs is reported to the server, so do not pass deduper.WithProgress.
+func New(fs afero.Fs, indexPath string, logger *slog.Logger, opts ...deduper.Option) *Server {
+	s := &Server{logger: logger, mux: http.NewServeMux()}
+	s.dedup = deduper.New(fs, indexPath, append(opts, deduper.WithLogger(logger), deduper.WithProgress(deduper.ProgressFunc(s.progress)))...)
+
+	s.mux.HandleFunc("POST /api/index", s.startJob)
+	s.mux.HandleFunc("GET /api/index", s.getJob)
+	s.mux.HandleFunc("DELETE /api/index", s.cancelJob)
+	s.mux.HandleFunc("GET /api/index/events", s.jobEvents)
+	s.mux.HandleFunc("GET /api/groups", s.getGroups)
+	s.mux.HandleFunc("GET /api/thumbnail", s.getThumbnail)
+	s.mux.HandleFunc("POST /api/actions", s.applyPlan)
+	s.mux.Handle("GET /", uiHandler())
+
+	return s
+}
+
+// Load loads the existing index, so that its groups can be queried without indexing first.
+func (s *Server) Load() error {
+	return s.dedup.Load()
+}
+
+// ServeHTTP serves requests sent to the server's own address only, from its own pages or clients that are not
+// browsers. Other sites cannot act on the index, not even when their host name resolves to the server's address.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !ownHost(r, r.Host) {
+		s.writeError(w, http.StatusForbidden, errForeignHost)
+		return
+	}
+	// browsers send the origin of requests from other sites, other clients don't send it at all
+	if origin := r.Header.Get("Origin"); "" != origin {
+		u, err := url.Parse(origin)
+		if nil != err || "http" != u.Scheme || !ownHost(r, u.Host) {
+			s.writeError(w, http.StatusForbidden, errForeignOrigin)
+			return
+		}
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+// ownHost returns whether host, from the Host or Origin header, is the address the request was received on, or
+// localhost when received on a loopback address. Other names, which a DNS rebinding site would use, are rejected.
+func ownHost(r *http.Request, host string) bool {
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return false
+	}
+	localIp, localPort, err := net.SplitHostPort(local.String())
+	if nil != err {
+		return false
+	}
+	name, port, err := net.SplitHostPort(host)
+	if nil != err {
+		// the default port is left out
+		name, port = host, "80"
+	}
+	if port != localPort {
+		return false
+	}
+	ip := net.ParseIP(localIp)
+	if "localhost" == strings.ToLower(name) {
+		return nil != ip && ip.IsLoopback()
+	}
+
+	return ip.Equal(net.ParseIP(strings.Trim(name, "[]")))
+}
+
+// apiError is the body of all error responses.
+type apiError struct {
+	Error string
+}
+
+func writeJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		s.logger.Error("Request failed", "error", err)
+	}
+	// errors of the deduper end with a new line
+	writeJson(w, status, apiError{strings.TrimSpace(err.Error())})
+}
+
+// readJson decodes the request body into v, rejecting unknown fields so that typos are not silently ignored.
+// Only json is accepted, so that other sites cannot send the body as a form, which browsers allow without asking.
+func readJson(r *http.Request, v any) error {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); nil != err || "application/json" != mediaType {
+		return errNotJson
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(v)
+}
+
+// readJsonStatus is the status of the response to a request of which readJson failed with err.
+func readJsonStatus(err error) int {
+	if errors.Is(err, errNotJson) {
+		return http.StatusUnsupportedMediaType
+	}
+
+	return http.StatusBadRequest
+}
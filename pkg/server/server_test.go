@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/driessamyn/deduplicater/pkg/deduper"
+)
+
+func testJpeg(t *testing.T) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 24))
+	for x := 0; x < 32; x++ {
+		for y := 0; y < 24; y++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 8), uint8(y * 10), uint8(x + y), 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	assert.NoError(t, jpeg.Encode(buf, img, nil))
+
+	return buf.Bytes()
+}
+
+// testFs has 2 groups of duplicates in photos: 3 copies of a photo and 2 of a text file.
+func testFs(t *testing.T) afero.Fs {
+	fs := afero.NewMemMapFs()
+	photo := testJpeg(t)
+	files := map[string][]byte{
+		"photos/a.jpg":          photo,
+		"photos/2020/a.jpg":     photo,
+		"photos/2021/a.jpg":     photo,
+		"photos/notes.txt":      []byte("notes"),
+		"photos/2020/notes.txt": []byte("notes"),
+		"photos/other.txt":      []byte("other"),
+	}
+	for name, content := range files {
+		assert.NoError(t, afero.WriteFile(fs, name, content, 0644))
+	}
+
+	return fs
+}
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// indexedServer creates a server for testFs, of which the photos are indexed.
+func indexedServer(t *testing.T, opts ...deduper.Option) (afero.Fs, *Server) {
+	fs := testFs(t)
+	assert.NoError(t, deduper.New(fs, "index", opts...).Create("photos"))
+
+	s := New(fs, "index", testLogger(), opts...)
+	assert.NoError(t, s.Load())
+
+	return fs, s
+}
+
+// newRequest creates a request received on 127.0.0.1:8080, sent to localhost:8080.
+func newRequest(method string, target string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	r.Host = "localhost:8080"
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+
+	return r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, local))
+}
+
+// request sends a request to the server, and decodes the json response into v, if not nil.
+func request(t *testing.T, s *Server, method string, target string, body any, v any) *httptest.ResponseRecorder {
+	r := newRequest(method, target, nil)
+	if nil != body {
+		data, err := json.Marshal(body)
+		assert.NoError(t, err)
+		r = newRequest(method, target, bytes.NewReader(data))
+		r.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	if nil != v {
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), v))
+	}
+
+	return rec
+}
+
+func Test_Not_Found(t *testing.T) {
+	_, s := indexedServer(t)
+
+	rec := request(t, s, http.MethodGet, "/api/unknown", nil, nil)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func Test_Method_Not_Allowed(t *testing.T) {
+	_, s := indexedServer(t)
+
+	rec := request(t, s, http.MethodPut, "/api/groups", nil, nil)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func Test_Error_Response(t *testing.T) {
+	_, s := indexedServer(t)
+	var apiErr apiError
+
+	rec := request(t, s, http.MethodGet, "/api/groups?limit=none", nil, &apiErr)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "invalid limit, use 1 to 1000", apiErr.Error)
+}
+
+func Test_Own_Host(t *testing.T) {
+	_, s := indexedServer(t)
+
+	for _, host := range []string{"localhost:8080", "LOCALHOST:8080", "127.0.0.1:8080"} {
+		r := newRequest(http.MethodGet, "/api/groups", nil)
+		r.Host = host
+		r.Header.Set("Origin", "http://"+host)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+
+		assert.Equal(t, http.StatusOK, rec.Code, host)
+	}
+}
+
+func Test_Foreign_Host(t *testing.T) {
+	_, s := indexedServer(t)
+
+	// a DNS rebinding site resolving to 127.0.0.1 sends its own name
+	for _, host := range []string{"attacker.example:8080", "localhost:8081", "127.0.0.2:8080", "localhost", ""} {
+		r := newRequest(http.MethodGet, "/api/groups", nil)
+		r.Host = host
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+
+		assert.Equal(t, http.StatusForbidden, rec.Code, host)
+	}
+}
+
+func Test_Foreign_Origin(t *testing.T) {
+	_, s := indexedServer(t)
+
+	for _, origin := range []string{"http://attacker.example", "http://localhost:8081", "https://localhost:8080", "null"} {
+		r := newRequest(http.MethodPost, "/api/index", strings.NewReader(`{"Dir": "photos"}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+
+		assert.Equal(t, http.StatusForbidden, rec.Code, origin)
+	}
+	assert.Nil(t, s.job)
+}
+
+func Test_Json_Required(t *testing.T) {
+	_, s := indexedServer(t)
+
+	// browsers send forms to other sites without asking
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		r := newRequest(http.MethodPost, "/api/index", strings.NewReader(`{"Dir": "photos"}`))
+		r.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code, contentType)
+	}
+	assert.Nil(t, s.job)
+
+	r := newRequest(http.MethodPost, "/api/index", strings.NewReader(`{"Dir": "photos"}`))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	jobEvents(t, s)
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// ui is the single page web UI to review groups of duplicates, served at the root.
+//
+//go:embed ui
+var ui embed.FS
+
+func uiHandler() http.Handler {
+	root, err := fs.Sub(ui, "ui")
+	if nil != err {
+		// the directory is embedded, so this cannot happen
+		panic(err)
+	}
+
+	return http.FileServerFS(root)
+}
@@ -0,0 +1,300 @@
+'use strict';
+
+// Reviews the groups of duplicates of the API, and turns the decisions into an action plan.
+
+const pageSize = 20;
+const thumbnailSize = 256;
+
+const state = {
+  offset: 0,
+  total: 0,
+  filters: new URLSearchParams({category: 'duplicate'}),
+  // decisions by group id, for the groups shown under the current filters: the keeper, whether to include the group
+  // in the plan, and the group itself
+  decisions: new Map(),
+};
+
+const $ = (id) => document.getElementById(id);
+
+function groupId(group) {
+  return group.Strategy + ':' + group.Key;
+}
+
+function formatBytes(bytes) {
+  const units = ['B', 'KiB', 'MiB', 'GiB', 'TiB'];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return (0 === i ? bytes : bytes.toFixed(1)) + ' ' + units[i];
+}
+
+function formatDate(member) {
+  const date = member.Image && member.Image.Taken ? member.Image.Taken : member.ModTime;
+  return date ? new Date(date).toLocaleString() : '';
+}
+
+async function api(method, path, body) {
+  const options = {method, headers: {}};
+  if (undefined !== body) {
+    options.headers['Content-Type'] = 'application/json';
+    options.body = JSON.stringify(body);
+  }
+  const response = await fetch(path, options);
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.Error);
+  }
+  return data;
+}
+
+function showError(element, err) {
+  element.textContent = err.message;
+  element.classList.add('error');
+}
+
+function showStatus(element, text) {
+  element.textContent = text;
+  element.classList.remove('error');
+}
+
+// decision returns the decision for the group, keeping the suggested keeper until the reviewer picks another one.
+function decision(group) {
+  const id = groupId(group);
+  if (!state.decisions.has(id)) {
+    state.decisions.set(id, {group, keeper: group.Keeper, include: false});
+  }
+  return state.decisions.get(id);
+}
+
+// reclaimable is the number of bytes freed by removing all members except the keeper and archive members.
+function reclaimable(d) {
+  return d.group.Members
+    .filter((m) => m.Path !== d.keeper && !m.Archive)
+    .reduce((sum, m) => sum + (m.Size || 0), 0);
+}
+
+function renderMember(group, d, member) {
+  const element = $('member-template').content.firstElementChild.cloneNode(true);
+  const img = element.querySelector('img');
+  if (member.Image && !member.Archive) {
+    img.src = '/api/thumbnail?' + new URLSearchParams({path: member.Path, size: thumbnailSize});
+    img.alt = member.Path;
+  } else {
+    img.remove();
+  }
+
+  const radio = element.querySelector('input');
+  radio.name = 'keeper-' + groupId(group);
+  radio.checked = member.Path === d.keeper;
+  // files in archives are never kept, as the archive may be changed or removed as a whole
+  radio.disabled = Boolean(member.Archive);
+  radio.addEventListener('change', () => {
+    d.keeper = member.Path;
+    renderGroups();
+    renderPlan();
+  });
+  element.classList.toggle('keeper', member.Path === d.keeper);
+
+  const details = [formatBytes(member.Size || 0)];
+  if (member.Image) {
+    details.push(member.Image.Width + 'x' + member.Image.Height);
+  }
+  details.push(formatDate(member));
+  if (member.Transform) {
+    details.push(member.Transform);
+  }
+  if (member.Archive) {
+    details.push('in archive');
+  }
+  element.querySelector('.details').textContent = details.filter(Boolean).join(', ');
+  element.querySelector('.path').textContent = member.Path;
+
+  return element;
+}
+
+let groups = [];
+
+function renderGroups() {
+  const container = $('groups');
+  container.replaceChildren();
+  if (0 === groups.length) {
+    container.textContent = 'No duplicates found';
+  }
+  for (const group of groups) {
+    const d = decision(group);
+    const element = $('group-template').content.firstElementChild.cloneNode(true);
+    element.classList.toggle('included', d.include);
+    element.querySelector('h2').textContent =
+      `[${group.Strategy}] ${group.Members.length} files, ${formatBytes(reclaimable(d))} reclaimable`;
+    const include = element.querySelector('.include input');
+    include.checked = d.include;
+    include.addEventListener('change', () => {
+      d.include = include.checked;
+      renderGroups();
+      renderPlan();
+    });
+    const members = element.querySelector('.members');
+    for (const member of group.Members) {
+      members.append(renderMember(group, d, member));
+    }
+    container.append(element);
+  }
+
+  const last = Math.min(state.offset + groups.length, state.total);
+  $('page-info').textContent = 0 === state.total ? '' : `${state.offset + 1}-${last} of ${state.total} groups`;
+  $('page-prev').disabled = 0 === state.offset;
+  $('page-next').disabled = last >= state.total;
+}
+
+async function loadGroups() {
+  const params = new URLSearchParams(state.filters);
+  params.set('offset', state.offset);
+  params.set('limit', pageSize);
+  try {
+    const page = await api('GET', '/api/groups?' + params);
+    groups = page.Groups;
+    state.total = page.Total;
+    renderGroups();
+    renderPlan();
+  } catch (err) {
+    showError($('groups'), err);
+  }
+}
+
+// planned returns the decisions of the groups included in the plan.
+function planned() {
+  return [...state.decisions.values()].filter((d) => d.include);
+}
+
+// plan returns the action plan for the groups included in it.
+function plan(dryRun) {
+  const action = document.querySelector('input[name="plan-action"]:checked').value;
+  const p = {
+    Action: action,
+    Groups: planned().map((d) => ({Strategy: d.group.Strategy, Key: d.group.Key, Keeper: d.keeper})),
+  };
+  if ('move' === action) {
+    p.Target = $('plan-target').value;
+  }
+  if (dryRun) {
+    p.DryRun = true;
+  }
+  return p;
+}
+
+function renderPlan() {
+  const included = planned();
+  const bytes = included.reduce((sum, d) => sum + reclaimable(d), 0);
+  $('plan-summary').textContent = `${included.length} groups planned, ${formatBytes(bytes)} reclaimable`;
+}
+
+function exportPlan() {
+  const blob = new Blob([JSON.stringify(plan(false), null, 2)], {type: 'application/json'});
+  const link = document.createElement('a');
+  link.href = URL.createObjectURL(blob);
+  link.download = 'action-plan.json';
+  link.click();
+  URL.revokeObjectURL(link.href);
+}
+
+async function applyPlan(dryRun) {
+  const p = plan(dryRun);
+  if (!dryRun && !confirm(`${'move' === p.Action ? 'Move' : 'Delete'} the duplicates of ${p.Groups.length} groups?`)) {
+    return;
+  }
+  try {
+    const result = await api('POST', '/api/actions', p);
+    const verb = dryRun ? 'Would free' : 'Freed';
+    showStatus($('plan-status'), `${verb} ${formatBytes(result.Reclaimed)} in ${result.Files.length} files`);
+    if (!dryRun) {
+      state.decisions.clear();
+      state.offset = 0;
+      await loadGroups();
+    }
+  } catch (err) {
+    showError($('plan-status'), err);
+  }
+}
+
+// watchJob shows the progress of the index job until it ends, and then reloads the groups.
+function watchJob() {
+  const progress = $('index-progress');
+  const status = $('index-status');
+  progress.hidden = false;
+  $('index-cancel').hidden = false;
+
+  const events = new EventSource('/api/index/events');
+  events.addEventListener('progress', (e) => {
+    const p = JSON.parse(e.data);
+    if (p.DiscoveryDone && p.BytesDiscovered > 0) {
+      progress.value = p.BytesHashed / p.BytesDiscovered;
+    } else {
+      progress.removeAttribute('value');
+    }
+    showStatus(status, `${p.FilesHashed} of ${p.FilesDiscovered} files, ${formatBytes(p.BytesHashed)}`);
+  });
+  for (const end of ['done', 'failed', 'cancelled']) {
+    events.addEventListener(end, (e) => {
+      events.close();
+      const job = JSON.parse(e.data);
+      progress.hidden = true;
+      $('index-cancel').hidden = true;
+      if ('failed' === end) {
+        showError(status, new Error(job.Error));
+      } else {
+        showStatus(status, `Indexing ${end}, ${job.Progress.FilesHashed} files hashed`);
+      }
+      state.decisions.clear();
+      state.offset = 0;
+      loadGroups();
+    });
+  }
+}
+
+$('index-form').addEventListener('submit', async (e) => {
+  e.preventDefault();
+  try {
+    await api('POST', '/api/index', {Dir: $('index-dir').value});
+    watchJob();
+  } catch (err) {
+    showError($('index-status'), err);
+  }
+});
+$('index-cancel').addEventListener('click', () => api('DELETE', '/api/index').catch((err) => showError($('index-status'), err)));
+
+$('filter-apply').addEventListener('click', () => {
+  state.filters = new URLSearchParams({category: 'duplicate'});
+  for (const [name, value] of [['strategy', $('filter-strategy').value], ['dir', $('filter-dir').value], ['min-size', $('filter-min-size').value]]) {
+    if (value && '0' !== value) {
+      state.filters.set(name, value);
+    }
+  }
+  // the plan only has groups included under the filters shown
+  state.decisions.clear();
+  state.offset = 0;
+  loadGroups();
+});
+$('page-prev').addEventListener('click', () => {
+  state.offset = Math.max(0, state.offset - pageSize);
+  loadGroups();
+});
+$('page-next').addEventListener('click', () => {
+  state.offset += pageSize;
+  loadGroups();
+});
+$('plan-export').addEventListener('click', exportPlan);
+$('plan-dry-run').addEventListener('click', () => applyPlan(true));
+$('plan-apply').addEventListener('click', () => applyPlan(false));
+
+// resume showing the progress of a job started before the page was loaded
+api('GET', '/api/index')
+  .then((job) => {
+    if ('running' === job.State) {
+      watchJob();
+    } else {
+      loadGroups();
+    }
+  })
+  .catch(() => loadGroups());
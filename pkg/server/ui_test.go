@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_UI(t *testing.T) {
+	_, s := indexedServer(t)
+	tests := map[string]string{
+		"/":          "text/html",
+		"/app.js":    "javascript",
+		"/style.css": "text/css",
+	}
+
+	for path, contentType := range tests {
+		rec := request(t, s, http.MethodGet, path, nil, nil)
+
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Contains(t, rec.Header().Get("Content-Type"), contentType, path)
+	}
+	rec := request(t, s, http.MethodGet, "/", nil, nil)
+	assert.Contains(t, rec.Body.String(), `<script src="app.js"></script>`)
+}
+
+func Test_UI_Not_Found(t *testing.T) {
+	_, s := indexedServer(t)
+
+	rec := request(t, s, http.MethodGet, "/missing.js", nil, nil)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}